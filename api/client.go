@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const clientTimeout = 30 * time.Second
+
+// Client calls the operator endpoints of a running deathnode
+type Client struct {
+	address    string
+	apiToken   string
+	httpClient *http.Client
+}
+
+// NewClient returns a new Client object for the deathnode HTTP API on address
+func NewClient(address, apiToken string) *Client {
+
+	return &Client{
+		address:    strings.TrimSuffix(address, "/"),
+		apiToken:   apiToken,
+		httpClient: &http.Client{Timeout: clientTimeout},
+	}
+}
+
+// Drain requests the drain of an instance
+func (c *Client) Drain(ctx context.Context, instanceID string) error {
+	return c.do(ctx, http.MethodPost, "/drain/"+instanceID)
+}
+
+// CancelDrain requests to cancel the drain of an instance
+func (c *Client) CancelDrain(ctx context.Context, instanceID string) error {
+	return c.do(ctx, http.MethodDelete, "/drain/"+instanceID)
+}
+
+func (c *Client) do(ctx context.Context, method, path string) error {
+
+	req, err := http.NewRequest(method, c.address+path, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+c.apiToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body := map[string]string{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return fmt.Errorf("%s %s returned %d: %s", method, path, resp.StatusCode, body["error"])
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package api
+
+// Embedded HTTP server exposing the deathnode state
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/alanbover/deathnode/deathnode"
+	"github.com/alanbover/deathnode/metrics"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const readTimeout = 10 * time.Second
+
+type statusProvider interface {
+	Status() deathnode.Status
+}
+
+type drainer interface {
+	Drain(ctx context.Context, instanceID string) error
+	CancelDrain(ctx context.Context, instanceID string) error
+}
+
+// Server serves the deathnode HTTP API
+type Server struct {
+	watcher    statusProvider
+	drainer    drainer
+	apiToken   string
+	httpServer *http.Server
+}
+
+// NewServer returns a new Server object listening on address. The operator endpoints require apiToken as bearer
+// token, and are disabled if it's empty
+func NewServer(address, apiToken string, watcher *deathnode.Watcher) *Server {
+
+	server := &Server{
+		watcher:  watcher,
+		drainer:  watcher,
+		apiToken: apiToken,
+	}
+	server.httpServer = &http.Server{
+		Addr:        address,
+		Handler:     server.handler(),
+		ReadTimeout: readTimeout,
+	}
+	return server
+}
+
+func (s *Server) handler() http.Handler {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/drain/", s.authenticated(s.handleDrain))
+	return mux
+}
+
+// Start listens for requests in background
+func (s *Server) Start() {
+
+	go func() {
+		log.Infof("Serving HTTP API on %s", s.httpServer.Addr)
+		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("HTTP API stopped: %s", err)
+		}
+	}()
+}
+
+// Shutdown stops the server, waiting for the in-flight requests until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.watcher.Status())
+}
+
+// authenticated rejects the requests without the API token
+func (s *Server) authenticated(handler http.HandlerFunc) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.apiToken == "" {
+			writeError(w, http.StatusForbidden, "operator endpoints are disabled, no API token configured")
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.apiToken)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid API token")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// handleDrain drains an instance on POST /drain/<instanceId>, and cancels the drain on DELETE
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) {
+
+	instanceID := strings.TrimPrefix(r.URL.Path, "/drain/")
+	if instanceID == "" || strings.Contains(instanceID, "/") {
+		writeError(w, http.StatusNotFound, "instance id expected as /drain/<instanceId>")
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		err = s.drainer.Drain(r.Context(), instanceID)
+	case http.MethodDelete:
+		err = s.drainer.CancelDrain(r.Context(), instanceID)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if err != nil {
+		log.Warnf("%s /drain/%s failed: %s", r.Method, instanceID, err)
+		writeError(w, drainErrorStatusCode(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"instanceId": instanceID})
+}
+
+func drainErrorStatusCode(err error) int {
+
+	switch err {
+	case deathnode.ErrInstanceNotFound:
+		return http.StatusNotFound
+	case deathnode.ErrAlreadyMarked, deathnode.ErrNotManualDrain, deathnode.ErrTerminating:
+		return http.StatusConflict
+	case deathnode.ErrNotLeader:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Warnf("Unable to write HTTP response: %s", err)
+	}
+}
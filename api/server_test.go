@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alanbover/deathnode/deathnode"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type statusProviderMock struct {
+	status deathnode.Status
+}
+
+func (p *statusProviderMock) Status() deathnode.Status {
+	return p.status
+}
+
+type drainerMock struct {
+	err       error
+	drained   []string
+	cancelled []string
+}
+
+func (d *drainerMock) Drain(ctx context.Context, instanceID string) error {
+	d.drained = append(d.drained, instanceID)
+	return d.err
+}
+
+func (d *drainerMock) CancelDrain(ctx context.Context, instanceID string) error {
+	d.cancelled = append(d.cancelled, instanceID)
+	return d.err
+}
+
+func TestStatusEndpoint(t *testing.T) {
+
+	Convey("When requesting the status", t, func() {
+		server := &Server{
+			watcher: &statusProviderMock{
+				status: deathnode.Status{
+					AutoscalingGroupPrefixes: []deathnode.AutoscalingGroupPrefixStatus{{
+						Prefix: "some-Autoscaling-Group",
+						AutoscalingGroups: []deathnode.AutoscalingGroupStatus{{
+							Name:            "some-Autoscaling-Group",
+							DesiredCapacity: 2,
+							Instances: []deathnode.InstanceStatus{{
+								InstanceID:     "i-34719eb8",
+								IP:             "10.0.0.2",
+								LifecycleState: "InService",
+								BlockedByMesos: true,
+							}},
+						}},
+					}},
+				},
+			},
+		}
+		recorder := httptest.NewRecorder()
+		server.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+		Convey("it should return the watcher status as JSON", func() {
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+			So(recorder.Header().Get("Content-Type"), ShouldEqual, "application/json")
+
+			status := deathnode.Status{}
+			So(json.Unmarshal(recorder.Body.Bytes(), &status), ShouldBeNil)
+			So(status.AutoscalingGroupPrefixes[0].AutoscalingGroups[0].DesiredCapacity, ShouldEqual, 2)
+			So(status.AutoscalingGroupPrefixes[0].AutoscalingGroups[0].Instances[0].BlockedByMesos, ShouldBeTrue)
+		})
+	})
+
+	Convey("When sending a non GET request to the status", t, func() {
+		server := &Server{watcher: &statusProviderMock{}}
+		recorder := httptest.NewRecorder()
+		server.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/status", nil))
+
+		Convey("it should be rejected", func() {
+			So(recorder.Code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}
+
+func TestDrainEndpoint(t *testing.T) {
+
+	Convey("When using the drain endpoint", t, func() {
+		drainer := &drainerMock{}
+		server := &Server{drainer: drainer, apiToken: "secret"}
+		httpServer := httptest.NewServer(server.handler())
+		defer httpServer.Close()
+
+		Convey("with the API token, the instance should be drained", func() {
+			So(NewClient(httpServer.URL, "secret").Drain(context.Background(), "i-34719eb8"), ShouldBeNil)
+			So(drainer.drained, ShouldResemble, []string{"i-34719eb8"})
+		})
+		Convey("with the API token, the drain should be cancelled", func() {
+			So(NewClient(httpServer.URL, "secret").CancelDrain(context.Background(), "i-34719eb8"), ShouldBeNil)
+			So(drainer.cancelled, ShouldResemble, []string{"i-34719eb8"})
+		})
+		Convey("with an invalid API token, it should be rejected", func() {
+			err := NewClient(httpServer.URL, "guess").Drain(context.Background(), "i-34719eb8")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "401")
+			So(drainer.drained, ShouldBeEmpty)
+		})
+		Convey("if the watcher refuses the drain, the reason should be returned", func() {
+			drainer.err = deathnode.ErrAlreadyMarked
+			err := NewClient(httpServer.URL, "secret").Drain(context.Background(), "i-34719eb8")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "409")
+			So(err.Error(), ShouldContainSubstring, deathnode.ErrAlreadyMarked.Error())
+		})
+		Convey("without an instance id, it should be rejected", func() {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/drain/", nil)
+			request.Header.Set("Authorization", "Bearer secret")
+			server.handler().ServeHTTP(recorder, request)
+			So(recorder.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+
+	Convey("When using the drain endpoint without an API token configured", t, func() {
+		drainer := &drainerMock{}
+		server := &Server{drainer: drainer}
+		recorder := httptest.NewRecorder()
+		server.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/drain/i-34719eb8", nil))
+
+		Convey("it should be disabled", func() {
+			So(recorder.Code, ShouldEqual, http.StatusForbidden)
+			So(drainer.drained, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,78 @@
+package audit
+
+// Append-only audit log of the scale-in decisions taken by deathnode
+
+import (
+	"github.com/benbjohnson/clock"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Types of the audit events
+const (
+	CandidateSelection        = "CANDIDATE_SELECTION"
+	MarkDeferred              = "MARK_DEFERRED"
+	MaintenanceEntered        = "MAINTENANCE_ENTERED"
+	MaintenanceReleased       = "MAINTENANCE_RELEASED"
+	AgentDrainRequested       = "AGENT_DRAIN_REQUESTED"
+	ProtectionRemoved         = "PROTECTION_REMOVED"
+	LifecycleHeartbeat        = "LIFECYCLE_HEARTBEAT"
+	DestroySkipped            = "DESTROY_SKIPPED"
+	DestroyBlocked            = "DESTROY_BLOCKED"
+	InverseOffersAccepted     = "INVERSE_OFFERS_ACCEPTED"
+	LifecycleCompleted        = "LIFECYCLE_COMPLETED"
+	LifecycleCompletionFailed = "LIFECYCLE_COMPLETION_FAILED"
+	DrainRequested            = "DRAIN_REQUESTED"
+	DrainCancelled            = "DRAIN_CANCELLED"
+	DrainEscalated            = "DRAIN_ESCALATED"
+	DrainNotRestored          = "DRAIN_NOT_RESTORED"
+	RunDegraded               = "RUN_DEGRADED"
+)
+
+// Event is a decision taken by deathnode
+type Event struct {
+	Timestamp        time.Time              `json:"timestamp"`
+	Type             string                 `json:"type"`
+	AutoscalingGroup string                 `json:"autoscalingGroup,omitempty"`
+	InstanceID       string                 `json:"instanceId,omitempty"`
+	Details          map[string]interface{} `json:"details,omitempty"`
+}
+
+// Sink stores the audit events
+type Sink interface {
+	Write(event Event) error
+}
+
+// Logger timestamps the audit events and writes them to a sink. A nil Logger discards all the events
+type Logger struct {
+	sink  Sink
+	clock clock.Clock
+}
+
+// NewLogger returns a new Logger object
+func NewLogger(sink Sink, clock clock.Clock) *Logger {
+
+	return &Logger{
+		sink:  sink,
+		clock: clock,
+	}
+}
+
+// Record writes an event to the sink. Failing to write it doesn't stop deathnode, it's only logged
+func (l *Logger) Record(eventType, autoscalingGroup, instanceID string, details map[string]interface{}) {
+
+	if l == nil {
+		return
+	}
+
+	event := Event{
+		Timestamp:        l.clock.Now(),
+		Type:             eventType,
+		AutoscalingGroup: autoscalingGroup,
+		InstanceID:       instanceID,
+		Details:          details,
+	}
+	if err := l.sink.Write(event); err != nil {
+		log.Errorf("Unable to write audit event %s: %s", eventType, err)
+	}
+}
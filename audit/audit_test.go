@@ -0,0 +1,44 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogger(t *testing.T) {
+
+	Convey("When recording events to a writer sink", t, func() {
+		buffer := &bytes.Buffer{}
+		clk := clock.NewMock()
+		clk.Add(time.Hour)
+		logger := NewLogger(NewWriterSink(buffer), clk)
+
+		logger.Record(ProtectionRemoved, "some-Autoscaling-Group", "i-34719eb8", nil)
+		logger.Record(DestroyBlocked, "some-Autoscaling-Group", "i-34719eb8",
+			map[string]interface{}{"protectingTasks": []string{"task1"}})
+
+		Convey("every event should be written as a JSON line", func() {
+			lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+			So(lines, ShouldHaveLength, 2)
+
+			event := Event{}
+			So(json.Unmarshal([]byte(lines[1]), &event), ShouldBeNil)
+			So(event.Type, ShouldEqual, DestroyBlocked)
+			So(event.InstanceID, ShouldEqual, "i-34719eb8")
+			So(event.Timestamp.Equal(clk.Now()), ShouldBeTrue)
+			So(event.Details["protectingTasks"], ShouldResemble, []interface{}{"task1"})
+		})
+	})
+
+	Convey("When recording events without a logger", t, func() {
+		var logger *Logger
+		Convey("they should be discarded", func() {
+			So(func() { logger.Record(ProtectionRemoved, "", "", nil) }, ShouldNotPanic)
+		})
+	})
+}
@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"sync"
+)
+
+// SinkMock stores the audit events in memory
+type SinkMock struct {
+	mutex  sync.Mutex
+	events []Event
+}
+
+// Write stores the event
+func (s *SinkMock) Write(event Event) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+// Events returns the events stored, optionally only the ones of the given types
+func (s *SinkMock) Events(eventTypes ...string) []Event {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	events := []Event{}
+	for _, event := range s.events {
+		if len(eventTypes) == 0 {
+			events = append(events, event)
+			continue
+		}
+		for _, eventType := range eventTypes {
+			if event.Type == eventType {
+				events = append(events, event)
+			}
+		}
+	}
+	return events
+}
@@ -3,41 +3,71 @@
 package aws
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"strings"
+	"time"
 )
 
+const lifecycleTransitionTerminationState = "autoscaling:EC2_INSTANCE_TERMINATING"
+
+// Default settings of the lifecycle hook put by deathnode
+const (
+	DefaultLifecycleHookName         = "DEATHNODE"
+	DefaultLifecycleHeartbeatTimeout = 3600
+)
+
+// LifecycleHookMetadata is the notification metadata of the lifecycle hooks put by deathnode, so the ones left
+// under a previous name can be found
+const LifecycleHookMetadata = "deathnode"
+
+// Results of a lifecycle action. On termination, both let AWS terminate the instance, but ABANDON skips the
+// remaining lifecycle hooks
 const (
-	lifecycleHookName                   = "DEATHNODE"
-	continueString                      = "CONTINUE"
-	lifecycleTransitionTerminationState = "autoscaling:EC2_INSTANCE_TERMINATING"
+	LifecycleActionContinue = "CONTINUE"
+	LifecycleActionAbandon  = "ABANDON"
 )
 
+// LifecycleHook stores the settings of an INSTANCE_TERMINATING lifecycle hook
+type LifecycleHook struct {
+	Name             string
+	HeartbeatTimeout int64
+	DefaultResult    string
+	Metadata         string
+}
+
 // Client holds the AWS SDK objects for call AWS API
 type Client struct {
 	ec2         *ec2.EC2
 	autoscaling *autoscaling.AutoScaling
+	callTimeout time.Duration
 }
 
 // ClientInterface implements a client with all required operations against AWS API
 type ClientInterface interface {
-	DescribeInstanceByID(instanceID string) (*ec2.Instance, error)
-	DescribeInstancesByTag(tagKey string) ([]*ec2.Instance, error)
-	DescribeAGsByPrefix(autoscalingGroupName string) ([]*autoscaling.Group, error)
-	RemoveASGInstanceProtection(autoscalingGroupName, instanceID *string) error
-	SetASGInstanceProtection(autoscalingGroupName *string, instanceIDs []*string) error
-	SetInstanceTag(key, value, instanceID string) error
-	HasLifeCycleHook(autoscalingGroupName string) (bool, error)
-	PutLifeCycleHook(autoscalingGroupName string, heartbeatTimeout *int64) error
-	CompleteLifecycleAction(autoscalingGroupName, instanceID *string) error
-	RecordLifecycleActionHeartbeat(autoscalingGroupName, instanceID *string) error
+	DescribeInstanceByID(ctx context.Context, instanceID string) (*ec2.Instance, error)
+	DescribeInstancesByTag(ctx context.Context, tagKey string) ([]*ec2.Instance, error)
+	DescribeAGsByPrefix(ctx context.Context, autoscalingGroupName string) ([]*autoscaling.Group, error)
+	RemoveASGInstanceProtection(ctx context.Context, autoscalingGroupName, instanceID *string) error
+	SetASGInstanceProtection(ctx context.Context, autoscalingGroupName *string, instanceIDs []*string) error
+	SetInstanceTag(ctx context.Context, key, value, instanceID string) error
+	DeleteInstanceTag(ctx context.Context, key, instanceID string) error
+	SetDesiredCapacity(ctx context.Context, autoscalingGroupName *string, desiredCapacity int64) error
+	DescribeLifeCycleHooks(ctx context.Context, autoscalingGroupName string) ([]*LifecycleHook, error)
+	PutLifeCycleHook(ctx context.Context, autoscalingGroupName string, lifecycleHook *LifecycleHook) error
+	DeleteLifeCycleHook(ctx context.Context, autoscalingGroupName, lifecycleHookName string) error
+	CompleteLifecycleAction(ctx context.Context, autoscalingGroupName, instanceID *string, lifecycleHookName,
+		result string) error
+	RecordLifecycleActionHeartbeat(ctx context.Context, autoscalingGroupName, instanceID *string,
+		lifecycleHookName string) error
 }
 
-// NewClient returns a new aws.client
-func NewClient(accessKey, secretKey, region, iamRole, iamSession string) (*Client, error) {
+// NewClient returns a new aws.client. Every call made by the client is cancelled after callTimeout
+func NewClient(accessKey, secretKey, region, iamRole, iamSession string, callTimeout time.Duration) (*Client, error) {
 
 	session, err := newAwsSession(&sessionParameters{
 		accessKey:  accessKey,
@@ -54,11 +84,31 @@ func NewClient(accessKey, secretKey, region, iamRole, iamSession string) (*Clien
 	return &Client{
 		ec2:         ec2.New(session),
 		autoscaling: autoscaling.New(session),
+		callTimeout: callTimeout,
 	}, nil
 }
 
+// send executes an AWS request, aborting it if ctx is done or the call timeout expires
+func (c *Client) send(ctx context.Context, req *request.Request) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if c.callTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.callTimeout)
+		defer cancel()
+	}
+
+	// The Cancel channel is kept by the SDK when the request is copied for a retry
+	req.HTTPRequest.Cancel = ctx.Done()
+	return req.Send()
+}
+
 // RecordLifecycleActionHeartbeat resets the timeout period for a lifecycle hook event
-func (c *Client) RecordLifecycleActionHeartbeat(autoscalingGroupName, instanceID *string) error {
+func (c *Client) RecordLifecycleActionHeartbeat(ctx context.Context, autoscalingGroupName, instanceID *string,
+	lifecycleHookName string) error {
 
 	recordLifeCycleActionHeartbeatInput := &autoscaling.RecordLifecycleActionHeartbeatInput{
 		AutoScalingGroupName: autoscalingGroupName,
@@ -66,62 +116,87 @@ func (c *Client) RecordLifecycleActionHeartbeat(autoscalingGroupName, instanceID
 		LifecycleHookName:    aws.String(lifecycleHookName),
 	}
 
-	_, err := c.autoscaling.RecordLifecycleActionHeartbeat(recordLifeCycleActionHeartbeatInput)
-	return err
+	req, _ := c.autoscaling.RecordLifecycleActionHeartbeatRequest(recordLifeCycleActionHeartbeatInput)
+	return c.send(ctx, req)
 }
 
-// CompleteLifecycleAction completes a lifecycle event for an instance pending to be deleted
-func (c *Client) CompleteLifecycleAction(autoscalingGroupName, instanceID *string) error {
+// CompleteLifecycleAction completes a lifecycle event for an instance pending to be deleted with result
+func (c *Client) CompleteLifecycleAction(ctx context.Context, autoscalingGroupName, instanceID *string,
+	lifecycleHookName, result string) error {
 
 	completeLifecycleActionInput := &autoscaling.CompleteLifecycleActionInput{
 		AutoScalingGroupName:  autoscalingGroupName,
 		InstanceId:            instanceID,
-		LifecycleActionResult: aws.String(continueString),
+		LifecycleActionResult: aws.String(result),
 		LifecycleHookName:     aws.String(lifecycleHookName),
 	}
 
-	_, err := c.autoscaling.CompleteLifecycleAction(completeLifecycleActionInput)
-	return err
+	req, _ := c.autoscaling.CompleteLifecycleActionRequest(completeLifecycleActionInput)
+	return c.send(ctx, req)
 }
 
-// HasLifeCycleHook checks if deathnode lifecyclehook is enabled for an autoscalingGroup
-func (c *Client) HasLifeCycleHook(autoscalingGroupName string) (bool, error) {
+// DescribeLifeCycleHooks returns the settings of the lifecycle hooks of an autoscalingGroup
+func (c *Client) DescribeLifeCycleHooks(ctx context.Context, autoscalingGroupName string) ([]*LifecycleHook, error) {
 
 	describeLifecycleHooksInput := &autoscaling.DescribeLifecycleHooksInput{
 		AutoScalingGroupName: aws.String(autoscalingGroupName),
-		LifecycleHookNames:   []*string{aws.String(lifecycleHookName)},
 	}
 
-	describeLifecycleHooksOutput, err := c.autoscaling.DescribeLifecycleHooks(describeLifecycleHooksInput)
-	if err != nil {
-		return false, err
+	req, describeLifecycleHooksOutput := c.autoscaling.DescribeLifecycleHooksRequest(describeLifecycleHooksInput)
+	if err := c.send(ctx, req); err != nil {
+		return nil, err
 	}
 
-	return len(describeLifecycleHooksOutput.LifecycleHooks) != 0, nil
+	lifecycleHooks := []*LifecycleHook{}
+	for _, lifecycleHook := range describeLifecycleHooksOutput.LifecycleHooks {
+		lifecycleHooks = append(lifecycleHooks, &LifecycleHook{
+			Name:             aws.StringValue(lifecycleHook.LifecycleHookName),
+			HeartbeatTimeout: aws.Int64Value(lifecycleHook.HeartbeatTimeout),
+			DefaultResult:    aws.StringValue(lifecycleHook.DefaultResult),
+			Metadata:         aws.StringValue(lifecycleHook.NotificationMetadata),
+		})
+	}
+	return lifecycleHooks, nil
 }
 
-// PutLifeCycleHook adds an INSTANCE_TERMINATING lifecycle hook to an autoscalingGroup
-func (c *Client) PutLifeCycleHook(autoscalingGroupName string, heartbeatTimeout *int64) error {
+// PutLifeCycleHook adds, or updates, an INSTANCE_TERMINATING lifecycle hook to an autoscalingGroup
+func (c *Client) PutLifeCycleHook(ctx context.Context, autoscalingGroupName string, lifecycleHook *LifecycleHook) error {
 
 	putLifecycleHookInput := &autoscaling.PutLifecycleHookInput{
 		AutoScalingGroupName: aws.String(autoscalingGroupName),
-		DefaultResult:        aws.String(continueString),
-		HeartbeatTimeout:     heartbeatTimeout,
-		LifecycleHookName:    aws.String(lifecycleHookName),
+		DefaultResult:        aws.String(lifecycleHook.DefaultResult),
+		HeartbeatTimeout:     aws.Int64(lifecycleHook.HeartbeatTimeout),
+		LifecycleHookName:    aws.String(lifecycleHook.Name),
 		LifecycleTransition:  aws.String(lifecycleTransitionTerminationState),
 	}
+	if lifecycleHook.Metadata != "" {
+		putLifecycleHookInput.NotificationMetadata = aws.String(lifecycleHook.Metadata)
+	}
 
-	_, err := c.autoscaling.PutLifecycleHook(putLifecycleHookInput)
-	return err
+	req, _ := c.autoscaling.PutLifecycleHookRequest(putLifecycleHookInput)
+	return c.send(ctx, req)
+}
+
+// DeleteLifeCycleHook removes a lifecycle hook from an autoscalingGroup
+func (c *Client) DeleteLifeCycleHook(ctx context.Context, autoscalingGroupName, lifecycleHookName string) error {
+
+	deleteLifecycleHookInput := &autoscaling.DeleteLifecycleHookInput{
+		AutoScalingGroupName: aws.String(autoscalingGroupName),
+		LifecycleHookName:    aws.String(lifecycleHookName),
+	}
+
+	req, _ := c.autoscaling.DeleteLifecycleHookRequest(deleteLifecycleHookInput)
+	return c.send(ctx, req)
 }
 
 // DescribeAGsByPrefix returns all autoscaling groups that matches a certain prefix
-func (c *Client) DescribeAGsByPrefix(autoscalingGroupPrefix string) ([]*autoscaling.Group, error) {
+func (c *Client) DescribeAGsByPrefix(ctx context.Context, autoscalingGroupPrefix string) ([]*autoscaling.Group, error) {
 
 	autoscalingGroupList := []*autoscaling.Group{}
 
 	filter := &autoscaling.DescribeAutoScalingGroupsInput{}
-	response, err := c.autoscaling.DescribeAutoScalingGroups(filter)
+	req, response := c.autoscaling.DescribeAutoScalingGroupsRequest(filter)
+	err := c.send(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -129,7 +204,7 @@ func (c *Client) DescribeAGsByPrefix(autoscalingGroupPrefix string) ([]*autoscal
 	autoscalingGroupList = appendASGByPrefix(autoscalingGroupList, response.AutoScalingGroups, autoscalingGroupPrefix)
 	for response.NextToken != nil {
 		nextToken := response.NextToken
-		response, err = c.describeAGByNameWithToken(nextToken)
+		response, err = c.describeAGByNameWithToken(ctx, nextToken)
 		if err != nil {
 			return nil, err
 		}
@@ -140,14 +215,14 @@ func (c *Client) DescribeAGsByPrefix(autoscalingGroupPrefix string) ([]*autoscal
 	return autoscalingGroupList, nil
 }
 
-func (c *Client) describeAGByNameWithToken(nextToken *string) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+func (c *Client) describeAGByNameWithToken(ctx context.Context, nextToken *string) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
 
 	filter := &autoscaling.DescribeAutoScalingGroupsInput{
 		NextToken: nextToken,
 	}
 
-	response, err := c.autoscaling.DescribeAutoScalingGroups(filter)
-	if err != nil {
+	req, response := c.autoscaling.DescribeAutoScalingGroupsRequest(filter)
+	if err := c.send(ctx, req); err != nil {
 		return nil, err
 	}
 
@@ -166,7 +241,7 @@ func appendASGByPrefix(asgResponse, asgToFilter []*autoscaling.Group, prefix str
 }
 
 // DescribeInstanceByID returns the instance that matches an instanceID
-func (c *Client) DescribeInstanceByID(instanceID string) (*ec2.Instance, error) {
+func (c *Client) DescribeInstanceByID(ctx context.Context, instanceID string) (*ec2.Instance, error) {
 
 	filter := &ec2.DescribeInstancesInput{
 		Filters: []*ec2.Filter{{
@@ -175,9 +250,9 @@ func (c *Client) DescribeInstanceByID(instanceID string) (*ec2.Instance, error)
 		}},
 	}
 
-	response, err := c.ec2.DescribeInstances(filter)
+	req, response := c.ec2.DescribeInstancesRequest(filter)
 
-	if err != nil {
+	if err := c.send(ctx, req); err != nil {
 		return nil, err
 	}
 
@@ -189,7 +264,7 @@ func (c *Client) DescribeInstanceByID(instanceID string) (*ec2.Instance, error)
 }
 
 // DescribeInstancesByTag return all instances with a certain tag set
-func (c *Client) DescribeInstancesByTag(tagKey string) ([]*ec2.Instance, error) {
+func (c *Client) DescribeInstancesByTag(ctx context.Context, tagKey string) ([]*ec2.Instance, error) {
 
 	instances := []*ec2.Instance{}
 
@@ -206,9 +281,9 @@ func (c *Client) DescribeInstancesByTag(tagKey string) ([]*ec2.Instance, error)
 		},
 	}
 
-	response, err := c.ec2.DescribeInstances(filter)
+	req, response := c.ec2.DescribeInstancesRequest(filter)
 
-	if err != nil {
+	if err := c.send(ctx, req); err != nil {
 		return nil, err
 	}
 
@@ -226,7 +301,7 @@ func (c *Client) DescribeInstancesByTag(tagKey string) ([]*ec2.Instance, error)
 }
 
 // RemoveASGInstanceProtection remove ProtectFromScaleIn flag from some instances from an autoscalingGroup
-func (c *Client) RemoveASGInstanceProtection(autoscalingGroupName, instanceID *string) error {
+func (c *Client) RemoveASGInstanceProtection(ctx context.Context, autoscalingGroupName, instanceID *string) error {
 
 	protectedFromScaleIn := false
 	setInstanceProtectionInput := &autoscaling.SetInstanceProtectionInput{
@@ -235,13 +310,13 @@ func (c *Client) RemoveASGInstanceProtection(autoscalingGroupName, instanceID *s
 		ProtectedFromScaleIn: &protectedFromScaleIn,
 	}
 
-	_, err := c.autoscaling.SetInstanceProtection(setInstanceProtectionInput)
+	req, _ := c.autoscaling.SetInstanceProtectionRequest(setInstanceProtectionInput)
 
-	return err
+	return c.send(ctx, req)
 }
 
 // SetASGInstanceProtection set an autoscalingGroup and all it's instances with ProtectFromScaleIn flag
-func (c *Client) SetASGInstanceProtection(autoscalingGroupName *string, instanceIds []*string) error {
+func (c *Client) SetASGInstanceProtection(ctx context.Context, autoscalingGroupName *string, instanceIds []*string) error {
 
 	instancesProtectedFromScaleIn := true
 	updateAutoScalingGroupInput := &autoscaling.UpdateAutoScalingGroupInput{
@@ -249,9 +324,9 @@ func (c *Client) SetASGInstanceProtection(autoscalingGroupName *string, instance
 		NewInstancesProtectedFromScaleIn: &instancesProtectedFromScaleIn,
 	}
 
-	_, err := c.autoscaling.UpdateAutoScalingGroup(updateAutoScalingGroupInput)
+	req, _ := c.autoscaling.UpdateAutoScalingGroupRequest(updateAutoScalingGroupInput)
 
-	if err != nil {
+	if err := c.send(ctx, req); err != nil {
 		return err
 	}
 
@@ -262,23 +337,46 @@ func (c *Client) SetASGInstanceProtection(autoscalingGroupName *string, instance
 		ProtectedFromScaleIn: &protectedFromScaleIn,
 	}
 
-	_, err = c.autoscaling.SetInstanceProtection(setInstanceProtectionInput)
+	req, _ = c.autoscaling.SetInstanceProtectionRequest(setInstanceProtectionInput)
 
-	return err
+	return c.send(ctx, req)
 }
 
 // SetInstanceTag set a tag with <key,value> to an AWS instance
-func (c *Client) SetInstanceTag(key, value, instanceID string) error {
+func (c *Client) SetInstanceTag(ctx context.Context, key, value, instanceID string) error {
 
 	tag := &ec2.Tag{
 		Key:   aws.String(key),
 		Value: aws.String(value),
 	}
 
-	_, err := c.ec2.CreateTags(&ec2.CreateTagsInput{
+	req, _ := c.ec2.CreateTagsRequest(&ec2.CreateTagsInput{
 		Resources: []*string{aws.String(instanceID)},
 		Tags:      []*ec2.Tag{tag},
 	})
 
-	return err
+	return c.send(ctx, req)
+}
+
+// DeleteInstanceTag deletes the tag with key from an AWS instance
+func (c *Client) DeleteInstanceTag(ctx context.Context, key, instanceID string) error {
+
+	req, _ := c.ec2.DeleteTagsRequest(&ec2.DeleteTagsInput{
+		Resources: []*string{aws.String(instanceID)},
+		Tags:      []*ec2.Tag{{Key: aws.String(key)}},
+	})
+
+	return c.send(ctx, req)
+}
+
+// SetDesiredCapacity sets the desired capacity of an autoscalingGroup, without honoring its cooldown
+func (c *Client) SetDesiredCapacity(ctx context.Context, autoscalingGroupName *string, desiredCapacity int64) error {
+
+	req, _ := c.autoscaling.SetDesiredCapacityRequest(&autoscaling.SetDesiredCapacityInput{
+		AutoScalingGroupName: autoscalingGroupName,
+		DesiredCapacity:      aws.Int64(desiredCapacity),
+		HonorCooldown:        aws.Bool(false),
+	})
+
+	return c.send(ctx, req)
 }
@@ -1,6 +1,7 @@
 package aws
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
@@ -8,12 +9,15 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 )
 
 // ConnectionMock is a aws mock client for testing purposes
 type ConnectionMock struct {
-	Records  map[string]*[]string
-	Requests map[string][][]string
+	Records        map[string]*[]string
+	Requests       map[string][][]string
+	lifecycleHooks map[string]*LifecycleHook
 }
 
 // FlushMock will flush all requests recorded on the mock side
@@ -22,28 +26,28 @@ func (c *ConnectionMock) FlushMock() {
 }
 
 // DescribeInstanceByID is a mock call for testing purposes
-func (c *ConnectionMock) DescribeInstanceByID(instanceID string) (*ec2.Instance, error) {
+func (c *ConnectionMock) DescribeInstanceByID(ctx context.Context, instanceID string) (*ec2.Instance, error) {
 
 	mockResponse, _ := c.replay(&ec2.Instance{}, "DescribeInstanceById")
 	return mockResponse.(*ec2.Instance), nil
 }
 
 // DescribeInstancesByTag is a mock call for testing purposes
-func (c *ConnectionMock) DescribeInstancesByTag(tagKey string) ([]*ec2.Instance, error) {
+func (c *ConnectionMock) DescribeInstancesByTag(ctx context.Context, tagKey string) ([]*ec2.Instance, error) {
 
 	mockResponse, _ := c.replay(&[]*ec2.Instance{}, "DescribeInstancesByTag")
 	return *mockResponse.(*[]*ec2.Instance), nil
 }
 
 // DescribeAGsByPrefix is a mock call for testing purposes
-func (c *ConnectionMock) DescribeAGsByPrefix(autoscalingGroupName string) ([]*autoscaling.Group, error) {
+func (c *ConnectionMock) DescribeAGsByPrefix(ctx context.Context, autoscalingGroupName string) ([]*autoscaling.Group, error) {
 
 	mockResponse, _ := c.replay(&[]*autoscaling.Group{}, "DescribeAGByName")
 	return *mockResponse.(*[]*autoscaling.Group), nil
 }
 
 // SetASGInstanceProtection is a mock call for testing purposes
-func (c *ConnectionMock) SetASGInstanceProtection(autoscalingGroupName *string, instanceIDs []*string) error {
+func (c *ConnectionMock) SetASGInstanceProtection(ctx context.Context, autoscalingGroupName *string, instanceIDs []*string) error {
 
 	inputValues := []string{*autoscalingGroupName}
 	for _, instanceID := range instanceIDs {
@@ -55,51 +59,92 @@ func (c *ConnectionMock) SetASGInstanceProtection(autoscalingGroupName *string,
 }
 
 // RemoveASGInstanceProtection is a mock call for testing purposes
-func (c *ConnectionMock) RemoveASGInstanceProtection(autoscalingGroupName, instanceID *string) error {
+func (c *ConnectionMock) RemoveASGInstanceProtection(ctx context.Context, autoscalingGroupName, instanceID *string) error {
 
 	c.addRequests("RemoveASGInstanceProtection", []string{*autoscalingGroupName, *instanceID})
 	return nil
 }
 
 // SetInstanceTag is a mock call for testing purposes
-func (c *ConnectionMock) SetInstanceTag(key, value, instanceID string) error {
+func (c *ConnectionMock) SetInstanceTag(ctx context.Context, key, value, instanceID string) error {
 
 	inputValues := []string{key, value, instanceID}
 	c.addRequests("SetInstanceTag", inputValues)
 	return nil
 }
 
-// HasLifeCycleHook is a mock call for testing purposes
-func (c *ConnectionMock) HasLifeCycleHook(autoscalingGroupName string) (bool, error) {
+// DeleteInstanceTag is a mock call for testing purposes
+func (c *ConnectionMock) DeleteInstanceTag(ctx context.Context, key, instanceID string) error {
 
-	records, ok := c.Records["HasLifeCycleHook"]
-	if !ok {
-		return false, nil
+	c.addRequests("DeleteInstanceTag", []string{key, instanceID})
+	return nil
+}
+
+// SetDesiredCapacity is a mock call for testing purposes
+func (c *ConnectionMock) SetDesiredCapacity(ctx context.Context, autoscalingGroupName *string, desiredCapacity int64) error {
+
+	c.addRequests("SetDesiredCapacity", []string{*autoscalingGroupName, fmt.Sprintf("%d", desiredCapacity)})
+	return nil
+}
+
+// DescribeLifeCycleHooks is a mock call for testing purposes. Without records, the lifecycle hooks are the ones
+// put and not deleted
+func (c *ConnectionMock) DescribeLifeCycleHooks(ctx context.Context, autoscalingGroupName string) ([]*LifecycleHook, error) {
+
+	if _, ok := c.Records["DescribeLifeCycleHooks"]; ok {
+		mockResponse, _ := c.replay(&[]*LifecycleHook{}, "DescribeLifeCycleHooks")
+		return *mockResponse.(*[]*LifecycleHook), nil
 	}
 
-	hasLifeCycleHook := (*records)[0]
-	*records = (*records)[1:]
-	return hasLifeCycleHook == "true", nil
+	keys := []string{}
+	for key := range c.lifecycleHooks {
+		if strings.HasPrefix(key, autoscalingGroupName+"/") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	lifecycleHooks := []*LifecycleHook{}
+	for _, key := range keys {
+		lifecycleHooks = append(lifecycleHooks, c.lifecycleHooks[key])
+	}
+	return lifecycleHooks, nil
 }
 
 // PutLifeCycleHook is a mock call for testing purposes
-func (c *ConnectionMock) PutLifeCycleHook(autoscalingGroupName string, heartbeatTimeout *int64) error {
+func (c *ConnectionMock) PutLifeCycleHook(ctx context.Context, autoscalingGroupName string,
+	lifecycleHook *LifecycleHook) error {
+
+	c.addRequests("PutLifeCycleHook", []string{autoscalingGroupName, fmt.Sprintf("%d", lifecycleHook.HeartbeatTimeout),
+		lifecycleHook.Name, lifecycleHook.DefaultResult})
+	if c.lifecycleHooks == nil {
+		c.lifecycleHooks = map[string]*LifecycleHook{}
+	}
+	put := *lifecycleHook
+	c.lifecycleHooks[autoscalingGroupName+"/"+lifecycleHook.Name] = &put
+	return nil
+}
+
+// DeleteLifeCycleHook is a mock call for testing purposes
+func (c *ConnectionMock) DeleteLifeCycleHook(ctx context.Context, autoscalingGroupName, lifecycleHookName string) error {
 
-	c.addRequests("PutLifeCycleHook", []string{autoscalingGroupName, fmt.Sprintf("%d", *heartbeatTimeout)})
+	c.addRequests("DeleteLifeCycleHook", []string{autoscalingGroupName, lifecycleHookName})
+	delete(c.lifecycleHooks, autoscalingGroupName+"/"+lifecycleHookName)
 	return nil
 }
 
 // CompleteLifecycleAction is a mock call for testing purposes
-func (c *ConnectionMock) CompleteLifecycleAction(autoscalingGroupName, instanceID *string) error {
+func (c *ConnectionMock) CompleteLifecycleAction(ctx context.Context, autoscalingGroupName, instanceID *string,
+	lifecycleHookName, result string) error {
 
-	c.addRequests("CompleteLifecycleAction", []string{*autoscalingGroupName, *instanceID})
+	c.addRequests("CompleteLifecycleAction", []string{*autoscalingGroupName, *instanceID, lifecycleHookName, result})
 	return nil
 }
 
 // RecordLifecycleActionHeartbeat is a mock call for testing purposes
-func (c *ConnectionMock) RecordLifecycleActionHeartbeat(autoscalingGroupName, instanceID *string) error {
+func (c *ConnectionMock) RecordLifecycleActionHeartbeat(ctx context.Context, autoscalingGroupName, instanceID *string,
+	lifecycleHookName string) error {
 
-	c.addRequests("RecordLifecycleActionHeartbeat", []string{*autoscalingGroupName, *instanceID})
+	c.addRequests("RecordLifecycleActionHeartbeat", []string{*autoscalingGroupName, *instanceID, lifecycleHookName})
 	return nil
 }
 
@@ -0,0 +1,380 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"github.com/alanbover/deathnode/dryrun"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	lifecycleStateInService       = "InService"
+	lifecycleStateTerminatingWait = "Terminating:Wait"
+)
+
+// DryRunClient decorates a ClientInterface for dry-run mode. Read calls are done against AWS, while mutating
+// calls are only recorded in a plan. The responses of the read calls are patched as if the mutating calls
+// had succeeded, so deathnode behaves the same way it would in a real execution across several runs
+type DryRunClient struct {
+	client             ClientInterface
+	plan               *dryrun.Plan
+	mutex              sync.Mutex
+	tags               map[string]map[string]string
+	deletedTags        map[string]map[string]bool
+	desiredCapacities  map[string]int64
+	instanceProtection map[string]bool
+	protectedGroups    map[string]bool
+	lifecycleHooks     map[string]*LifecycleHook
+	terminated         map[string]bool
+}
+
+// NewDryRunClient returns a new DryRunClient object
+func NewDryRunClient(client ClientInterface, plan *dryrun.Plan) *DryRunClient {
+
+	return &DryRunClient{
+		client:             client,
+		plan:               plan,
+		tags:               map[string]map[string]string{},
+		deletedTags:        map[string]map[string]bool{},
+		desiredCapacities:  map[string]int64{},
+		instanceProtection: map[string]bool{},
+		protectedGroups:    map[string]bool{},
+		lifecycleHooks:     map[string]*LifecycleHook{},
+		terminated:         map[string]bool{},
+	}
+}
+
+// DescribeInstanceByID returns the instance from AWS, with the tags set during the dry run
+func (c *DryRunClient) DescribeInstanceByID(ctx context.Context, instanceID string) (*ec2.Instance, error) {
+
+	instance, err := c.client.DescribeInstanceByID(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.simulateInstance(instance), nil
+}
+
+// DescribeInstancesByTag returns the running instances with a tag, either set in AWS or during the dry run
+func (c *DryRunClient) DescribeInstancesByTag(ctx context.Context, tagKey string) ([]*ec2.Instance, error) {
+
+	response, err := c.client.DescribeInstancesByTag(ctx, tagKey)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	taggedInstanceIDs := []string{}
+	for instanceID, tags := range c.tags {
+		if _, ok := tags[tagKey]; ok && !c.terminated[instanceID] {
+			taggedInstanceIDs = append(taggedInstanceIDs, instanceID)
+		}
+	}
+	c.mutex.Unlock()
+
+	instances := []*ec2.Instance{}
+	found := map[string]bool{}
+	for _, instance := range response {
+		instanceID := aws.StringValue(instance.InstanceId)
+		found[instanceID] = true
+		if !c.isTerminated(instanceID) && !c.isTagDeleted(instanceID, tagKey) {
+			instances = append(instances, instance)
+		}
+	}
+
+	for _, instanceID := range taggedInstanceIDs {
+		if found[instanceID] {
+			continue
+		}
+		instance, err := c.client.DescribeInstanceByID(ctx, instanceID)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, instance)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, instance := range instances {
+		instances[i] = c.simulateInstance(instance)
+	}
+	return instances, nil
+}
+
+// DescribeAGsByPrefix returns the autoscaling groups from AWS, with the instance protection and terminations
+// done during the dry run
+func (c *DryRunClient) DescribeAGsByPrefix(ctx context.Context, autoscalingGroupPrefix string) ([]*autoscaling.Group, error) {
+
+	response, err := c.client.DescribeAGsByPrefix(ctx, autoscalingGroupPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	autoscalingGroups := []*autoscaling.Group{}
+	for _, autoscalingGroup := range response {
+		autoscalingGroups = append(autoscalingGroups, c.simulateAutoscalingGroup(autoscalingGroup))
+	}
+	return autoscalingGroups, nil
+}
+
+// DescribeLifeCycleHooks returns the lifecycle hooks in AWS, with the ones put or deleted during the dry run
+func (c *DryRunClient) DescribeLifeCycleHooks(ctx context.Context, autoscalingGroupName string) ([]*LifecycleHook, error) {
+
+	response, err := c.client.DescribeLifeCycleHooks(ctx, autoscalingGroupName)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	lifecycleHooks := []*LifecycleHook{}
+	for _, lifecycleHook := range response {
+		if _, simulated := c.lifecycleHooks[autoscalingGroupName+"/"+lifecycleHook.Name]; !simulated {
+			lifecycleHooks = append(lifecycleHooks, lifecycleHook)
+		}
+	}
+	keys := []string{}
+	for key, lifecycleHook := range c.lifecycleHooks {
+		if lifecycleHook != nil && strings.HasPrefix(key, autoscalingGroupName+"/") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		lifecycleHooks = append(lifecycleHooks, c.lifecycleHooks[key])
+	}
+	return lifecycleHooks, nil
+}
+
+// RemoveASGInstanceProtection records the call in the plan
+func (c *DryRunClient) RemoveASGInstanceProtection(ctx context.Context, autoscalingGroupName, instanceID *string) error {
+
+	c.plan.Record("RemoveASGInstanceProtection", map[string]string{
+		"autoscalingGroup": *autoscalingGroupName,
+		"instanceId":       *instanceID,
+	})
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.instanceProtection[*instanceID] = false
+	return nil
+}
+
+// SetASGInstanceProtection records the call in the plan
+func (c *DryRunClient) SetASGInstanceProtection(ctx context.Context, autoscalingGroupName *string, instanceIDs []*string) error {
+
+	c.plan.Record("SetASGInstanceProtection", map[string]string{
+		"autoscalingGroup": *autoscalingGroupName,
+		"instanceIds":      strings.Join(aws.StringValueSlice(instanceIDs), ","),
+	})
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.protectedGroups[*autoscalingGroupName] = true
+	for _, instanceID := range instanceIDs {
+		c.instanceProtection[*instanceID] = true
+	}
+	return nil
+}
+
+// SetInstanceTag records the call in the plan
+func (c *DryRunClient) SetInstanceTag(ctx context.Context, key, value, instanceID string) error {
+
+	c.plan.Record("SetInstanceTag", map[string]string{
+		"key":        key,
+		"value":      value,
+		"instanceId": instanceID,
+	})
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.tags[instanceID]; !ok {
+		c.tags[instanceID] = map[string]string{}
+	}
+	c.tags[instanceID][key] = value
+	delete(c.deletedTags[instanceID], key)
+	return nil
+}
+
+// DeleteInstanceTag records the call in the plan
+func (c *DryRunClient) DeleteInstanceTag(ctx context.Context, key, instanceID string) error {
+
+	c.plan.Record("DeleteInstanceTag", map[string]string{
+		"key":        key,
+		"instanceId": instanceID,
+	})
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.tags[instanceID], key)
+	if _, ok := c.deletedTags[instanceID]; !ok {
+		c.deletedTags[instanceID] = map[string]bool{}
+	}
+	c.deletedTags[instanceID][key] = true
+	return nil
+}
+
+// SetDesiredCapacity records the call in the plan
+func (c *DryRunClient) SetDesiredCapacity(ctx context.Context, autoscalingGroupName *string, desiredCapacity int64) error {
+
+	c.plan.Record("SetDesiredCapacity", map[string]string{
+		"autoscalingGroup": *autoscalingGroupName,
+		"desiredCapacity":  fmt.Sprintf("%d", desiredCapacity),
+	})
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.desiredCapacities[*autoscalingGroupName] = desiredCapacity
+	return nil
+}
+
+// PutLifeCycleHook records the call in the plan
+func (c *DryRunClient) PutLifeCycleHook(ctx context.Context, autoscalingGroupName string,
+	lifecycleHook *LifecycleHook) error {
+
+	c.plan.Record("PutLifeCycleHook", map[string]string{
+		"autoscalingGroup": autoscalingGroupName,
+		"lifecycleHook":    lifecycleHook.Name,
+		"heartbeatTimeout": fmt.Sprintf("%d", lifecycleHook.HeartbeatTimeout),
+		"defaultResult":    lifecycleHook.DefaultResult,
+	})
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	simulated := *lifecycleHook
+	c.lifecycleHooks[autoscalingGroupName+"/"+lifecycleHook.Name] = &simulated
+	return nil
+}
+
+// DeleteLifeCycleHook records the call in the plan
+func (c *DryRunClient) DeleteLifeCycleHook(ctx context.Context, autoscalingGroupName, lifecycleHookName string) error {
+
+	c.plan.Record("DeleteLifeCycleHook", map[string]string{
+		"autoscalingGroup": autoscalingGroupName,
+		"lifecycleHook":    lifecycleHookName,
+	})
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lifecycleHooks[autoscalingGroupName+"/"+lifecycleHookName] = nil
+	return nil
+}
+
+// CompleteLifecycleAction records the call in the plan
+func (c *DryRunClient) CompleteLifecycleAction(ctx context.Context, autoscalingGroupName, instanceID *string,
+	lifecycleHookName, result string) error {
+
+	c.plan.Record("CompleteLifecycleAction", map[string]string{
+		"autoscalingGroup": *autoscalingGroupName,
+		"instanceId":       *instanceID,
+		"lifecycleHook":    lifecycleHookName,
+		"result":           result,
+	})
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.terminated[*instanceID] = true
+	return nil
+}
+
+// RecordLifecycleActionHeartbeat records the call in the plan
+func (c *DryRunClient) RecordLifecycleActionHeartbeat(ctx context.Context, autoscalingGroupName, instanceID *string,
+	lifecycleHookName string) error {
+
+	c.plan.Record("RecordLifecycleActionHeartbeat", map[string]string{
+		"autoscalingGroup": *autoscalingGroupName,
+		"instanceId":       *instanceID,
+		"lifecycleHook":    lifecycleHookName,
+	})
+	return nil
+}
+
+func (c *DryRunClient) isTerminated(instanceID string) bool {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.terminated[instanceID]
+}
+
+func (c *DryRunClient) isTagDeleted(instanceID, key string) bool {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.deletedTags[instanceID][key]
+}
+
+func (c *DryRunClient) simulateInstance(instance *ec2.Instance) *ec2.Instance {
+
+	tags, ok := c.tags[aws.StringValue(instance.InstanceId)]
+	deletedTags, deleted := c.deletedTags[aws.StringValue(instance.InstanceId)]
+	if !ok && !deleted {
+		return instance
+	}
+
+	simulatedInstance := *instance
+	simulatedInstance.Tags = []*ec2.Tag{}
+	for _, tag := range instance.Tags {
+		if _, overridden := tags[*tag.Key]; !overridden && !deletedTags[*tag.Key] {
+			simulatedInstance.Tags = append(simulatedInstance.Tags, tag)
+		}
+	}
+	for key, value := range tags {
+		simulatedInstance.Tags = append(simulatedInstance.Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	return &simulatedInstance
+}
+
+func (c *DryRunClient) simulateAutoscalingGroup(autoscalingGroup *autoscaling.Group) *autoscaling.Group {
+
+	simulatedGroup := *autoscalingGroup
+	if c.protectedGroups[*autoscalingGroup.AutoScalingGroupName] {
+		simulatedGroup.NewInstancesProtectedFromScaleIn = aws.Bool(true)
+	}
+	if desiredCapacity, ok := c.desiredCapacities[*autoscalingGroup.AutoScalingGroupName]; ok {
+		simulatedGroup.DesiredCapacity = aws.Int64(desiredCapacity)
+	}
+
+	// Instances whose lifecycle action was completed are terminated by AWS
+	simulatedGroup.Instances = []*autoscaling.Instance{}
+	inService := 0
+	for _, instance := range autoscalingGroup.Instances {
+		if c.terminated[*instance.InstanceId] {
+			continue
+		}
+		simulatedInstance := *instance
+		if protected, ok := c.instanceProtection[*instance.InstanceId]; ok {
+			simulatedInstance.ProtectedFromScaleIn = aws.Bool(protected)
+		}
+		if aws.StringValue(simulatedInstance.LifecycleState) == lifecycleStateInService {
+			inService++
+		}
+		simulatedGroup.Instances = append(simulatedGroup.Instances, &simulatedInstance)
+	}
+
+	// While the group is over its desired capacity, AWS starts terminating the instances unprotected during the
+	// dry run. The rest of them are in the state AWS reported
+	surplus := inService - int(aws.Int64Value(simulatedGroup.DesiredCapacity))
+	for _, instance := range simulatedGroup.Instances {
+		if surplus <= 0 {
+			break
+		}
+		protected, simulated := c.instanceProtection[*instance.InstanceId]
+		if simulated && !protected && aws.StringValue(instance.LifecycleState) == lifecycleStateInService {
+			instance.LifecycleState = aws.String(lifecycleStateTerminatingWait)
+			surplus--
+		}
+	}
+
+	return &simulatedGroup
+}
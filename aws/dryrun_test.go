@@ -0,0 +1,106 @@
+package aws
+
+import (
+	"context"
+	"github.com/alanbover/deathnode/dryrun"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestDryRunClient(t *testing.T) {
+
+	Convey("When running in dry-run mode", t, func() {
+		awsConn := &ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById":   {"node1", "node1"},
+				"DescribeInstancesByTag": {"default"},
+				"DescribeAGByName":       {"one_undesired_host", "one_undesired_host", "one_undesired_host"},
+			},
+		}
+		plan := dryrun.NewPlan(clock.New())
+		client := NewDryRunClient(awsConn, plan)
+		ctx := context.Background()
+
+		Convey("mutating calls should be recorded instead of performed", func() {
+			client.SetASGInstanceProtection(ctx, aws.String("some-Autoscaling-Group"), []*string{aws.String("i-34719eb8")})
+			client.RemoveASGInstanceProtection(ctx, aws.String("some-Autoscaling-Group"), aws.String("i-34719eb8"))
+			client.SetInstanceTag(ctx, "DEATH_NODE_MARK", "1190995200", "i-34719eb8")
+			client.PutLifeCycleHook(ctx, "some-Autoscaling-Group", &LifecycleHook{
+				Name: DefaultLifecycleHookName, HeartbeatTimeout: 3600, DefaultResult: LifecycleActionContinue})
+			client.RecordLifecycleActionHeartbeat(ctx, aws.String("some-Autoscaling-Group"), aws.String("i-34719eb8"),
+				DefaultLifecycleHookName)
+			client.CompleteLifecycleAction(ctx, aws.String("some-Autoscaling-Group"), aws.String("i-34719eb8"),
+				DefaultLifecycleHookName, LifecycleActionContinue)
+			So(awsConn.Requests, ShouldBeEmpty)
+			So(plan.Operations(), ShouldResemble, []string{
+				"SetASGInstanceProtection", "RemoveASGInstanceProtection", "SetInstanceTag",
+				"PutLifeCycleHook", "RecordLifecycleActionHeartbeat", "CompleteLifecycleAction",
+			})
+			So(plan.Actions()[2].Params["instanceId"], ShouldEqual, "i-34719eb8")
+		})
+		Convey("after tagging an instance", func() {
+			client.SetInstanceTag(ctx, "DEATH_NODE_MARK", "1190995200", "i-34719eb8")
+			Convey("describing it should return the tag", func() {
+				instance, _ := client.DescribeInstanceByID(ctx, "i-34719eb8")
+				So(instance.Tags, ShouldHaveLength, 1)
+				So(*instance.Tags[0].Value, ShouldEqual, "1190995200")
+			})
+			Convey("it should be returned when describing instances by tag", func() {
+				instances, _ := client.DescribeInstancesByTag(ctx, "DEATH_NODE_MARK")
+				So(instances, ShouldHaveLength, 1)
+				So(*instances[0].InstanceId, ShouldEqual, "i-34719eb8")
+			})
+			Convey("and deleting the tag, it should not be returned anymore", func() {
+				client.DeleteInstanceTag(ctx, "DEATH_NODE_MARK", "i-34719eb8")
+				instance, _ := client.DescribeInstanceByID(ctx, "i-34719eb8")
+				So(instance.Tags, ShouldBeEmpty)
+				instances, _ := client.DescribeInstancesByTag(ctx, "DEATH_NODE_MARK")
+				So(instances, ShouldBeEmpty)
+			})
+		})
+		Convey("after setting the desired capacity", func() {
+			client.SetDesiredCapacity(ctx, aws.String("some-Autoscaling-Group"), 1)
+			Convey("describing the autoscaling group should return it", func() {
+				groups, _ := client.DescribeAGsByPrefix(ctx, "some-Autoscaling-Group")
+				So(*groups[0].DesiredCapacity, ShouldEqual, 1)
+			})
+		})
+		Convey("after setting the autoscaling group protection", func() {
+			client.SetASGInstanceProtection(ctx, aws.String("some-Autoscaling-Group"),
+				[]*string{aws.String("i-34719eb8"), aws.String("i-446a73cf"), aws.String("i-ab7ca923")})
+			groups, _ := client.DescribeAGsByPrefix(ctx, "some-Autoscaling-Group")
+			Convey("the group and its instances should be protected", func() {
+				So(*groups[0].NewInstancesProtectedFromScaleIn, ShouldBeTrue)
+				for _, instance := range groups[0].Instances {
+					So(*instance.ProtectedFromScaleIn, ShouldBeTrue)
+					So(*instance.LifecycleState, ShouldEqual, "InService")
+				}
+			})
+			Convey("and removing an instance protection", func() {
+				client.RemoveASGInstanceProtection(ctx, aws.String("some-Autoscaling-Group"), aws.String("i-34719eb8"))
+				groups, _ := client.DescribeAGsByPrefix(ctx, "some-Autoscaling-Group")
+				Convey("AWS should start terminating it, as the group is over its desired capacity", func() {
+					So(*groups[0].Instances[0].InstanceId, ShouldEqual, "i-34719eb8")
+					So(*groups[0].Instances[0].LifecycleState, ShouldEqual, "Terminating:Wait")
+					So(*groups[0].Instances[1].LifecycleState, ShouldEqual, "InService")
+				})
+				Convey("and completing its lifecycle action, it should disappear from the group", func() {
+					client.CompleteLifecycleAction(ctx, aws.String("some-Autoscaling-Group"), aws.String("i-34719eb8"),
+						DefaultLifecycleHookName, LifecycleActionContinue)
+					groups, _ := client.DescribeAGsByPrefix(ctx, "some-Autoscaling-Group")
+					So(groups[0].Instances, ShouldHaveLength, 2)
+					So(*groups[0].Instances[0].InstanceId, ShouldEqual, "i-446a73cf")
+				})
+			})
+		})
+		Convey("after putting a lifecycle hook, it should be reported as set", func() {
+			client.PutLifeCycleHook(ctx, "some-Autoscaling-Group", &LifecycleHook{
+				Name: DefaultLifecycleHookName, HeartbeatTimeout: 3600, DefaultResult: LifecycleActionContinue})
+			lifecycleHooks, _ := client.DescribeLifeCycleHooks(ctx, "some-Autoscaling-Group")
+			So(lifecycleHooks, ShouldHaveLength, 1)
+			So(lifecycleHooks[0].HeartbeatTimeout, ShouldEqual, 3600)
+		})
+	})
+}
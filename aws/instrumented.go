@@ -0,0 +1,149 @@
+package aws
+
+import (
+	"context"
+	"github.com/alanbover/deathnode/metrics"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"time"
+)
+
+// InstrumentedClient decorates a ClientInterface, measuring the latency and errors of every call
+type InstrumentedClient struct {
+	client ClientInterface
+}
+
+// NewInstrumentedClient returns a new InstrumentedClient object
+func NewInstrumentedClient(client ClientInterface) *InstrumentedClient {
+	return &InstrumentedClient{client: client}
+}
+
+func observe(call string, start time.Time, err error) {
+
+	metrics.APICallDuration.Observe(time.Since(start).Seconds(), "aws", call)
+	if err != nil {
+		metrics.APICallErrors.Inc("aws", call)
+	}
+}
+
+// DescribeInstanceByID measures the call to the decorated client
+func (c *InstrumentedClient) DescribeInstanceByID(ctx context.Context, instanceID string) (*ec2.Instance, error) {
+
+	start := time.Now()
+	instance, err := c.client.DescribeInstanceByID(ctx, instanceID)
+	observe("DescribeInstanceByID", start, err)
+	return instance, err
+}
+
+// DescribeInstancesByTag measures the call to the decorated client
+func (c *InstrumentedClient) DescribeInstancesByTag(ctx context.Context, tagKey string) ([]*ec2.Instance, error) {
+
+	start := time.Now()
+	instances, err := c.client.DescribeInstancesByTag(ctx, tagKey)
+	observe("DescribeInstancesByTag", start, err)
+	return instances, err
+}
+
+// DescribeAGsByPrefix measures the call to the decorated client
+func (c *InstrumentedClient) DescribeAGsByPrefix(ctx context.Context, autoscalingGroupPrefix string) ([]*autoscaling.Group, error) {
+
+	start := time.Now()
+	autoscalingGroups, err := c.client.DescribeAGsByPrefix(ctx, autoscalingGroupPrefix)
+	observe("DescribeAGsByPrefix", start, err)
+	return autoscalingGroups, err
+}
+
+// DescribeLifeCycleHooks measures the call to the decorated client
+func (c *InstrumentedClient) DescribeLifeCycleHooks(ctx context.Context, autoscalingGroupName string) (
+	[]*LifecycleHook, error) {
+
+	start := time.Now()
+	lifecycleHooks, err := c.client.DescribeLifeCycleHooks(ctx, autoscalingGroupName)
+	observe("DescribeLifeCycleHooks", start, err)
+	return lifecycleHooks, err
+}
+
+// RemoveASGInstanceProtection measures the call to the decorated client
+func (c *InstrumentedClient) RemoveASGInstanceProtection(ctx context.Context, autoscalingGroupName, instanceID *string) error {
+
+	start := time.Now()
+	err := c.client.RemoveASGInstanceProtection(ctx, autoscalingGroupName, instanceID)
+	observe("RemoveASGInstanceProtection", start, err)
+	return err
+}
+
+// SetASGInstanceProtection measures the call to the decorated client
+func (c *InstrumentedClient) SetASGInstanceProtection(ctx context.Context, autoscalingGroupName *string, instanceIDs []*string) error {
+
+	start := time.Now()
+	err := c.client.SetASGInstanceProtection(ctx, autoscalingGroupName, instanceIDs)
+	observe("SetASGInstanceProtection", start, err)
+	return err
+}
+
+// SetInstanceTag measures the call to the decorated client
+func (c *InstrumentedClient) SetInstanceTag(ctx context.Context, key, value, instanceID string) error {
+
+	start := time.Now()
+	err := c.client.SetInstanceTag(ctx, key, value, instanceID)
+	observe("SetInstanceTag", start, err)
+	return err
+}
+
+// PutLifeCycleHook measures the call to the decorated client
+func (c *InstrumentedClient) PutLifeCycleHook(ctx context.Context, autoscalingGroupName string,
+	lifecycleHook *LifecycleHook) error {
+
+	start := time.Now()
+	err := c.client.PutLifeCycleHook(ctx, autoscalingGroupName, lifecycleHook)
+	observe("PutLifeCycleHook", start, err)
+	return err
+}
+
+// DeleteLifeCycleHook measures the call to the decorated client
+func (c *InstrumentedClient) DeleteLifeCycleHook(ctx context.Context, autoscalingGroupName,
+	lifecycleHookName string) error {
+
+	start := time.Now()
+	err := c.client.DeleteLifeCycleHook(ctx, autoscalingGroupName, lifecycleHookName)
+	observe("DeleteLifeCycleHook", start, err)
+	return err
+}
+
+// CompleteLifecycleAction measures the call to the decorated client
+func (c *InstrumentedClient) CompleteLifecycleAction(ctx context.Context, autoscalingGroupName, instanceID *string,
+	lifecycleHookName, result string) error {
+
+	start := time.Now()
+	err := c.client.CompleteLifecycleAction(ctx, autoscalingGroupName, instanceID, lifecycleHookName, result)
+	observe("CompleteLifecycleAction", start, err)
+	return err
+}
+
+// RecordLifecycleActionHeartbeat measures the call to the decorated client
+func (c *InstrumentedClient) RecordLifecycleActionHeartbeat(ctx context.Context, autoscalingGroupName, instanceID *string,
+	lifecycleHookName string) error {
+
+	start := time.Now()
+	err := c.client.RecordLifecycleActionHeartbeat(ctx, autoscalingGroupName, instanceID, lifecycleHookName)
+	observe("RecordLifecycleActionHeartbeat", start, err)
+	return err
+}
+
+// DeleteInstanceTag measures the call to the decorated client
+func (c *InstrumentedClient) DeleteInstanceTag(ctx context.Context, key, instanceID string) error {
+
+	start := time.Now()
+	err := c.client.DeleteInstanceTag(ctx, key, instanceID)
+	observe("DeleteInstanceTag", start, err)
+	return err
+}
+
+// SetDesiredCapacity measures the call to the decorated client
+func (c *InstrumentedClient) SetDesiredCapacity(ctx context.Context, autoscalingGroupName *string, desiredCapacity int64) error {
+
+	start := time.Now()
+	err := c.client.SetDesiredCapacity(ctx, autoscalingGroupName, desiredCapacity)
+	observe("SetDesiredCapacity", start, err)
+	return err
+}
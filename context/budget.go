@@ -0,0 +1,79 @@
+package context
+
+// Drain budgets, limiting how fast the instances of an autoscaling group, or of the whole cluster, are removed
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DrainBudget limits the instances that can be draining at once, and the lifecycle completions per time window.
+// Limits are an absolute count ("2") or a percentage of the desired capacity ("10%"). Empty limits are unlimited
+type DrainBudget struct {
+	MaxDraining             string `json:"maxDraining"`
+	MaxCompletions          string `json:"maxCompletions"`
+	CompletionWindowSeconds int    `json:"completionWindow"`
+}
+
+// Unlimited is the value returned by BudgetLimit for empty limits
+const Unlimited = -1
+
+// BudgetLimit returns the number of instances a limit allows, given the desired capacity it applies to.
+// Percentages are rounded down, but always allow at least one instance so the budget can't block the drains forever
+func BudgetLimit(limit string, desiredCapacity int64) int {
+
+	if limit == "" {
+		return Unlimited
+	}
+
+	if strings.HasSuffix(limit, "%") {
+		percentage, _ := strconv.Atoi(strings.TrimSuffix(limit, "%"))
+		allowed := int(desiredCapacity) * percentage / 100
+		if allowed < 1 {
+			return 1
+		}
+		return allowed
+	}
+
+	allowed, _ := strconv.Atoi(limit)
+	return allowed
+}
+
+// Validate checks that the limits are a count or a percentage, and that the completions have a time window
+func (b *DrainBudget) Validate() error {
+
+	for _, limit := range []string{b.MaxDraining, b.MaxCompletions} {
+		if err := validateBudgetLimit(limit); err != nil {
+			return err
+		}
+	}
+
+	if b.CompletionWindowSeconds < 0 {
+		return fmt.Errorf("Negative drain budget completionWindow %d", b.CompletionWindowSeconds)
+	}
+	if b.MaxCompletions != "" && b.CompletionWindowSeconds == 0 {
+		return fmt.Errorf("Drain budget maxCompletions %s requires a completionWindow", b.MaxCompletions)
+	}
+	return nil
+}
+
+func validateBudgetLimit(limit string) error {
+
+	if limit == "" {
+		return nil
+	}
+
+	if strings.HasSuffix(limit, "%") {
+		percentage, err := strconv.Atoi(strings.TrimSuffix(limit, "%"))
+		if err != nil || percentage < 0 || percentage > 100 {
+			return fmt.Errorf("Invalid drain budget percentage %s", limit)
+		}
+		return nil
+	}
+
+	if count, err := strconv.Atoi(limit); err != nil || count < 0 {
+		return fmt.Errorf("Invalid drain budget %s, expected a count or a percentage", limit)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package context
+
+// Reads the declarative configuration file, with the global policy and the per autoscaling group prefix overrides
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/alanbover/deathnode/aws"
+	"github.com/alanbover/deathnode/notify"
+	"io/ioutil"
+)
+
+// configFile is the format of the configuration file. Settings present in the file replace the ones given
+// as flags
+type configFile struct {
+	DeathNodeMark            *string                   `json:"deathNodeMark"`
+	AutoscalingGroupPrefixes []string                  `json:"autoscalingGroupPrefixes"`
+	Defaults                 PolicyOverride            `json:"defaults"`
+	Overrides                map[string]PolicyOverride `json:"overrides"`
+	DrainStuckThreshold      *int                      `json:"drainStuckThreshold"`
+	Webhooks                 []notify.Webhook          `json:"webhooks"`
+	ClusterDrainBudget       *DrainBudget              `json:"clusterDrainBudget"`
+	MaintenanceLead          *int                      `json:"maintenanceLeadTime"`
+	MaintenanceDuration      *int                      `json:"maintenanceDuration"`
+	DrainStrategy            *string                   `json:"drainStrategy"`
+	DrainGracePeriod         *int                      `json:"drainGracePeriod"`
+	DrainMarkGone            *bool                     `json:"drainMarkGone"`
+}
+
+// Heartbeat timeouts accepted by AWS for the lifecycle hooks
+const (
+	minLifecycleTimeout = 30
+	maxLifecycleTimeout = 7200
+)
+
+// LoadConfigFile reads the configuration file in path and applies it over conf
+func LoadConfigFile(path string, conf *ApplicationConf) error {
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	config := configFile{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return fmt.Errorf("Invalid config file %s: %s", path, err)
+	}
+
+	if config.DeathNodeMark != nil {
+		conf.DeathNodeMark = *config.DeathNodeMark
+	}
+	if config.AutoscalingGroupPrefixes != nil {
+		conf.AutoscalingGroupPrefixes = config.AutoscalingGroupPrefixes
+	}
+
+	defaults := conf.Policy("")
+	config.Defaults.apply(&defaults)
+	conf.ConstraintsType = defaults.ConstraintsType
+	conf.RecommenderType = defaults.RecommenderType
+	conf.ProtectedFrameworks = defaults.ProtectedFrameworks
+	conf.ProtectedTasksLabels = defaults.ProtectedTasksLabels
+	conf.DelayDeleteSeconds = defaults.DelayDeleteSeconds
+	conf.ResetLifecycle = defaults.ResetLifecycle
+	conf.HonourInverseOffers = defaults.HonourInverseOffers
+	conf.DrainBudget = defaults.DrainBudget
+	conf.MaxDrainSeconds = defaults.MaxDrainSeconds
+	conf.DrainDeadlinePolicy = defaults.DrainDeadlinePolicy
+	conf.LifecycleHookName = defaults.LifecycleHookName
+	conf.LifecycleTimeout = defaults.LifecycleTimeout
+	conf.LifecycleDefault = defaults.LifecycleDefault
+
+	conf.PolicyOverrides = config.Overrides
+
+	if config.DrainStuckThreshold != nil {
+		conf.DrainStuckThresholdSeconds = *config.DrainStuckThreshold
+	}
+	if config.Webhooks != nil {
+		conf.Webhooks = config.Webhooks
+	}
+	if config.ClusterDrainBudget != nil {
+		conf.ClusterDrainBudget = *config.ClusterDrainBudget
+	}
+	if config.MaintenanceLead != nil {
+		conf.MaintenanceLeadSeconds = *config.MaintenanceLead
+	}
+	if config.MaintenanceDuration != nil {
+		conf.MaintenanceDurationSeconds = *config.MaintenanceDuration
+	}
+	if config.DrainStrategy != nil {
+		conf.DrainStrategy = *config.DrainStrategy
+	}
+	if config.DrainGracePeriod != nil {
+		conf.DrainGracePeriodSeconds = *config.DrainGracePeriod
+	}
+	if config.DrainMarkGone != nil {
+		conf.DrainMarkGone = *config.DrainMarkGone
+	}
+	return conf.Validate()
+}
+
+// Validate checks that the configuration has all the required settings, and that the overrides are consistent
+func (c *ApplicationConf) Validate() error {
+
+	if len(c.AutoscalingGroupPrefixes) < 1 {
+		return fmt.Errorf("at least one autoscalingGroupName flag is required")
+	}
+
+	if len(c.ProtectedFrameworks) < 1 {
+		return fmt.Errorf("at least one registeredFramework flag is required")
+	}
+
+	if len(c.ConstraintsType) < 1 {
+		return fmt.Errorf("at least one constraintsType flag is required")
+	}
+
+	for _, webhook := range c.Webhooks {
+		if err := webhook.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.MaintenanceLeadSeconds < 0 || c.MaintenanceDurationSeconds < 0 {
+		return fmt.Errorf("Negative maintenanceLeadTime or maintenanceDuration found")
+	}
+
+	switch c.MesosAPI {
+	case "", MesosAPILegacy, MesosAPIV1:
+	default:
+		return fmt.Errorf("mesosApi must be legacy or v1")
+	}
+
+	switch c.DrainStrategy {
+	case "", DrainStrategyMaintenance, DrainStrategyDrainAgent:
+	default:
+		return fmt.Errorf("Invalid drainStrategy %s found", c.DrainStrategy)
+	}
+	if c.DrainStrategy == DrainStrategyDrainAgent && c.MesosAPI != MesosAPIV1 {
+		return fmt.Errorf("drainStrategy drainAgent requires mesosApi v1")
+	}
+	if c.DrainGracePeriodSeconds < 0 {
+		return fmt.Errorf("Negative drainGracePeriod found")
+	}
+	if c.MesosStateMaxAgeSeconds < 0 {
+		return fmt.Errorf("Negative mesosStateMaxAge found")
+	}
+
+	for autoscalingGroupPrefix := range c.PolicyOverrides {
+		if !c.isMonitored(autoscalingGroupPrefix) {
+			return fmt.Errorf("Override found for autoscalingGroupPrefix %s, which is not monitored",
+				autoscalingGroupPrefix)
+		}
+	}
+
+	for _, autoscalingGroupPrefix := range append([]string{""}, c.AutoscalingGroupPrefixes...) {
+		policy := c.Policy(autoscalingGroupPrefix)
+		if policy.DelayDeleteSeconds < 0 {
+			return fmt.Errorf("Negative delayDelete found for autoscalingGroupPrefix %s", autoscalingGroupPrefix)
+		}
+		if policy.MaxDrainSeconds < 0 {
+			return fmt.Errorf("Negative maxDrain found for autoscalingGroupPrefix %s", autoscalingGroupPrefix)
+		}
+		switch policy.DrainDeadlinePolicy {
+		case "", DrainDeadlineContinue, DrainDeadlineAbandon, DrainDeadlineWait:
+		default:
+			return fmt.Errorf("Invalid drainDeadlinePolicy %s found for autoscalingGroupPrefix %s",
+				policy.DrainDeadlinePolicy, autoscalingGroupPrefix)
+		}
+		if policy.LifecycleTimeout != 0 &&
+			(policy.LifecycleTimeout < minLifecycleTimeout || policy.LifecycleTimeout > maxLifecycleTimeout) {
+			return fmt.Errorf("Invalid lifecycleTimeout %d found for autoscalingGroupPrefix %s, expected %d to %d",
+				policy.LifecycleTimeout, autoscalingGroupPrefix, minLifecycleTimeout, maxLifecycleTimeout)
+		}
+		switch policy.LifecycleDefault {
+		case "", aws.LifecycleActionContinue, aws.LifecycleActionAbandon:
+		default:
+			return fmt.Errorf("Invalid lifecycleDefaultResult %s found for autoscalingGroupPrefix %s",
+				policy.LifecycleDefault, autoscalingGroupPrefix)
+		}
+		if err := policy.DrainBudget.Validate(); err != nil {
+			return fmt.Errorf("%s for autoscalingGroupPrefix %s", err, autoscalingGroupPrefix)
+		}
+	}
+	return c.ClusterDrainBudget.Validate()
+}
+
+func (c *ApplicationConf) isMonitored(autoscalingGroupPrefix string) bool {
+
+	for _, monitored := range c.AutoscalingGroupPrefixes {
+		if monitored == autoscalingGroupPrefix {
+			return true
+		}
+	}
+	return false
+}
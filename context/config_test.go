@@ -0,0 +1,202 @@
+package context
+
+import (
+	"github.com/alanbover/deathnode/aws"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+
+	Convey("When loading a config file", t, func() {
+		conf := newTestConf()
+		err := LoadConfigFile("testdata/config.json", &conf)
+		So(err, ShouldBeNil)
+
+		Convey("the monitored prefixes should be the ones in the file", func() {
+			So(conf.AutoscalingGroupPrefixes, ShouldResemble, arrayFlags{"stateful", "stateless"})
+		})
+		Convey("a prefix without overrides should get the defaults from the file", func() {
+			policy := conf.Policy("stateful")
+			So(policy.ConstraintsType, ShouldResemble, []string{"protectedConstraint"})
+			So(policy.ProtectedFrameworks, ShouldResemble, []string{"frameworkName1"})
+			So(policy.DelayDeleteSeconds, ShouldEqual, 600)
+			Convey("and the flag values for the settings missing in the file", func() {
+				So(policy.RecommenderType, ShouldEqual, "firstAvailableAgent")
+				So(policy.ProtectedTasksLabels, ShouldResemble, []string{"DEATHNODE_PROTECTED"})
+				So(policy.ResetLifecycle, ShouldBeFalse)
+				So(policy.HonourInverseOffers, ShouldBeFalse)
+			})
+		})
+		Convey("a prefix with overrides should get them on top of the defaults", func() {
+			policy := conf.Policy("stateless")
+			So(policy.ConstraintsType, ShouldResemble, []string{"noContraint"})
+			So(policy.RecommenderType, ShouldEqual, "smallestInstanceId")
+			So(policy.DelayDeleteSeconds, ShouldEqual, 0)
+			So(policy.ResetLifecycle, ShouldBeTrue)
+			So(policy.HonourInverseOffers, ShouldBeTrue)
+			So(policy.ProtectedFrameworks, ShouldResemble, []string{"frameworkName1"})
+		})
+	})
+
+	Convey("When loading a config file with webhooks", t, func() {
+		conf := newTestConf()
+		So(LoadConfigFile("testdata/config.json", &conf), ShouldBeNil)
+
+		Convey("the webhooks, the drain stuck threshold and the maintenance window should be set", func() {
+			So(conf.Webhooks, ShouldHaveLength, 1)
+			So(conf.Webhooks[0].Events, ShouldResemble, []string{"DRAIN_STUCK"})
+			So(conf.DrainStuckThresholdSeconds, ShouldEqual, 3600)
+			So(conf.MaintenanceLeadSeconds, ShouldEqual, 900)
+			So(conf.MaintenanceDurationSeconds, ShouldEqual, 7200)
+		})
+	})
+
+	Convey("When loading a config file with drain budgets", t, func() {
+		conf := newTestConf()
+		So(LoadConfigFile("testdata/config.json", &conf), ShouldBeNil)
+
+		Convey("the prefixes should get the default budget and the cluster one should be set", func() {
+			So(conf.Policy("stateless").DrainBudget, ShouldResemble, DrainBudget{MaxDraining: "10%"})
+			So(conf.ClusterDrainBudget, ShouldResemble,
+				DrainBudget{MaxCompletions: "5", CompletionWindowSeconds: 3600})
+		})
+	})
+
+	Convey("When loading a config file with an invalid drain budget", t, func() {
+		conf := newTestConf()
+		Convey("it should fail", func() {
+			So(LoadConfigFile("testdata/invalid_budget.json", &conf), ShouldNotBeNil)
+		})
+	})
+
+	Convey("When loading a config file with a drain strategy", t, func() {
+		conf := newTestConf()
+		So(LoadConfigFile("testdata/config.json", &conf), ShouldBeNil)
+
+		Convey("the strategy, its grace period and mark gone should be set", func() {
+			So(conf.DrainStrategy, ShouldEqual, DrainStrategyDrainAgent)
+			So(conf.DrainGracePeriodSeconds, ShouldEqual, 300)
+			So(conf.DrainMarkGone, ShouldBeTrue)
+		})
+	})
+
+	Convey("When loading a config file with the drainAgent strategy for the legacy Mesos API", t, func() {
+		conf := newTestConf()
+		conf.MesosAPI = MesosAPILegacy
+		Convey("it should fail", func() {
+			So(LoadConfigFile("testdata/config.json", &conf), ShouldNotBeNil)
+		})
+	})
+
+	Convey("When loading a config file with an invalid drain strategy", t, func() {
+		conf := newTestConf()
+		Convey("it should fail", func() {
+			So(LoadConfigFile("testdata/invalid_drain_strategy.json", &conf), ShouldNotBeNil)
+		})
+	})
+
+	Convey("When loading a config file with an invalid drain deadline policy", t, func() {
+		conf := newTestConf()
+		Convey("it should fail", func() {
+			So(LoadConfigFile("testdata/invalid_deadline_policy.json", &conf), ShouldNotBeNil)
+		})
+	})
+
+	Convey("When loading a config file with lifecycle hook settings", t, func() {
+		conf := newTestConf()
+		So(LoadConfigFile("testdata/config.json", &conf), ShouldBeNil)
+
+		Convey("a prefix without them should get the default lifecycle hook", func() {
+			So(conf.Policy("stateful").LifecycleHook(), ShouldResemble,
+				&aws.LifecycleHook{Name: "DEATHNODE", HeartbeatTimeout: 3600, DefaultResult: "CONTINUE",
+					Metadata: aws.LifecycleHookMetadata})
+		})
+		Convey("a prefix with overrides should get its own timeout and default result", func() {
+			So(conf.Policy("stateless").LifecycleHook(), ShouldResemble,
+				&aws.LifecycleHook{Name: "DEATHNODE", HeartbeatTimeout: 300, DefaultResult: "ABANDON",
+					Metadata: aws.LifecycleHookMetadata})
+		})
+	})
+
+	Convey("When loading a config file with an invalid lifecycle timeout", t, func() {
+		conf := newTestConf()
+		Convey("it should fail", func() {
+			So(LoadConfigFile("testdata/invalid_lifecycle_timeout.json", &conf), ShouldNotBeNil)
+		})
+	})
+
+	Convey("When loading a config file with an invalid webhook", t, func() {
+		conf := newTestConf()
+		Convey("it should fail", func() {
+			So(LoadConfigFile("testdata/invalid_webhook.json", &conf), ShouldNotBeNil)
+		})
+	})
+
+	Convey("When loading a config file that removes a required setting", t, func() {
+		conf := newTestConf()
+		Convey("it should fail", func() {
+			So(LoadConfigFile("testdata/no_prefixes.json", &conf), ShouldNotBeNil)
+		})
+	})
+
+	Convey("When loading a config file with overrides for a prefix not monitored", t, func() {
+		conf := newTestConf()
+		Convey("it should fail", func() {
+			So(LoadConfigFile("testdata/unknown_prefix.json", &conf), ShouldNotBeNil)
+		})
+	})
+
+	Convey("When loading a config file with unknown settings", t, func() {
+		conf := newTestConf()
+		Convey("it should fail", func() {
+			So(LoadConfigFile("testdata/unknown_field.json", &conf), ShouldNotBeNil)
+		})
+	})
+
+	Convey("When loading a config file that doesn't exist", t, func() {
+		conf := newTestConf()
+		Convey("it should fail", func() {
+			So(LoadConfigFile("testdata/missing.json", &conf), ShouldNotBeNil)
+		})
+	})
+}
+
+func newTestConf() ApplicationConf {
+
+	return ApplicationConf{
+		DeathNodeMark:        "DEATH_NODE_MARK",
+		ConstraintsType:      []string{"noContraint"},
+		RecommenderType:      "firstAvailableAgent",
+		ProtectedFrameworks:  []string{"frameworkName2"},
+		ProtectedTasksLabels: []string{"DEATHNODE_PROTECTED"},
+		MesosAPI:             MesosAPIV1,
+	}
+}
+
+func TestBudgetLimit(t *testing.T) {
+
+	Convey("When computing the instances a drain budget allows", t, func() {
+		Convey("an empty limit should be unlimited", func() {
+			So(BudgetLimit("", 10), ShouldEqual, Unlimited)
+		})
+		Convey("a count should be used as is", func() {
+			So(BudgetLimit("2", 10), ShouldEqual, 2)
+		})
+		Convey("a percentage should be rounded down over the desired capacity", func() {
+			So(BudgetLimit("25%", 10), ShouldEqual, 2)
+		})
+		Convey("a percentage should allow at least one instance", func() {
+			So(BudgetLimit("10%", 5), ShouldEqual, 1)
+		})
+	})
+
+	Convey("When validating a drain budget", t, func() {
+		Convey("completions without a time window should fail", func() {
+			So((&DrainBudget{MaxCompletions: "1"}).Validate(), ShouldNotBeNil)
+		})
+		Convey("a limit that is not a count or a percentage should fail", func() {
+			So((&DrainBudget{MaxDraining: "two"}).Validate(), ShouldNotBeNil)
+		})
+	})
+}
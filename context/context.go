@@ -1,29 +1,74 @@
 package context
 
 import (
+	"github.com/alanbover/deathnode/audit"
 	"github.com/alanbover/deathnode/aws"
+	"github.com/alanbover/deathnode/election"
 	"github.com/alanbover/deathnode/mesos"
+	"github.com/alanbover/deathnode/notify"
+	"github.com/alanbover/deathnode/state"
 	"github.com/benbjohnson/clock"
 )
 
-// ApplicationConf stores the application configurations
+// ApplicationConf stores the application configurations. The policy settings are the global ones, use Policy
+// to get the ones for an autoscaling group prefix
 type ApplicationConf struct {
-	ConstraintsType          arrayFlags
-	RecommenderType          string
-	DeathNodeMark            string
-	AutoscalingGroupPrefixes arrayFlags
-	ProtectedFrameworks      arrayFlags
-	ProtectedTasksLabels     arrayFlags
-	DelayDeleteSeconds       int
-	ResetLifecycle           bool
+	ConstraintsType            arrayFlags
+	RecommenderType            string
+	DeathNodeMark              string
+	AutoscalingGroupPrefixes   arrayFlags
+	ProtectedFrameworks        arrayFlags
+	ProtectedTasksLabels       arrayFlags
+	DelayDeleteSeconds         int
+	ResetLifecycle             bool
+	HonourInverseOffers        bool
+	LeaderLeaseTTLSeconds      int
+	PolicyOverrides            map[string]PolicyOverride
+	Webhooks                   []notify.Webhook
+	DrainStuckThresholdSeconds int
+	DrainBudget                DrainBudget
+	ClusterDrainBudget         DrainBudget
+	MaxDrainSeconds            int
+	DrainDeadlinePolicy        string
+	LifecycleHookName          string
+	LifecycleTimeout           int
+	LifecycleDefault           string
+	MaintenanceLeadSeconds     int
+	MaintenanceDurationSeconds int
+	DrainStrategy              string
+	DrainGracePeriodSeconds    int
+	DrainMarkGone              bool
+	MesosStateMaxAgeSeconds    int
+	MesosAPI                   string
 }
 
-// ApplicationContext stores the application configurations and both AWS and Mesos connections
+// Strategies to drain the Mesos agents of the instances marked to be removed. An empty strategy uses the
+// maintenance schedule
+const (
+	DrainStrategyMaintenance = "maintenance"
+	DrainStrategyDrainAgent  = "drainAgent"
+)
+
+// Mesos master APIs. An empty API uses the legacy endpoints
+const (
+	MesosAPILegacy = "legacy"
+	MesosAPIV1     = "v1"
+)
+
+// ApplicationContext stores the application configurations and both AWS and Mesos connections.
+// Elector is nil when leader election is disabled, Audit when the audit log is disabled, Notifier when
+// webhook notifications are disabled and Store when the state isn't persisted
 type ApplicationContext struct {
 	Conf      ApplicationConf
 	AwsConn   aws.ClientInterface
 	MesosConn mesos.ClientInterface
-	Clock     clock.Clock
+	// MesosEvents follows the event stream of the Mesos master, to keep the cache up to date. Polled if nil
+	MesosEvents mesos.Subscriber
+	Elector     election.Elector
+	Audit       *audit.Logger
+	Notifier    *notify.Notifier
+	Store       state.Store
+	Clock       clock.Clock
 }
 
 type arrayFlags []string
@@ -0,0 +1,139 @@
+package context
+
+import (
+	"github.com/alanbover/deathnode/aws"
+)
+
+// Policy stores the settings that decide how the instances of an autoscaling group prefix are drained
+type Policy struct {
+	ConstraintsType      []string
+	RecommenderType      string
+	ProtectedFrameworks  []string
+	ProtectedTasksLabels []string
+	DelayDeleteSeconds   int
+	ResetLifecycle       bool
+	HonourInverseOffers  bool
+	DrainBudget          DrainBudget
+	MaxDrainSeconds      int
+	DrainDeadlinePolicy  string
+	LifecycleHookName    string
+	LifecycleTimeout     int
+	LifecycleDefault     string
+}
+
+// Escalation policies applied when the drain of an instance lasts more than MaxDrainSeconds, or its lifecycle
+// action is close to the AWS global timeout. An empty policy waits
+const (
+	DrainDeadlineContinue = "CONTINUE"
+	DrainDeadlineAbandon  = "ABANDON"
+	DrainDeadlineWait     = "WAIT"
+)
+
+// PolicyOverride stores the settings to override for an autoscaling group prefix. Unset fields (nil) keep
+// the global value
+type PolicyOverride struct {
+	ConstraintsType      []string     `json:"constraintsType"`
+	RecommenderType      *string      `json:"recommenderType"`
+	ProtectedFrameworks  []string     `json:"protectedFrameworks"`
+	ProtectedTasksLabels []string     `json:"protectedTaskLabels"`
+	DelayDeleteSeconds   *int         `json:"delayDelete"`
+	ResetLifecycle       *bool        `json:"resetLifecycle"`
+	HonourInverseOffers  *bool        `json:"honourInverseOffers"`
+	DrainBudget          *DrainBudget `json:"drainBudget"`
+	MaxDrainSeconds      *int         `json:"maxDrain"`
+	DrainDeadlinePolicy  *string      `json:"drainDeadlinePolicy"`
+	LifecycleHookName    *string      `json:"lifecycleHookName"`
+	LifecycleTimeout     *int         `json:"lifecycleTimeout"`
+	LifecycleDefault     *string      `json:"lifecycleDefaultResult"`
+}
+
+// Policy returns the effective policy for an autoscaling group prefix: the global settings, with the prefix
+// overrides applied on top
+func (c *ApplicationConf) Policy(autoscalingGroupPrefix string) Policy {
+
+	policy := Policy{
+		ConstraintsType:      c.ConstraintsType,
+		RecommenderType:      c.RecommenderType,
+		ProtectedFrameworks:  c.ProtectedFrameworks,
+		ProtectedTasksLabels: c.ProtectedTasksLabels,
+		DelayDeleteSeconds:   c.DelayDeleteSeconds,
+		ResetLifecycle:       c.ResetLifecycle,
+		HonourInverseOffers:  c.HonourInverseOffers,
+		DrainBudget:          c.DrainBudget,
+		MaxDrainSeconds:      c.MaxDrainSeconds,
+		DrainDeadlinePolicy:  c.DrainDeadlinePolicy,
+		LifecycleHookName:    c.LifecycleHookName,
+		LifecycleTimeout:     c.LifecycleTimeout,
+		LifecycleDefault:     c.LifecycleDefault,
+	}
+
+	if override, ok := c.PolicyOverrides[autoscalingGroupPrefix]; ok {
+		override.apply(&policy)
+	}
+	return policy
+}
+
+func (o *PolicyOverride) apply(policy *Policy) {
+
+	if o.ConstraintsType != nil {
+		policy.ConstraintsType = o.ConstraintsType
+	}
+	if o.RecommenderType != nil {
+		policy.RecommenderType = *o.RecommenderType
+	}
+	if o.ProtectedFrameworks != nil {
+		policy.ProtectedFrameworks = o.ProtectedFrameworks
+	}
+	if o.ProtectedTasksLabels != nil {
+		policy.ProtectedTasksLabels = o.ProtectedTasksLabels
+	}
+	if o.DelayDeleteSeconds != nil {
+		policy.DelayDeleteSeconds = *o.DelayDeleteSeconds
+	}
+	if o.ResetLifecycle != nil {
+		policy.ResetLifecycle = *o.ResetLifecycle
+	}
+	if o.HonourInverseOffers != nil {
+		policy.HonourInverseOffers = *o.HonourInverseOffers
+	}
+	if o.DrainBudget != nil {
+		policy.DrainBudget = *o.DrainBudget
+	}
+	if o.MaxDrainSeconds != nil {
+		policy.MaxDrainSeconds = *o.MaxDrainSeconds
+	}
+	if o.DrainDeadlinePolicy != nil {
+		policy.DrainDeadlinePolicy = *o.DrainDeadlinePolicy
+	}
+	if o.LifecycleHookName != nil {
+		policy.LifecycleHookName = *o.LifecycleHookName
+	}
+	if o.LifecycleTimeout != nil {
+		policy.LifecycleTimeout = *o.LifecycleTimeout
+	}
+	if o.LifecycleDefault != nil {
+		policy.LifecycleDefault = *o.LifecycleDefault
+	}
+}
+
+// LifecycleHook returns the lifecycle hook the autoscaling groups of the policy should have. Unset settings
+// take the deathnode defaults
+func (p Policy) LifecycleHook() *aws.LifecycleHook {
+
+	lifecycleHook := &aws.LifecycleHook{
+		Name:             p.LifecycleHookName,
+		HeartbeatTimeout: int64(p.LifecycleTimeout),
+		DefaultResult:    p.LifecycleDefault,
+		Metadata:         aws.LifecycleHookMetadata,
+	}
+	if lifecycleHook.Name == "" {
+		lifecycleHook.Name = aws.DefaultLifecycleHookName
+	}
+	if lifecycleHook.HeartbeatTimeout == 0 {
+		lifecycleHook.HeartbeatTimeout = aws.DefaultLifecycleHeartbeatTimeout
+	}
+	if lifecycleHook.DefaultResult == "" {
+		lifecycleHook.DefaultResult = aws.LifecycleActionContinue
+	}
+	return lifecycleHook
+}
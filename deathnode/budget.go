@@ -0,0 +1,108 @@
+package deathnode
+
+// Drain budgets of the autoscaling groups and of the whole cluster. The instances marked to be removed count as
+// draining until they leave the autoscaling group, and the completions are counted from the persisted state
+
+import (
+	"github.com/alanbover/deathnode/context"
+	"github.com/alanbover/deathnode/monitor"
+	"time"
+)
+
+// remainingDrains returns how many more instances of the autoscaling group can be marked to be removed, given its
+// budget and the cluster one, or context.Unlimited
+func (n *Notebook) remainingDrains(autoscalingMonitor *monitor.AutoscalingGroupMonitor) int {
+	return minRemaining(n.autoscalingGroupRemainingDrains(autoscalingMonitor), n.clusterRemainingDrains())
+}
+
+// remainingCompletions returns how many more lifecycle actions can be completed on the autoscaling group, given its
+// budget and the cluster one, or context.Unlimited
+func (n *Notebook) remainingCompletions(autoscalingMonitor *monitor.AutoscalingGroupMonitor) int {
+	return minRemaining(n.autoscalingGroupRemainingCompletions(autoscalingMonitor), n.clusterRemainingCompletions())
+}
+
+func (n *Notebook) autoscalingGroupRemainingDrains(autoscalingMonitor *monitor.AutoscalingGroupMonitor) int {
+
+	limit := context.BudgetLimit(autoscalingMonitor.Policy().DrainBudget.MaxDraining, autoscalingMonitor.DesiredCapacity())
+	return remaining(limit, numMarked(autoscalingMonitor))
+}
+
+func (n *Notebook) clusterRemainingDrains() int {
+
+	marked := 0
+	for _, autoscalingMonitor := range n.autoscalingGroups.GetAutoscalingGroupMonitorsList() {
+		marked += numMarked(autoscalingMonitor)
+	}
+	return remaining(context.BudgetLimit(n.ctx.Conf.ClusterDrainBudget.MaxDraining, n.clusterDesiredCapacity()), marked)
+}
+
+func (n *Notebook) autoscalingGroupRemainingCompletions(autoscalingMonitor *monitor.AutoscalingGroupMonitor) int {
+
+	budget := autoscalingMonitor.Policy().DrainBudget
+	limit := context.BudgetLimit(budget.MaxCompletions, autoscalingMonitor.DesiredCapacity())
+	since := n.ctx.Clock.Now().Add(-time.Duration(budget.CompletionWindowSeconds) * time.Second)
+	return remaining(limit, n.state.CompletionsSince(autoscalingMonitor.AutoscalingGroupName(), since))
+}
+
+func (n *Notebook) clusterRemainingCompletions() int {
+
+	budget := n.ctx.Conf.ClusterDrainBudget
+	limit := context.BudgetLimit(budget.MaxCompletions, n.clusterDesiredCapacity())
+	since := n.ctx.Clock.Now().Add(-time.Duration(budget.CompletionWindowSeconds) * time.Second)
+	return remaining(limit, n.state.CompletionsSince("", since))
+}
+
+func (n *Notebook) clusterDesiredCapacity() int64 {
+
+	desiredCapacity := int64(0)
+	for _, autoscalingMonitor := range n.autoscalingGroups.GetAutoscalingGroupMonitorsList() {
+		desiredCapacity += autoscalingMonitor.DesiredCapacity()
+	}
+	return desiredCapacity
+}
+
+// pruneCompletions forgets the completions older than the longest completion window configured
+func (n *Notebook) pruneCompletions() {
+
+	window := n.ctx.Conf.ClusterDrainBudget.CompletionWindowSeconds
+	for _, autoscalingGroupPrefix := range n.ctx.Conf.AutoscalingGroupPrefixes {
+		policy := n.ctx.Conf.Policy(autoscalingGroupPrefix)
+		if policy.DrainBudget.CompletionWindowSeconds > window {
+			window = policy.DrainBudget.CompletionWindowSeconds
+		}
+	}
+	n.state.PruneCompletions(n.ctx.Clock.Now().Add(-time.Duration(window) * time.Second))
+}
+
+func numMarked(autoscalingMonitor *monitor.AutoscalingGroupMonitor) int {
+
+	marked := 0
+	for _, instanceMonitor := range autoscalingMonitor.GetAllInstances() {
+		if instanceMonitor.IsMarkedToBeRemoved() {
+			marked++
+		}
+	}
+	return marked
+}
+
+func remaining(limit, used int) int {
+
+	if limit == context.Unlimited {
+		return context.Unlimited
+	}
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
+
+func minRemaining(a, b int) int {
+
+	if a == context.Unlimited {
+		return b
+	}
+	if b == context.Unlimited || a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,82 @@
+package deathnode
+
+import (
+	gocontext "context"
+	"github.com/alanbover/deathnode/audit"
+	"github.com/alanbover/deathnode/context"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestDrainBudget(t *testing.T) {
+
+	Convey("When an autoscaling group scales in by two instances", t, func() {
+		watcher, plan, sink := newDrainWatcher()
+		autoscalingGroupName := "some-Autoscaling-Group"
+		watcher.ctx.AwsConn.SetDesiredCapacity(gocontext.Background(), &autoscalingGroupName, 1)
+
+		Convey("with a budget of one instance draining at once", func() {
+			watcher.ctx.Conf.DrainBudget = context.DrainBudget{MaxDraining: "1"}
+			watcher.Run(gocontext.Background())
+
+			Convey("only one instance should be marked", func() {
+				So(actionParams(plan, "SetInstanceTag"), ShouldHaveLength, 1)
+				So(sink.Events(audit.MarkDeferred), ShouldHaveLength, 1)
+			})
+			Convey("the status should show the budget exhausted", func() {
+				budget := watcher.Status().AutoscalingGroupPrefixes[0].AutoscalingGroups[0].DrainBudget
+				So(*budget.RemainingDrains, ShouldEqual, 0)
+				So(budget.RemainingCompletions, ShouldBeNil)
+			})
+		})
+		Convey("with a cluster budget of one instance draining at once", func() {
+			watcher.ctx.Conf.ClusterDrainBudget = context.DrainBudget{MaxDraining: "50%"}
+			watcher.Run(gocontext.Background())
+
+			Convey("only one instance should be marked", func() {
+				So(actionParams(plan, "SetInstanceTag"), ShouldHaveLength, 1)
+				So(*watcher.Status().ClusterDrainBudget.RemainingDrains, ShouldEqual, 0)
+			})
+		})
+		Convey("with the cluster budget unlimited", func() {
+			watcher.Run(gocontext.Background())
+
+			Convey("the status should not show any budget", func() {
+				So(watcher.Status().ClusterDrainBudget, ShouldResemble, BudgetStatus{})
+			})
+		})
+	})
+
+	Convey("When an instance is waiting to be terminated", t, func() {
+		watcher, plan, sink := newDrainWatcher()
+		autoscalingGroupName := "some-Autoscaling-Group"
+		watcher.ctx.AwsConn.SetDesiredCapacity(gocontext.Background(), &autoscalingGroupName, 2)
+
+		Convey("with a budget of one completion per hour already used", func() {
+			watcher.ctx.Conf.DrainBudget = context.DrainBudget{MaxCompletions: "1", CompletionWindowSeconds: 3600}
+			watcher.notebook.state.RecordCompletion(autoscalingGroupName, watcher.ctx.Clock.Now())
+			watcher.Run(gocontext.Background())
+			watcher.Run(gocontext.Background())
+
+			Convey("its lifecycle action should not be completed", func() {
+				So(actionParams(plan, "SetInstanceTag"), ShouldHaveLength, 1)
+				So(actionParams(plan, "CompleteLifecycleAction"), ShouldBeEmpty)
+				So(sink.Events(audit.DestroySkipped), ShouldNotBeEmpty)
+				budget := watcher.Status().AutoscalingGroupPrefixes[0].AutoscalingGroups[0].DrainBudget
+				So(*budget.RemainingCompletions, ShouldEqual, 0)
+			})
+		})
+		Convey("with a budget of one completion per hour used before the window", func() {
+			watcher.ctx.Conf.DrainBudget = context.DrainBudget{MaxCompletions: "1", CompletionWindowSeconds: 3600}
+			watcher.notebook.state.RecordCompletion(autoscalingGroupName, watcher.ctx.Clock.Now().Add(-2*time.Hour))
+			watcher.Run(gocontext.Background())
+			watcher.Run(gocontext.Background())
+
+			Convey("its lifecycle action should be completed", func() {
+				So(actionParams(plan, "CompleteLifecycleAction"), ShouldHaveLength, 1)
+				So(watcher.notebook.state.Completions[autoscalingGroupName], ShouldHaveLength, 1)
+			})
+		})
+	})
+}
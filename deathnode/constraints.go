@@ -48,7 +48,7 @@ func (c *protectedConstraint) filter(instanceMonitors []*monitor.InstanceMonitor
 
 	filteredInstanceMonitors := []*monitor.InstanceMonitor{}
 	for _, instanceMonitor := range instanceMonitors {
-		if !mesosMonitor.IsProtected(instanceMonitor.IP()) {
+		if !mesosMonitor.IsProtected(instanceMonitor.IP(), instanceMonitor.Policy()) {
 			filteredInstanceMonitors = append(filteredInstanceMonitors, instanceMonitor)
 		}
 	}
@@ -1,10 +1,12 @@
 package deathnode
 
 import (
+	gocontext "context"
 	"github.com/alanbover/deathnode/aws"
 	"github.com/alanbover/deathnode/context"
 	"github.com/alanbover/deathnode/mesos"
 	"github.com/alanbover/deathnode/monitor"
+	"github.com/benbjohnson/clock"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
 )
@@ -53,7 +55,7 @@ func TestProtectedConstraint(t *testing.T) {
 			},
 		}
 		instanceMonitor, mesosMonitor := prepareMonitorsForConstraints(awsConn, mesosConn, []string{"frameworkName1"})
-		mesosMonitor.Refresh()
+		mesosMonitor.Refresh(gocontext.Background())
 
 		constraint, _ := newConstraint("protectedConstraint")
 		Convey("it should filter instances with protectedLabels or protectedFrameworks", func() {
@@ -80,7 +82,7 @@ func TestFilterFrameworkContraint(t *testing.T) {
 			},
 		}
 		instanceMonitor, mesosMonitor := prepareMonitorsForConstraints(awsConn, mesosConn, []string{"frameworkName1", "frameworkName2"})
-		mesosMonitor.Refresh()
+		mesosMonitor.Refresh(gocontext.Background())
 
 		Convey("it should filter instances with tasks running those frameworks", func() {
 			constraint, _ := newConstraint("filterFrameworkConstraint=frameworkName2")
@@ -111,7 +113,7 @@ func TestTaskNameRegexpConstraint(t *testing.T) {
 			},
 		}
 		instanceMonitor, mesosMonitor := prepareMonitorsForConstraints(awsConn, mesosConn, []string{"frameworkName1", "frameworkName2"})
-		mesosMonitor.Refresh()
+		mesosMonitor.Refresh(gocontext.Background())
 
 		Convey("it should filter instances with tasks running those frameworks", func() {
 			constraint, _ := newConstraint("taskNameRegexpConstraint=.*ask1")
@@ -126,6 +128,7 @@ func prepareMonitorsForConstraints(
 	*monitor.AutoscalingGroupMonitor, *monitor.MesosMonitor) {
 
 	ctx := &context.ApplicationContext{
+		Clock:     clock.New(),
 		AwsConn:   awsConn,
 		MesosConn: mesosConn,
 		Conf: context.ApplicationConf{
@@ -136,6 +139,6 @@ func prepareMonitorsForConstraints(
 	}
 
 	autoscalingGroups := monitor.NewAutoscalingServiceMonitor(ctx)
-	autoscalingGroups.Refresh()
+	autoscalingGroups.Refresh(gocontext.Background())
 	return autoscalingGroups.GetAutoscalingGroupMonitorsList()[0], monitor.NewMesosMonitor(ctx)
 }
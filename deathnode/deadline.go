@@ -0,0 +1,140 @@
+package deathnode
+
+// Drain deadlines, escalating the drains that last too long or whose lifecycle action is about to time out in AWS
+
+import (
+	gocontext "context"
+	"github.com/alanbover/deathnode/audit"
+	"github.com/alanbover/deathnode/aws"
+	"github.com/alanbover/deathnode/context"
+	"github.com/alanbover/deathnode/monitor"
+	"github.com/alanbover/deathnode/notify"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Reasons for a drain deadline to expire
+const (
+	deadlineMaxDrain      = "maxDrain"
+	deadlineGlobalTimeout = "lifecycleGlobalTimeout"
+)
+
+// drainDeadlineExpired returns why the drain of the instance is over its deadline, or empty if it's not. The drain
+// is measured from the first mark, as the heartbeats re-tag the instance
+func (n *Notebook) drainDeadlineExpired(instanceMonitor *monitor.InstanceMonitor) string {
+
+	drain := n.state.Drain(*instanceMonitor.InstanceID())
+	maxDrainSeconds := instanceMonitor.Policy().MaxDrainSeconds
+	if maxDrainSeconds > 0 && n.ctx.Clock.Since(drain.MarkedAt).Seconds() > float64(maxDrainSeconds) {
+		return deadlineMaxDrain
+	}
+
+	// AWS applies the hook default result once the global timeout expires, whatever the heartbeats sent
+	heartbeatTimeout := instanceMonitor.Policy().LifecycleHook().HeartbeatTimeout
+	globalTimeout := time.Duration(monitor.LifeCycleGlobalTimeout(heartbeatTimeout)) * time.Second
+	maxSecondsWaiting := globalTimeout.Seconds() * monitor.LifeCycleRefreshTimeoutPercentage
+	if !drain.TerminatingWaitAt.IsZero() && n.ctx.Clock.Since(drain.TerminatingWaitAt).Seconds() > maxSecondsWaiting {
+		return deadlineGlobalTimeout
+	}
+	return ""
+}
+
+// escalateDrain applies the drain deadline policy of the instance, notifying it only once. It returns true when
+// the drain is over, because its lifecycle action has been completed or the instance has been restored
+func (n *Notebook) escalateDrain(runCtx gocontext.Context, instanceMonitor *monitor.InstanceMonitor,
+	reason string) (bool, error) {
+
+	policy := instanceMonitor.Policy().DrainDeadlinePolicy
+	drain := n.state.Drain(*instanceMonitor.InstanceID())
+	if drain.EscalatedAt.IsZero() {
+		log.Warnf("Drain deadline of instance %s expired (%s), applying policy %s",
+			*instanceMonitor.InstanceID(), reason, policy)
+		drain.EscalatedAt = n.ctx.Clock.Now()
+		n.ctx.Audit.Record(audit.DrainEscalated, *instanceMonitor.AutoscalingGroupID(), *instanceMonitor.InstanceID(),
+			map[string]interface{}{
+				"reason":             reason,
+				"policy":             policy,
+				"secondsSinceMarked": n.ctx.Clock.Since(drain.MarkedAt).Seconds(),
+			})
+		n.notify(notify.DrainEscalated, instanceMonitor)
+	}
+
+	switch policy {
+	case context.DrainDeadlineContinue:
+		if instanceMonitor.LifecycleState() != monitor.LifecycleStateTerminatingWait {
+			return false, nil
+		}
+		return true, n.destroyInstance(runCtx, instanceMonitor, aws.LifecycleActionContinue)
+	case context.DrainDeadlineAbandon:
+		// Completing the lifecycle action would terminate the instance anyway, and restoring a scale-in mark
+		// would override the desired capacity set by the autoscaling group owner
+		if instanceMonitor.DrainReason() != monitor.DrainReasonManual {
+			n.recordNotRestored(instanceMonitor, "scaleIn")
+			return false, nil
+		}
+		if instanceMonitor.IsTerminating() {
+			n.recordNotRestored(instanceMonitor, "terminating")
+			return false, nil
+		}
+		return n.restoreInstance(runCtx, instanceMonitor)
+	}
+	return false, nil
+}
+
+// recordNotRestored notifies, only once, that the drain of the instance can't be abandoned. It keeps waiting as
+// with the WAIT policy
+func (n *Notebook) recordNotRestored(instanceMonitor *monitor.InstanceMonitor, reason string) {
+
+	drain := n.state.Drain(*instanceMonitor.InstanceID())
+	if !drain.NotRestoredAt.IsZero() {
+		return
+	}
+	log.Warnf("Unable to restore instance %s (%s), waiting for its drain", *instanceMonitor.InstanceID(), reason)
+	drain.NotRestoredAt = n.ctx.Clock.Now()
+	n.ctx.Audit.Record(audit.DrainNotRestored, *instanceMonitor.AutoscalingGroupID(), *instanceMonitor.InstanceID(),
+		map[string]interface{}{"reason": reason})
+}
+
+// restoreInstance gives a manually drained instance its instance protection back, and raises the desired capacity
+// of its autoscaling group back from its current value. The instance is unmarked, so it leaves the Mesos
+// maintenance schedule on the next run. It returns false if AWS started terminating the instance before its
+// protection was set
+func (n *Notebook) restoreInstance(runCtx gocontext.Context, instanceMonitor *monitor.InstanceMonitor) (bool, error) {
+
+	instanceID := *instanceMonitor.InstanceID()
+	if !instanceMonitor.IsProtected() {
+		if err := instanceMonitor.SetInstanceProtection(runCtx); err != nil {
+			return false, err
+		}
+	}
+	if err := n.autoscalingGroups.Refresh(runCtx); err != nil {
+		return false, err
+	}
+	instanceMonitor, err := n.autoscalingGroups.GetInstanceByID(instanceID)
+	if err != nil {
+		return false, err
+	}
+	if instanceMonitor.IsTerminating() {
+		n.recordNotRestored(instanceMonitor, "terminating")
+		return false, nil
+	}
+
+	autoscalingMonitor, err := n.autoscalingGroups.GetAutoscalingGroupMonitor(*instanceMonitor.AutoscalingGroupID())
+	if err != nil {
+		return false, err
+	}
+	desiredCapacity := autoscalingMonitor.DesiredCapacity() + 1
+	if err := autoscalingMonitor.SetDesiredCapacity(runCtx, desiredCapacity); err != nil {
+		return false, err
+	}
+
+	log.Infof("Restoring instance %s, its drain deadline expired", instanceID)
+	if err := instanceMonitor.UntagToBeRemoved(runCtx); err != nil {
+		return false, err
+	}
+	n.forgetDrain(instanceID)
+	n.ctx.Audit.Record(audit.DrainCancelled, *instanceMonitor.AutoscalingGroupID(), instanceID,
+		map[string]interface{}{"reason": "drainDeadlineExpired", "desiredCapacity": desiredCapacity})
+	n.notify(notify.DrainCancelled, instanceMonitor)
+	return true, nil
+}
@@ -0,0 +1,147 @@
+package deathnode
+
+import (
+	gocontext "context"
+	"github.com/alanbover/deathnode/audit"
+	"github.com/alanbover/deathnode/context"
+	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestDrainDeadline(t *testing.T) {
+
+	Convey("When an instance waiting to be terminated is blocked for more than maxDrain", t, func() {
+		watcher, plan, sink := newDrainWatcher()
+		clockMock := clock.NewMock()
+		clockMock.Set(time.Unix(1500000000, 0))
+		watcher.ctx.Clock = clockMock
+		watcher.ctx.Conf.MaxDrainSeconds = 3600
+		watcher.ctx.Conf.DrainBudget = context.DrainBudget{MaxCompletions: "0", CompletionWindowSeconds: 3600}
+
+		autoscalingGroupName := "some-Autoscaling-Group"
+		watcher.ctx.AwsConn.SetDesiredCapacity(gocontext.Background(), &autoscalingGroupName, 2)
+		watcher.Run(gocontext.Background())
+		watcher.Run(gocontext.Background())
+		So(actionParams(plan, "CompleteLifecycleAction"), ShouldBeEmpty)
+		clockMock.Add(2 * time.Hour)
+
+		Convey("with the CONTINUE policy, its lifecycle action should be completed", func() {
+			watcher.ctx.Conf.DrainDeadlinePolicy = context.DrainDeadlineContinue
+			watcher.Run(gocontext.Background())
+			So(actionParams(plan, "CompleteLifecycleAction"), ShouldHaveLength, 1)
+			So(actionParams(plan, "CompleteLifecycleAction")[0]["result"], ShouldEqual, "CONTINUE")
+			So(sink.Events(audit.DrainEscalated), ShouldHaveLength, 1)
+		})
+		Convey("with the ABANDON policy, it should keep waiting as it can't be restored", func() {
+			watcher.ctx.Conf.DrainDeadlinePolicy = context.DrainDeadlineAbandon
+			watcher.Run(gocontext.Background())
+			watcher.Run(gocontext.Background())
+			So(actionParams(plan, "CompleteLifecycleAction"), ShouldBeEmpty)
+			So(sink.Events(audit.DrainNotRestored), ShouldHaveLength, 1)
+			So(sink.Events(audit.DrainCancelled), ShouldBeEmpty)
+		})
+		Convey("with the WAIT policy, it should be escalated only once", func() {
+			watcher.ctx.Conf.DrainDeadlinePolicy = context.DrainDeadlineWait
+			watcher.Run(gocontext.Background())
+			watcher.Run(gocontext.Background())
+			So(actionParams(plan, "CompleteLifecycleAction"), ShouldBeEmpty)
+			So(sink.Events(audit.DrainEscalated), ShouldHaveLength, 1)
+		})
+	})
+
+	Convey("When a scale-in drain lasts more than maxDrain before AWS starts terminating the instance", t, func() {
+		watcher, plan, sink := newDrainWatcher()
+		clockMock := clock.NewMock()
+		clockMock.Set(time.Unix(1500000000, 0))
+		watcher.ctx.Clock = clockMock
+		watcher.ctx.Conf.MaxDrainSeconds = 3600
+		watcher.ctx.Conf.DrainDeadlinePolicy = context.DrainDeadlineAbandon
+
+		// Mark the instance without running the destroy attempt, which would remove its instance protection
+		autoscalingGroupName := "some-Autoscaling-Group"
+		watcher.ctx.AwsConn.SetDesiredCapacity(gocontext.Background(), &autoscalingGroupName, 2)
+		watcher.autoscalingServiceMonitor.Refresh(gocontext.Background())
+		watcher.mesosMonitor.Refresh(gocontext.Background())
+		watcher.TagInstancesToBeRemoved(gocontext.Background(), watcher.autoscalingServiceMonitor.GetAutoscalingGroupMonitorsList()[0])
+		So(sink.Events(audit.CandidateSelection), ShouldHaveLength, 1)
+		clockMock.Add(2 * time.Hour)
+		watcher.Run(gocontext.Background())
+
+		Convey("with the ABANDON policy, it should keep waiting without overriding the desired capacity", func() {
+			So(sink.Events(audit.DrainNotRestored), ShouldHaveLength, 1)
+			So(sink.Events(audit.DrainNotRestored)[0].Details["reason"], ShouldEqual, "scaleIn")
+			So(sink.Events(audit.DrainCancelled), ShouldBeEmpty)
+			So(actionParams(plan, "SetDesiredCapacity"), ShouldHaveLength, 1)
+			So(actionParams(plan, "SetASGInstanceProtection"), ShouldBeEmpty)
+		})
+	})
+
+	Convey("When an instance is close to the AWS lifecycle global timeout", t, func() {
+		watcher, plan, sink := newDrainWatcher()
+		clockMock := clock.NewMock()
+		clockMock.Set(time.Unix(1500000000, 0))
+		watcher.ctx.Clock = clockMock
+		watcher.ctx.Conf.DrainBudget = context.DrainBudget{MaxCompletions: "0", CompletionWindowSeconds: 3600}
+		watcher.ctx.Conf.DrainDeadlinePolicy = context.DrainDeadlineContinue
+
+		autoscalingGroupName := "some-Autoscaling-Group"
+		watcher.ctx.AwsConn.SetDesiredCapacity(gocontext.Background(), &autoscalingGroupName, 2)
+		watcher.Run(gocontext.Background())
+		watcher.Run(gocontext.Background())
+		clockMock.Add(40 * time.Hour)
+		watcher.Run(gocontext.Background())
+
+		Convey("the drain deadline policy should be applied", func() {
+			So(actionParams(plan, "CompleteLifecycleAction"), ShouldHaveLength, 1)
+			So(sink.Events(audit.DrainEscalated)[0].Details["reason"], ShouldEqual, deadlineGlobalTimeout)
+		})
+	})
+
+	Convey("When a manual drain lasts more than maxDrain before AWS starts terminating the instance", t, func() {
+		watcher, plan, sink := newDrainWatcher()
+		clockMock := clock.NewMock()
+		clockMock.Set(time.Unix(1500000000, 0))
+		watcher.ctx.Clock = clockMock
+		watcher.ctx.Conf.MaxDrainSeconds = 3600
+		watcher.ctx.Conf.DrainDeadlinePolicy = context.DrainDeadlineAbandon
+
+		So(watcher.Drain(gocontext.Background(), "i-34719eb8"), ShouldBeNil)
+		clockMock.Add(2 * time.Hour)
+		watcher.Run(gocontext.Background())
+
+		Convey("with the ABANDON policy, it should be restored", func() {
+			So(actionParams(plan, "RemoveASGInstanceProtection"), ShouldBeEmpty)
+			So(actionParams(plan, "DeleteInstanceTag"), ShouldHaveLength, 2)
+			So(actionParams(plan, "SetDesiredCapacity")[1]["desiredCapacity"], ShouldEqual, "3")
+			So(sink.Events(audit.DrainCancelled), ShouldHaveLength, 1)
+			watcher.Run(gocontext.Background())
+			So(actionParams(plan, "CompleteLifecycleAction"), ShouldBeEmpty)
+		})
+	})
+
+	Convey("When a manual drain lasts more than maxDrain once AWS is waiting for its lifecycle action", t, func() {
+		watcher, plan, sink := newDrainWatcher()
+		clockMock := clock.NewMock()
+		clockMock.Set(time.Unix(1500000000, 0))
+		watcher.ctx.Clock = clockMock
+		watcher.ctx.Conf.MaxDrainSeconds = 3600
+		watcher.ctx.Conf.DrainBudget = context.DrainBudget{MaxCompletions: "0", CompletionWindowSeconds: 3600}
+		watcher.ctx.Conf.DrainDeadlinePolicy = context.DrainDeadlineAbandon
+
+		So(watcher.Drain(gocontext.Background(), "i-34719eb8"), ShouldBeNil)
+		watcher.Run(gocontext.Background())
+		watcher.Run(gocontext.Background())
+		clockMock.Add(2 * time.Hour)
+		watcher.Run(gocontext.Background())
+
+		Convey("with the ABANDON policy, its lifecycle action should not be completed", func() {
+			So(actionParams(plan, "CompleteLifecycleAction"), ShouldBeEmpty)
+			So(actionParams(plan, "SetDesiredCapacity"), ShouldHaveLength, 1)
+			So(sink.Events(audit.DrainCancelled), ShouldBeEmpty)
+			So(sink.Events(audit.DrainNotRestored), ShouldHaveLength, 1)
+			So(sink.Events(audit.DrainNotRestored)[0].Details["reason"], ShouldEqual, "terminating")
+		})
+	})
+}
@@ -0,0 +1,184 @@
+package deathnode
+
+// Drains requested by an operator for a specific instance, on top of the ones decided on scale-in
+
+import (
+	gocontext "context"
+	"errors"
+	"github.com/alanbover/deathnode/audit"
+	"github.com/alanbover/deathnode/monitor"
+	"github.com/alanbover/deathnode/notify"
+	log "github.com/sirupsen/logrus"
+)
+
+// Errors returned by Drain and CancelDrain
+var (
+	ErrNotLeader        = errors.New("this replica is not the leader")
+	ErrInstanceNotFound = errors.New("instance not found in the monitored autoscaling groups")
+	ErrAlreadyMarked    = errors.New("instance already marked to be removed")
+	ErrNotManualDrain   = errors.New("instance not drained manually")
+	ErrTerminating      = errors.New("instance already being terminated")
+)
+
+// Drain marks an instance to be removed by request of an operator, tagging it with the manual drain reason and
+// decrementing the desired capacity of its autoscaling group. Either both changes are done or none of them.
+// The instance is then drained and terminated as any other instance marked to be removed
+func (y *Watcher) Drain(runCtx gocontext.Context, instanceID string) error {
+
+	y.mutex.Lock()
+	defer y.mutex.Unlock()
+
+	runCtx, cancel, err := y.leadOperation(runCtx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	instanceMonitor, autoscalingMonitor, err := y.findInstance(instanceID)
+	if err != nil {
+		return err
+	}
+	if instanceMonitor.IsMarkedToBeRemoved() {
+		return ErrAlreadyMarked
+	}
+
+	// The desired capacity is decremented from its current value, not the one of the last run
+	if err := y.autoscalingServiceMonitor.Refresh(runCtx); err != nil {
+		return err
+	}
+	if instanceMonitor, autoscalingMonitor, err = y.findInstance(instanceID); err != nil {
+		return err
+	}
+	if instanceMonitor.IsMarkedToBeRemoved() {
+		return ErrAlreadyMarked
+	}
+
+	desiredCapacity := autoscalingMonitor.DesiredCapacity() - 1
+	log.Infof("Draining instance %s by request, setting autoscaling group %s desired capacity to %d",
+		instanceID, autoscalingMonitor.AutoscalingGroupName(), desiredCapacity)
+
+	err = instanceMonitor.TagToBeRemoved(runCtx)
+	if err == nil {
+		err = instanceMonitor.TagDrainReason(runCtx, monitor.DrainReasonManual)
+	}
+	if err == nil {
+		err = autoscalingMonitor.SetDesiredCapacity(runCtx, desiredCapacity)
+	}
+	if err != nil {
+		log.Errorf("Unable to drain instance %s, reverting its tags: %s", instanceID, err)
+		if untagErr := instanceMonitor.UntagToBeRemoved(gocontext.Background()); untagErr != nil {
+			log.Errorf("Unable to untag instance %s: %s", instanceID, untagErr)
+		}
+		return err
+	}
+
+	y.notebook.recordDrain(instanceMonitor)
+	y.notebook.saveState()
+	y.ctx.Audit.Record(audit.DrainRequested, autoscalingMonitor.AutoscalingGroupName(), instanceID,
+		map[string]interface{}{"desiredCapacity": desiredCapacity})
+	y.notifyDrainStarted(instanceMonitor)
+	y.updateStatus()
+	return nil
+}
+
+// CancelDrain reverts a Drain, as long as AWS hasn't started terminating the instance yet. The instance gets
+// its instance protection back before checking it, so AWS can't pick it while the drain is cancelled. It leaves
+// the Mesos maintenance schedule on the next run
+func (y *Watcher) CancelDrain(runCtx gocontext.Context, instanceID string) error {
+
+	y.mutex.Lock()
+	defer y.mutex.Unlock()
+
+	runCtx, cancel, err := y.leadOperation(runCtx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	instanceMonitor, autoscalingMonitor, err := y.findInstance(instanceID)
+	if err != nil {
+		return err
+	}
+	if instanceMonitor.DrainReason() != monitor.DrainReasonManual {
+		return ErrNotManualDrain
+	}
+
+	if !instanceMonitor.IsProtected() {
+		if err := instanceMonitor.SetInstanceProtection(runCtx); err != nil {
+			return err
+		}
+	}
+	if err := y.autoscalingServiceMonitor.Refresh(runCtx); err != nil {
+		return err
+	}
+	if instanceMonitor, autoscalingMonitor, err = y.findInstance(instanceID); err != nil {
+		return err
+	}
+	if instanceMonitor.IsTerminating() {
+		return ErrTerminating
+	}
+
+	desiredCapacity := autoscalingMonitor.DesiredCapacity() + 1
+	log.Infof("Cancelling the drain of instance %s, setting autoscaling group %s desired capacity to %d",
+		instanceID, autoscalingMonitor.AutoscalingGroupName(), desiredCapacity)
+
+	if err := autoscalingMonitor.SetDesiredCapacity(runCtx, desiredCapacity); err != nil {
+		return err
+	}
+	if err := instanceMonitor.UntagToBeRemoved(runCtx); err != nil {
+		return err
+	}
+
+	y.notebook.forgetDrain(instanceID)
+	y.notebook.saveState()
+	y.ctx.Audit.Record(audit.DrainCancelled, autoscalingMonitor.AutoscalingGroupName(), instanceID,
+		map[string]interface{}{"reason": monitor.DrainReasonManual, "desiredCapacity": desiredCapacity})
+	y.notifyDrainCancelled(instanceMonitor)
+	y.updateStatus()
+	return nil
+}
+
+// leadOperation returns a context bound to the leadership lease, if leader election is enabled
+func (y *Watcher) leadOperation(runCtx gocontext.Context) (gocontext.Context, gocontext.CancelFunc, error) {
+
+	if y.ctx.Elector == nil {
+		return runCtx, func() {}, nil
+	}
+
+	leaseCtx, cancel, ok := y.lead(runCtx)
+	if !ok {
+		return nil, nil, ErrNotLeader
+	}
+	return leaseCtx, cancel, nil
+}
+
+func (y *Watcher) findInstance(instanceID string) (*monitor.InstanceMonitor, *monitor.AutoscalingGroupMonitor, error) {
+
+	instanceMonitor, err := y.autoscalingServiceMonitor.GetInstanceByID(instanceID)
+	if err != nil {
+		return nil, nil, ErrInstanceNotFound
+	}
+	autoscalingMonitor, err := y.autoscalingServiceMonitor.GetAutoscalingGroupMonitor(*instanceMonitor.AutoscalingGroupID())
+	if err != nil {
+		return nil, nil, ErrInstanceNotFound
+	}
+	return instanceMonitor, autoscalingMonitor, nil
+}
+
+func (y *Watcher) notifyDrainStarted(instanceMonitor *monitor.InstanceMonitor) {
+	y.notifyDrain(notify.DrainStarted, instanceMonitor)
+}
+
+func (y *Watcher) notifyDrainCancelled(instanceMonitor *monitor.InstanceMonitor) {
+	y.notifyDrain(notify.DrainCancelled, instanceMonitor)
+}
+
+func (y *Watcher) notifyDrain(eventType string, instanceMonitor *monitor.InstanceMonitor) {
+
+	y.ctx.Notifier.Notify(notify.Event{
+		Type:             eventType,
+		AutoscalingGroup: *instanceMonitor.AutoscalingGroupID(),
+		InstanceID:       *instanceMonitor.InstanceID(),
+		IP:               instanceMonitor.IP(),
+	})
+}
@@ -0,0 +1,131 @@
+package deathnode
+
+// Strategies to drain the Mesos agents of the instances marked to be removed, before completing their lifecycle
+// action
+
+import (
+	gocontext "context"
+	"fmt"
+	"github.com/alanbover/deathnode/audit"
+	"github.com/alanbover/deathnode/context"
+	"github.com/alanbover/deathnode/mesos"
+	"github.com/alanbover/deathnode/monitor"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+func newDrainStrategy(notebook *Notebook, name string) (drainStrategy, error) {
+
+	switch name {
+	case "", context.DrainStrategyMaintenance:
+		return &maintenanceStrategy{notebook: notebook}, nil
+	case context.DrainStrategyDrainAgent:
+		return &drainAgentStrategy{notebook: notebook}, nil
+	default:
+		return nil, fmt.Errorf("Drain strategy %v not found", name)
+	}
+}
+
+type drainStrategy interface {
+	// drainAgents starts draining the agents of the instances marked to be removed, on every run
+	drainAgents(runCtx gocontext.Context, instances []*ec2.Instance) error
+	// shouldWait returns true while the agent of the instance is not drained enough to be destroyed
+	shouldWait(instanceMonitor *monitor.InstanceMonitor) bool
+	// recordTermination is called once the lifecycle action of the instance is completed
+	recordTermination(instanceMonitor *monitor.InstanceMonitor)
+}
+
+// maintenanceStrategy puts the agents in the Mesos maintenance schedule, so the frameworks honouring inverse
+// offers move their tasks, and waits for the maintenance lead time
+type maintenanceStrategy struct {
+	notebook *Notebook
+}
+
+func (s *maintenanceStrategy) drainAgents(runCtx gocontext.Context, instances []*ec2.Instance) error {
+	return s.notebook.setAgentsInMaintenance(runCtx, instances)
+}
+
+func (s *maintenanceStrategy) shouldWait(instanceMonitor *monitor.InstanceMonitor) bool {
+	return s.notebook.shouldWaitForMaintenance(instanceMonitor)
+}
+
+func (s *maintenanceStrategy) recordTermination(instanceMonitor *monitor.InstanceMonitor) {
+	s.notebook.recordTermination(instanceMonitor)
+}
+
+// drainAgentStrategy drains the agents with DRAIN_AGENT, which kills their tasks within the grace period, and
+// waits until they are DRAINED. Requires Mesos 1.9 or newer
+type drainAgentStrategy struct {
+	notebook *Notebook
+	// agents are the agents registered in the master by IP, read on every run. nil if they couldn't be read
+	agents map[string]mesos.Slave
+}
+
+func (s *drainAgentStrategy) drainAgents(runCtx gocontext.Context, instances []*ec2.Instance) error {
+
+	n := s.notebook
+	// Release the machines left in the maintenance schedule by the maintenance strategy
+	n.releaseAgents(runCtx, map[string]string{})
+
+	agents, err := n.mesosMonitor.GetRegisteredAgents(runCtx)
+	s.agents = agents
+	if err != nil {
+		log.Warnf("Unable to read the drain state of the agents: %s", err)
+		return err
+	}
+
+	gracePeriod := time.Duration(n.ctx.Conf.DrainGracePeriodSeconds) * time.Second
+	for _, instance := range instances {
+		drain := n.state.Drain(*instance.InstanceId)
+		agent, registered := agents[*instance.PrivateIpAddress]
+		if !drain.AgentDrainRequestedAt.IsZero() || !registered {
+			continue
+		}
+
+		// The agent may be already drained by an operator, or by deathnode before losing its state
+		if agent.DrainInfo == nil {
+			log.Infof("Draining agent %s of instance %s", agent.Hostname, *instance.InstanceId)
+			err := n.mesosMonitor.DrainMesosAgent(runCtx, agent, gracePeriod, n.ctx.Conf.DrainMarkGone)
+			if err != nil {
+				log.Warnf("Unable to drain agent %s: %s", agent.Hostname, err)
+				continue
+			}
+		}
+		drain.AgentDrainRequestedAt = n.ctx.Clock.Now()
+		n.ctx.Audit.Record(audit.AgentDrainRequested, n.autoscalingGroupName(*instance.InstanceId),
+			*instance.InstanceId, map[string]interface{}{
+				"hostname":       agent.Hostname,
+				"ip":             *instance.PrivateIpAddress,
+				"maxGracePeriod": n.ctx.Conf.DrainGracePeriodSeconds,
+				"markGone":       n.ctx.Conf.DrainMarkGone,
+			})
+	}
+	return nil
+}
+
+// shouldWait waits until the agent is DRAINED, or not registered anymore, as it happens once marked gone
+func (s *drainAgentStrategy) shouldWait(instanceMonitor *monitor.InstanceMonitor) bool {
+
+	agentDrainState := "UNKNOWN"
+	if s.agents != nil {
+		agent, registered := s.agents[instanceMonitor.IP()]
+		if !registered {
+			return false
+		}
+		if agent.DrainInfo == nil {
+			agentDrainState = "NOT_DRAINING"
+		} else if agentDrainState = agent.DrainInfo.State; agentDrainState == mesos.DrainStateDrained {
+			return false
+		}
+	}
+
+	log.Debugf("Agent drain state is %s. Instance %s will not be destroyed", agentDrainState,
+		*instanceMonitor.InstanceID())
+	n := s.notebook
+	n.ctx.Audit.Record(audit.DestroySkipped, *instanceMonitor.AutoscalingGroupID(), *instanceMonitor.InstanceID(),
+		map[string]interface{}{"agentDrainState": agentDrainState})
+	return true
+}
+
+func (s *drainAgentStrategy) recordTermination(instanceMonitor *monitor.InstanceMonitor) {}
@@ -0,0 +1,79 @@
+package deathnode
+
+import (
+	gocontext "context"
+	"github.com/alanbover/deathnode/audit"
+	"github.com/alanbover/deathnode/context"
+	"github.com/alanbover/deathnode/mesos"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestDrainAgentStrategy(t *testing.T) {
+
+	Convey("When the agents are drained with DRAIN_AGENT", t, func() {
+		watcher, plan, sink := newDrainWatcher()
+		watcher.ctx.Conf.DrainStrategy = context.DrainStrategyDrainAgent
+		watcher.ctx.Conf.DrainGracePeriodSeconds = 300
+		watcher.ctx.Conf.DrainMarkGone = true
+		watcher.notebook.drainStrategy, _ = newDrainStrategy(watcher.notebook, context.DrainStrategyDrainAgent)
+
+		// Every run reads the agents twice: to refresh the cache and to get their drain state
+		watcher.ctx.MesosConn = mesos.NewDryRunClient(&mesos.ClientMock{
+			Records: map[string]*[]string{
+				"GetMesosFrameworks": {"default", "default", "default"},
+				"GetMesosSlaves":     {"default", "default", "default", "draining", "default", "drained"},
+				"GetMesosTasks":      {"notasks", "notasks", "notasks"},
+			},
+		}, plan)
+
+		autoscalingGroupName := "some-Autoscaling-Group"
+		watcher.ctx.AwsConn.SetDesiredCapacity(gocontext.Background(), &autoscalingGroupName, 2)
+		watcher.Run(gocontext.Background())
+
+		Convey("the agent should be drained with the grace period and mark gone", func() {
+			So(actionParams(plan, "DrainAgent"), ShouldHaveLength, 1)
+			So(actionParams(plan, "DrainAgent")[0]["maxGracePeriod"], ShouldEqual, "5m0s")
+			So(actionParams(plan, "DrainAgent")[0]["markGone"], ShouldEqual, "true")
+			So(actionParams(plan, "UpdateMaintenanceSchedule"), ShouldBeEmpty)
+			So(sink.Events(audit.AgentDrainRequested), ShouldHaveLength, 1)
+		})
+		Convey("the instance should not be destroyed while its agent is draining", func() {
+			watcher.Run(gocontext.Background())
+			So(actionParams(plan, "DrainAgent"), ShouldHaveLength, 1)
+			So(actionParams(plan, "CompleteLifecycleAction"), ShouldBeEmpty)
+			skipped := sink.Events(audit.DestroySkipped)
+			So(skipped[len(skipped)-1].Details["agentDrainState"], ShouldEqual, mesos.DrainStateDraining)
+
+			Convey("and it should be destroyed once its agent is drained", func() {
+				watcher.Run(gocontext.Background())
+				So(actionParams(plan, "CompleteLifecycleAction"), ShouldHaveLength, 1)
+			})
+		})
+	})
+
+	Convey("When the agents are drained with DRAIN_AGENT in dry run", t, func() {
+		watcher, plan, sink := newDrainWatcher()
+		watcher.ctx.Conf.DrainStrategy = context.DrainStrategyDrainAgent
+		watcher.notebook.drainStrategy, _ = newDrainStrategy(watcher.notebook, context.DrainStrategyDrainAgent)
+		watcher.ctx.MesosConn = mesos.NewDryRunClient(&mesos.ClientMock{
+			Records: map[string]*[]string{
+				"GetMesosFrameworks": {"default", "default"},
+				"GetMesosSlaves":     {"default", "default", "default", "default"},
+				"GetMesosTasks":      {"notasks", "notasks"},
+			},
+		}, plan)
+
+		autoscalingGroupName := "some-Autoscaling-Group"
+		watcher.ctx.AwsConn.SetDesiredCapacity(gocontext.Background(), &autoscalingGroupName, 2)
+		watcher.Run(gocontext.Background())
+		watcher.Run(gocontext.Background())
+
+		Convey("the drained agent should be simulated as draining", func() {
+			So(actionParams(plan, "DrainAgent"), ShouldHaveLength, 1)
+			So(actionParams(plan, "CompleteLifecycleAction"), ShouldBeEmpty)
+			skipped := sink.Events(audit.DestroySkipped)
+			So(skipped[len(skipped)-1].Details["agentDrainState"], ShouldEqual, mesos.DrainStateDraining)
+		})
+	})
+}
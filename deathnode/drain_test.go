@@ -0,0 +1,176 @@
+package deathnode
+
+import (
+	gocontext "context"
+	"github.com/alanbover/deathnode/audit"
+	"github.com/alanbover/deathnode/aws"
+	"github.com/alanbover/deathnode/dryrun"
+	"github.com/alanbover/deathnode/mesos"
+	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+// newDrainWatcher returns a watcher, already run once, over an autoscaling group without undesired instances.
+// The AWS and Mesos calls are simulated by the dry-run clients, so the changes are visible on the next runs
+func newDrainWatcher() (*Watcher, *dryrun.Plan, *audit.SinkMock) {
+
+	values := testCollectionValues{
+		awsConn: &aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById": {
+					"node1", "node2", "node3",
+					"node1_with_dns", "node1_with_dns", "node1_with_dns", "node1_with_dns", "node1_with_dns",
+				},
+				"DescribeInstancesByTag": {"default", "default", "default", "default", "default", "default"},
+				"DescribeAGByName":       {"default", "default", "default", "default", "default", "default", "default"},
+			},
+		},
+		mesosConn: &mesos.ClientMock{
+			Records: map[string]*[]string{
+				"GetMesosFrameworks": {"default", "default", "default", "default", "default", "default"},
+				"GetMesosSlaves":     {"default", "default", "default", "default", "default", "default"},
+				"GetMesosTasks":      {"notasks", "notasks", "notasks", "notasks", "notasks", "notasks"},
+			},
+		},
+	}
+	watcher := newWatcher(values)
+	sink := &audit.SinkMock{}
+	watcher.ctx.Audit = audit.NewLogger(sink, watcher.ctx.Clock)
+	plan := dryrun.NewPlan(clock.New())
+	watcher.ctx.AwsConn = aws.NewDryRunClient(values.awsConn, plan)
+	watcher.ctx.MesosConn = mesos.NewDryRunClient(values.mesosConn, plan)
+	watcher.Run(gocontext.Background())
+	return watcher, plan, sink
+}
+
+func actionParams(plan *dryrun.Plan, operation string) []map[string]string {
+
+	params := []map[string]string{}
+	for _, action := range plan.Actions() {
+		if action.Operation == operation {
+			params = append(params, action.Params)
+		}
+	}
+	return params
+}
+
+func TestDrain(t *testing.T) {
+
+	Convey("When draining an instance manually", t, func() {
+		watcher, plan, sink := newDrainWatcher()
+		err := watcher.Drain(gocontext.Background(), "i-34719eb8")
+
+		Convey("it should be tagged with the manual reason", func() {
+			So(err, ShouldBeNil)
+			tags := actionParams(plan, "SetInstanceTag")
+			So(tags, ShouldHaveLength, 2)
+			So(tags[0]["key"], ShouldEqual, "DEATH_NODE_MARK")
+			So(tags[1]["key"], ShouldEqual, "DEATH_NODE_MARK_REASON")
+			So(tags[1]["value"], ShouldEqual, "manual")
+			So(watcher.Status().AutoscalingGroupPrefixes[0].AutoscalingGroups[0].Instances[0].DrainReason,
+				ShouldEqual, "manual")
+			So(sink.Events(audit.DrainRequested), ShouldHaveLength, 1)
+		})
+		Convey("the desired capacity of its autoscaling group should be decremented", func() {
+			So(actionParams(plan, "SetDesiredCapacity"), ShouldResemble, []map[string]string{{
+				"autoscalingGroup": "some-Autoscaling-Group",
+				"desiredCapacity":  "2",
+			}})
+		})
+		Convey("draining it again should fail", func() {
+			So(watcher.Drain(gocontext.Background(), "i-34719eb8"), ShouldEqual, ErrAlreadyMarked)
+		})
+		Convey("the next runs should drain and terminate it through the lifecycle hook, without marking others", func() {
+			watcher.Run(gocontext.Background())
+			So(actionParams(plan, "RemoveASGInstanceProtection")[0]["instanceId"], ShouldEqual, "i-34719eb8")
+			watcher.Run(gocontext.Background())
+			So(actionParams(plan, "CompleteLifecycleAction"), ShouldHaveLength, 1)
+			So(actionParams(plan, "CompleteLifecycleAction")[0]["instanceId"], ShouldEqual, "i-34719eb8")
+			So(sink.Events(audit.CandidateSelection), ShouldBeEmpty)
+		})
+		Convey("and cancelling it", func() {
+			err := watcher.CancelDrain(gocontext.Background(), "i-34719eb8")
+
+			Convey("its tags should be deleted and the desired capacity restored", func() {
+				So(err, ShouldBeNil)
+				So(actionParams(plan, "DeleteInstanceTag"), ShouldHaveLength, 2)
+				So(actionParams(plan, "SetDesiredCapacity")[1]["desiredCapacity"], ShouldEqual, "3")
+				So(watcher.Status().AutoscalingGroupPrefixes[0].AutoscalingGroups[0].Instances[0].MarkedToBeRemoved,
+					ShouldBeFalse)
+				So(sink.Events(audit.DrainCancelled), ShouldHaveLength, 1)
+			})
+			Convey("cancelling it again should fail", func() {
+				So(watcher.CancelDrain(gocontext.Background(), "i-34719eb8"), ShouldEqual, ErrNotManualDrain)
+			})
+		})
+		Convey("and cancelling it once its instance protection was removed", func() {
+			watcher.Run(gocontext.Background())
+			err := watcher.CancelDrain(gocontext.Background(), "i-34719eb8")
+
+			Convey("it should be protected again before AWS picks it", func() {
+				So(err, ShouldBeNil)
+				So(actionParams(plan, "SetASGInstanceProtection"), ShouldHaveLength, 1)
+				watcher.Run(gocontext.Background())
+				So(actionParams(plan, "CompleteLifecycleAction"), ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("When draining an instance after the desired capacity changed since the last run", t, func() {
+		watcher, plan, _ := newDrainWatcher()
+		autoscalingGroupName := "some-Autoscaling-Group"
+		watcher.ctx.AwsConn.SetDesiredCapacity(gocontext.Background(), &autoscalingGroupName, 5)
+		err := watcher.Drain(gocontext.Background(), "i-34719eb8")
+
+		Convey("the current desired capacity should be decremented", func() {
+			So(err, ShouldBeNil)
+			So(actionParams(plan, "SetDesiredCapacity")[1]["desiredCapacity"], ShouldEqual, "4")
+		})
+	})
+
+	Convey("When draining an instance not monitored", t, func() {
+		watcher, _, _ := newDrainWatcher()
+		Convey("it should fail", func() {
+			So(watcher.Drain(gocontext.Background(), "i-00000000"), ShouldEqual, ErrInstanceNotFound)
+			So(watcher.CancelDrain(gocontext.Background(), "i-00000000"), ShouldEqual, ErrInstanceNotFound)
+		})
+	})
+
+	Convey("When draining an instance without being the leader", t, func() {
+		watcher, _, _ := newDrainWatcher()
+		watcher.ctx.Elector = &electorMock{leader: false}
+		Convey("it should fail", func() {
+			So(watcher.Drain(gocontext.Background(), "i-34719eb8"), ShouldEqual, ErrNotLeader)
+		})
+	})
+}
+
+func TestUnmarkSurplusInstances(t *testing.T) {
+
+	Convey("When the desired capacity grows back after a scale-in", t, func() {
+		watcher, plan, sink := newDrainWatcher()
+		autoscalingGroupName := "some-Autoscaling-Group"
+		watcher.ctx.AwsConn.SetDesiredCapacity(gocontext.Background(), &autoscalingGroupName, 2)
+		watcher.Run(gocontext.Background())
+		So(actionParams(plan, "RemoveASGInstanceProtection"), ShouldHaveLength, 1)
+		markedInstanceID := actionParams(plan, "RemoveASGInstanceProtection")[0]["instanceId"]
+
+		watcher.ctx.AwsConn.SetDesiredCapacity(gocontext.Background(), &autoscalingGroupName, 3)
+		watcher.Run(gocontext.Background())
+
+		Convey("the marked instance should be protected again and unmarked", func() {
+			So(actionParams(plan, "SetASGInstanceProtection"), ShouldHaveLength, 1)
+			So(actionParams(plan, "DeleteInstanceTag")[0]["instanceId"], ShouldEqual, markedInstanceID)
+			So(sink.Events(audit.DrainCancelled), ShouldHaveLength, 1)
+		})
+		Convey("it should leave the Mesos maintenance schedule", func() {
+			maintenances := actionParams(plan, "UpdateMaintenanceSchedule")
+			So(maintenances[len(maintenances)-1]["machines"], ShouldEqual, "")
+		})
+		Convey("it should not be terminated", func() {
+			watcher.Run(gocontext.Background())
+			So(actionParams(plan, "CompleteLifecycleAction"), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,38 @@
+package deathnode
+
+import (
+	gocontext "context"
+	"github.com/alanbover/deathnode/audit"
+	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceLeadTime(t *testing.T) {
+
+	Convey("When the maintenance windows start after a lead time", t, func() {
+		watcher, plan, sink := newDrainWatcher()
+		clockMock := clock.NewMock()
+		clockMock.Set(time.Unix(1500000000, 0))
+		watcher.ctx.Clock = clockMock
+		watcher.ctx.Conf.MaintenanceLeadSeconds = 600
+		watcher.ctx.Conf.MaintenanceDurationSeconds = 3600
+
+		autoscalingGroupName := "some-Autoscaling-Group"
+		watcher.ctx.AwsConn.SetDesiredCapacity(gocontext.Background(), &autoscalingGroupName, 2)
+		watcher.Run(gocontext.Background())
+		watcher.Run(gocontext.Background())
+
+		Convey("the instance should not be destroyed before its window starts", func() {
+			So(actionParams(plan, "UpdateMaintenanceSchedule"), ShouldHaveLength, 1)
+			So(actionParams(plan, "CompleteLifecycleAction"), ShouldBeEmpty)
+			So(sink.Events(audit.DestroySkipped)[0].Details["maintenanceLeadTime"], ShouldEqual, 600)
+		})
+		Convey("the instance should be destroyed once its window starts", func() {
+			clockMock.Add(10 * time.Minute)
+			watcher.Run(gocontext.Background())
+			So(actionParams(plan, "CompleteLifecycleAction"), ShouldHaveLength, 1)
+		})
+	})
+}
@@ -0,0 +1,60 @@
+package deathnode
+
+// Updates the gauges describing the monitors state at the end of every run
+
+import (
+	"github.com/alanbover/deathnode/metrics"
+	"time"
+)
+
+// updateMetrics sets the gauges from the monitors. It must be called while holding the watcher mutex
+func (y *Watcher) updateMetrics() {
+
+	metrics.InstancesMarkedToBeRemoved.Reset()
+	metrics.UndesiredInstances.Reset()
+	metrics.DrainingSeconds.Reset()
+	metrics.BlockedDrainingAgents.Reset()
+
+	now := y.ctx.Clock.Now()
+	cacheStatus := y.mesosMonitor.CacheStatus()
+	if cacheStatus.Trusted {
+		metrics.MesosStateTrusted.Set(1)
+	} else {
+		metrics.MesosStateTrusted.Set(0)
+	}
+	// The event stream keeps the state up to date while subscribed
+	if cacheStatus.Subscribed {
+		metrics.MesosStateAgeSeconds.Set(0)
+	} else if !cacheStatus.RefreshedAt.IsZero() {
+		metrics.MesosStateAgeSeconds.Set(now.Sub(cacheStatus.RefreshedAt).Seconds())
+	}
+
+	for _, autoscalingMonitor := range y.autoscalingServiceMonitor.GetAutoscalingGroupMonitorsList() {
+		autoscalingGroupName := autoscalingMonitor.AutoscalingGroupName()
+		metrics.UndesiredInstances.Set(float64(autoscalingMonitor.GetNumUndesiredInstances()), autoscalingGroupName)
+		metrics.InstancesMarkedToBeRemoved.Set(0, autoscalingGroupName)
+
+		for _, instanceMonitor := range autoscalingMonitor.GetAllInstances() {
+			if !instanceMonitor.IsMarkedToBeRemoved() {
+				continue
+			}
+			metrics.InstancesMarkedToBeRemoved.Add(1, autoscalingGroupName)
+			metrics.DrainingSeconds.Set(now.Sub(time.Unix(instanceMonitor.TagRemovalTimestamp(), 0)).Seconds(),
+				autoscalingGroupName, *instanceMonitor.InstanceID())
+
+			// Every framework or label counts once per agent, even if it's protecting several of its tasks
+			blockers := map[[2]string]bool{}
+			for _, task := range y.mesosMonitor.GetProtectingTasks(instanceMonitor.IP(), instanceMonitor.Policy()) {
+				if task.Framework != "" {
+					blockers[[2]string{"framework", task.Framework}] = true
+				}
+				if task.Label != "" {
+					blockers[[2]string{"label", task.Label}] = true
+				}
+			}
+			for blocker := range blockers {
+				metrics.BlockedDrainingAgents.Add(1, blocker[0], blocker[1])
+			}
+		}
+	}
+}
@@ -4,81 +4,323 @@ package deathnode
 // they are not running any tasks
 
 import (
+	gocontext "context"
+	"github.com/alanbover/deathnode/audit"
+	"github.com/alanbover/deathnode/aws"
 	"github.com/alanbover/deathnode/context"
+	"github.com/alanbover/deathnode/mesos"
+	"github.com/alanbover/deathnode/metrics"
 	"github.com/alanbover/deathnode/monitor"
+	"github.com/alanbover/deathnode/notify"
+	"github.com/alanbover/deathnode/state"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	log "github.com/sirupsen/logrus"
 	"time"
 )
 
+// saveStateTimeout bounds the saves of the state, which outlive the run that changed it
+const saveStateTimeout = 10 * time.Second
+
 // Notebook stores the necessary information for deal with instances that should be deleted
 type Notebook struct {
-	mesosMonitor        *monitor.MesosMonitor
-	autoscalingGroups   *monitor.AutoscalingServiceMonitor
-	lastDeleteTimestamp time.Time
-	ctx                 *context.ApplicationContext
+	mesosMonitor      *monitor.MesosMonitor
+	autoscalingGroups *monitor.AutoscalingServiceMonitor
+	state             *state.State
+	unmarked          map[string]bool
+	drainStrategy     drainStrategy
+	ctx               *context.ApplicationContext
 }
 
-// NewNotebook creates a notebook object, which is in charge of monitoring and delete instances marked to be deleted
+// NewNotebook creates a notebook object, which is in charge of monitoring and delete instances marked to be deleted.
+// It starts from the state persisted in the store, if any
 func NewNotebook(ctx *context.ApplicationContext, autoscalingGroups *monitor.AutoscalingServiceMonitor,
 	mesosMonitor *monitor.MesosMonitor) *Notebook {
 
-	return &Notebook{
-		mesosMonitor:        mesosMonitor,
-		autoscalingGroups:   autoscalingGroups,
-		lastDeleteTimestamp: time.Time{},
-		ctx:                 ctx,
+	notebook := &Notebook{
+		mesosMonitor:      mesosMonitor,
+		autoscalingGroups: autoscalingGroups,
+		state:             loadState(ctx),
+		unmarked:          map[string]bool{},
+		ctx:               ctx,
+	}
+
+	drainStrategy, err := newDrainStrategy(notebook, ctx.Conf.DrainStrategy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	notebook.drainStrategy = drainStrategy
+	return notebook
+}
+
+// loadState returns the state persisted in the store, or an empty one if it's disabled or can't be read
+func loadState(ctx *context.ApplicationContext) *state.State {
+
+	if ctx.Store == nil {
+		return state.New()
+	}
+
+	loaded, err := ctx.Store.Load(gocontext.Background())
+	if err != nil {
+		log.Errorf("Unable to load the state, starting with an empty one: %s", err)
+		return state.New()
+	}
+	log.Infof("State loaded with %d instances being drained", len(loaded.Drains))
+	return loaded
+}
+
+// saveState persists the state in the store, if enabled. It's not bound to the run, as the changes it records are
+// already done in AWS even if the run is cancelled afterwards
+func (n *Notebook) saveState() {
+
+	if n.ctx.Store == nil {
+		return
+	}
+	saveCtx, cancel := gocontext.WithTimeout(gocontext.Background(), saveStateTimeout)
+	defer cancel()
+	if err := n.ctx.Store.Save(saveCtx, n.state); err != nil {
+		log.Errorf("Unable to save the state: %s", err)
+	}
+}
+
+// recordDrain returns the drain record of an instance marked to be removed, creating it if it's not tracked yet
+func (n *Notebook) recordDrain(instanceMonitor *monitor.InstanceMonitor) *state.Drain {
+
+	delete(n.unmarked, *instanceMonitor.InstanceID())
+	drain := n.state.Drain(*instanceMonitor.InstanceID())
+	if drain.MarkedAt.IsZero() {
+		drain.AutoscalingGroup = *instanceMonitor.AutoscalingGroupID()
+		drain.AutoscalingGroupPrefix = instanceMonitor.AutoscalingGroupPrefix()
+		drain.Reason = instanceMonitor.DrainReason()
+		drain.MarkedAt = time.Unix(instanceMonitor.TagRemovalTimestamp(), 0)
+	}
+	return drain
+}
+
+// forgetDrain stops tracking an instance that is not marked to be removed anymore. As the tags are eventually
+// consistent, the instance is ignored if it's still returned with the mark
+func (n *Notebook) forgetDrain(instanceID string) {
+
+	delete(n.state.Drains, instanceID)
+	n.unmarked[instanceID] = true
+}
+
+// withoutUnmarked filters out the instances that have been unmarked, and forgets the ones not returned anymore
+func (n *Notebook) withoutUnmarked(instances []*ec2.Instance) []*ec2.Instance {
+
+	returned := map[string]bool{}
+	filtered := []*ec2.Instance{}
+	for _, instance := range instances {
+		returned[*instance.InstanceId] = true
+		if !n.unmarked[*instance.InstanceId] {
+			filtered = append(filtered, instance)
+		}
+	}
+
+	for instanceID := range n.unmarked {
+		if !returned[instanceID] {
+			delete(n.unmarked, instanceID)
+		}
 	}
+	return filtered
 }
 
-func (n *Notebook) setAgentsInMaintenance(instances []*ec2.Instance) error {
+func (n *Notebook) setAgentsInMaintenance(runCtx gocontext.Context, instances []*ec2.Instance) error {
 
 	hosts := map[string]string{}
 	for _, instance := range instances {
 		hosts[*instance.PrivateDnsName] = *instance.PrivateIpAddress
 	}
+	n.releaseAgents(runCtx, hosts)
 
-	return n.mesosMonitor.SetMesosAgentsInMaintenance(hosts)
+	owned := map[string]bool{}
+	for hostname := range n.state.Maintenances {
+		owned[hostname] = true
+	}
+	added, err := n.mesosMonitor.SetMesosAgentsInMaintenance(runCtx, hosts, owned, n.maintenanceUnavailability())
+	if err != nil {
+		log.Warnf("Unable to set agents in maintenance: %s", err)
+		return err
+	}
+	for hostname := range added {
+		n.state.Maintenances[hostname] = &state.Maintenance{IP: hosts[hostname]}
+	}
+
+	// Audit only the instances that were not in maintenance before
+	for _, instance := range instances {
+		drain := n.state.Drain(*instance.InstanceId)
+		if drain.MaintenanceRequestedAt.IsZero() {
+			drain.MaintenanceRequestedAt = n.ctx.Clock.Now()
+			n.ctx.Audit.Record(audit.MaintenanceEntered, n.autoscalingGroupName(*instance.InstanceId),
+				*instance.InstanceId, map[string]interface{}{
+					"hostname": *instance.PrivateDnsName,
+					"ip":       *instance.PrivateIpAddress,
+				})
+		}
+	}
+	return nil
+}
+
+// maintenanceUnavailability returns the unavailability of the agents scheduled now: it starts once the lead time
+// is over, and lasts the maintenance duration if set
+func (n *Notebook) maintenanceUnavailability() mesos.MaintenanceUnavailability {
+
+	start := n.ctx.Clock.Now().Add(time.Duration(n.ctx.Conf.MaintenanceLeadSeconds) * time.Second)
+	unavailability := mesos.MaintenanceUnavailability{
+		Start: mesos.MaintenanceStart{Nanoseconds: start.UnixNano()},
+	}
+	if n.ctx.Conf.MaintenanceDurationSeconds > 0 {
+		duration := time.Duration(n.ctx.Conf.MaintenanceDurationSeconds) * time.Second
+		unavailability.Duration = &mesos.MaintenanceDuration{Nanoseconds: duration.Nanoseconds()}
+	}
+	return unavailability
+}
+
+// releaseAgents prunes from the maintenance schedule the machines deathnode put there whose instance is not
+// being drained anymore, because it was terminated or unmarked. Only the terminated ones are moved down
+func (n *Notebook) releaseAgents(runCtx gocontext.Context, hosts map[string]string) {
+
+	released := map[string]string{}
+	terminated := map[string]bool{}
+	for hostname, maintenance := range n.state.Maintenances {
+		if _, ok := hosts[hostname]; !ok {
+			released[hostname] = maintenance.IP
+			terminated[hostname] = !maintenance.TerminatedAt.IsZero()
+		}
+	}
+	if len(released) == 0 {
+		return
+	}
+
+	if err := n.mesosMonitor.ReleaseMesosAgents(runCtx, released, terminated); err != nil {
+		log.Warnf("Unable to release agents from maintenance: %s", err)
+		return
+	}
+	for hostname, ip := range released {
+		log.Infof("Agent %s released from maintenance", hostname)
+		delete(n.state.Maintenances, hostname)
+		n.ctx.Audit.Record(audit.MaintenanceReleased, "", "", map[string]interface{}{
+			"hostname":   hostname,
+			"ip":         ip,
+			"terminated": terminated[hostname],
+		})
+	}
 }
 
-func (n *Notebook) shouldWaitForNextDestroy() bool {
-	return n.ctx.Clock.Since(n.lastDeleteTimestamp).Seconds() <= float64(n.ctx.Conf.DelayDeleteSeconds)
+// recordTermination flags the maintenance of the instance as terminated, so its machine is moved down once
+// the instance is gone
+func (n *Notebook) recordTermination(instanceMonitor *monitor.InstanceMonitor) {
+
+	for _, maintenance := range n.state.Maintenances {
+		if maintenance.IP == instanceMonitor.IP() {
+			maintenance.TerminatedAt = n.ctx.Clock.Now()
+		}
+	}
 }
 
-func (n *Notebook) destroyInstance(instanceMonitor *monitor.InstanceMonitor) error {
+func (n *Notebook) autoscalingGroupName(instanceID string) string {
+
+	instanceMonitor, err := n.autoscalingGroups.GetInstanceByID(instanceID)
+	if err != nil {
+		return ""
+	}
+	return *instanceMonitor.AutoscalingGroupID()
+}
+
+// shouldWaitForNextDestroy checks the time since the last destroy on the same autoscalingGroupPrefix
+func (n *Notebook) shouldWaitForNextDestroy(instanceMonitor *monitor.InstanceMonitor) bool {
+	lastDeleteTimestamp := n.state.LastDeleteTimestamps[instanceMonitor.AutoscalingGroupPrefix()]
+	return n.ctx.Clock.Since(lastDeleteTimestamp).Seconds() <= float64(instanceMonitor.Policy().DelayDeleteSeconds)
+}
+
+// shouldWaitForMaintenance checks if the maintenance window of the instance agent is still to start, so the
+// frameworks have the lead time to move their tasks before it's drained
+func (n *Notebook) shouldWaitForMaintenance(instanceMonitor *monitor.InstanceMonitor) bool {
+
+	lead := n.ctx.Conf.MaintenanceLeadSeconds
+	if lead == 0 {
+		return false
+	}
+	drain := n.state.Drain(*instanceMonitor.InstanceID())
+	if !drain.MaintenanceRequestedAt.IsZero() && n.ctx.Clock.Since(drain.MaintenanceRequestedAt).Seconds() >= float64(lead) {
+		return false
+	}
+
+	log.Debugf("Maintenance window not started yet. Instance %s will not be destroyed", *instanceMonitor.InstanceID())
+	n.ctx.Audit.Record(audit.DestroySkipped, *instanceMonitor.AutoscalingGroupID(), *instanceMonitor.InstanceID(),
+		map[string]interface{}{"maintenanceLeadTime": lead})
+	return true
+}
+
+// shouldWaitForCompletionBudget checks if the drain budgets allow to complete the lifecycle action of the instance
+func (n *Notebook) shouldWaitForCompletionBudget(instanceMonitor *monitor.InstanceMonitor) bool {
+
+	if instanceMonitor.LifecycleState() != monitor.LifecycleStateTerminatingWait {
+		return false
+	}
+	autoscalingMonitor, err := n.autoscalingGroups.GetAutoscalingGroupMonitor(*instanceMonitor.AutoscalingGroupID())
+	if err != nil || n.remainingCompletions(autoscalingMonitor) != 0 {
+		return false
+	}
+
+	log.Debugf("Drain budget exhausted. Instance %s will not be destroyed", *instanceMonitor.InstanceID())
+	n.ctx.Audit.Record(audit.DestroySkipped, *instanceMonitor.AutoscalingGroupID(), *instanceMonitor.InstanceID(),
+		map[string]interface{}{"drainBudgetExhausted": true})
+	return true
+}
+
+func (n *Notebook) destroyInstance(runCtx gocontext.Context, instanceMonitor *monitor.InstanceMonitor,
+	result string) error {
 
 	if instanceMonitor.LifecycleState() == monitor.LifecycleStateTerminatingWait {
-		log.Infof("Destroy instance %s", *instanceMonitor.InstanceID())
+		if err := runCtx.Err(); err != nil {
+			return err
+		}
+		log.Infof("Destroy instance %s with lifecycle action result %s", *instanceMonitor.InstanceID(), result)
 		err := n.ctx.AwsConn.CompleteLifecycleAction(
-			instanceMonitor.AutoscalingGroupID(), instanceMonitor.InstanceID())
+			runCtx, instanceMonitor.AutoscalingGroupID(), instanceMonitor.InstanceID(),
+			instanceMonitor.Policy().LifecycleHook().Name, result)
 		if err != nil {
 			log.Errorf("Unable to complete lifecycle action on instance %s", *instanceMonitor.InstanceID())
+			metrics.LifecycleActionsCompleted.Inc("failure")
+			n.ctx.Audit.Record(audit.LifecycleCompletionFailed, *instanceMonitor.AutoscalingGroupID(),
+				*instanceMonitor.InstanceID(), map[string]interface{}{"error": err.Error()})
 			return err
 		}
-		if n.ctx.Conf.DelayDeleteSeconds != 0 {
-			n.lastDeleteTimestamp = n.ctx.Clock.Now()
+		metrics.LifecycleActionsCompleted.Inc("success")
+		n.notify(notify.InstanceTerminated, instanceMonitor)
+		n.ctx.Audit.Record(audit.LifecycleCompleted, *instanceMonitor.AutoscalingGroupID(),
+			*instanceMonitor.InstanceID(), map[string]interface{}{
+				"secondsSinceMarked": n.drainingSeconds(instanceMonitor),
+				"result":             result,
+			})
+		if instanceMonitor.Policy().DelayDeleteSeconds != 0 {
+			n.state.LastDeleteTimestamps[instanceMonitor.AutoscalingGroupPrefix()] = n.ctx.Clock.Now()
 		}
+		n.state.RecordCompletion(*instanceMonitor.AutoscalingGroupID(), n.ctx.Clock.Now())
+		n.drainStrategy.recordTermination(instanceMonitor)
+		n.saveState()
 	} else {
 		log.Debugf("Instance %s waiting for AWS to start termination lifecycle", *instanceMonitor.InstanceID())
 	}
 	return nil
 }
 
-func (n *Notebook) resetLifecycle(instanceMonitor *monitor.InstanceMonitor) {
+func (n *Notebook) resetLifecycle(runCtx gocontext.Context, instanceMonitor *monitor.InstanceMonitor) {
 
 	// Check if timeout is close to expire
 	startTimeoutTimestamp := time.Unix(instanceMonitor.TagRemovalTimestamp(), 0)
-	maxSecondsToRefresh := float64(monitor.LifeCycleTimeout) * monitor.LifeCycleRefreshTimeoutPercentage
+	heartbeatTimeout := instanceMonitor.Policy().LifecycleHook().HeartbeatTimeout
+	maxSecondsToRefresh := float64(heartbeatTimeout) * monitor.LifeCycleRefreshTimeoutPercentage
 
 	if instanceMonitor.LifecycleState() == monitor.LifecycleStateTerminatingWait && n.ctx.Clock.Since(startTimeoutTimestamp).Seconds() > maxSecondsToRefresh {
-		err := instanceMonitor.RefreshLifecycleHook()
+		err := instanceMonitor.RefreshLifecycleHook(runCtx)
 		if err != nil {
 			log.Errorf("Unable to reset lifecycle hook for instance %s", *instanceMonitor.InstanceID())
 		}
 	}
 }
 
-func (n *Notebook) destroyInstanceAttempt(instance *ec2.Instance) error {
+func (n *Notebook) destroyInstanceAttempt(runCtx gocontext.Context, instance *ec2.Instance) error {
 
 	log.Debugf("Starting process to delete instance %s", *instance.InstanceId)
 
@@ -86,49 +328,103 @@ func (n *Notebook) destroyInstanceAttempt(instance *ec2.Instance) error {
 	if err != nil {
 		return err
 	}
+	drain := n.recordDrain(instanceMonitor)
+	if instanceMonitor.LifecycleState() == monitor.LifecycleStateTerminatingWait && drain.TerminatingWaitAt.IsZero() {
+		drain.TerminatingWaitAt = n.ctx.Clock.Now()
+	}
+
+	// Apply the drain deadline policy if the drain lasts too long
+	if reason := n.drainDeadlineExpired(instanceMonitor); reason != "" {
+		if over, err := n.escalateDrain(runCtx, instanceMonitor, reason); over {
+			return err
+		}
+	}
 
 	// If the instance is protected, remove instance protection
-	n.removeInstanceProtection(instanceMonitor)
+	n.removeInstanceProtection(runCtx, instanceMonitor)
+
+	// Notify, only once, if the instance is draining for too long
+	n.notifyIfStuck(instanceMonitor)
 
 	// Reset lifecycle hook timeout if needed
-	if n.ctx.Conf.ResetLifecycle {
-		n.resetLifecycle(instanceMonitor)
+	if instanceMonitor.Policy().ResetLifecycle {
+		n.resetLifecycle(runCtx, instanceMonitor)
 	}
 
 	// Check if we need to wait before destroy another instance
-	if n.shouldWaitForNextDestroy() {
+	if n.drainStrategy.shouldWait(instanceMonitor) || n.shouldWaitForCompletionBudget(instanceMonitor) {
+		return nil
+	}
+	if n.shouldWaitForNextDestroy(instanceMonitor) {
+		secondsSinceLastDestroy := n.ctx.Clock.Since(
+			n.state.LastDeleteTimestamps[instanceMonitor.AutoscalingGroupPrefix()]).Seconds()
 		log.Debugf("Seconds since last destroy: %v. Instance %s will not be destroyed",
-			n.ctx.Clock.Since(n.lastDeleteTimestamp).Seconds(), *instance.InstanceId)
+			secondsSinceLastDestroy, *instance.InstanceId)
+		n.ctx.Audit.Record(audit.DestroySkipped, *instanceMonitor.AutoscalingGroupID(), *instance.InstanceId,
+			map[string]interface{}{
+				"secondsSinceLastDestroy": secondsSinceLastDestroy,
+				"delayDeleteSeconds":      instanceMonitor.Policy().DelayDeleteSeconds,
+			})
 		return nil
 	}
 
 	// If the instance can be killed, delete it
-	if !n.mesosMonitor.IsProtected(*instance.PrivateIpAddress) {
-		if err := n.destroyInstance(instanceMonitor); err != nil {
-			return err
-		}
+	protectingTasks := n.mesosMonitor.GetProtectingTasks(*instance.PrivateIpAddress, instanceMonitor.Policy())
+	if len(protectingTasks) > 0 && n.hasAcceptedInverseOffers(instanceMonitor) {
+		log.Infof("Frameworks of instance %s accepted the inverse offers. Ignoring its %d protected tasks",
+			*instance.InstanceId, len(protectingTasks))
+		n.ctx.Audit.Record(audit.InverseOffersAccepted, *instanceMonitor.AutoscalingGroupID(), *instance.InstanceId,
+			map[string]interface{}{"protectingTasks": protectingTasks})
+		protectingTasks = nil
 	}
-	return nil
+	if len(protectingTasks) > 0 {
+		log.Debugf("Instance %s has %d protected tasks running. It will not be destroyed",
+			*instance.InstanceId, len(protectingTasks))
+		n.ctx.Audit.Record(audit.DestroyBlocked, *instanceMonitor.AutoscalingGroupID(), *instance.InstanceId,
+			map[string]interface{}{"protectingTasks": protectingTasks})
+		return nil
+	}
+	return n.destroyInstance(runCtx, instanceMonitor, aws.LifecycleActionContinue)
+}
+
+// hasAcceptedInverseOffers returns true if the instance policy honours the inverse offers, and every framework
+// running tasks in its agent accepted them
+func (n *Notebook) hasAcceptedInverseOffers(instanceMonitor *monitor.InstanceMonitor) bool {
+
+	return instanceMonitor.Policy().HonourInverseOffers && n.mesosMonitor.HasAcceptedInverseOffers(instanceMonitor.IP())
 }
 
 // DestroyInstancesAttempt iterates around all instances marked to be deleted, and:
-// - set them in maintenance
+// - drain their agents, with the drain strategy
 // - remove instance protection
 // - complete lifecycle action if there is no tasks running from the protected frameworks
-func (n *Notebook) DestroyInstancesAttempt() error {
+func (n *Notebook) DestroyInstancesAttempt(runCtx gocontext.Context) error {
 
 	// Get instances marked for removal
-	instances, err := n.ctx.AwsConn.DescribeInstancesByTag(n.ctx.Conf.DeathNodeMark)
+	instances, err := n.ctx.AwsConn.DescribeInstancesByTag(runCtx, n.ctx.Conf.DeathNodeMark)
 	if err != nil {
 		log.Debugf("Error retrieving instances with tag %s", n.ctx.Conf.DeathNodeMark)
 		return err
 	}
+	instances = n.withoutUnmarked(instances)
 
-	// Set instances in maintenance
-	n.setAgentsInMaintenance(instances)
+	// Forget the instances not marked anymore, as they are already gone
+	marked := map[string]bool{}
+	for _, instance := range instances {
+		marked[*instance.InstanceId] = true
+	}
+	n.state.Prune(marked)
+	n.pruneCompletions()
+	defer n.saveState()
+
+	// Drain the agents of the instances
+	n.drainStrategy.drainAgents(runCtx, instances)
 
 	for _, instance := range instances {
-		if err := n.destroyInstanceAttempt(instance); err != nil {
+		if err := runCtx.Err(); err != nil {
+			return err
+		}
+		if err := n.destroyInstanceAttempt(runCtx, instance); err != nil {
 			log.Warn(err)
 		}
 	}
@@ -136,10 +432,66 @@ func (n *Notebook) DestroyInstancesAttempt() error {
 	return nil
 }
 
-func (n *Notebook) removeInstanceProtection(instance *monitor.InstanceMonitor) error {
+// RefreshLifecycles only resets the lifecycle hook timeout of the instances marked to be removed, for the runs
+// where they can't be destroyed
+func (n *Notebook) RefreshLifecycles(runCtx gocontext.Context) error {
+
+	instances, err := n.ctx.AwsConn.DescribeInstancesByTag(runCtx, n.ctx.Conf.DeathNodeMark)
+	if err != nil {
+		return err
+	}
+
+	for _, instance := range n.withoutUnmarked(instances) {
+		instanceMonitor, err := n.autoscalingGroups.GetInstanceByID(*instance.InstanceId)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		if instanceMonitor.Policy().ResetLifecycle {
+			n.resetLifecycle(runCtx, instanceMonitor)
+		}
+	}
+	return nil
+}
+
+func (n *Notebook) notifyIfStuck(instanceMonitor *monitor.InstanceMonitor) {
+
+	threshold := n.ctx.Conf.DrainStuckThresholdSeconds
+	drain := n.state.Drain(*instanceMonitor.InstanceID())
+	if threshold == 0 || !drain.StuckNotifiedAt.IsZero() {
+		return
+	}
+
+	if n.drainingSeconds(instanceMonitor) > float64(threshold) {
+		log.Warnf("Instance %s is draining for more than %d seconds", *instanceMonitor.InstanceID(), threshold)
+		n.notify(notify.DrainStuck, instanceMonitor)
+		drain.StuckNotifiedAt = n.ctx.Clock.Now()
+	}
+}
+
+func (n *Notebook) notify(eventType string, instanceMonitor *monitor.InstanceMonitor) {
+
+	n.ctx.Notifier.Notify(notify.Event{
+		Type:             eventType,
+		AutoscalingGroup: *instanceMonitor.AutoscalingGroupID(),
+		InstanceID:       *instanceMonitor.InstanceID(),
+		IP:               instanceMonitor.IP(),
+		DrainingSeconds:  n.drainingSeconds(instanceMonitor),
+	})
+}
+
+func (n *Notebook) drainingSeconds(instanceMonitor *monitor.InstanceMonitor) float64 {
+	return n.ctx.Clock.Since(time.Unix(instanceMonitor.TagRemovalTimestamp(), 0)).Seconds()
+}
+
+func (n *Notebook) removeInstanceProtection(runCtx gocontext.Context, instance *monitor.InstanceMonitor) error {
 
 	if instance.IsProtected() {
-		return instance.RemoveInstanceProtection()
+		if err := instance.RemoveInstanceProtection(runCtx); err != nil {
+			return err
+		}
+		n.state.Drain(*instance.InstanceID()).ProtectionRemovedAt = n.ctx.Clock.Now()
+		n.ctx.Audit.Record(audit.ProtectionRemoved, *instance.AutoscalingGroupID(), *instance.InstanceID(), nil)
 	}
 
 	return nil
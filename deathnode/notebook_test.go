@@ -1,12 +1,17 @@
 package deathnode
 
 import (
+	gocontext "context"
 	"github.com/alanbover/deathnode/aws"
 	"github.com/alanbover/deathnode/context"
 	"github.com/alanbover/deathnode/mesos"
 	"github.com/alanbover/deathnode/monitor"
+	"github.com/alanbover/deathnode/state"
 	"github.com/benbjohnson/clock"
 	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -37,7 +42,7 @@ func TestRecordLifecycleActionHeartbeat(t *testing.T) {
 		notebook := newNotebook(awsConn, mesosConn, 0, clockMock)
 		Convey("it should have recorded lifecycleAction and reset tag for the already terminating instance", func() {
 			clockMock.Set(time.Unix(1190997840, 0))
-			notebook.DestroyInstancesAttempt()
+			notebook.DestroyInstancesAttempt(gocontext.Background())
 			clockMock.Set(time.Unix(1190995200, 0))
 			So(awsConn.Requests["RecordLifecycleActionHeartbeat"], ShouldHaveLength, 1)
 			So(awsConn.Requests["SetInstanceTag"], ShouldHaveLength, 1)
@@ -45,7 +50,7 @@ func TestRecordLifecycleActionHeartbeat(t *testing.T) {
 		Convey("it should do nothing if no lifecycle to be refreshed", func() {
 			awsConn.FlushMock()
 			clockMock.Set(time.Unix(1190997840, 0))
-			notebook.DestroyInstancesAttempt()
+			notebook.DestroyInstancesAttempt(gocontext.Background())
 			clockMock.Set(time.Unix(1190995200, 0))
 			So(awsConn.Requests["RecordLifecycleActionHeartbeat"], ShouldBeNil)
 			So(awsConn.Requests["SetInstanceTag"], ShouldBeNil)
@@ -53,7 +58,7 @@ func TestRecordLifecycleActionHeartbeat(t *testing.T) {
 		Convey("it should refresh lifecycle if time is close to be expired", func() {
 			awsConn.FlushMock()
 			clockMock.Set(time.Unix(1190997960, 0))
-			notebook.DestroyInstancesAttempt()
+			notebook.DestroyInstancesAttempt(gocontext.Background())
 			clockMock.Set(time.Unix(1190995200, 0))
 			So(awsConn.Requests["RecordLifecycleActionHeartbeat"], ShouldHaveLength, 1)
 			So(awsConn.Requests["SetInstanceTag"], ShouldHaveLength, 1)
@@ -85,8 +90,8 @@ func TestDestroyInstanceAttempt(t *testing.T) {
 
 		Convey("if there is no instances marked to be removed", func() {
 			Convey("it should do nothing", func() {
-				notebook.DestroyInstancesAttempt()
-				So(mesosConn.Requests["SetHostInMaintenance"], ShouldNotBeNil)
+				notebook.DestroyInstancesAttempt(gocontext.Background())
+				So(mesosConn.Requests["UpdateMaintenanceSchedule"], ShouldBeNil)
 				So(awsConn.Requests["DetachInstance"], ShouldBeNil)
 				So(awsConn.Requests["TerminateInstance"], ShouldBeNil)
 				So(awsConn.Requests["CompleteLifecycleAction"], ShouldBeNil)
@@ -99,7 +104,7 @@ func TestDestroyInstanceAttempt(t *testing.T) {
 			}
 			instanceMonitor, _ := notebook.autoscalingGroups.GetInstanceByID("i-34719eb8")
 			Convey("Check remove instance protection flags", func() {
-				notebook.DestroyInstancesAttempt()
+				notebook.DestroyInstancesAttempt(gocontext.Background())
 				Convey("if it has instanceProtection flag, it should be removed", func() {
 					So(awsConn.Requests["RemoveASGInstanceProtection"], ShouldNotBeNil)
 					So(awsConn.Requests["RemoveASGInstanceProtection"], ShouldHaveLength, 1)
@@ -107,7 +112,7 @@ func TestDestroyInstanceAttempt(t *testing.T) {
 				})
 				Convey("if it doesn't have instanceProtection flag", func() {
 					Convey("it should not remove more instanceProtection flag", func() {
-						notebook.DestroyInstancesAttempt()
+						notebook.DestroyInstancesAttempt(gocontext.Background())
 						So(awsConn.Requests["RemoveASGInstanceProtection"], ShouldNotBeNil)
 						So(awsConn.Requests["RemoveASGInstanceProtection"], ShouldHaveLength, 1)
 						So(instanceMonitor.IsProtected(), ShouldBeFalse)
@@ -116,17 +121,37 @@ func TestDestroyInstanceAttempt(t *testing.T) {
 			})
 			Convey("Check completeLifeCycle", func() {
 				Convey("if it has tasks running from protected frameworks, completeLifeCycle should not be called", func() {
-					notebook.DestroyInstancesAttempt()
+					notebook.DestroyInstancesAttempt(gocontext.Background())
 					So(awsConn.Requests["CompleteLifecycleAction"], ShouldBeNil)
 				})
+				Convey("if its frameworks accepted the inverse offers, honouring them", func() {
+					notebook.ctx.Conf.HonourInverseOffers = true
+					mesosConn.Records["GetMaintenanceStatus"] = &[]string{"inverseoffers"}
+					mesosConn.Records["GetMesosFrameworks"] = &[]string{"default"}
+					mesosConn.Records["GetMesosSlaves"] = &[]string{"default"}
+					mesosConn.Records["GetMesosTasks"] = &[]string{"default"}
+					notebook.mesosMonitor.Refresh(gocontext.Background())
+					awsConn.Records = map[string]*[]string{
+						"DescribeInstanceById": {
+							"node1", "node2", "node3",
+						},
+						"DescribeInstancesByTag": {"one_undesired_host"},
+						"DescribeAGByName":       {"one_undesired_host_one_terminating"},
+					}
+					notebook.autoscalingGroups.Refresh(gocontext.Background())
+					notebook.DestroyInstancesAttempt(gocontext.Background())
+					Convey("completeLifeCycle should be called even with tasks running from protected frameworks", func() {
+						So(awsConn.Requests["CompleteLifecycleAction"], ShouldNotBeNil)
+					})
+				})
 				Convey("if it has no task running from protected frameworks, ", func() {
 					mesosConn.Records = map[string]*[]string{
 						"GetMesosFrameworks": {"default"},
 						"GetMesosSlaves":     {"default"},
 						"GetMesosTasks":      {"notasks"},
 					}
-					notebook.mesosMonitor.Refresh()
-					notebook.DestroyInstancesAttempt()
+					notebook.mesosMonitor.Refresh(gocontext.Background())
+					notebook.DestroyInstancesAttempt(gocontext.Background())
 					Convey("completeLifeCycle should not be called if instance lifeCycleState is not in waiting state", func() {
 						So(awsConn.Requests["CompleteLifecycleAction"], ShouldBeNil)
 					})
@@ -138,8 +163,8 @@ func TestDestroyInstanceAttempt(t *testing.T) {
 							"DescribeInstancesByTag": {"one_undesired_host"},
 							"DescribeAGByName":       {"one_undesired_host_one_terminating"},
 						}
-						notebook.autoscalingGroups.Refresh()
-						notebook.DestroyInstancesAttempt()
+						notebook.autoscalingGroups.Refresh(gocontext.Background())
+						notebook.DestroyInstancesAttempt(gocontext.Background())
 						So(awsConn.Requests["CompleteLifecycleAction"], ShouldNotBeNil)
 					})
 				})
@@ -153,15 +178,15 @@ func TestDestroyInstanceAttempt(t *testing.T) {
 				"DescribeInstancesByTag": {"two_undesired_hosts"},
 				"DescribeAGByName":       {"two_undesired_hosts_two_terminating"},
 			}
-			notebook.autoscalingGroups.Refresh()
+			notebook.autoscalingGroups.Refresh(gocontext.Background())
 			Convey("both should be removed if no delayDeleteSeconds", func() {
 				mesosConn.Records = map[string]*[]string{
 					"GetMesosFrameworks": {"default"},
 					"GetMesosSlaves":     {"default"},
 					"GetMesosTasks":      {"notasks"},
 				}
-				notebook.mesosMonitor.Refresh()
-				notebook.DestroyInstancesAttempt()
+				notebook.mesosMonitor.Refresh(gocontext.Background())
+				notebook.DestroyInstancesAttempt(gocontext.Background())
 				So(awsConn.Requests["CompleteLifecycleAction"], ShouldNotBeNil)
 				So(awsConn.Requests["CompleteLifecycleAction"], ShouldHaveLength, 2)
 			})
@@ -172,11 +197,44 @@ func TestDestroyInstanceAttempt(t *testing.T) {
 					"GetMesosSlaves":     {"default"},
 					"GetMesosTasks":      {"notasks"},
 				}
-				notebook.mesosMonitor.Refresh()
-				notebook.DestroyInstancesAttempt()
+				notebook.mesosMonitor.Refresh(gocontext.Background())
+				notebook.DestroyInstancesAttempt(gocontext.Background())
 				So(awsConn.Requests["CompleteLifecycleAction"], ShouldNotBeNil)
 				So(awsConn.Requests["CompleteLifecycleAction"], ShouldHaveLength, 1)
 			})
+			Convey("delayDeleteSeconds should be honoured after a restart", func() {
+				dir, err := ioutil.TempDir("", "deathnode-state")
+				So(err, ShouldBeNil)
+				defer os.RemoveAll(dir)
+				notebook.ctx.Store = state.NewFileStore(filepath.Join(dir, "state.json"))
+				awsConn.Records["DescribeInstancesByTag"] = &[]string{"two_undesired_hosts", "two_undesired_hosts"}
+				notebook.ctx.Conf.DelayDeleteSeconds = 100
+				mesosConn.Records = map[string]*[]string{
+					"GetMesosFrameworks": {"default"},
+					"GetMesosSlaves":     {"default"},
+					"GetMesosTasks":      {"notasks"},
+				}
+				notebook.mesosMonitor.Refresh(gocontext.Background())
+				notebook.DestroyInstancesAttempt(gocontext.Background())
+				So(awsConn.Requests["CompleteLifecycleAction"], ShouldHaveLength, 1)
+
+				awsConn.FlushMock()
+				restarted := NewNotebook(notebook.ctx, notebook.autoscalingGroups, notebook.mesosMonitor)
+				So(restarted.state.Drains, ShouldHaveLength, 2)
+				restarted.DestroyInstancesAttempt(gocontext.Background())
+				So(awsConn.Requests["CompleteLifecycleAction"], ShouldBeNil)
+			})
+			Convey("the state should be saved even if the run is cancelled", func() {
+				dir, err := ioutil.TempDir("", "deathnode-state")
+				So(err, ShouldBeNil)
+				defer os.RemoveAll(dir)
+				notebook.ctx.Store = state.NewFileStore(filepath.Join(dir, "state.json"))
+				runCtx, cancel := gocontext.WithCancel(gocontext.Background())
+				cancel()
+				So(notebook.DestroyInstancesAttempt(runCtx), ShouldNotBeNil)
+				_, err = os.Stat(filepath.Join(dir, "state.json"))
+				So(err, ShouldBeNil)
+			})
 		})
 	})
 }
@@ -198,10 +256,10 @@ func newNotebook(awsConn aws.ClientInterface, mesosConn mesos.ClientInterface, d
 	}
 
 	mesosMonitor := monitor.NewMesosMonitor(ctx)
-	mesosMonitor.Refresh()
+	mesosMonitor.Refresh(gocontext.Background())
 
 	autoscalingGroups := monitor.NewAutoscalingServiceMonitor(ctx)
-	autoscalingGroups.Refresh()
+	autoscalingGroups.Refresh(gocontext.Background())
 
 	notebook := NewNotebook(ctx, autoscalingGroups, mesosMonitor)
 	return notebook
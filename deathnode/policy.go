@@ -0,0 +1,53 @@
+package deathnode
+
+// Builds the constraints and recommender of every autoscaling group prefix from its effective policy
+
+import (
+	"github.com/alanbover/deathnode/context"
+)
+
+// policy stores the constraints and recommender used to pick the instances to remove
+type policy struct {
+	constraintsType []string
+	constraints     []constraint
+	recommenderType string
+	recommender     recommender
+}
+
+func newPolicy(conf context.Policy) (*policy, error) {
+
+	constraints := []constraint{}
+	for _, constraint := range conf.ConstraintsType {
+		newConstraint, err := newConstraint(constraint)
+		if err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, newConstraint)
+	}
+
+	recommender, err := newRecommender(conf.RecommenderType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &policy{
+		constraintsType: conf.ConstraintsType,
+		constraints:     constraints,
+		recommenderType: conf.RecommenderType,
+		recommender:     recommender,
+	}, nil
+}
+
+// newPolicies returns the policy of every autoscaling group prefix, or an error if any of them is not valid
+func newPolicies(conf *context.ApplicationConf) (map[string]*policy, error) {
+
+	policies := map[string]*policy{}
+	for _, autoscalingGroupPrefix := range conf.AutoscalingGroupPrefixes {
+		policy, err := newPolicy(conf.Policy(autoscalingGroupPrefix))
+		if err != nil {
+			return nil, err
+		}
+		policies[autoscalingGroupPrefix] = policy
+	}
+	return policies, nil
+}
@@ -1,6 +1,7 @@
 package deathnode
 
 import (
+	gocontext "context"
 	"github.com/alanbover/deathnode/aws"
 	"github.com/alanbover/deathnode/context"
 	"github.com/alanbover/deathnode/monitor"
@@ -41,7 +42,7 @@ func prepareMonitors(awsConn *aws.ConnectionMock) *monitor.AutoscalingGroupMonit
 	}
 
 	autoscalingGroups := monitor.NewAutoscalingServiceMonitor(ctx)
-	autoscalingGroups.Refresh()
+	autoscalingGroups.Refresh(gocontext.Background())
 
 	return autoscalingGroups.GetAutoscalingGroupMonitorsList()[0]
 }
@@ -0,0 +1,103 @@
+package deathnode
+
+// Triggers the Watcher periodically, making sure that two runs never overlap
+
+import (
+	gocontext "context"
+	"github.com/alanbover/deathnode/context"
+	"github.com/benbjohnson/clock"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type runner interface {
+	Run(runCtx gocontext.Context)
+}
+
+// Scheduler runs the Watcher every polling interval. If a run is still in flight when the next tick
+// arrives, the tick is skipped instead of starting a concurrent run
+type Scheduler struct {
+	watcher         runner
+	clock           clock.Clock
+	interval        time.Duration
+	shutdownTimeout time.Duration
+	running         int32
+	skippedRuns     int64
+	wg              sync.WaitGroup
+}
+
+// NewScheduler returns a new Scheduler object
+func NewScheduler(ctx *context.ApplicationContext, watcher *Watcher, interval,
+	shutdownTimeout time.Duration) *Scheduler {
+
+	return &Scheduler{
+		watcher:         watcher,
+		clock:           ctx.Clock,
+		interval:        interval,
+		shutdownTimeout: shutdownTimeout,
+	}
+}
+
+// SkippedRuns returns the number of ticks skipped because the previous run was still in flight
+func (s *Scheduler) SkippedRuns() int64 {
+	return atomic.LoadInt64(&s.skippedRuns)
+}
+
+// Start triggers a run immediately and then once every interval, until ctx is done. On shutdown it waits
+// up to shutdownTimeout for the in-flight run to finish, and cancels it afterwards
+func (s *Scheduler) Start(ctx gocontext.Context) {
+
+	// Runs are not derived from ctx, so a shutdown lets the in-flight run finish before cancelling it
+	runCtx, cancelRun := gocontext.WithCancel(gocontext.Background())
+	defer cancelRun()
+
+	ticker := s.clock.Ticker(s.interval)
+	defer ticker.Stop()
+
+	s.trigger(runCtx)
+	for {
+		select {
+		case <-ticker.C:
+			s.trigger(runCtx)
+		case <-ctx.Done():
+			s.shutdown(cancelRun)
+			return
+		}
+	}
+}
+
+func (s *Scheduler) trigger(runCtx gocontext.Context) {
+
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		skippedRuns := atomic.AddInt64(&s.skippedRuns, 1)
+		log.Warnf("Previous run still in progress, skipping this one (%d runs skipped so far)", skippedRuns)
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer atomic.StoreInt32(&s.running, 0)
+		s.watcher.Run(runCtx)
+	}()
+}
+
+func (s *Scheduler) shutdown(cancelRun gocontext.CancelFunc) {
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	log.Info("Shutting down, waiting for the in-flight run to finish")
+	select {
+	case <-done:
+	case <-s.clock.After(s.shutdownTimeout):
+		log.Warnf("In-flight run didn't finish after %v, cancelling it", s.shutdownTimeout)
+		cancelRun()
+		<-done
+	}
+}
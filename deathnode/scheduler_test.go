@@ -0,0 +1,100 @@
+package deathnode
+
+import (
+	gocontext "context"
+	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type blockingRunner struct {
+	runs      int32
+	cancelled int32
+	release   chan struct{}
+}
+
+func (r *blockingRunner) Run(runCtx gocontext.Context) {
+
+	atomic.AddInt32(&r.runs, 1)
+	select {
+	case <-r.release:
+	case <-runCtx.Done():
+		atomic.StoreInt32(&r.cancelled, 1)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+
+	Convey("When the scheduler is started", t, func() {
+		clockMock := clock.NewMock()
+		runner := &blockingRunner{release: make(chan struct{})}
+		scheduler := &Scheduler{
+			watcher:         runner,
+			clock:           clockMock,
+			interval:        time.Minute,
+			shutdownTimeout: time.Minute,
+		}
+
+		ctx, cancel := gocontext.WithCancel(gocontext.Background())
+		stopped := make(chan struct{})
+		go func() {
+			scheduler.Start(ctx)
+			close(stopped)
+		}()
+
+		Convey("it should trigger a run immediately", func() {
+			So(eventually(func() bool { return atomic.LoadInt32(&runner.runs) == 1 }), ShouldBeTrue)
+		})
+		Convey("while a run is in flight", func() {
+			So(eventually(func() bool { return atomic.LoadInt32(&runner.runs) == 1 }), ShouldBeTrue)
+			clockMock.Add(time.Minute)
+			Convey("the next tick should be skipped", func() {
+				So(eventually(func() bool { return scheduler.SkippedRuns() == 1 }), ShouldBeTrue)
+				So(atomic.LoadInt32(&runner.runs), ShouldEqual, 1)
+			})
+		})
+		Convey("after the run finishes, the next tick should trigger a new run", func() {
+			So(eventually(func() bool { return atomic.LoadInt32(&runner.runs) == 1 }), ShouldBeTrue)
+			runner.release <- struct{}{}
+			So(eventually(func() bool { return atomic.LoadInt32(&scheduler.running) == 0 }), ShouldBeTrue)
+			clockMock.Add(time.Minute)
+			So(eventually(func() bool { return atomic.LoadInt32(&runner.runs) == 2 }), ShouldBeTrue)
+			So(scheduler.SkippedRuns(), ShouldEqual, 0)
+		})
+		Convey("when it's stopped", func() {
+			So(eventually(func() bool { return atomic.LoadInt32(&runner.runs) == 1 }), ShouldBeTrue)
+			cancel()
+			Convey("it should let the in-flight run finish", func() {
+				runner.release <- struct{}{}
+				<-stopped
+				So(atomic.LoadInt32(&runner.cancelled), ShouldEqual, 0)
+			})
+			Convey("it should cancel the in-flight run after the shutdown timeout", func() {
+				So(eventually(func() bool {
+					clockMock.Add(time.Minute)
+					return atomic.LoadInt32(&runner.cancelled) == 1
+				}), ShouldBeTrue)
+				<-stopped
+			})
+		})
+
+		Reset(func() {
+			cancel()
+			close(runner.release)
+			<-stopped
+		})
+	})
+}
+
+func eventually(condition func() bool) bool {
+
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package deathnode
+
+// Snapshot of the monitors state, taken at the end of every run so it can be read while the next run is in flight
+
+import (
+	"github.com/alanbover/deathnode/context"
+	"github.com/alanbover/deathnode/monitor"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status stores the state of all the monitored autoscaling groups on the last run. The instances are not marked
+// nor destroyed while the Mesos state is not trusted
+type Status struct {
+	LastRun                  time.Time                      `json:"lastRun"`
+	Mesos                    monitor.MesosCacheStatus       `json:"mesos"`
+	ClusterDrainBudget       BudgetStatus                   `json:"clusterDrainBudget"`
+	AutoscalingGroupPrefixes []AutoscalingGroupPrefixStatus `json:"autoscalingGroupPrefixes"`
+}
+
+// AutoscalingGroupPrefixStatus stores the state of the autoscaling groups found for a prefix
+type AutoscalingGroupPrefixStatus struct {
+	Prefix            string                   `json:"prefix"`
+	AutoscalingGroups []AutoscalingGroupStatus `json:"autoscalingGroups"`
+}
+
+// AutoscalingGroupStatus stores the state of an autoscaling group
+type AutoscalingGroupStatus struct {
+	Name               string           `json:"name"`
+	DesiredCapacity    int64            `json:"desiredCapacity"`
+	UndesiredInstances int              `json:"undesiredInstances"`
+	DrainBudget        BudgetStatus     `json:"drainBudget"`
+	Instances          []InstanceStatus `json:"instances"`
+}
+
+// BudgetStatus stores the remaining drain budget. Unlimited budgets are omitted
+type BudgetStatus struct {
+	RemainingDrains      *int `json:"remainingDrains,omitempty"`
+	RemainingCompletions *int `json:"remainingCompletions,omitempty"`
+}
+
+// InstanceStatus stores the state of an instance. BlockedByMesos is true when it's running tasks protected by
+// the policy of its autoscaling group. InverseOffers are the responses of the frameworks to the inverse offers of
+// its agent, by framework ID, while it's draining in a maintenance window
+type InstanceStatus struct {
+	InstanceID          string            `json:"instanceId"`
+	IP                  string            `json:"ip"`
+	LifecycleState      string            `json:"lifecycleState"`
+	IsProtected         bool              `json:"isProtected"`
+	MarkedToBeRemoved   bool              `json:"markedToBeRemoved"`
+	DrainReason         string            `json:"drainReason,omitempty"`
+	TagRemovalTimestamp int64             `json:"tagRemovalTimestamp"`
+	BlockedByMesos      bool              `json:"blockedByMesos"`
+	InverseOffers       map[string]string `json:"inverseOffers,omitempty"`
+}
+
+// statusStore holds the last status snapshot
+type statusStore struct {
+	mutex  sync.RWMutex
+	status Status
+}
+
+func (s *statusStore) get() Status {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.status
+}
+
+func (s *statusStore) set(status Status) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status = status
+}
+
+// Status returns the state of the monitored autoscaling groups on the last run. It's safe to call it while the
+// watcher is running
+func (y *Watcher) Status() Status {
+	return y.status.get()
+}
+
+// updateStatus takes a snapshot of the monitors. It must be called while holding the watcher mutex
+func (y *Watcher) updateStatus() {
+
+	prefixes := map[string]*AutoscalingGroupPrefixStatus{}
+	for _, autoscalingGroupPrefix := range y.ctx.Conf.AutoscalingGroupPrefixes {
+		prefixes[autoscalingGroupPrefix] = &AutoscalingGroupPrefixStatus{
+			Prefix:            autoscalingGroupPrefix,
+			AutoscalingGroups: []AutoscalingGroupStatus{},
+		}
+	}
+
+	for _, autoscalingMonitor := range y.autoscalingServiceMonitor.GetAutoscalingGroupMonitorsList() {
+		prefix, ok := prefixes[autoscalingMonitor.AutoscalingGroupPrefix()]
+		if !ok {
+			continue
+		}
+		prefix.AutoscalingGroups = append(prefix.AutoscalingGroups, y.autoscalingGroupStatus(autoscalingMonitor))
+	}
+
+	status := Status{
+		LastRun: y.ctx.Clock.Now(),
+		Mesos:   y.mesosMonitor.CacheStatus(),
+		ClusterDrainBudget: newBudgetStatus(
+			y.notebook.clusterRemainingDrains(), y.notebook.clusterRemainingCompletions()),
+		AutoscalingGroupPrefixes: []AutoscalingGroupPrefixStatus{},
+	}
+	for _, autoscalingGroupPrefix := range y.ctx.Conf.AutoscalingGroupPrefixes {
+		prefix := prefixes[autoscalingGroupPrefix]
+		sort.Slice(prefix.AutoscalingGroups, func(i, j int) bool {
+			return prefix.AutoscalingGroups[i].Name < prefix.AutoscalingGroups[j].Name
+		})
+		status.AutoscalingGroupPrefixes = append(status.AutoscalingGroupPrefixes, *prefix)
+	}
+
+	y.status.set(status)
+}
+
+func (y *Watcher) autoscalingGroupStatus(autoscalingMonitor *monitor.AutoscalingGroupMonitor) AutoscalingGroupStatus {
+
+	instances := []InstanceStatus{}
+	for _, instanceMonitor := range autoscalingMonitor.GetAllInstances() {
+		inverseOffers, _ := y.mesosMonitor.GetInverseOfferStatuses(instanceMonitor.IP())
+		instances = append(instances, InstanceStatus{
+			InstanceID:          *instanceMonitor.InstanceID(),
+			IP:                  instanceMonitor.IP(),
+			LifecycleState:      instanceMonitor.LifecycleState(),
+			IsProtected:         instanceMonitor.IsProtected(),
+			MarkedToBeRemoved:   instanceMonitor.IsMarkedToBeRemoved(),
+			DrainReason:         instanceMonitor.DrainReason(),
+			TagRemovalTimestamp: instanceMonitor.TagRemovalTimestamp(),
+			BlockedByMesos:      y.mesosMonitor.IsProtected(instanceMonitor.IP(), instanceMonitor.Policy()),
+			InverseOffers:       inverseOffers,
+		})
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].InstanceID < instances[j].InstanceID
+	})
+
+	return AutoscalingGroupStatus{
+		Name:               autoscalingMonitor.AutoscalingGroupName(),
+		DesiredCapacity:    autoscalingMonitor.DesiredCapacity(),
+		UndesiredInstances: autoscalingMonitor.GetNumUndesiredInstances(),
+		DrainBudget: newBudgetStatus(
+			y.notebook.autoscalingGroupRemainingDrains(autoscalingMonitor),
+			y.notebook.autoscalingGroupRemainingCompletions(autoscalingMonitor)),
+		Instances: instances,
+	}
+}
+
+func newBudgetStatus(remainingDrains, remainingCompletions int) BudgetStatus {
+
+	status := BudgetStatus{}
+	if remainingDrains != context.Unlimited {
+		status.RemainingDrains = &remainingDrains
+	}
+	if remainingCompletions != context.Unlimited {
+		status.RemainingCompletions = &remainingCompletions
+	}
+	return status
+}
@@ -3,18 +3,29 @@ package deathnode
 // Given an autoscaling group, decides which is/are the best agent/s to kill
 
 import (
+	gocontext "context"
+	"github.com/alanbover/deathnode/audit"
 	"github.com/alanbover/deathnode/context"
+	"github.com/alanbover/deathnode/election"
 	"github.com/alanbover/deathnode/monitor"
 	log "github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
 )
 
+// defaultLeaseRenewInterval is used to renew the leadership lease when no TTL is configured
+const defaultLeaseRenewInterval = 10 * time.Second
+
 // Watcher stores the enough information for decide, if instances need to be removed, which ones are the best
 type Watcher struct {
 	notebook                  *Notebook
 	mesosMonitor              *monitor.MesosMonitor
 	autoscalingServiceMonitor *monitor.AutoscalingServiceMonitor
-	constraints               []constraint
-	recommender               recommender
+	policies                  map[string]*policy
+	ctx                       *context.ApplicationContext
+	mutex                     sync.Mutex
+	status                    statusStore
 }
 
 // NewWatcher returns a new Watcher object
@@ -23,16 +34,7 @@ func NewWatcher(ctx *context.ApplicationContext) *Watcher {
 	autoscalingServiceMonitor := monitor.NewAutoscalingServiceMonitor(ctx)
 	mesosMonitor := monitor.NewMesosMonitor(ctx)
 
-	constraints := []constraint{}
-	for _, constraint := range ctx.Conf.ConstraintsType {
-		newConstraint, err := newConstraint(constraint)
-		if err != nil {
-			log.Fatal(err)
-		}
-		constraints = append(constraints, newConstraint)
-	}
-
-	recommender, err := newRecommender(ctx.Conf.RecommenderType)
+	policies, err := newPolicies(&ctx.Conf)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -40,56 +42,275 @@ func NewWatcher(ctx *context.ApplicationContext) *Watcher {
 	return &Watcher{
 		notebook:                  NewNotebook(ctx, autoscalingServiceMonitor, mesosMonitor),
 		mesosMonitor:              mesosMonitor,
-		constraints:               constraints,
-		recommender:               recommender,
+		policies:                  policies,
 		autoscalingServiceMonitor: autoscalingServiceMonitor,
+		ctx:                       ctx,
 	}
 }
 
+// FollowMesosEvents keeps the Mesos state up to date with the event stream of the master until ctx is done
+func (y *Watcher) FollowMesosEvents(ctx gocontext.Context) {
+	y.mesosMonitor.FollowEvents(ctx)
+}
+
 // TagInstancesToBeRemoved finds, if any instances to be removed for an autoscaling group, the best instances to
 // kill and tags them to be removed
-func (y *Watcher) TagInstancesToBeRemoved(autoscalingMonitor *monitor.AutoscalingGroupMonitor) {
+func (y *Watcher) TagInstancesToBeRemoved(runCtx gocontext.Context, autoscalingMonitor *monitor.AutoscalingGroupMonitor) {
+
+	policy, ok := y.policies[autoscalingMonitor.AutoscalingGroupPrefix()]
+	if !ok {
+		log.Errorf("No policy found for autoscalingGroupPrefix %s", autoscalingMonitor.AutoscalingGroupPrefix())
+		return
+	}
 
 	numUndesiredInstances := autoscalingMonitor.GetNumUndesiredInstances()
 	log.Debugf("Undesired Mesos Agents: %d", numUndesiredInstances)
 
 	for removedInstances := 0; removedInstances < numUndesiredInstances; removedInstances++ {
 
+		if y.notebook.remainingDrains(autoscalingMonitor) == 0 {
+			log.Infof("Drain budget exhausted for autoscaling group %s, %d instances will be marked later",
+				autoscalingMonitor.AutoscalingGroupName(), numUndesiredInstances-removedInstances)
+			y.ctx.Audit.Record(audit.MarkDeferred, autoscalingMonitor.AutoscalingGroupName(), "",
+				map[string]interface{}{"undesiredInstances": numUndesiredInstances - removedInstances})
+			break
+		}
+
 		allowedInstances := autoscalingMonitor.GetInstances()
-		for _, constraint := range y.constraints {
+		candidates := instanceIDs(allowedInstances)
+		constraintSteps := []map[string]interface{}{}
+		for i, constraint := range policy.constraints {
 			allowedInstances = constraint.filter(allowedInstances, y.mesosMonitor)
+			constraintSteps = append(constraintSteps, map[string]interface{}{
+				"constraint": policy.constraintsType[i],
+				"candidates": instanceIDs(allowedInstances),
+			})
 		}
-		bestInstance := y.recommender.find(allowedInstances)
+		bestInstance := policy.recommender.find(allowedInstances)
 
 		log.Debugf("Tagging instance %s for removal", *bestInstance.InstanceID())
-		if err := bestInstance.TagToBeRemoved(); err != nil {
+		err := bestInstance.TagToBeRemoved(runCtx)
+		details := map[string]interface{}{
+			"undesiredInstances": numUndesiredInstances - removedInstances,
+			"candidates":         candidates,
+			"constraints":        constraintSteps,
+			"recommender":        policy.recommenderType,
+			"tagged":             err == nil,
+		}
+		if err != nil {
+			details["error"] = err.Error()
+		}
+		y.ctx.Audit.Record(audit.CandidateSelection, autoscalingMonitor.AutoscalingGroupName(),
+			*bestInstance.InstanceID(), details)
+
+		if err != nil {
 			log.Errorf("Unable to tag instance %s for removal", bestInstance.IP())
 			log.Error(err)
 			break
 		}
+		y.notebook.recordDrain(bestInstance)
+		y.notebook.saveState()
+		y.notifyDrainStarted(bestInstance)
 	}
 }
 
+// UnmarkSurplusInstances reverts the scale-in marks not needed anymore because the desired capacity of their
+// autoscaling group has grown back. The instances get their instance protection back before checking them again,
+// so AWS can't pick them while they are unmarked. They leave the Mesos maintenance schedule on this same run
+func (y *Watcher) UnmarkSurplusInstances(runCtx gocontext.Context) {
+
+	surplus := []*monitor.InstanceMonitor{}
+	reprotected := false
+	for _, autoscalingMonitor := range y.autoscalingServiceMonitor.GetAutoscalingGroupMonitorsList() {
+		for _, instanceMonitor := range autoscalingMonitor.GetSurplusMarkedInstances() {
+			if !instanceMonitor.IsProtected() {
+				if err := instanceMonitor.SetInstanceProtection(runCtx); err != nil {
+					log.Errorf("Unable to set instance protection back for instance %s: %s",
+						*instanceMonitor.InstanceID(), err)
+					continue
+				}
+				reprotected = true
+			}
+			surplus = append(surplus, instanceMonitor)
+		}
+	}
+	if len(surplus) == 0 {
+		return
+	}
+
+	// AWS may have started terminating them before they were protected again
+	if reprotected {
+		if err := y.autoscalingServiceMonitor.Refresh(runCtx); err != nil {
+			log.Error(err)
+			return
+		}
+	}
+
+	for _, instanceMonitor := range surplus {
+		instanceID := *instanceMonitor.InstanceID()
+		instanceMonitor, autoscalingMonitor, err := y.findInstance(instanceID)
+		if err != nil || instanceMonitor.IsTerminating() {
+			log.Infof("Instance %s is already being terminated, keeping it marked", instanceID)
+			continue
+		}
+
+		log.Infof("Unmarking instance %s, autoscaling group %s desired capacity grew to %d",
+			instanceID, autoscalingMonitor.AutoscalingGroupName(), autoscalingMonitor.DesiredCapacity())
+		if err := instanceMonitor.UntagToBeRemoved(runCtx); err != nil {
+			log.Errorf("Unable to unmark instance %s: %s", instanceID, err)
+			continue
+		}
+
+		y.notebook.forgetDrain(instanceID)
+		y.notebook.saveState()
+		y.ctx.Audit.Record(audit.DrainCancelled, autoscalingMonitor.AutoscalingGroupName(), instanceID,
+			map[string]interface{}{
+				"reason":          "desiredCapacityIncreased",
+				"desiredCapacity": autoscalingMonitor.DesiredCapacity(),
+			})
+		y.notifyDrainCancelled(instanceMonitor)
+	}
+}
+
+func instanceIDs(instanceMonitors []*monitor.InstanceMonitor) []string {
+
+	ids := []string{}
+	for _, instanceMonitor := range instanceMonitors {
+		ids = append(ids, *instanceMonitor.InstanceID())
+	}
+	sort.Strings(ids)
+	return ids
+}
+
 // DestroyInstancesAttempt try for those instances marked to be deleted to delete them
-func (y *Watcher) DestroyInstancesAttempt() {
+func (y *Watcher) DestroyInstancesAttempt(runCtx gocontext.Context) {
 
-	err := y.notebook.DestroyInstancesAttempt()
+	err := y.notebook.DestroyInstancesAttempt(runCtx)
 	if err != nil {
 		log.Error(err)
 	}
 }
 
-// Run starts the process of check instances to be killed and try to kill them for all Autoscalings
-func (y *Watcher) Run() {
+// Reload validates conf and, if it's valid, replaces the running configuration once the in-flight run finishes.
+// The monitors of the autoscaling groups that are still monitored keep their state, and the drain strategy is
+// only replaced if it changed
+func (y *Watcher) Reload(conf context.ApplicationConf) error {
+
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	policies, err := newPolicies(&conf)
+	if err != nil {
+		return err
+	}
+	drainStrategy, err := newDrainStrategy(y.notebook, conf.DrainStrategy)
+	if err != nil {
+		return err
+	}
+
+	y.mutex.Lock()
+	defer y.mutex.Unlock()
+
+	if conf.DrainStrategy != y.ctx.Conf.DrainStrategy {
+		y.notebook.drainStrategy = drainStrategy
+	}
+	y.ctx.Conf = conf
+	y.policies = policies
+	y.autoscalingServiceMonitor.SetAutoscalingGroupPrefixes(conf.AutoscalingGroupPrefixes)
+	y.ctx.Notifier.SetWebhooks(conf.Webhooks)
+	y.updateStatus()
+	log.Info("Configuration reloaded")
+	return nil
+}
+
+// Run starts the process of check instances to be killed and try to kill them for all Autoscalings.
+// If runCtx is cancelled the run stops before performing any further action
+func (y *Watcher) Run(runCtx gocontext.Context) {
 
 	log.Debug("New check triggered")
 
-	y.autoscalingServiceMonitor.Refresh()
-	y.mesosMonitor.Refresh()
+	y.mutex.Lock()
+	defer y.mutex.Unlock()
+
+	if y.ctx.Elector != nil {
+		leaseCtx, cancel, ok := y.lead(runCtx)
+		if !ok {
+			return
+		}
+		defer cancel()
+		runCtx = leaseCtx
+	}
+
+	y.autoscalingServiceMonitor.Refresh(runCtx)
+	y.mesosMonitor.Refresh(runCtx)
+	defer y.updateStatus()
+	defer y.updateMetrics()
+
+	// A failed, partial or stale Mesos refresh would report the agents as unprotected
+	if cacheStatus := y.mesosMonitor.CacheStatus(); !cacheStatus.Trusted {
+		y.runDegraded(runCtx, cacheStatus)
+		return
+	}
+
+	y.UnmarkSurplusInstances(runCtx)
 
 	for _, autoscalingGroup := range y.autoscalingServiceMonitor.GetAutoscalingGroupMonitorsList() {
-		y.TagInstancesToBeRemoved(autoscalingGroup)
+		if y.isCancelled(runCtx) {
+			return
+		}
+		y.TagInstancesToBeRemoved(runCtx, autoscalingGroup)
+	}
+
+	if y.isCancelled(runCtx) {
+		return
+	}
+	y.DestroyInstancesAttempt(runCtx)
+}
+
+// runDegraded skips marking and destroying instances while the Mesos state is not trusted. The lifecycle hooks
+// of the marked instances are still kept alive, so AWS doesn't apply their default result in the meantime
+func (y *Watcher) runDegraded(runCtx gocontext.Context, cacheStatus monitor.MesosCacheStatus) {
+
+	log.Warnf("Mesos state not trusted (endpoints %v, stale %t, last refreshed at %s), skipping instances mark "+
+		"and destroy", cacheStatus.Endpoints, cacheStatus.Stale, cacheStatus.RefreshedAt)
+	y.ctx.Audit.Record(audit.RunDegraded, "", "", map[string]interface{}{
+		"endpoints":   cacheStatus.Endpoints,
+		"stale":       cacheStatus.Stale,
+		"refreshedAt": cacheStatus.RefreshedAt,
+	})
+
+	if err := y.notebook.RefreshLifecycles(runCtx); err != nil {
+		log.Error(err)
 	}
+}
 
-	y.DestroyInstancesAttempt()
+// lead acquires the leadership lease, returning a context that gets cancelled if the lease is lost during the run
+func (y *Watcher) lead(runCtx gocontext.Context) (gocontext.Context, gocontext.CancelFunc, bool) {
+
+	ok, err := y.ctx.Elector.Acquire(runCtx)
+	if err != nil {
+		log.Warnf("Unable to acquire leadership lease: %s", err)
+	}
+	if !ok {
+		log.Debug("Not the leader, skipping check")
+		return nil, nil, false
+	}
+
+	renewInterval := defaultLeaseRenewInterval
+	if y.ctx.Conf.LeaderLeaseTTLSeconds > 0 {
+		renewInterval = time.Duration(y.ctx.Conf.LeaderLeaseTTLSeconds) * time.Second / 3
+	}
+
+	leaseCtx, cancel := election.WithLease(runCtx, y.ctx.Elector, renewInterval, y.ctx.Clock)
+	return leaseCtx, cancel, true
+}
+
+func (y *Watcher) isCancelled(runCtx gocontext.Context) bool {
+
+	if err := runCtx.Err(); err != nil {
+		log.Warnf("Run cancelled: %s", err)
+		return true
+	}
+	return false
 }
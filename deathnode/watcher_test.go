@@ -1,12 +1,23 @@
 package deathnode
 
 import (
+	gocontext "context"
+	"encoding/json"
 	"fmt"
+	"github.com/alanbover/deathnode/audit"
 	"github.com/alanbover/deathnode/aws"
 	"github.com/alanbover/deathnode/context"
+	"github.com/alanbover/deathnode/dryrun"
 	"github.com/alanbover/deathnode/mesos"
+	"github.com/alanbover/deathnode/metrics"
+	"github.com/alanbover/deathnode/notify"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 type testCollectionValues struct {
@@ -258,6 +269,534 @@ func runWatcher(testValues testCollectionValues) {
 
 	watcher := newWatcher(testValues)
 	for iter := 0; iter < testValues.times; iter++ {
-		watcher.Run()
+		watcher.Run(gocontext.Background())
 	}
 }
+
+type electorMock struct {
+	leader bool
+}
+
+func (e *electorMock) Acquire(ctx gocontext.Context) (bool, error) {
+	return e.leader, nil
+}
+
+func (e *electorMock) IsLeader() bool {
+	return e.leader
+}
+
+func (e *electorMock) Release(ctx gocontext.Context) error {
+	e.leader = false
+	return nil
+}
+
+// leaseLosingConnection loses the leadership lease when notebook starts looking for instances to destroy
+type leaseLosingConnection struct {
+	*aws.ConnectionMock
+	elector *electorMock
+}
+
+func (c *leaseLosingConnection) DescribeInstancesByTag(ctx gocontext.Context, tagKey string) ([]*ec2.Instance, error) {
+	c.elector.leader = false
+	return c.ConnectionMock.DescribeInstancesByTag(ctx, tagKey)
+}
+
+func TestWatcherLeadership(t *testing.T) {
+
+	Convey("When running the watcher with leader election", t, func() {
+		values := testCollectionValues{
+			awsConn: &aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById": {
+						"node1", "node2", "node3",
+					},
+					"DescribeInstancesByTag": {"one_undesired_host"},
+					"DescribeAGByName":       {"one_undesired_host_one_terminating"},
+				},
+			},
+			mesosConn: &mesos.ClientMock{
+				Records: map[string]*[]string{
+					"GetMesosFrameworks": {"default"},
+					"GetMesosSlaves":     {"default"},
+					"GetMesosTasks":      {"notasks"},
+				},
+			},
+		}
+		elector := &electorMock{}
+		watcher := newWatcher(values)
+		watcher.ctx.Elector = elector
+
+		Convey("if it's not the leader, it should not do anything", func() {
+			watcher.Run(gocontext.Background())
+			So(values.awsConn.Requests, ShouldBeEmpty)
+			So(values.mesosConn.Requests, ShouldBeEmpty)
+		})
+		Convey("if it's the leader, it should tag and destroy instances", func() {
+			elector.leader = true
+			watcher.Run(gocontext.Background())
+			So(values.awsConn.Requests["SetInstanceTag"], ShouldHaveLength, 1)
+			So(values.awsConn.Requests["CompleteLifecycleAction"], ShouldHaveLength, 1)
+		})
+		Convey("if it loses the lease in the middle of the run", func() {
+			elector.leader = true
+			watcher.ctx.AwsConn = &leaseLosingConnection{ConnectionMock: values.awsConn, elector: elector}
+			watcher.Run(gocontext.Background())
+			Convey("it should stop before completing any lifecycle action", func() {
+				So(values.awsConn.Requests["SetInstanceTag"], ShouldHaveLength, 1)
+				So(values.awsConn.Requests["CompleteLifecycleAction"], ShouldBeNil)
+				So(values.mesosConn.Requests, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestWatcherDryRun(t *testing.T) {
+
+	Convey("When running the watcher in dry-run mode", t, func() {
+		values := testCollectionValues{
+			awsConn: &aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById": {
+						"node1", "node2", "node3", "node1_with_dns", "node1_with_dns", "node1_with_dns",
+					},
+					"DescribeInstancesByTag": {"default", "default", "default"},
+					"DescribeAGByName":       {"one_undesired_host", "one_undesired_host", "one_undesired_host"},
+				},
+			},
+			mesosConn: &mesos.ClientMock{
+				Records: map[string]*[]string{
+					"GetMesosFrameworks": {"default", "default", "default"},
+					"GetMesosSlaves":     {"default", "default", "default"},
+					"GetMesosTasks":      {"notasks", "notasks", "notasks"},
+				},
+			},
+		}
+		watcher := newWatcher(values)
+		plan := dryrun.NewPlan(clock.New())
+		watcher.ctx.AwsConn = aws.NewDryRunClient(values.awsConn, plan)
+		watcher.ctx.MesosConn = mesos.NewDryRunClient(values.mesosConn, plan)
+
+		watcher.Run(gocontext.Background())
+		Convey("no mutating call should be performed", func() {
+			So(values.awsConn.Requests, ShouldBeEmpty)
+			So(values.mesosConn.Requests, ShouldBeEmpty)
+		})
+		Convey("the first run should plan to tag, set in maintenance and unprotect the instance", func() {
+			So(plan.Operations(), ShouldResemble, []string{
+				"PutLifeCycleHook", "SetASGInstanceProtection", "SetInstanceTag",
+				"UpdateMaintenanceSchedule", "RemoveASGInstanceProtection",
+			})
+		})
+		Convey("the next run should plan to complete its lifecycle action", func() {
+			watcher.Run(gocontext.Background())
+			operations := plan.Operations()
+			So(operations, ShouldHaveLength, 6)
+			So(operations[5], ShouldEqual, "CompleteLifecycleAction")
+			So(plan.Actions()[5].Params["instanceId"], ShouldEqual, "i-34719eb8")
+			So(values.awsConn.Requests, ShouldBeEmpty)
+		})
+		Convey("the run after its termination should move its agent down and up, and prune it from the schedule", func() {
+			watcher.Run(gocontext.Background())
+			watcher.Run(gocontext.Background())
+			So(plan.Operations()[6:], ShouldResemble, []string{
+				"StartMaintenance", "StopMaintenance", "UpdateMaintenanceSchedule",
+			})
+			So(plan.Actions()[8].Params["machines"], ShouldEqual, "")
+			So(values.mesosConn.Requests, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestWatcherReload(t *testing.T) {
+
+	Convey("When reloading the watcher configuration", t, func() {
+		values := testCollectionValues{
+			awsConn: &aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById":   {"node1", "node2", "node3"},
+					"DescribeInstancesByTag": {"default", "default"},
+					"DescribeAGByName":       {"default", "default"},
+				},
+			},
+			mesosConn: &mesos.ClientMock{
+				Records: map[string]*[]string{
+					"GetMesosFrameworks": {"default", "default"},
+					"GetMesosSlaves":     {"default", "default"},
+					"GetMesosTasks":      {"default", "default"},
+				},
+			},
+		}
+		watcher := newWatcher(values)
+		watcher.Run(gocontext.Background())
+		autoscalingMonitor := watcher.autoscalingServiceMonitor.GetAutoscalingGroupMonitorsList()[0]
+		conf := watcher.ctx.Conf
+
+		Convey("an invalid configuration should be rejected without touching the running one", func() {
+			conf.RecommenderType = "noExistingRecommender"
+			So(watcher.Reload(conf), ShouldNotBeNil)
+			So(watcher.ctx.Conf.RecommenderType, ShouldEqual, "smallestInstanceId")
+			So(watcher.policies, ShouldContainKey, "some-Autoscaling-Group")
+		})
+		Convey("a valid configuration should be applied", func() {
+			conf.AutoscalingGroupPrefixes = []string{"some-Autoscaling-Group", "other-Autoscaling-Group"}
+			conf.PolicyOverrides = map[string]context.PolicyOverride{
+				"other-Autoscaling-Group": {ConstraintsType: []string{"protectedConstraint"}},
+			}
+			So(watcher.Reload(conf), ShouldBeNil)
+			So(watcher.policies, ShouldContainKey, "other-Autoscaling-Group")
+			Convey("keeping the state of the autoscaling groups still monitored", func() {
+				So(watcher.autoscalingServiceMonitor.GetAutoscalingGroupMonitorsList(), ShouldHaveLength, 1)
+				So(watcher.autoscalingServiceMonitor.GetAutoscalingGroupMonitorsList()[0], ShouldEqual, autoscalingMonitor)
+			})
+		})
+		Convey("a new drain strategy should be used from the next run", func() {
+			conf.DrainStrategy = context.DrainStrategyDrainAgent
+			conf.MesosAPI = context.MesosAPIV1
+			So(watcher.Reload(conf), ShouldBeNil)
+			So(watcher.notebook.drainStrategy, ShouldHaveSameTypeAs, &drainAgentStrategy{})
+		})
+		Convey("the drainAgent strategy should be rejected with the legacy Mesos API", func() {
+			conf.DrainStrategy = context.DrainStrategyDrainAgent
+			So(watcher.Reload(conf), ShouldNotBeNil)
+			So(watcher.notebook.drainStrategy, ShouldHaveSameTypeAs, &maintenanceStrategy{})
+		})
+		Convey("a new policy for an autoscaling group already monitored", func() {
+			conf.ConstraintsType = []string{"protectedConstraint"}
+			So(watcher.Reload(conf), ShouldBeNil)
+			Convey("should not enforce its lifecycle hook or protection again on the next run", func() {
+				values.awsConn.Requests = map[string][][]string{}
+				watcher.Run(gocontext.Background())
+				So(values.awsConn.Requests["PutLifeCycleHook"], ShouldBeEmpty)
+				So(values.awsConn.Requests["SetASGInstanceProtection"], ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestWatcherStatus(t *testing.T) {
+
+	Convey("When the watcher has run", t, func() {
+		values := testCollectionValues{
+			awsConn: &aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById":   {"node1", "node2", "node3"},
+					"DescribeInstancesByTag": {"default"},
+					"DescribeAGByName":       {"one_undesired_host"},
+				},
+			},
+			mesosConn: &mesos.ClientMock{
+				Records: map[string]*[]string{
+					"GetMesosFrameworks":   {"default"},
+					"GetMesosSlaves":       {"default"},
+					"GetMesosTasks":        {"default"},
+					"GetMaintenanceStatus": {"inverseoffers"},
+				},
+			},
+		}
+		watcher := newWatcher(values)
+		watcher.Run(gocontext.Background())
+		status := watcher.Status()
+
+		Convey("its status should contain the monitored autoscaling groups", func() {
+			So(status.AutoscalingGroupPrefixes, ShouldHaveLength, 1)
+			So(status.AutoscalingGroupPrefixes[0].Prefix, ShouldEqual, "some-Autoscaling-Group")
+			autoscalingGroups := status.AutoscalingGroupPrefixes[0].AutoscalingGroups
+			So(autoscalingGroups, ShouldHaveLength, 1)
+			So(autoscalingGroups[0].DesiredCapacity, ShouldEqual, 2)
+			Convey("with all their instances, including the ones marked to be removed", func() {
+				instances := autoscalingGroups[0].Instances
+				So(instances, ShouldHaveLength, 3)
+				So(instances[0].InstanceID, ShouldEqual, "i-34719eb8")
+				So(instances[0].MarkedToBeRemoved, ShouldBeTrue)
+				So(instances[1].MarkedToBeRemoved, ShouldBeFalse)
+			})
+			Convey("and whether their Mesos tasks block them", func() {
+				blocked := 0
+				for _, instance := range autoscalingGroups[0].Instances {
+					if instance.BlockedByMesos {
+						blocked++
+					}
+				}
+				So(blocked, ShouldBeGreaterThan, 0)
+			})
+			Convey("and the inverse offer responses of the draining agents", func() {
+				instances := autoscalingGroups[0].Instances
+				So(instances[0].InverseOffers, ShouldResemble, map[string]string{
+					"frameworkId1": mesos.InverseOfferAccept, "frameworkId2": mesos.InverseOfferDecline})
+				So(instances[2].InverseOffers, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestWatcherMetrics(t *testing.T) {
+
+	Convey("When the watcher has run over an autoscaling group with one undesired instance", t, func() {
+		values := testCollectionValues{
+			awsConn: &aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById":   {"node1", "node2", "node3"},
+					"DescribeInstancesByTag": {"default"},
+					"DescribeAGByName":       {"one_undesired_host"},
+				},
+			},
+			mesosConn: &mesos.ClientMock{
+				Records: map[string]*[]string{
+					"GetMesosFrameworks": {"default"},
+					"GetMesosSlaves":     {"default"},
+					"GetMesosTasks":      {"default"},
+				},
+			},
+		}
+		watcher := newWatcher(values)
+		watcher.ctx.AwsConn = aws.NewInstrumentedClient(values.awsConn)
+		calls := metrics.APICallDuration.Count("aws", "DescribeAGsByPrefix")
+		watcher.Run(gocontext.Background())
+
+		Convey("the instances marked to be removed should be reported", func() {
+			So(metrics.InstancesMarkedToBeRemoved.Value("some-Autoscaling-Group"), ShouldEqual, 1)
+			So(metrics.DrainingSeconds.Value("some-Autoscaling-Group", "i-34719eb8"), ShouldBeGreaterThanOrEqualTo, 0)
+		})
+		Convey("the AWS calls should be measured", func() {
+			So(metrics.APICallDuration.Count("aws", "DescribeAGsByPrefix"), ShouldEqual, calls+1)
+		})
+	})
+}
+
+func TestWatcherAudit(t *testing.T) {
+
+	Convey("When running the watcher with the audit log enabled", t, func() {
+		values := testCollectionValues{
+			awsConn: &aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById": {
+						"node1", "node2", "node3", "node1_with_dns", "node1_with_dns",
+					},
+					"DescribeInstancesByTag": {"default", "default"},
+					"DescribeAGByName":       {"one_undesired_host", "one_undesired_host"},
+				},
+			},
+			mesosConn: &mesos.ClientMock{
+				Records: map[string]*[]string{
+					"GetMesosFrameworks": {"default", "default"},
+					"GetMesosSlaves":     {"default", "default"},
+					"GetMesosTasks":      {"notasks", "notasks"},
+				},
+			},
+		}
+		watcher := newWatcher(values)
+		sink := &audit.SinkMock{}
+		watcher.ctx.Audit = audit.NewLogger(sink, watcher.ctx.Clock)
+		plan := dryrun.NewPlan(clock.New())
+		watcher.ctx.AwsConn = aws.NewDryRunClient(values.awsConn, plan)
+		watcher.ctx.MesosConn = mesos.NewDryRunClient(values.mesosConn, plan)
+
+		watcher.Run(gocontext.Background())
+		Convey("the candidate selection should be recorded with the candidates after every constraint", func() {
+			events := sink.Events(audit.CandidateSelection)
+			So(events, ShouldHaveLength, 1)
+			So(events[0].InstanceID, ShouldEqual, "i-34719eb8")
+			So(events[0].Details["candidates"], ShouldHaveLength, 3)
+			So(events[0].Details["constraints"], ShouldHaveLength, 2)
+			So(events[0].Details["recommender"], ShouldEqual, "smallestInstanceId")
+		})
+		Convey("entering maintenance and removing the protection should be recorded", func() {
+			So(sink.Events(audit.MaintenanceEntered), ShouldHaveLength, 1)
+			So(sink.Events(audit.ProtectionRemoved), ShouldHaveLength, 1)
+		})
+		Convey("the next run should record the lifecycle completion, without entering maintenance again", func() {
+			watcher.Run(gocontext.Background())
+			events := sink.Events(audit.LifecycleCompleted)
+			So(events, ShouldHaveLength, 1)
+			So(events[0].InstanceID, ShouldEqual, "i-34719eb8")
+			So(sink.Events(audit.MaintenanceEntered), ShouldHaveLength, 1)
+		})
+	})
+}
+
+func TestWatcherUntrustedMesosState(t *testing.T) {
+
+	Convey("When the Mesos state can't be completely read", t, func() {
+		values := testCollectionValues{
+			awsConn: &aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById": {
+						"node1", "node2", "node3", "node1_with_dns", "node1_with_dns", "node1_with_dns",
+					},
+					"DescribeInstancesByTag": {"default", "default", "default"},
+					"DescribeAGByName":       {"one_undesired_host", "one_undesired_host", "one_undesired_host"},
+				},
+			},
+			mesosConn: &mesos.ClientMock{
+				Records: map[string]*[]string{
+					"GetMesosFrameworks": {"default", "default"},
+					"GetMesosSlaves":     {"default", "default"},
+					"GetMesosTasks":      {"notasks"},
+				},
+				Errors: map[string]error{"GetMesosTasks": fmt.Errorf("connection refused")},
+			},
+		}
+		watcher := newWatcher(values)
+		sink := &audit.SinkMock{}
+		watcher.ctx.Audit = audit.NewLogger(sink, watcher.ctx.Clock)
+		plan := dryrun.NewPlan(clock.New())
+		watcher.ctx.AwsConn = aws.NewDryRunClient(values.awsConn, plan)
+		watcher.ctx.MesosConn = mesos.NewDryRunClient(values.mesosConn, plan)
+		watcher.Run(gocontext.Background())
+
+		Convey("no instance should be marked nor destroyed", func() {
+			So(actionParams(plan, "SetInstanceTag"), ShouldBeEmpty)
+			So(sink.Events(audit.CandidateSelection), ShouldBeEmpty)
+			So(actionParams(plan, "CompleteLifecycleAction"), ShouldBeEmpty)
+		})
+		Convey("the degraded state should be reported", func() {
+			So(sink.Events(audit.RunDegraded), ShouldHaveLength, 1)
+			So(watcher.Status().Mesos.Trusted, ShouldBeFalse)
+			So(watcher.Status().Mesos.Endpoints["tasks"], ShouldBeFalse)
+			So(metrics.MesosStateTrusted.Value(), ShouldEqual, 0)
+		})
+		Convey("the instances should be marked again once the Mesos state is read", func() {
+			values.mesosConn.Errors = nil
+			watcher.Run(gocontext.Background())
+			So(sink.Events(audit.CandidateSelection), ShouldHaveLength, 1)
+			So(watcher.Status().Mesos.Trusted, ShouldBeTrue)
+			So(metrics.MesosStateTrusted.Value(), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestWatcherStaleMesosState(t *testing.T) {
+
+	Convey("When the Mesos event stream stops updating the Mesos state", t, func() {
+		watcher, plan, sink := newDrainWatcher()
+		clockMock := clock.NewMock()
+		clockMock.Set(time.Unix(1500000000, 0))
+		watcher.ctx.Clock = clockMock
+		watcher.ctx.Conf.MesosStateMaxAgeSeconds = 300
+		subscriber := &subscriberMock{events: make(chan *mesos.Event), handled: make(chan bool)}
+		watcher.ctx.MesosEvents = subscriber
+
+		ctx, cancel := gocontext.WithCancel(gocontext.Background())
+		stopped := make(chan bool)
+		go func() {
+			watcher.FollowMesosEvents(ctx)
+			close(stopped)
+		}()
+		tasks, _ := watcher.ctx.MesosConn.GetMesosTasks(ctx)
+		frameworks, _ := watcher.ctx.MesosConn.GetMesosFrameworks(ctx)
+		agents, _ := watcher.ctx.MesosConn.GetMesosAgents(ctx)
+		subscriber.send(&mesos.Event{Type: "SUBSCRIBED", Tasks: tasks, Frameworks: frameworks, Agents: agents})
+
+		autoscalingGroupName := "some-Autoscaling-Group"
+		watcher.ctx.AwsConn.SetDesiredCapacity(gocontext.Background(), &autoscalingGroupName, 2)
+		watcher.Run(gocontext.Background())
+		So(sink.Events(audit.CandidateSelection), ShouldHaveLength, 1)
+		clockMock.Add(10 * time.Minute)
+		watcher.Run(gocontext.Background())
+
+		Convey("the marked instance should not be destroyed", func() {
+			So(actionParams(plan, "CompleteLifecycleAction"), ShouldBeEmpty)
+			So(sink.Events(audit.RunDegraded), ShouldHaveLength, 1)
+			So(watcher.Status().Mesos.Stale, ShouldBeTrue)
+			So(watcher.Status().Mesos.Trusted, ShouldBeFalse)
+		})
+		Convey("no instance should be marked", func() {
+			watcher.ctx.AwsConn.SetDesiredCapacity(gocontext.Background(), &autoscalingGroupName, 1)
+			watcher.Run(gocontext.Background())
+			So(sink.Events(audit.CandidateSelection), ShouldHaveLength, 1)
+		})
+		Convey("the marked instance should be destroyed once the stream sends a heartbeat", func() {
+			subscriber.send(&mesos.Event{Type: "HEARTBEAT"})
+			watcher.Run(gocontext.Background())
+			So(actionParams(plan, "CompleteLifecycleAction"), ShouldHaveLength, 1)
+			So(watcher.Status().Mesos.Stale, ShouldBeFalse)
+		})
+
+		Reset(func() {
+			cancel()
+			<-stopped
+		})
+	})
+}
+
+// subscriberMock handles every event received in events, until ctx is done
+type subscriberMock struct {
+	events  chan *mesos.Event
+	handled chan bool
+}
+
+func (s *subscriberMock) Subscribe(ctx gocontext.Context, handle func(event *mesos.Event) error) error {
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event := <-s.events:
+			handle(event)
+			s.handled <- true
+		}
+	}
+}
+
+// send waits until the event is handled
+func (s *subscriberMock) send(event *mesos.Event) {
+
+	s.events <- event
+	<-s.handled
+}
+
+func TestWatcherNotifications(t *testing.T) {
+
+	Convey("When running the watcher with webhook notifications enabled", t, func() {
+		events := make(chan notify.Event, 10)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			event := notify.Event{}
+			json.NewDecoder(r.Body).Decode(&event)
+			events <- event
+		}))
+		defer server.Close()
+
+		values := testCollectionValues{
+			awsConn: &aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById": {
+						"node1", "node2", "node3", "node1_with_dns", "node1_with_dns",
+					},
+					"DescribeInstancesByTag": {"default", "default"},
+					"DescribeAGByName":       {"one_undesired_host", "one_undesired_host"},
+				},
+			},
+			mesosConn: &mesos.ClientMock{
+				Records: map[string]*[]string{
+					"GetMesosFrameworks": {"default", "default"},
+					"GetMesosSlaves":     {"default", "default"},
+					"GetMesosTasks":      {"notasks", "notasks"},
+				},
+			},
+		}
+		watcher := newWatcher(values)
+		clockMock := clock.NewMock()
+		clockMock.Set(time.Unix(1500000000, 0))
+		watcher.ctx.Clock = clockMock
+		watcher.ctx.Conf.DrainStuckThresholdSeconds = 3600
+		watcher.ctx.Notifier = notify.NewNotifier([]notify.Webhook{{URL: server.URL}}, nil, clock.New())
+		plan := dryrun.NewPlan(clockMock)
+		watcher.ctx.AwsConn = aws.NewDryRunClient(values.awsConn, plan)
+		watcher.ctx.MesosConn = mesos.NewDryRunClient(values.mesosConn, plan)
+
+		watcher.Run(gocontext.Background())
+		Convey("the instance marked should be notified as draining", func() {
+			event := <-events
+			So(event.Type, ShouldEqual, notify.DrainStarted)
+			So(event.InstanceID, ShouldEqual, "i-34719eb8")
+
+			Convey("and, after the threshold, as stuck and then terminated", func() {
+				clockMock.Add(2 * time.Hour)
+				watcher.Run(gocontext.Background())
+				So(watcher.ctx.Notifier.Close(gocontext.Background()), ShouldBeNil)
+				So((<-events).Type, ShouldEqual, notify.DrainStuck)
+				So((<-events).Type, ShouldEqual, notify.InstanceTerminated)
+			})
+		})
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	gocontext "context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alanbover/deathnode/api"
+)
+
+// apiTokenEnv is the environment variable the API token is read from by default
+const apiTokenEnv = "DEATHNODE_API_TOKEN"
+
+// drainCommand implements "deathnode drain [-cancel] <instance-id>", asking a running deathnode to drain an
+// instance, or to cancel its drain
+func drainCommand(args []string) int {
+
+	flags := flag.NewFlagSet("drain", flag.ExitOnError)
+	cancel := flags.Bool("cancel", false, "Cancel the drain of the instance, if AWS hasn't started terminating it yet.")
+	address := flags.String("address", "http://localhost:8080", "The URL of the deathnode HTTP API.")
+	token := flags.String("apiToken", os.Getenv(apiTokenEnv), "The API token. Defaults to $"+apiTokenEnv+".")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: deathnode drain [-cancel] [-address url] [-apiToken token] <instance-id>")
+		flags.PrintDefaults()
+	}
+
+	// Accept the flags after the instance id too
+	flags.Parse(args)
+	instanceID := flags.Arg(0)
+	if flags.NArg() > 1 {
+		flags.Parse(flags.Args()[1:])
+		if flags.NArg() > 0 {
+			instanceID = ""
+		}
+	}
+	if instanceID == "" {
+		flags.Usage()
+		return 2
+	}
+
+	client := api.NewClient(*address, *token)
+	var err error
+	if *cancel {
+		err = client.CancelDrain(gocontext.Background(), instanceID)
+	} else {
+		err = client.Drain(gocontext.Background(), instanceID)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *cancel {
+		fmt.Printf("Drain of instance %s cancelled\n", instanceID)
+	} else {
+		fmt.Printf("Instance %s marked to be drained\n", instanceID)
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package dryrun
+
+// Records the mutating calls deathnode would have done against AWS and Mesos when running in dry-run mode
+
+import (
+	"github.com/benbjohnson/clock"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// Action is a mutating call intercepted in dry-run mode
+type Action struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Operation string            `json:"operation"`
+	Params    map[string]string `json:"params"`
+}
+
+// Plan stores, in order, all the actions intercepted in dry-run mode
+type Plan struct {
+	clock   clock.Clock
+	mutex   sync.Mutex
+	actions []Action
+}
+
+// NewPlan returns a new empty Plan object
+func NewPlan(clock clock.Clock) *Plan {
+
+	return &Plan{
+		clock:   clock,
+		actions: []Action{},
+	}
+}
+
+// Record adds an action to the plan, and logs it as a structured entry
+func (p *Plan) Record(operation string, params map[string]string) {
+
+	action := Action{
+		Timestamp: p.clock.Now(),
+		Operation: operation,
+		Params:    params,
+	}
+
+	p.mutex.Lock()
+	p.actions = append(p.actions, action)
+	p.mutex.Unlock()
+
+	fields := log.Fields{
+		"dryRun":    true,
+		"operation": operation,
+	}
+	for key, value := range params {
+		fields[key] = value
+	}
+	log.WithFields(fields).Info("Dry run: skipping mutating call")
+}
+
+// Actions returns a copy of all the actions recorded so far
+func (p *Plan) Actions() []Action {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	actions := make([]Action, len(p.actions))
+	copy(actions, p.actions)
+	return actions
+}
+
+// Operations returns the operation name of all the actions recorded so far
+func (p *Plan) Operations() []string {
+
+	operations := []string{}
+	for _, action := range p.Actions() {
+		operations = append(operations, action.Operation)
+	}
+	return operations
+}
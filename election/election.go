@@ -0,0 +1,95 @@
+package election
+
+// Leader election between deathnode replicas. Only the replica holding the lease is allowed to act on the
+// autoscaling groups, the rest of them wait as hot standby
+
+import (
+	"context"
+	"errors"
+	"github.com/benbjohnson/clock"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// ErrLeadershipLost is returned by the context created with WithLease once the lease is not held anymore
+var ErrLeadershipLost = errors.New("leadership lease lost")
+
+// Elector implements a leader election backend based on a lease with a TTL
+type Elector interface {
+	// Acquire tries to become the leader, or renews the lease if it's already the leader. It doesn't block
+	// waiting for another replica to release the lease
+	Acquire(ctx context.Context) (bool, error)
+	// IsLeader returns true while the lease acquired is still valid
+	IsLeader() bool
+	// Release gives up the lease, if held
+	Release(ctx context.Context) error
+}
+
+type leaseContext struct {
+	context.Context
+	elector Elector
+	cancel  context.CancelFunc
+	mutex   sync.Mutex
+	lost    bool
+}
+
+// WithLease returns a context derived from parent that is cancelled as soon as the elector loses the lease.
+// The lease is renewed every renewInterval of clock while the context is alive. Err returns ErrLeadershipLost
+// once the lease is gone, so any caller checking it before a destructive action stops in time
+func WithLease(parent context.Context, elector Elector, renewInterval time.Duration, clock clock.Clock) (
+	context.Context, context.CancelFunc) {
+
+	ctx, cancel := context.WithCancel(parent)
+	leaseCtx := &leaseContext{
+		Context: ctx,
+		elector: elector,
+		cancel:  cancel,
+	}
+
+	go leaseCtx.renew(clock.Ticker(renewInterval))
+	return leaseCtx, cancel
+}
+
+func (c *leaseContext) Err() error {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.lost {
+		return ErrLeadershipLost
+	}
+	if err := c.Context.Err(); err != nil {
+		return err
+	}
+	if !c.elector.IsLeader() {
+		c.lost = true
+		c.cancel()
+		return ErrLeadershipLost
+	}
+	return nil
+}
+
+func (c *leaseContext) renew(ticker *clock.Ticker) {
+
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.Done():
+			return
+		case <-ticker.C:
+			ok, err := c.elector.Acquire(c.Context)
+			if err != nil {
+				log.Warnf("Unable to renew leadership lease: %s", err)
+			}
+			if !ok || !c.elector.IsLeader() {
+				c.mutex.Lock()
+				c.lost = true
+				c.cancel()
+				c.mutex.Unlock()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package election
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/benbjohnson/clock"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EtcdElector implements Elector on top of an etcd v3 cluster, using its JSON gRPC gateway. The leader
+// is the replica that owns the election key, which is bound to an etcd lease with the configured TTL
+type EtcdElector struct {
+	endpoint   string
+	key        string
+	id         string
+	ttl        time.Duration
+	clock      clock.Clock
+	httpClient *http.Client
+	mutex      sync.Mutex
+	leaseID    string
+	expiresAt  time.Time
+}
+
+type etcdLeaseRequest struct {
+	TTL int64  `json:"TTL,omitempty"`
+	ID  string `json:"ID,omitempty"`
+}
+
+type etcdLeaseResponse struct {
+	ID  string `json:"ID"`
+	TTL string `json:"TTL"`
+}
+
+type etcdKeepAliveResponse struct {
+	Result etcdLeaseResponse `json:"result"`
+}
+
+type etcdKeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Lease string `json:"lease,omitempty"`
+}
+
+type etcdCompare struct {
+	Key            string `json:"key"`
+	Result         string `json:"result"`
+	Target         string `json:"target"`
+	CreateRevision string `json:"create_revision"`
+}
+
+type etcdRequestOp struct {
+	RequestPut   *etcdKeyValue `json:"request_put,omitempty"`
+	RequestRange *etcdKeyValue `json:"request_range,omitempty"`
+}
+
+type etcdTxnRequest struct {
+	Compare []etcdCompare   `json:"compare"`
+	Success []etcdRequestOp `json:"success"`
+	Failure []etcdRequestOp `json:"failure"`
+}
+
+type etcdTxnResponse struct {
+	Succeeded bool `json:"succeeded"`
+	Responses []struct {
+		ResponseRange struct {
+			Kvs []etcdKeyValue `json:"kvs"`
+		} `json:"response_range"`
+	} `json:"responses"`
+}
+
+// NewEtcdElector returns a new EtcdElector object. endpoint is the base URL of an etcd member, key the election
+// key shared by all the replicas, and id identifies this replica
+func NewEtcdElector(endpoint, key, id string, ttl time.Duration, clock clock.Clock) *EtcdElector {
+
+	return &EtcdElector{
+		endpoint:   strings.TrimSuffix(endpoint, "/"),
+		key:        key,
+		id:         id,
+		ttl:        ttl,
+		clock:      clock,
+		httpClient: &http.Client{Timeout: ttl},
+	}
+}
+
+// Acquire keeps the current etcd lease alive, or grants a new one, and tries to own the election key with it
+func (e *EtcdElector) Acquire(ctx context.Context) (bool, error) {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	start := e.clock.Now()
+	if e.leaseID != "" {
+		alive, err := e.keepAlive(ctx)
+		if err != nil || !alive {
+			e.leaseID = ""
+			e.expiresAt = time.Time{}
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if e.leaseID == "" {
+		leaseID, err := e.grant(ctx)
+		if err != nil {
+			return false, err
+		}
+		e.leaseID = leaseID
+	}
+
+	owner, err := e.campaign(ctx)
+	if err != nil {
+		e.expiresAt = time.Time{}
+		return false, err
+	}
+
+	if owner.Value != e.id || owner.Lease != e.leaseID {
+		e.expiresAt = time.Time{}
+		e.revoke(ctx)
+		e.leaseID = ""
+		return false, nil
+	}
+
+	e.expiresAt = start.Add(e.ttl)
+	return true, nil
+}
+
+// IsLeader returns true if this replica owns the election key and its lease hasn't expired yet
+func (e *EtcdElector) IsLeader() bool {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.clock.Now().Before(e.expiresAt)
+}
+
+// Release revokes the lease, which deletes the election key if it's owned by this replica
+func (e *EtcdElector) Release(ctx context.Context) error {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.expiresAt = time.Time{}
+	if e.leaseID == "" {
+		return nil
+	}
+
+	err := e.revoke(ctx)
+	e.leaseID = ""
+	return err
+}
+
+func (e *EtcdElector) grant(ctx context.Context) (string, error) {
+
+	var response etcdLeaseResponse
+	request := etcdLeaseRequest{TTL: int64(e.ttl.Seconds())}
+	if err := e.call(ctx, "/v3/lease/grant", request, &response); err != nil {
+		return "", err
+	}
+
+	if response.ID == "" {
+		return "", fmt.Errorf("etcd didn't return a lease id")
+	}
+	return response.ID, nil
+}
+
+func (e *EtcdElector) keepAlive(ctx context.Context) (bool, error) {
+
+	var response etcdKeepAliveResponse
+	request := etcdLeaseRequest{ID: e.leaseID}
+	if err := e.call(ctx, "/v3/lease/keepalive", request, &response); err != nil {
+		return false, err
+	}
+
+	ttl, _ := strconv.ParseInt(response.Result.TTL, 10, 64)
+	return ttl > 0, nil
+}
+
+func (e *EtcdElector) revoke(ctx context.Context) error {
+
+	request := etcdLeaseRequest{ID: e.leaseID}
+	return e.call(ctx, "/v3/lease/revoke", request, &struct{}{})
+}
+
+// campaign creates the election key bound to our lease if nobody owns it, and returns its current owner
+func (e *EtcdElector) campaign(ctx context.Context) (*etcdKeyValue, error) {
+
+	key := base64.StdEncoding.EncodeToString([]byte(e.key))
+	request := etcdTxnRequest{
+		Compare: []etcdCompare{{
+			Key:            key,
+			Result:         "EQUAL",
+			Target:         "CREATE",
+			CreateRevision: "0",
+		}},
+		Success: []etcdRequestOp{{
+			RequestPut: &etcdKeyValue{
+				Key:   key,
+				Value: base64.StdEncoding.EncodeToString([]byte(e.id)),
+				Lease: e.leaseID,
+			},
+		}},
+		Failure: []etcdRequestOp{{
+			RequestRange: &etcdKeyValue{Key: key},
+		}},
+	}
+
+	var response etcdTxnResponse
+	if err := e.call(ctx, "/v3/kv/txn", request, &response); err != nil {
+		return nil, err
+	}
+
+	if response.Succeeded {
+		return &etcdKeyValue{Value: e.id, Lease: e.leaseID}, nil
+	}
+
+	if len(response.Responses) == 0 || len(response.Responses[0].ResponseRange.Kvs) == 0 {
+		// The key expired between the compare and the range, let the next attempt take it
+		return &etcdKeyValue{}, nil
+	}
+
+	owner := response.Responses[0].ResponseRange.Kvs[0]
+	value, err := base64.StdEncoding.DecodeString(owner.Value)
+	if err != nil {
+		return nil, err
+	}
+	owner.Value = string(value)
+	return &owner, nil
+}
+
+func (e *EtcdElector) call(ctx context.Context, path string, request, response interface{}) error {
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", e.endpoint+path, bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd call %s failed with status %d: %s", path, resp.StatusCode, body)
+	}
+
+	return json.Unmarshal(body, response)
+}
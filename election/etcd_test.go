@@ -0,0 +1,200 @@
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// etcdStandIn implements the subset of the etcd v3 JSON gateway used by EtcdElector
+type etcdStandIn struct {
+	clock     clock.Clock
+	mutex     sync.Mutex
+	nextLease int
+	leases    map[string]time.Time
+	leaseTTLs map[string]int64
+	kvs       map[string]etcdKeyValue
+}
+
+func newEtcdStandIn(clock clock.Clock) *httptest.Server {
+
+	standIn := &etcdStandIn{
+		clock:     clock,
+		leases:    map[string]time.Time{},
+		leaseTTLs: map[string]int64{},
+		kvs:       map[string]etcdKeyValue{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3/lease/grant", standIn.grant)
+	mux.HandleFunc("/v3/lease/keepalive", standIn.keepAlive)
+	mux.HandleFunc("/v3/lease/revoke", standIn.revoke)
+	mux.HandleFunc("/v3/kv/txn", standIn.txn)
+	return httptest.NewServer(mux)
+}
+
+func (s *etcdStandIn) expire() {
+
+	for id, expiresAt := range s.leases {
+		if !s.clock.Now().Before(expiresAt) {
+			s.deleteLease(id)
+		}
+	}
+}
+
+func (s *etcdStandIn) deleteLease(id string) {
+
+	delete(s.leases, id)
+	for key, kv := range s.kvs {
+		if kv.Lease == id {
+			delete(s.kvs, key)
+		}
+	}
+}
+
+func (s *etcdStandIn) grant(w http.ResponseWriter, r *http.Request) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var request etcdLeaseRequest
+	json.NewDecoder(r.Body).Decode(&request)
+
+	s.nextLease++
+	id := fmt.Sprintf("%d", s.nextLease)
+	s.leases[id] = s.clock.Now().Add(time.Duration(request.TTL) * time.Second)
+	s.leaseTTLs[id] = request.TTL
+	json.NewEncoder(w).Encode(etcdLeaseResponse{ID: id, TTL: strconv.FormatInt(request.TTL, 10)})
+}
+
+func (s *etcdStandIn) keepAlive(w http.ResponseWriter, r *http.Request) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire()
+
+	var request etcdLeaseRequest
+	json.NewDecoder(r.Body).Decode(&request)
+
+	ttl := int64(0)
+	if _, ok := s.leases[request.ID]; ok {
+		ttl = s.leaseTTLs[request.ID]
+		s.leases[request.ID] = s.clock.Now().Add(time.Duration(ttl) * time.Second)
+	}
+	json.NewEncoder(w).Encode(etcdKeepAliveResponse{
+		Result: etcdLeaseResponse{ID: request.ID, TTL: strconv.FormatInt(ttl, 10)},
+	})
+}
+
+func (s *etcdStandIn) revoke(w http.ResponseWriter, r *http.Request) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var request etcdLeaseRequest
+	json.NewDecoder(r.Body).Decode(&request)
+	s.deleteLease(request.ID)
+	w.Write([]byte("{}"))
+}
+
+func (s *etcdStandIn) txn(w http.ResponseWriter, r *http.Request) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire()
+
+	var request etcdTxnRequest
+	json.NewDecoder(r.Body).Decode(&request)
+
+	key := request.Compare[0].Key
+	if _, exists := s.kvs[key]; !exists {
+		put := request.Success[0].RequestPut
+		if _, ok := s.leases[put.Lease]; !ok {
+			http.Error(w, `{"error":"requested lease not found"}`, http.StatusBadRequest)
+			return
+		}
+		s.kvs[key] = *put
+		w.Write([]byte(`{"succeeded":true}`))
+		return
+	}
+
+	response := map[string]interface{}{
+		"responses": []interface{}{
+			map[string]interface{}{
+				"response_range": map[string]interface{}{
+					"kvs": []etcdKeyValue{s.kvs[key]},
+				},
+			},
+		},
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func TestEtcdElector(t *testing.T) {
+
+	Convey("When two replicas use the same etcd election key", t, func() {
+		clockMock := clock.NewMock()
+		server := newEtcdStandIn(clockMock)
+		replica1 := NewEtcdElector(server.URL, "/deathnode/leader", "replica1", 10*time.Second, clockMock)
+		replica2 := NewEtcdElector(server.URL, "/deathnode/leader", "replica2", 10*time.Second, clockMock)
+
+		Convey("the first one acquiring the lease should be the leader", func() {
+			ok, err := replica1.Acquire(context.Background())
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(replica1.IsLeader(), ShouldBeTrue)
+
+			Convey("the second one should not be able to acquire it", func() {
+				ok, err := replica2.Acquire(context.Background())
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeFalse)
+				So(replica2.IsLeader(), ShouldBeFalse)
+			})
+			Convey("the leader should be able to renew it", func() {
+				clockMock.Add(8 * time.Second)
+				ok, _ := replica1.Acquire(context.Background())
+				So(ok, ShouldBeTrue)
+				clockMock.Add(8 * time.Second)
+				So(replica1.IsLeader(), ShouldBeTrue)
+				ok, _ = replica2.Acquire(context.Background())
+				So(ok, ShouldBeFalse)
+			})
+			Convey("if the leader doesn't renew it", func() {
+				clockMock.Add(11 * time.Second)
+				Convey("it should stop being the leader", func() {
+					So(replica1.IsLeader(), ShouldBeFalse)
+				})
+				Convey("the second one should be able to acquire it", func() {
+					ok, _ := replica2.Acquire(context.Background())
+					So(ok, ShouldBeTrue)
+					ok, _ = replica1.Acquire(context.Background())
+					So(ok, ShouldBeFalse)
+				})
+			})
+			Convey("if the leader releases it, the second one should be able to acquire it", func() {
+				So(replica1.Release(context.Background()), ShouldBeNil)
+				So(replica1.IsLeader(), ShouldBeFalse)
+				ok, _ := replica2.Acquire(context.Background())
+				So(ok, ShouldBeTrue)
+			})
+		})
+		Convey("if etcd is not reachable, it should not be the leader", func() {
+			server.Close()
+			ok, err := replica1.Acquire(context.Background())
+			So(err, ShouldNotBeNil)
+			So(ok, ShouldBeFalse)
+		})
+
+		Reset(func() {
+			server.Close()
+		})
+	})
+}
@@ -0,0 +1,138 @@
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/benbjohnson/clock"
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FileElector implements Elector using a lease file protected by an exclusive flock. All the replicas
+// need to share the same filesystem, so it's mainly intended for testing and single host deployments
+type FileElector struct {
+	path      string
+	id        string
+	ttl       time.Duration
+	clock     clock.Clock
+	mutex     sync.Mutex
+	expiresAt time.Time
+}
+
+type fileLease struct {
+	Holder    string `json:"holder"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// NewFileElector returns a new FileElector object. id identifies this replica and must be unique between them
+func NewFileElector(path, id string, ttl time.Duration, clock clock.Clock) *FileElector {
+
+	return &FileElector{
+		path:  path,
+		id:    id,
+		ttl:   ttl,
+		clock: clock,
+	}
+}
+
+// Acquire takes the lease if it's free, expired or already held by this replica
+func (e *FileElector) Acquire(ctx context.Context) (bool, error) {
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	now := e.clock.Now()
+	acquired := false
+	err := e.withLockedFile(func(file *os.File, lease *fileLease) error {
+		if lease.Holder != "" && lease.Holder != e.id && now.Before(time.Unix(0, lease.ExpiresAt)) {
+			return nil
+		}
+
+		acquired = true
+		return writeFileLease(file, &fileLease{
+			Holder:    e.id,
+			ExpiresAt: now.Add(e.ttl).UnixNano(),
+		})
+	})
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if err != nil || !acquired {
+		e.expiresAt = time.Time{}
+		return false, err
+	}
+
+	e.expiresAt = now.Add(e.ttl)
+	return true, nil
+}
+
+// IsLeader returns true if the lease held by this replica hasn't expired yet
+func (e *FileElector) IsLeader() bool {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.clock.Now().Before(e.expiresAt)
+}
+
+// Release frees the lease if it's held by this replica
+func (e *FileElector) Release(ctx context.Context) error {
+
+	e.mutex.Lock()
+	e.expiresAt = time.Time{}
+	e.mutex.Unlock()
+
+	return e.withLockedFile(func(file *os.File, lease *fileLease) error {
+		if lease.Holder != e.id {
+			return nil
+		}
+		return writeFileLease(file, &fileLease{})
+	})
+}
+
+func (e *FileElector) withLockedFile(fn func(*os.File, *fileLease) error) error {
+
+	file, err := os.OpenFile(e.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	lease := &fileLease{}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, lease); err != nil {
+			return err
+		}
+	}
+
+	return fn(file, lease)
+}
+
+func writeFileLease(file *os.File, lease *fileLease) error {
+
+	content, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(content, 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
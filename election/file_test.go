@@ -0,0 +1,160 @@
+package election
+
+import (
+	"context"
+	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileElector(t *testing.T) {
+
+	Convey("When two replicas share a lease file", t, func() {
+		dir, _ := ioutil.TempDir("", "deathnode-election")
+		path := filepath.Join(dir, "leader.lock")
+		clockMock := clock.NewMock()
+		replica1 := NewFileElector(path, "replica1", time.Minute, clockMock)
+		replica2 := NewFileElector(path, "replica2", time.Minute, clockMock)
+
+		Convey("the first one acquiring the lease should be the leader", func() {
+			ok, err := replica1.Acquire(context.Background())
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(replica1.IsLeader(), ShouldBeTrue)
+
+			Convey("the second one should not be able to acquire it", func() {
+				ok, err := replica2.Acquire(context.Background())
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeFalse)
+				So(replica2.IsLeader(), ShouldBeFalse)
+			})
+			Convey("the leader should be able to renew it", func() {
+				clockMock.Add(50 * time.Second)
+				ok, _ := replica1.Acquire(context.Background())
+				So(ok, ShouldBeTrue)
+				clockMock.Add(50 * time.Second)
+				So(replica1.IsLeader(), ShouldBeTrue)
+			})
+			Convey("if the leader doesn't renew it", func() {
+				clockMock.Add(2 * time.Minute)
+				Convey("it should stop being the leader", func() {
+					So(replica1.IsLeader(), ShouldBeFalse)
+				})
+				Convey("the second one should be able to acquire it", func() {
+					ok, _ := replica2.Acquire(context.Background())
+					So(ok, ShouldBeTrue)
+					ok, _ = replica1.Acquire(context.Background())
+					So(ok, ShouldBeFalse)
+				})
+			})
+			Convey("if the leader releases it, the second one should be able to acquire it", func() {
+				So(replica1.Release(context.Background()), ShouldBeNil)
+				So(replica1.IsLeader(), ShouldBeFalse)
+				ok, _ := replica2.Acquire(context.Background())
+				So(ok, ShouldBeTrue)
+			})
+		})
+
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+	})
+}
+
+func TestWithLease(t *testing.T) {
+
+	Convey("When creating a context bound to a lease", t, func() {
+		dir, _ := ioutil.TempDir("", "deathnode-election")
+		clockMock := clock.NewMock()
+		elector := &renewalsElector{
+			FileElector: NewFileElector(filepath.Join(dir, "leader.lock"), "replica1", time.Minute, clockMock),
+			renewals:    make(chan bool, 10),
+		}
+		elector.Acquire(context.Background())
+		<-elector.renewals
+
+		ctx, cancel := WithLease(context.Background(), elector, 20*time.Second, clockMock)
+		Convey("it should not have errors while the lease is held", func() {
+			So(ctx.Err(), ShouldBeNil)
+		})
+		Convey("it should return ErrLeadershipLost once the lease can't be renewed", func() {
+			elector.refuse()
+			clockMock.Add(20 * time.Second)
+			So(elector.waitForRenewal(), ShouldBeTrue)
+			clockMock.Add(time.Minute)
+			So(ctx.Err(), ShouldEqual, ErrLeadershipLost)
+			So(isDone(ctx), ShouldBeTrue)
+		})
+		Convey("it should keep the lease while renewing it on every interval", func() {
+			for renewal := 0; renewal < 6; renewal++ {
+				clockMock.Add(20 * time.Second)
+				So(elector.waitForRenewal(), ShouldBeTrue)
+			}
+			So(ctx.Err(), ShouldBeNil)
+		})
+		Convey("it should be cancelled when calling its cancel function", func() {
+			cancel()
+			So(ctx.Err(), ShouldEqual, context.Canceled)
+		})
+
+		Reset(func() {
+			cancel()
+			os.RemoveAll(dir)
+		})
+	})
+}
+
+// renewalsElector notifies every Acquire of the FileElector, and fails them once refused
+type renewalsElector struct {
+	*FileElector
+	renewals chan bool
+	mutex    sync.Mutex
+	refused  bool
+}
+
+func (e *renewalsElector) Acquire(ctx context.Context) (bool, error) {
+
+	e.mutex.Lock()
+	refused := e.refused
+	e.mutex.Unlock()
+
+	acquired := false
+	var err error
+	if !refused {
+		acquired, err = e.FileElector.Acquire(ctx)
+	}
+	e.renewals <- acquired
+	return acquired, err
+}
+
+func (e *renewalsElector) refuse() {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.refused = true
+}
+
+func (e *renewalsElector) waitForRenewal() bool {
+
+	select {
+	case <-e.renewals:
+		return true
+	case <-time.After(5 * time.Second):
+		return false
+	}
+}
+
+func isDone(ctx context.Context) bool {
+
+	select {
+	case <-ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
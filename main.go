@@ -4,23 +4,54 @@ import "time"
 import "flag"
 
 import (
+	gocontext "context"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/alanbover/deathnode/api"
+	"github.com/alanbover/deathnode/audit"
 	"github.com/alanbover/deathnode/aws"
 	"github.com/alanbover/deathnode/context"
 	"github.com/alanbover/deathnode/deathnode"
+	"github.com/alanbover/deathnode/dryrun"
+	"github.com/alanbover/deathnode/election"
 	"github.com/alanbover/deathnode/mesos"
+	"github.com/alanbover/deathnode/notify"
+	"github.com/alanbover/deathnode/state"
 	"github.com/benbjohnson/clock"
 	log "github.com/sirupsen/logrus"
 )
 
-var accessKey, secretKey, region, iamRole, iamSession, mesosURL string
-var debug bool
-var pollingSeconds int
+const mesosPasswordEnv = "DEATHNODE_MESOS_PASSWORD"
+const mesosTokenEnv = "DEATHNODE_MESOS_TOKEN"
+
+var accessKey, secretKey, region, iamRole, iamSession, mesosURL, configFile, httpAddress, auditLog, webhookDeadLetterLog, stateFile, apiToken string
+var leaderElection, leaderLockFile, etcdURL, leaderKey, replicaID string
+var debug, dryRun, mesosSubscribe bool
+var mesosUsername, mesosPassword, mesosToken, mesosServiceAccount string
+var mesosEncoding string
+var mesosTLS mesos.TLSOptions
+var pollingSeconds, callTimeoutSeconds, shutdownTimeoutSeconds, mesosRetries, mesosRetryBackoffMillis int
 
 func main() {
 
+	if len(os.Args) > 1 && os.Args[1] == "drain" {
+		os.Exit(drainCommand(os.Args[2:]))
+	}
+
 	ctx := &context.ApplicationContext{Clock: clock.New()}
 
 	initFlags(ctx)
+	flagsConf := ctx.Conf
+	if configFile != "" {
+		if err := context.LoadConfigFile(configFile, &ctx.Conf); err != nil {
+			log.Fatal("Error loading config file: ", err)
+		}
+	}
 	enforceFlags(ctx)
 
 	log.SetLevel(log.InfoLevel)
@@ -29,25 +60,213 @@ func main() {
 	}
 
 	// Create the monitors for autoscaling groups
-	if awsConn, err := aws.NewClient(accessKey, secretKey, region, iamRole, iamSession); err != nil {
+	callTimeout := time.Second * time.Duration(callTimeoutSeconds)
+	if awsConn, err := aws.NewClient(accessKey, secretKey, region, iamRole, iamSession, callTimeout); err != nil {
 		log.Fatal("Error connecting to AWS: ", err)
 	} else {
-		ctx.AwsConn = awsConn
+		ctx.AwsConn = aws.NewInstrumentedClient(awsConn)
 	}
 
-	// Create the Mesos monitor
-	ctx.MesosConn = &mesos.Client{
-		MasterURL: mesosURL,
+	// Create the Mesos monitor. With several masters, or ZooKeeper, the calls go to the leading one
+	var resolver mesos.LeaderResolver
+	if strings.Contains(mesosURL, ",") || strings.HasPrefix(mesosURL, "zk://") {
+		var err error
+		if resolver, err = mesos.NewLeaderResolver(mesosURL, callTimeout); err != nil {
+			log.Fatal("Error configuring the Mesos masters: ", err)
+		}
+	}
+	if zookeeperResolver, ok := resolver.(*mesos.ZookeeperResolver); ok && mesosTLS.Enabled() {
+		zookeeperResolver.Scheme = "https"
+	}
+	httpClient := &http.Client{}
+	if mesosTLS.Enabled() {
+		tlsConfig, err := mesos.NewTLSConfig(mesosTLS)
+		if err != nil {
+			log.Fatal("Error configuring TLS for Mesos: ", err)
+		}
+		httpClient.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}
+	}
+	mesosClient := &mesos.Client{
+		MasterURL:     mesosURL,
+		Resolver:      resolver,
+		Authenticator: mesosAuthenticator(),
+		HTTPClient:    httpClient,
+		Timeout:       callTimeout,
+		Retries:       mesosRetries,
+		RetryBackoff:  time.Millisecond * time.Duration(mesosRetryBackoffMillis),
+	}
+	if ctx.Conf.MesosAPI == context.MesosAPIV1 {
+		operatorClient := &mesos.OperatorClient{Transport: mesosClient, Encoding: mesosEncoding, Clock: ctx.Clock}
+		ctx.MesosConn = mesos.NewInstrumentedClient(operatorClient)
+		if mesosSubscribe {
+			ctx.MesosEvents = operatorClient
+		}
+	} else {
+		ctx.MesosConn = mesos.NewInstrumentedClient(mesosClient)
+	}
+
+	// Record the mutating calls instead of performing them
+	if dryRun {
+		log.Info("Running in dry-run mode, no changes will be done in AWS or Mesos")
+		plan := dryrun.NewPlan(ctx.Clock)
+		ctx.AwsConn = aws.NewDryRunClient(ctx.AwsConn, plan)
+		ctx.MesosConn = mesos.NewDryRunClient(ctx.MesosConn, plan)
+	}
+
+	// Write the scale-in decisions to the audit log, if enabled
+	if auditLog != "" {
+		sink, err := audit.NewFileSink(auditLog)
+		if err != nil {
+			log.Fatal("Error opening audit log: ", err)
+		}
+		ctx.Audit = audit.NewLogger(sink, ctx.Clock)
+	}
+
+	// Send the drain lifecycle events to the webhooks. With a config file it's always created, as webhooks can
+	// be added on reload
+	if len(ctx.Conf.Webhooks) > 0 || configFile != "" {
+		ctx.Notifier = notify.NewNotifier(ctx.Conf.Webhooks, openDeadLetterLog(), ctx.Clock)
 	}
 
+	// Persist the drain bookkeeping across restarts. The simulated changes of a dry run are not persisted
+	if stateFile != "" {
+		if dryRun {
+			log.Info("Running in dry-run mode, the state will not be persisted")
+		} else {
+			ctx.Store = state.NewFileStore(stateFile)
+		}
+	}
+
+	// Create the leader elector, if deathnode runs with several replicas
+	ctx.Elector = newElector(ctx)
+
 	// Create deathnoteWatcher
 	deathNodeWatcher := deathnode.NewWatcher(ctx)
+	if configFile != "" {
+		reloadOnSignal(flagsConf, deathNodeWatcher)
+	}
+
+	// Serve the HTTP API, if enabled
+	var server *api.Server
+	if httpAddress != "" {
+		server = api.NewServer(httpAddress, apiToken, deathNodeWatcher)
+		server.Start()
+	}
+
+	// Follow the Mesos event stream, if enabled, instead of polling the master on every run
+	stopCtx := signalContext()
+	if ctx.MesosEvents != nil {
+		go deathNodeWatcher.FollowMesosEvents(stopCtx)
+	}
+
+	scheduler := deathnode.NewScheduler(ctx, deathNodeWatcher,
+		time.Second*time.Duration(pollingSeconds), time.Second*time.Duration(shutdownTimeoutSeconds))
+	scheduler.Start(stopCtx)
 
-	ticker := time.NewTicker(time.Second * time.Duration(pollingSeconds))
-	for {
-		go deathNodeWatcher.Run()
-		<-ticker.C
+	if server != nil {
+		shutdownCtx, cancel := gocontext.WithTimeout(gocontext.Background(), 5*time.Second)
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Warnf("Unable to stop the HTTP API: %s", err)
+		}
+		cancel()
 	}
+
+	if ctx.Notifier != nil {
+		shutdownCtx, cancel := gocontext.WithTimeout(gocontext.Background(), 5*time.Second)
+		if err := ctx.Notifier.Close(shutdownCtx); err != nil {
+			log.Warnf("Unable to deliver the pending notifications: %s", err)
+		}
+		cancel()
+	}
+
+	if ctx.Elector != nil {
+		if err := ctx.Elector.Release(gocontext.Background()); err != nil {
+			log.Warnf("Unable to release leadership lease: %s", err)
+		}
+	}
+	log.Info("Deathnode stopped")
+}
+
+// openDeadLetterLog returns the writer for the undelivered notifications, or nil to only log them
+func openDeadLetterLog() io.Writer {
+
+	if webhookDeadLetterLog == "" {
+		return nil
+	}
+	file, err := os.OpenFile(webhookDeadLetterLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatal("Error opening webhook dead-letter log: ", err)
+	}
+	return file
+}
+
+func newElector(ctx *context.ApplicationContext) election.Elector {
+
+	leaseTTL := time.Second * time.Duration(ctx.Conf.LeaderLeaseTTLSeconds)
+	switch leaderElection {
+	case "":
+		return nil
+	case "file":
+		return election.NewFileElector(leaderLockFile, replicaID, leaseTTL, ctx.Clock)
+	case "etcd":
+		return election.NewEtcdElector(etcdURL, leaderKey, replicaID, leaseTTL, ctx.Clock)
+	default:
+		log.Fatalf("Leader election backend %s not found", leaderElection)
+		return nil
+	}
+}
+
+// mesosAuthenticator returns the authenticator for the Mesos calls set by the flags, or nil if none is set
+func mesosAuthenticator() mesos.Authenticator {
+
+	switch {
+	case mesosServiceAccount != "":
+		authenticator, err := mesos.NewServiceAccountAuthenticator(mesosServiceAccount)
+		if err != nil {
+			log.Fatal("Error reading the DC/OS service account: ", err)
+		}
+		return authenticator
+	case mesosToken != "":
+		return &mesos.TokenAuthenticator{Token: mesosToken}
+	case mesosUsername != "":
+		return &mesos.BasicAuthenticator{Username: mesosUsername, Password: mesosPassword}
+	}
+	return nil
+}
+
+// signalContext returns a context that is cancelled when SIGINT or SIGTERM is received
+func signalContext() gocontext.Context {
+
+	signalCtx, cancel := gocontext.WithCancel(gocontext.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Infof("Received signal %s", sig)
+		cancel()
+	}()
+	return signalCtx
+}
+
+// reloadOnSignal reloads the config file into the watcher every time SIGHUP is received. Invalid configurations
+// are rejected, keeping the running one
+func reloadOnSignal(flagsConf context.ApplicationConf, watcher *deathnode.Watcher) {
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			log.Infof("Received signal SIGHUP, reloading config file %s", configFile)
+			conf := flagsConf
+			if err := context.LoadConfigFile(configFile, &conf); err != nil {
+				log.Errorf("Error reloading config file, keeping the running configuration: %s", err)
+				continue
+			}
+			if err := watcher.Reload(conf); err != nil {
+				log.Errorf("Invalid configuration, keeping the running one: %s", err)
+			}
+		}
+	}()
 }
 
 func initFlags(context *context.ApplicationContext) {
@@ -58,8 +277,28 @@ func initFlags(context *context.ApplicationContext) {
 	flag.StringVar(&iamRole, "iamRole", "", "IAMROLE to assume.")
 	flag.StringVar(&iamSession, "iamSession", "", "Session for IAMROLE.")
 
+	flag.StringVar(&configFile, "config", "",
+		"JSON config file with the global policy and per autoscalingGroupName overrides. Its settings replace the flags.")
+
+	flag.StringVar(&httpAddress, "httpAddress", "", "Address to serve the HTTP API on (e.g. :8080). Disabled by default.")
+	flag.StringVar(&apiToken, "apiToken", os.Getenv(apiTokenEnv),
+		"Bearer token required by the operator endpoints of the HTTP API, which are disabled without it. Defaults to $"+
+			apiTokenEnv+".")
+
+	flag.StringVar(&auditLog, "auditLog", "",
+		"File to append the scale-in decisions to, as JSON lines (- for stdout). Disabled by default.")
+
+	flag.StringVar(&webhookDeadLetterLog, "webhookDeadLetterLog", "",
+		"File to append the notifications that couldn't be delivered to the webhooks to. By default they are only logged.")
+	flag.IntVar(&context.Conf.DrainStuckThresholdSeconds, "drainStuckThreshold", 0,
+		"Seconds an instance can be draining before notifying it as stuck. Disabled by default.")
+
+	flag.StringVar(&stateFile, "stateFile", "",
+		"File to persist the instances being drained and the last destroys in, to keep them across restarts. Disabled by default.")
+
 	flag.BoolVar(&debug, "debug", false, "Enable debug logging.")
-	flag.StringVar(&mesosURL, "mesosUrl", "", "The URL for Mesos master.")
+	flag.BoolVar(&dryRun, "dryRun", false, "Log the changes to AWS and Mesos instead of performing them.")
+	flag.StringVar(&mesosURL, "mesosUrl", "", "The URL for Mesos master, a comma separated list of master URLs, or a ZooKeeper path (zk://host1:2181,host2:2181/mesos).")
 
 	flag.Var(&context.Conf.AutoscalingGroupPrefixes, "autoscalingGroupName", "An autoscalingGroup prefix for monitor.")
 	flag.Var(&context.Conf.ProtectedFrameworks, "protectedFrameworks", "The mesos frameworks to wait for kill the node.")
@@ -72,32 +311,123 @@ func initFlags(context *context.ApplicationContext) {
 	flag.StringVar(
 		&context.Conf.DeathNodeMark, "deathNodeMark", "DEATH_NODE_MARK", "The tag to apply for instances to be deleted.")
 	flag.BoolVar(&context.Conf.ResetLifecycle, "resetLifecycle", false, "Reset lifecycle when it's close to expire.")
+	flag.BoolVar(&context.Conf.HonourInverseOffers, "honourInverseOffers", false,
+		"Destroy the instances with protected tasks once every framework running tasks in them accepted the inverse offers.")
+
+	hostname, _ := os.Hostname()
+	flag.StringVar(&leaderElection, "leaderElection", "",
+		"Leader election backend to use when running several replicas (file or etcd). Disabled by default.")
+	flag.StringVar(&leaderLockFile, "leaderLockFile", "/var/run/deathnode/leader.lock",
+		"The lease file shared by the replicas for file leader election.")
+	flag.StringVar(&etcdURL, "etcdUrl", "", "The URL for etcd, for etcd leader election.")
+	flag.StringVar(&leaderKey, "leaderKey", "/deathnode/leader", "The etcd key used for etcd leader election.")
+	flag.StringVar(&replicaID, "replicaId", hostname, "Unique identifier of this replica for leader election.")
+	flag.IntVar(&context.Conf.LeaderLeaseTTLSeconds, "leaderLeaseTTL", 30, "Seconds the leadership lease lasts if not renewed.")
 
 	flag.IntVar(&pollingSeconds, "polling", 60, "Seconds between executions.")
+	flag.IntVar(&callTimeoutSeconds, "callTimeout", 30, "Seconds before an AWS or Mesos API call is cancelled.")
+	flag.StringVar(&context.Conf.MesosAPI, "mesosApi", "legacy",
+		"Mesos master API to use: legacy, for the /master and /maintenance endpoints, or v1, for the operator API.")
+	flag.StringVar(&mesosEncoding, "mesosApiEncoding", mesos.EncodingJSON,
+		"Encoding of the calls to the Mesos v1 operator API: json or protobuf.")
+	flag.BoolVar(&mesosSubscribe, "mesosSubscribe", false,
+		"Keep the Mesos state up to date with the v1 operator API event stream instead of reading it on every run. Requires mesosApi v1.")
+	flag.IntVar(&context.Conf.MesosStateMaxAgeSeconds, "mesosStateMaxAge", 300,
+		"Seconds after the last complete read or event of the Mesos state until it's not trusted anymore. 0 disables it.")
+	flag.StringVar(&mesosUsername, "mesosUsername", "", "Username to authenticate against Mesos with HTTP basic auth.")
+	flag.StringVar(&mesosPassword, "mesosPassword", os.Getenv(mesosPasswordEnv),
+		"Password to authenticate against Mesos with HTTP basic auth. Defaults to $"+mesosPasswordEnv+".")
+	flag.StringVar(&mesosToken, "mesosToken", os.Getenv(mesosTokenEnv),
+		"Bearer token to authenticate against Mesos. Defaults to $"+mesosTokenEnv+".")
+	flag.StringVar(&mesosServiceAccount, "mesosServiceAccount", "",
+		"DC/OS service account secret file (uid, private_key and login_endpoint) to authenticate against Mesos.")
+	flag.StringVar(&mesosTLS.CAFile, "mesosCACert", "", "PEM file with the CAs to trust for the Mesos masters.")
+	flag.StringVar(&mesosTLS.CertFile, "mesosClientCert", "", "PEM client certificate to present to the Mesos masters.")
+	flag.StringVar(&mesosTLS.KeyFile, "mesosClientKey", "", "PEM key of the client certificate for the Mesos masters.")
+	flag.StringVar(&mesosTLS.ServerName, "mesosServerName", "",
+		"Name to verify in the certificates of the Mesos masters, instead of their host.")
+	flag.IntVar(&mesosRetries, "mesosRetries", 2,
+		"Times a Mesos read is retried after a network error or a 5xx response.")
+	flag.IntVar(&mesosRetryBackoffMillis, "mesosRetryBackoff", 500,
+		"Milliseconds to wait before the first Mesos retry, doubled on every retry and jittered.")
+	flag.IntVar(&shutdownTimeoutSeconds, "shutdownTimeout", 60,
+		"Seconds to wait for the in-flight execution to finish on shutdown before cancelling it.")
 	flag.IntVar(&context.Conf.DelayDeleteSeconds, "delayDelete", 0, "Time to wait between kill executions (in seconds).")
 
+	flag.IntVar(&context.Conf.MaxDrainSeconds, "maxDrain", 0,
+		"Seconds an instance can be draining before applying drainDeadlinePolicy. Disabled by default.")
+	flag.StringVar(&context.Conf.DrainDeadlinePolicy, "drainDeadlinePolicy", "WAIT",
+		"What to do with the instances draining for more than maxDrain, or close to the AWS lifecycle global timeout: CONTINUE, ABANDON or WAIT.")
+
+	flag.IntVar(&context.Conf.MaintenanceLeadSeconds, "maintenanceLeadTime", 0,
+		"Seconds between scheduling the maintenance of an agent and the start of its unavailability. Agents are not destroyed before it starts.")
+	flag.IntVar(&context.Conf.MaintenanceDurationSeconds, "maintenanceDuration", 0,
+		"Expected seconds of unavailability of the agents in maintenance. Unset by default.")
+	flag.StringVar(&context.Conf.DrainStrategy, "drainStrategy", "maintenance",
+		"How to drain the agents of the instances to remove: maintenance, with the Mesos maintenance schedule, or drainAgent, with DRAIN_AGENT (Mesos 1.9+).")
+	flag.IntVar(&context.Conf.DrainGracePeriodSeconds, "drainGracePeriod", 0,
+		"Maximum seconds the tasks of an agent drained with drainAgent get to stop. Their own kill grace period is used if unset.")
+	flag.BoolVar(&context.Conf.DrainMarkGone, "drainMarkGone", false,
+		"Mark the agents drained with drainAgent as gone once drained, so Mesos doesn't expect them back.")
+
+	flag.StringVar(&context.Conf.LifecycleHookName, "lifecycleHookName", "DEATHNODE",
+		"Name of the lifecycle hook put on the autoscaling groups. Use a different one per deathnode deployment.")
+	flag.IntVar(&context.Conf.LifecycleTimeout, "lifecycleTimeout", 3600,
+		"Heartbeat timeout of the lifecycle hook, in seconds (30 to 7200).")
+	flag.StringVar(&context.Conf.LifecycleDefault, "lifecycleDefaultResult", "CONTINUE",
+		"Result AWS applies when the lifecycle hook times out: CONTINUE or ABANDON.")
+
+	flag.StringVar(&context.Conf.DrainBudget.MaxDraining, "maxDraining", "",
+		"Instances of an autoscaling group that can be draining at once, as a count or a percentage of its desired capacity. Unlimited by default.")
+	flag.StringVar(&context.Conf.DrainBudget.MaxCompletions, "maxCompletions", "",
+		"Instances of an autoscaling group that can be terminated per completionWindow, as a count or a percentage. Unlimited by default.")
+	flag.IntVar(&context.Conf.DrainBudget.CompletionWindowSeconds, "completionWindow", 0,
+		"Seconds of the time window maxCompletions applies to.")
+	flag.StringVar(&context.Conf.ClusterDrainBudget.MaxDraining, "clusterMaxDraining", "",
+		"Instances of all the autoscaling groups that can be draining at once, as a count or a percentage. Unlimited by default.")
+	flag.StringVar(&context.Conf.ClusterDrainBudget.MaxCompletions, "clusterMaxCompletions", "",
+		"Instances of all the autoscaling groups that can be terminated per clusterCompletionWindow. Unlimited by default.")
+	flag.IntVar(&context.Conf.ClusterDrainBudget.CompletionWindowSeconds, "clusterCompletionWindow", 0,
+		"Seconds of the time window clusterMaxCompletions applies to.")
+
 	flag.Parse()
 }
 
-func enforceFlags(context *context.ApplicationContext) {
+func enforceFlags(ctx *context.ApplicationContext) {
 
 	if mesosURL == "" {
 		flag.Usage()
 		log.Fatal("mesosUrl flag is required")
 	}
 
-	if len(context.Conf.AutoscalingGroupPrefixes) < 1 {
+	if err := ctx.Conf.Validate(); err != nil {
+		flag.Usage()
+		log.Fatal(err)
+	}
+
+	if mesosEncoding != mesos.EncodingJSON && mesosEncoding != mesos.EncodingProtobuf {
 		flag.Usage()
-		log.Fatal("at least one autoscalingGroupName flag is required")
+		log.Fatal("mesosApiEncoding must be json or protobuf")
 	}
 
-	if len(context.Conf.ProtectedFrameworks) < 1 {
+	if mesosSubscribe && ctx.Conf.MesosAPI != context.MesosAPIV1 {
+		flag.Usage()
+		log.Fatal("mesosSubscribe requires mesosApi v1")
+	}
+
+	authenticators := 0
+	for _, set := range []bool{mesosUsername != "", mesosToken != "", mesosServiceAccount != ""} {
+		if set {
+			authenticators++
+		}
+	}
+	if authenticators > 1 {
 		flag.Usage()
-		log.Fatal("at least one registeredFramework flag is required")
+		log.Fatal("Only one of mesosUsername, mesosToken and mesosServiceAccount can be used")
 	}
 
-	if len(context.Conf.ConstraintsType) < 1 {
+	if leaderElection == "etcd" && etcdURL == "" {
 		flag.Usage()
-		log.Fatal("at least one registeredFramework flag is required")
+		log.Fatal("etcdUrl flag is required for etcd leader election")
 	}
 }
@@ -0,0 +1,247 @@
+package mesos
+
+// Authentication of the calls against the Mesos master: basic credentials, a static bearer token, or a DC/OS
+// service account exchanging a signed JWT for an ACS token
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authenticator adds the credentials to the requests sent to the Mesos master
+type Authenticator interface {
+	Authenticate(ctx context.Context, req *http.Request, httpClient *http.Client) error
+	// Invalidate drops any credential obtained by the authenticator, after the master rejected it
+	Invalidate()
+}
+
+// BasicAuthenticator authenticates with HTTP basic credentials
+type BasicAuthenticator struct {
+	Username string
+	Password string
+}
+
+// Authenticate sets the basic credentials
+func (a *BasicAuthenticator) Authenticate(ctx context.Context, req *http.Request, httpClient *http.Client) error {
+	req.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+// Invalidate does nothing, the credentials are static
+func (a *BasicAuthenticator) Invalidate() {}
+
+// TokenAuthenticator authenticates with a static bearer token
+type TokenAuthenticator struct {
+	Token string
+}
+
+// Authenticate sets the bearer token
+func (a *TokenAuthenticator) Authenticate(ctx context.Context, req *http.Request, httpClient *http.Client) error {
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+	return nil
+}
+
+// Invalidate does nothing, the token is static
+func (a *TokenAuthenticator) Invalidate() {}
+
+// serviceAccountLoginLifetime is the lifetime of the JWT sent to log in, and of the ACS tokens without expiration
+const serviceAccountLoginLifetime = 5 * time.Minute
+
+// serviceAccountRefreshMargin is the time before the expiration of an ACS token when it's renewed
+const serviceAccountRefreshMargin = time.Minute
+
+// ServiceAccountAuthenticator logs in to the DC/OS ACS as a service account, sending a JWT signed with its private
+// key, and authenticates with the ACS token obtained. The token is renewed before it expires
+type ServiceAccountAuthenticator struct {
+	UID        string
+	PrivateKey *rsa.PrivateKey
+	LoginURL   string
+	// Now returns the current time, time.Now if nil
+	Now       func() time.Time
+	token     string
+	expiresAt time.Time
+	mutex     sync.Mutex
+}
+
+// serviceAccountCredentials is the DC/OS service account secret, as created by the DC/OS CLI
+type serviceAccountCredentials struct {
+	Scheme        string `json:"scheme"`
+	UID           string `json:"uid"`
+	PrivateKey    string `json:"private_key"`
+	LoginEndpoint string `json:"login_endpoint"`
+}
+
+// NewServiceAccountAuthenticator reads the DC/OS service account secret stored in credentialsFile
+func NewServiceAccountAuthenticator(credentialsFile string) (*ServiceAccountAuthenticator, error) {
+
+	content, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	var credentials serviceAccountCredentials
+	if err := json.Unmarshal(content, &credentials); err != nil {
+		return nil, fmt.Errorf("Invalid DC/OS service account %s: %s", credentialsFile, err)
+	}
+	if credentials.Scheme != "" && credentials.Scheme != "RS256" {
+		return nil, fmt.Errorf("Unsupported DC/OS service account scheme %s", credentials.Scheme)
+	}
+	if credentials.UID == "" || credentials.LoginEndpoint == "" {
+		return nil, fmt.Errorf("DC/OS service account %s requires uid and login_endpoint", credentialsFile)
+	}
+
+	block, _ := pem.Decode([]byte(credentials.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("DC/OS service account %s has no PEM private key", credentialsFile)
+	}
+	privateKey, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid DC/OS service account private key: %s", err)
+	}
+
+	return &ServiceAccountAuthenticator{
+		UID:        credentials.UID,
+		PrivateKey: privateKey,
+		LoginURL:   credentials.LoginEndpoint,
+	}, nil
+}
+
+// Authenticate sets the ACS token, logging in if there is none or it's about to expire
+func (a *ServiceAccountAuthenticator) Authenticate(ctx context.Context, req *http.Request,
+	httpClient *http.Client) error {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.token == "" || !a.now().Before(a.expiresAt.Add(-serviceAccountRefreshMargin)) {
+		if err := a.login(ctx, httpClient); err != nil {
+			return err
+		}
+	}
+	req.Header.Set("Authorization", "token="+a.token)
+	return nil
+}
+
+// Invalidate drops the ACS token, so the next call logs in again
+func (a *ServiceAccountAuthenticator) Invalidate() {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.token = ""
+}
+
+func (a *ServiceAccountAuthenticator) login(ctx context.Context, httpClient *http.Client) error {
+
+	now := a.now()
+	loginToken, err := signJWT(a.PrivateKey, map[string]interface{}{
+		"uid": a.UID,
+		"exp": now.Add(serviceAccountLoginLifetime).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	payload, _ := json.Marshal(map[string]string{"uid": a.UID, "token": loginToken})
+
+	req, err := http.NewRequest("POST", a.LoginURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Unable to log in to DC/OS as %s: %s", a.UID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unable to log in to DC/OS as %s: login returned %d", a.UID, resp.StatusCode)
+	}
+
+	var response struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil || response.Token == "" {
+		return fmt.Errorf("Unable to log in to DC/OS as %s: no token returned", a.UID)
+	}
+
+	a.token = response.Token
+	a.expiresAt = now.Add(serviceAccountLoginLifetime)
+	if exp, ok := jwtExpiration(response.Token); ok {
+		a.expiresAt = exp
+	}
+	return nil
+}
+
+func (a *ServiceAccountAuthenticator) now() time.Time {
+
+	if a.Now != nil {
+		return a.Now()
+	}
+	return time.Now()
+}
+
+// signJWT returns the claims as a JWT signed with RS256
+func signJWT(privateKey *rsa.PrivateKey, claims map[string]interface{}) (string, error) {
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// jwtExpiration returns the exp claim of a JWT, without verifying it
+func jwtExpiration(token string) (time.Time, bool) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(body, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+func parsePrivateKey(der []byte) (*rsa.PrivateKey, error) {
+
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the private key is not RSA")
+	}
+	return rsaKey, nil
+}
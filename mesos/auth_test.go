@@ -0,0 +1,225 @@
+package mesos
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAuthenticators(t *testing.T) {
+
+	Convey("When the Mesos master requires authentication", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			username, password, ok := r.BasicAuth()
+			if r.Header.Get("Authorization") != "Bearer secret" && !(ok && username == "deathnode" && password == "secret") {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"frameworks": []}`)
+		}))
+		defer server.Close()
+
+		Convey("a call with valid basic credentials should succeed", func() {
+			client := &Client{MasterURL: server.URL, Authenticator: &BasicAuthenticator{Username: "deathnode", Password: "secret"}}
+			_, err := client.GetMesosFrameworks(context.Background())
+			So(err, ShouldBeNil)
+		})
+		Convey("a call with a valid bearer token should succeed", func() {
+			client := &Client{MasterURL: server.URL, Authenticator: &TokenAuthenticator{Token: "secret"}}
+			_, err := client.GetMesosFrameworks(context.Background())
+			So(err, ShouldBeNil)
+		})
+		Convey("a call with invalid credentials should fail as unauthorized", func() {
+			client := &Client{MasterURL: server.URL, Authenticator: &TokenAuthenticator{Token: "wrong"}}
+			_, err := client.GetMesosFrameworks(context.Background())
+			So(err, ShouldHaveSameTypeAs, &APIError{})
+			So(err.(*APIError).StatusCode, ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+}
+
+func TestServiceAccountAuthenticator(t *testing.T) {
+
+	Convey("When deathnode logs in to DC/OS with a service account", t, func() {
+		privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+		now := time.Unix(1500000000, 0)
+		var logins int32
+		var validToken atomic.Value
+
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/acs/api/v1/auth/login" {
+				var login struct {
+					UID   string `json:"uid"`
+					Token string `json:"token"`
+				}
+				json.NewDecoder(r.Body).Decode(&login)
+				if login.UID != "deathnode" || !verifyJWT(&privateKey.PublicKey, login.Token) {
+					http.Error(w, "invalid login", http.StatusUnauthorized)
+					return
+				}
+				n := atomic.AddInt32(&logins, 1)
+				claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp": %d}`, now.Add(time.Hour).Unix())))
+				token := fmt.Sprintf("header.%s.acs%d", claims, n)
+				validToken.Store("token=" + token)
+				fmt.Fprintf(w, `{"token": "%s"}`, token)
+				return
+			}
+			if r.Header.Get("Authorization") != validToken.Load() {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"frameworks": []}`)
+		}))
+		defer server.Close()
+
+		dir, _ := ioutil.TempDir("", "deathnode-mesos")
+		defer os.RemoveAll(dir)
+		credentialsFile := filepath.Join(dir, "service-account.json")
+		credentials, _ := json.Marshal(map[string]string{
+			"scheme":         "RS256",
+			"uid":            "deathnode",
+			"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})),
+			"login_endpoint": server.URL + "/acs/api/v1/auth/login",
+		})
+		ioutil.WriteFile(credentialsFile, credentials, 0600)
+
+		authenticator, err := NewServiceAccountAuthenticator(credentialsFile)
+		So(err, ShouldBeNil)
+		authenticator.Now = func() time.Time { return now }
+		client := &Client{MasterURL: server.URL, Authenticator: authenticator, HTTPClient: server.Client()}
+
+		_, err = client.GetMesosFrameworks(context.Background())
+		So(err, ShouldBeNil)
+
+		Convey("the ACS token should be reused while it's valid", func() {
+			_, err := client.GetMesosFrameworks(context.Background())
+			So(err, ShouldBeNil)
+			So(atomic.LoadInt32(&logins), ShouldEqual, 1)
+		})
+		Convey("the ACS token should be renewed before it expires", func() {
+			now = now.Add(time.Hour - serviceAccountRefreshMargin)
+			_, err := client.GetMesosFrameworks(context.Background())
+			So(err, ShouldBeNil)
+			So(atomic.LoadInt32(&logins), ShouldEqual, 2)
+		})
+		Convey("the ACS token should be renewed if the master rejects it", func() {
+			validToken.Store("revoked")
+			_, err := client.GetMesosFrameworks(context.Background())
+			So(err, ShouldBeNil)
+			So(atomic.LoadInt32(&logins), ShouldEqual, 2)
+		})
+	})
+}
+
+func TestTLSConfig(t *testing.T) {
+
+	Convey("When the Mesos master is served with HTTPS", t, func() {
+		clientCert, clientKey, clientPool := newClientCertificate()
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"frameworks": []}`)
+		}))
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientPool}
+		server.StartTLS()
+		defer server.Close()
+
+		dir, _ := ioutil.TempDir("", "deathnode-mesos")
+		defer os.RemoveAll(dir)
+		options := TLSOptions{
+			CAFile:   filepath.Join(dir, "ca.pem"),
+			CertFile: filepath.Join(dir, "client.pem"),
+			KeyFile:  filepath.Join(dir, "client-key.pem"),
+		}
+		ioutil.WriteFile(options.CAFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+		ioutil.WriteFile(options.CertFile, clientCert, 0600)
+		ioutil.WriteFile(options.KeyFile, clientKey, 0600)
+
+		call := func(options TLSOptions) error {
+			config, err := NewTLSConfig(options)
+			if err != nil {
+				return err
+			}
+			client := &Client{MasterURL: server.URL, HTTPClient: &http.Client{Transport: &http.Transport{TLSClientConfig: config}}}
+			_, err = client.GetMesosFrameworks(context.Background())
+			return err
+		}
+
+		Convey("a call trusting the CA and with a client certificate should succeed", func() {
+			So(call(options), ShouldBeNil)
+		})
+		Convey("a call verifying the name in the certificate should succeed", func() {
+			options.ServerName = "example.com"
+			So(call(options), ShouldBeNil)
+		})
+		Convey("a call verifying another name should fail", func() {
+			options.ServerName = "mesos.example.org"
+			So(call(options), ShouldNotBeNil)
+		})
+		Convey("a call without client certificate should fail", func() {
+			options.CertFile, options.KeyFile = "", ""
+			So(call(options), ShouldNotBeNil)
+		})
+		Convey("a client certificate without key should be rejected", func() {
+			options.KeyFile = ""
+			_, err := NewTLSConfig(options)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// verifyJWT returns true if the token is signed with the key of publicKey
+func verifyJWT(publicKey *rsa.PublicKey, token string) bool {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) == nil
+}
+
+// newClientCertificate returns a self-signed client certificate and key, in PEM, and a pool trusting it
+func newClientCertificate() ([]byte, []byte, *x509.CertPool) {
+
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "deathnode"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+
+		BasicConstraintsValid: true,
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	certificate, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), pool
+}
@@ -1,27 +1,52 @@
 package mesos
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
 // ClientInterface is an interface for mesos api clients
 type ClientInterface interface {
-	GetMesosTasks() (*TasksResponse, error)
-	GetMesosFrameworks() (*FrameworksResponse, error)
-	GetMesosAgents() (*SlavesResponse, error)
-	SetHostsInMaintenance(map[string]string) error
+	GetMesosTasks(ctx context.Context) (*TasksResponse, error)
+	GetMesosFrameworks(ctx context.Context) (*FrameworksResponse, error)
+	GetMesosAgents(ctx context.Context) (*SlavesResponse, error)
+	GetMaintenanceSchedule(ctx context.Context) (*MaintenanceSchedule, error)
+	GetMaintenanceStatus(ctx context.Context) (*MaintenanceStatus, error)
+	UpdateMaintenanceSchedule(ctx context.Context, schedule *MaintenanceSchedule) error
+	StartMaintenance(ctx context.Context, machines []MaintenanceMachinesID) error
+	StopMaintenance(ctx context.Context, machines []MaintenanceMachinesID) error
+	DrainAgent(ctx context.Context, agentID string, maxGracePeriod time.Duration, markGone bool) error
 }
 
-// Client implements a client for mesos api
+// Client implements a client for mesos api. The calls are sent to the leading master, found by the Resolver and
+// cached until a call fails or is redirected. Without Resolver, the calls are sent to MasterURL, or to the master
+// it redirects them to
 type Client struct {
 	MasterURL string
+	Resolver  LeaderResolver
+	// Authenticator adds the credentials to every call. The calls are not authenticated if nil
+	Authenticator Authenticator
+	// Timeout bounds every HTTP call attempt against the Mesos master. Zero means no timeout
+	Timeout time.Duration
+	// Retries is the number of times an idempotent call is retried after a network error or a 5xx response
+	Retries int
+	// RetryBackoff is the base wait before a retry, doubled on every attempt and jittered
+	RetryBackoff time.Duration
+	// HTTPClient performs the calls. The default client is used if nil. Redirects are never followed
+	HTTPClient *http.Client
+	leaderURL  string
+	mutex      sync.Mutex
 }
 
+// tasksPageSize is the number of tasks read per call
+const tasksPageSize = 100
+
 // SlavesResponse is part of the mesos slaves response API endpoint
 type SlavesResponse struct {
 	Slaves []Slave `json:"slaves"`
@@ -29,11 +54,23 @@ type SlavesResponse struct {
 
 // Slave is part of the mesos slaves response API endpoint
 type Slave struct {
-	ID       string `json:"id"`
-	Pid      string `json:"pid"`
-	Hostname string `json:"hostname"`
+	ID        string     `json:"id"`
+	Pid       string     `json:"pid"`
+	Hostname  string     `json:"hostname"`
+	DrainInfo *DrainInfo `json:"drain_info,omitempty"`
+}
+
+// DrainInfo is the drain state of an agent drained with DRAIN_AGENT, only returned while it's draining or drained
+type DrainInfo struct {
+	State string `json:"state"`
 }
 
+// Drain states of the agents
+const (
+	DrainStateDraining = "DRAINING"
+	DrainStateDrained  = "DRAINED"
+)
+
 // FrameworksResponse is part of the mesos frameworks response API endpoint
 type FrameworksResponse struct {
 	Frameworks []Framework `json:"frameworks"`
@@ -53,13 +90,13 @@ type TasksResponse struct {
 
 // Task is part of the mesos tasks response API endpoint
 type Task struct {
+	ID          string   `json:"id"`
 	Name        string   `json:"name"`
 	State       string   `json:"state"`
 	SlaveID     string   `json:"slave_id"`
 	FrameworkID string   `json:"framework_id"`
 	Statuses    []Status `json:"statuses"`
 	Labels      []Labels `json:"labels"`
-	IsProtected bool
 }
 
 // Labels is part of the mesos tasks response API endpoint
@@ -74,168 +111,180 @@ type Status struct {
 	Timestamp float64 `json:"timestamp"`
 }
 
-// MaintenanceRequest implements the payload for set mesos instances in maintenance API call
-type MaintenanceRequest struct {
+// MaintenanceSchedule is part of the mesos maintenance schedule API endpoint
+type MaintenanceSchedule struct {
 	Windows []MaintenanceWindow `json:"windows"`
 }
 
-// MaintenanceWindow implements the payload for set mesos instances in maintenance API call
+// MaintenanceWindow is part of the mesos maintenance schedule API endpoint
 type MaintenanceWindow struct {
 	MachinesIds    []MaintenanceMachinesID   `json:"machine_ids"`
 	Unavailability MaintenanceUnavailability `json:"unavailability"`
 }
 
-// MaintenanceMachinesID implements the payload for set mesos instances in maintenance API call
+// MaintenanceMachinesID is part of the mesos maintenance schedule and machine API endpoints
 type MaintenanceMachinesID struct {
 	Hostname string `json:"hostname"`
 	IP       string `json:"ip"`
 }
 
-// MaintenanceUnavailability implements the payload for set mesos instances in maintenance API call
+// MaintenanceUnavailability is part of the mesos maintenance schedule API endpoint
 type MaintenanceUnavailability struct {
-	Start MaintenanceStart `json:"start"`
+	Start    MaintenanceStart     `json:"start"`
+	Duration *MaintenanceDuration `json:"duration,omitempty"`
 }
 
-// MaintenanceStart implements the payload for set mesos instances in maintenance API call
+// MaintenanceStart is part of the mesos maintenance schedule API endpoint
 type MaintenanceStart struct {
-	Nanoseconds int32 `json:"nanoseconds"`
+	Nanoseconds int64 `json:"nanoseconds"`
 }
 
-// SetHostsInMaintenance configures nodes in maintenance for Mesos cluster
-func (c *Client) SetHostsInMaintenance(hosts map[string]string) error {
-
-	url := fmt.Sprintf(c.MasterURL + "/maintenance/schedule")
-	payload := genMaintenanceCallPayload(hosts)
-	return mesosPostAPICall(url, payload)
+// MaintenanceDuration is part of the mesos maintenance schedule API endpoint
+type MaintenanceDuration struct {
+	Nanoseconds int64 `json:"nanoseconds"`
 }
 
-// GetMesosTasks return the running tasks on the Mesos cluster
-func (c *Client) GetMesosTasks() (*TasksResponse, error) {
+// MaintenanceStatus is part of the mesos maintenance status API endpoint
+type MaintenanceStatus struct {
+	DrainingMachines []DrainingMachine       `json:"draining_machines"`
+	DownMachines     []MaintenanceMachinesID `json:"down_machines"`
+}
 
-	var tasks TasksResponse
-	if err := c.getMesosTasksRecursive(&tasks, 0); err != nil {
-		return nil, err
-	}
+// DrainingMachine is part of the mesos maintenance status API endpoint
+type DrainingMachine struct {
+	ID       MaintenanceMachinesID `json:"id"`
+	Statuses []InverseOfferStatus  `json:"statuses"`
+}
 
-	return &tasks, nil
+// InverseOfferStatus is part of the mesos maintenance status API endpoint. It's the last response of a framework
+// to the inverse offers of a draining machine
+type InverseOfferStatus struct {
+	Status      string      `json:"status"`
+	FrameworkID FrameworkID `json:"framework_id"`
 }
 
-func (c *Client) getMesosTasksRecursive(tasksResponse *TasksResponse, offset int) error {
+// FrameworkID is part of the mesos maintenance status API endpoint
+type FrameworkID struct {
+	Value string `json:"value"`
+}
 
-	url := fmt.Sprintf("%s/master/tasks?limit=100&offset=%d", c.MasterURL, offset)
+// Responses of the frameworks to the inverse offers
+const (
+	InverseOfferUnknown = "UNKNOWN"
+	InverseOfferAccept  = "ACCEPT"
+	InverseOfferDecline = "DECLINE"
+)
 
-	var tasks TasksResponse
-	if err := mesosGetAPICall(url, &tasks); err != nil {
-		return err
-	}
+// GetMaintenanceSchedule returns the maintenance schedule of the Mesos cluster
+func (c *Client) GetMaintenanceSchedule(ctx context.Context) (*MaintenanceSchedule, error) {
 
-	tasksResponse.Tasks = append(tasksResponse.Tasks, tasks.Tasks...)
+	path := "/maintenance/schedule"
 
-	if len(tasks.Tasks) == 100 {
-		c.getMesosTasksRecursive(tasksResponse, offset+100)
+	var schedule MaintenanceSchedule
+	if err := c.get(ctx, path, &schedule); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return &schedule, nil
 }
 
-// GetMesosFrameworks returns the registered frameworks in Mesos
-func (c *Client) GetMesosFrameworks() (*FrameworksResponse, error) {
+// GetMaintenanceStatus returns the machines draining, with the inverse offer responses of the frameworks, and the
+// machines down
+func (c *Client) GetMaintenanceStatus(ctx context.Context) (*MaintenanceStatus, error) {
 
-	url := fmt.Sprintf(c.MasterURL + "/master/frameworks")
+	path := "/maintenance/status"
 
-	var frameworks FrameworksResponse
-	if err := mesosGetAPICall(url, &frameworks); err != nil {
+	var status MaintenanceStatus
+	if err := c.get(ctx, path, &status); err != nil {
 		return nil, err
 	}
 
-	return &frameworks, nil
+	return &status, nil
 }
 
-// GetMesosAgents returns the Mesos Agents registered in the Mesos cluster
-func (c *Client) GetMesosAgents() (*SlavesResponse, error) {
+// UpdateMaintenanceSchedule replaces the maintenance schedule of the Mesos cluster
+func (c *Client) UpdateMaintenanceSchedule(ctx context.Context, schedule *MaintenanceSchedule) error {
 
-	url := fmt.Sprintf(c.MasterURL + "/master/slaves")
+	path := "/maintenance/schedule"
+	payload, _ := json.Marshal(schedule)
+	// Replacing the schedule with the same one has no side effects, so it's retried
+	return c.post(ctx, path, payload, true)
+}
 
-	var slaves SlavesResponse
-	if err := mesosGetAPICall(url, &slaves); err != nil {
-		return nil, err
-	}
+// StartMaintenance moves scheduled machines to down mode, killing their tasks
+func (c *Client) StartMaintenance(ctx context.Context, machines []MaintenanceMachinesID) error {
 
-	return &slaves, nil
+	path := "/machine/down"
+	payload, _ := json.Marshal(machines)
+	return c.post(ctx, path, payload, false)
 }
 
-func genMaintenanceCallPayload(hosts map[string]string) []byte {
+// StopMaintenance brings machines in down mode back up, removing them from the maintenance schedule
+func (c *Client) StopMaintenance(ctx context.Context, machines []MaintenanceMachinesID) error {
 
-	maintenanceMachinesIDs := []MaintenanceMachinesID{}
-	for host := range hosts {
-		maintenanceMachinesID := MaintenanceMachinesID{
-			Hostname: host,
-			IP:       hosts[host],
-		}
-		maintenanceMachinesIDs = append(maintenanceMachinesIDs, maintenanceMachinesID)
-	}
+	path := "/machine/up"
+	payload, _ := json.Marshal(machines)
+	return c.post(ctx, path, payload, false)
+}
 
-	maintenanceWindow := MaintenanceWindow{
-		MachinesIds: maintenanceMachinesIDs,
-		Unavailability: MaintenanceUnavailability{
-			MaintenanceStart{
-				Nanoseconds: 1,
-			},
-		},
-	}
+// DrainAgent is only supported by the v1 operator API
+func (c *Client) DrainAgent(ctx context.Context, agentID string, maxGracePeriod time.Duration, markGone bool) error {
+	return fmt.Errorf("Mesos DRAIN_AGENT requires the v1 operator API")
+}
 
-	maintenanceRequest := MaintenanceRequest{
-		Windows: []MaintenanceWindow{maintenanceWindow},
+// GetMesosTasks return the running tasks on the Mesos cluster. It fails if any page of tasks can't be read
+func (c *Client) GetMesosTasks(ctx context.Context) (*TasksResponse, error) {
+
+	var tasks TasksResponse
+	if err := c.getMesosTasksRecursive(ctx, &tasks, 0); err != nil {
+		return nil, err
 	}
 
-	template, _ := json.Marshal(maintenanceRequest)
-	return template
+	return &tasks, nil
 }
 
-func mesosGetAPICall(url string, response interface{}) error {
+func (c *Client) getMesosTasksRecursive(ctx context.Context, tasksResponse *TasksResponse, offset int) error {
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		fmt.Print("Error preparing HTTP request: ", err)
-		return err
-	}
+	path := fmt.Sprintf("/master/tasks?limit=%d&offset=%d", tasksPageSize, offset)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Print("Error calling HTTP request: ", err)
-		return err
+	var tasks TasksResponse
+	if err := c.get(ctx, path, &tasks); err != nil {
+		return fmt.Errorf("Unable to read the tasks from offset %d: %s", offset, err)
 	}
 
-	defer resp.Body.Close()
+	tasksResponse.Tasks = append(tasksResponse.Tasks, tasks.Tasks...)
 
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		fmt.Print("Error decoding HTTP request: ", err)
-		return err
+	if len(tasks.Tasks) == tasksPageSize {
+		return c.getMesosTasksRecursive(ctx, tasksResponse, offset+tasksPageSize)
 	}
 
 	return nil
 }
 
-func mesosPostAPICall(url string, payload []byte) error {
+// GetMesosFrameworks returns the registered frameworks in Mesos
+func (c *Client) GetMesosFrameworks(ctx context.Context) (*FrameworksResponse, error) {
+
+	path := "/master/frameworks"
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-	if err != nil {
-		fmt.Print("Error preparing HTTP request: ", err)
-		return err
+	var frameworks FrameworksResponse
+	if err := c.get(ctx, path, &frameworks); err != nil {
+		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
+	return &frameworks, nil
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Print("Error calling HTTP request: ", err)
-		return err
+// GetMesosAgents returns the Mesos Agents registered in the Mesos cluster
+func (c *Client) GetMesosAgents(ctx context.Context) (*SlavesResponse, error) {
+
+	path := "/master/slaves"
+
+	var slaves SlavesResponse
+	if err := c.get(ctx, path, &slaves); err != nil {
+		return nil, err
 	}
 
-	defer resp.Body.Close()
-	return nil
+	return &slaves, nil
 }
 
 func getCurrentPath() string {
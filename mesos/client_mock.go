@@ -1,57 +1,123 @@
 package mesos
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// ClientMock implements mesos.ClientInterface for testing purposes
+// ClientMock implements mesos.ClientInterface for testing purposes. The reads of the cluster state fail with the
+// error in Errors for their record, if any, without consuming a record
 type ClientMock struct {
 	Records  map[string]*[]string
 	Requests map[string]*[]string
+	Errors   map[string]error
 }
 
 // GetMesosTasks mocked for testing purposes
-func (c *ClientMock) GetMesosTasks() (*TasksResponse, error) {
+func (c *ClientMock) GetMesosTasks(ctx context.Context) (*TasksResponse, error) {
+
+	if err := c.Errors["GetMesosTasks"]; err != nil {
+		return nil, err
+	}
 	mockResponse, _ := c.replay(&TasksResponse{}, "GetMesosTasks")
 	return mockResponse.(*TasksResponse), nil
 }
 
 // GetMesosFrameworks mocked for testing purposes
-func (c *ClientMock) GetMesosFrameworks() (*FrameworksResponse, error) {
+func (c *ClientMock) GetMesosFrameworks(ctx context.Context) (*FrameworksResponse, error) {
+
+	if err := c.Errors["GetMesosFrameworks"]; err != nil {
+		return nil, err
+	}
 	mockResponse, _ := c.replay(&FrameworksResponse{}, "GetMesosFrameworks")
 	return mockResponse.(*FrameworksResponse), nil
 }
 
 // GetMesosAgents mocked for testing purposes
-func (c *ClientMock) GetMesosAgents() (*SlavesResponse, error) {
+func (c *ClientMock) GetMesosAgents(ctx context.Context) (*SlavesResponse, error) {
+
+	if err := c.Errors["GetMesosSlaves"]; err != nil {
+		return nil, err
+	}
 	mockResponse, _ := c.replay(&SlavesResponse{}, "GetMesosSlaves")
 	return mockResponse.(*SlavesResponse), nil
 }
 
-// GenMaintenanceCallPayload mocked for testing purposes
-func (c *ClientMock) GenMaintenanceCallPayload(hosts map[string]string) []byte {
-	return genMaintenanceCallPayload(hosts)
+// GetMaintenanceSchedule mocked for testing purposes. Without records, the schedule is empty
+func (c *ClientMock) GetMaintenanceSchedule(ctx context.Context) (*MaintenanceSchedule, error) {
+
+	if _, ok := c.Records["GetMaintenanceSchedule"]; !ok {
+		return &MaintenanceSchedule{}, nil
+	}
+	mockResponse, _ := c.replay(&MaintenanceSchedule{}, "GetMaintenanceSchedule")
+	return mockResponse.(*MaintenanceSchedule), nil
 }
 
-// SetHostsInMaintenance mocked for testing purposes
-func (c *ClientMock) SetHostsInMaintenance(hosts map[string]string) error {
-	if c.Requests == nil {
-		c.Requests = map[string]*[]string{}
+// GetMaintenanceStatus mocked for testing purposes. Without records, no machine is draining or down
+func (c *ClientMock) GetMaintenanceStatus(ctx context.Context) (*MaintenanceStatus, error) {
+
+	if _, ok := c.Records["GetMaintenanceStatus"]; !ok {
+		return &MaintenanceStatus{}, nil
 	}
+	mockResponse, _ := c.replay(&MaintenanceStatus{}, "GetMaintenanceStatus")
+	return mockResponse.(*MaintenanceStatus), nil
+}
 
-	hostsCallArguments := []string{}
-	for _, host := range hosts {
-		hostsCallArguments = append(hostsCallArguments, host)
-		hostsCallArguments = append(hostsCallArguments, hosts[host])
+// UpdateMaintenanceSchedule mocked for testing purposes. The request stores the hostnames of every window,
+// comma separated
+func (c *ClientMock) UpdateMaintenanceSchedule(ctx context.Context, schedule *MaintenanceSchedule) error {
+
+	windows := []string{}
+	for _, window := range schedule.Windows {
+		windows = append(windows, strings.Join(hostnames(window.MachinesIds), ","))
 	}
+	c.addRequest("UpdateMaintenanceSchedule", windows)
+	return nil
+}
 
-	c.Requests["SetHostInMaintenance"] = &hostsCallArguments
+// StartMaintenance mocked for testing purposes
+func (c *ClientMock) StartMaintenance(ctx context.Context, machines []MaintenanceMachinesID) error {
+	c.addRequest("StartMaintenance", hostnames(machines))
 	return nil
 }
 
+// StopMaintenance mocked for testing purposes
+func (c *ClientMock) StopMaintenance(ctx context.Context, machines []MaintenanceMachinesID) error {
+	c.addRequest("StopMaintenance", hostnames(machines))
+	return nil
+}
+
+// DrainAgent mocked for testing purposes. The request stores the agent ID, the grace period and mark gone
+func (c *ClientMock) DrainAgent(ctx context.Context, agentID string, maxGracePeriod time.Duration, markGone bool) error {
+	c.addRequest("DrainAgent", []string{agentID, maxGracePeriod.String(), strconv.FormatBool(markGone)})
+	return nil
+}
+
+func (c *ClientMock) addRequest(method string, callArguments []string) {
+
+	if c.Requests == nil {
+		c.Requests = map[string]*[]string{}
+	}
+	c.Requests[method] = &callArguments
+}
+
+func hostnames(machines []MaintenanceMachinesID) []string {
+
+	hostnames := []string{}
+	for _, machine := range machines {
+		hostnames = append(hostnames, machine.Hostname)
+	}
+	sort.Strings(hostnames)
+	return hostnames
+}
+
 func (c *ClientMock) replay(mockResponse interface{}, templateFileName string) (interface{}, error) {
 
 	records, ok := c.Records[templateFileName]
@@ -0,0 +1,113 @@
+package mesos
+
+import (
+	"context"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientRetries(t *testing.T) {
+
+	Convey("When the Mesos master fails with a 5xx", t, func() {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				http.Error(w, "leader unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, `{"frameworks": [{"id": "framework1", "name": "frameworkName1", "active": true}]}`)
+		}))
+		defer server.Close()
+		client := &Client{MasterURL: server.URL, Retries: 2, RetryBackoff: time.Millisecond}
+
+		Convey("a read should be retried", func() {
+			frameworks, err := client.GetMesosFrameworks(context.Background())
+			So(err, ShouldBeNil)
+			So(frameworks.Frameworks, ShouldHaveLength, 1)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+		})
+		Convey("a machine down should not be retried", func() {
+			err := client.StartMaintenance(context.Background(), []MaintenanceMachinesID{{Hostname: "host1"}})
+			So(err, ShouldHaveSameTypeAs, &APIError{})
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		})
+	})
+
+	Convey("When the Mesos master rejects a call with a 4xx", t, func() {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			http.Error(w, "Machine is not in the schedule", http.StatusBadRequest)
+		}))
+		defer server.Close()
+		client := &Client{MasterURL: server.URL, Retries: 2, RetryBackoff: time.Millisecond}
+
+		Convey("it should fail with the status code and the body, without retrying", func() {
+			err := client.UpdateMaintenanceSchedule(context.Background(), &MaintenanceSchedule{})
+			So(err, ShouldHaveSameTypeAs, &APIError{})
+			So(err.(*APIError).StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(err.(*APIError).Body, ShouldContainSubstring, "Machine is not in the schedule")
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		})
+	})
+
+	Convey("When the Mesos master takes longer than the timeout", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}))
+		defer server.Close()
+		client := &Client{MasterURL: server.URL, Timeout: 10 * time.Millisecond}
+
+		Convey("the call should fail", func() {
+			_, err := client.GetMesosAgents(context.Background())
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestGetMesosTasks(t *testing.T) {
+
+	Convey("When the tasks span several pages", t, func() {
+		var failSecondPage int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("offset") != "0" {
+				if atomic.LoadInt32(&failSecondPage) == 1 {
+					http.Error(w, "internal error", http.StatusInternalServerError)
+					return
+				}
+				fmt.Fprint(w, `{"tasks": [{"name": "last", "state": "TASK_RUNNING"}]}`)
+				return
+			}
+			fmt.Fprint(w, `{"tasks": [`)
+			for i := 0; i < tasksPageSize; i++ {
+				if i > 0 {
+					fmt.Fprint(w, ",")
+				}
+				fmt.Fprintf(w, `{"name": "task%d", "state": "TASK_RUNNING"}`, i)
+			}
+			fmt.Fprint(w, `]}`)
+		}))
+		defer server.Close()
+		client := &Client{MasterURL: server.URL}
+
+		Convey("all of them should be returned", func() {
+			tasks, err := client.GetMesosTasks(context.Background())
+			So(err, ShouldBeNil)
+			So(tasks.Tasks, ShouldHaveLength, tasksPageSize+1)
+		})
+		Convey("the call should fail if a page can't be read", func() {
+			atomic.StoreInt32(&failSecondPage, 1)
+			tasks, err := client.GetMesosTasks(context.Background())
+			So(err, ShouldNotBeNil)
+			So(tasks, ShouldBeNil)
+		})
+	})
+}
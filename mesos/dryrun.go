@@ -0,0 +1,156 @@
+package mesos
+
+import (
+	"context"
+	"github.com/alanbover/deathnode/dryrun"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DryRunClient decorates a ClientInterface for dry-run mode. Read calls are done against the Mesos master,
+// while mutating calls are only recorded in a plan. Once planned, the maintenance schedule and the agents
+// drained are simulated so following executions see them updated
+type DryRunClient struct {
+	client   ClientInterface
+	plan     *dryrun.Plan
+	schedule *MaintenanceSchedule
+	drained  map[string]bool
+	mutex    sync.Mutex
+}
+
+// NewDryRunClient returns a new DryRunClient object
+func NewDryRunClient(client ClientInterface, plan *dryrun.Plan) *DryRunClient {
+
+	return &DryRunClient{
+		client:  client,
+		plan:    plan,
+		drained: map[string]bool{},
+	}
+}
+
+// GetMesosTasks return the running tasks on the Mesos cluster
+func (c *DryRunClient) GetMesosTasks(ctx context.Context) (*TasksResponse, error) {
+	return c.client.GetMesosTasks(ctx)
+}
+
+// GetMesosFrameworks returns the registered frameworks in Mesos
+func (c *DryRunClient) GetMesosFrameworks(ctx context.Context) (*FrameworksResponse, error) {
+	return c.client.GetMesosFrameworks(ctx)
+}
+
+// GetMesosAgents returns the Mesos Agents registered in the Mesos cluster. The agents drained during the dry run
+// are returned as draining, unless Mesos reports their drain state
+func (c *DryRunClient) GetMesosAgents(ctx context.Context) (*SlavesResponse, error) {
+
+	agents, err := c.client.GetMesosAgents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	simulated := &SlavesResponse{Slaves: []Slave{}}
+	for _, agent := range agents.Slaves {
+		if agent.DrainInfo == nil && c.drained[agent.ID] {
+			agent.DrainInfo = &DrainInfo{State: DrainStateDraining}
+		}
+		simulated.Slaves = append(simulated.Slaves, agent)
+	}
+	return simulated, nil
+}
+
+// GetMaintenanceSchedule returns the maintenance schedule planned during the dry run, or the one in Mesos
+func (c *DryRunClient) GetMaintenanceSchedule(ctx context.Context) (*MaintenanceSchedule, error) {
+
+	c.mutex.Lock()
+	simulated := c.schedule
+	c.mutex.Unlock()
+	if simulated != nil {
+		return simulated.WithoutMachines(map[string]bool{}), nil
+	}
+
+	return c.client.GetMaintenanceSchedule(ctx)
+}
+
+// GetMaintenanceStatus returns the maintenance status in Mesos
+func (c *DryRunClient) GetMaintenanceStatus(ctx context.Context) (*MaintenanceStatus, error) {
+	return c.client.GetMaintenanceStatus(ctx)
+}
+
+// UpdateMaintenanceSchedule records the call in the plan
+func (c *DryRunClient) UpdateMaintenanceSchedule(ctx context.Context, schedule *MaintenanceSchedule) error {
+
+	machines := []MaintenanceMachinesID{}
+	for _, window := range schedule.Windows {
+		machines = append(machines, window.MachinesIds...)
+	}
+
+	c.plan.Record("UpdateMaintenanceSchedule", map[string]string{
+		"machines": formatMachines(machines),
+		"windows":  strconv.Itoa(len(schedule.Windows)),
+	})
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.schedule = schedule.WithoutMachines(map[string]bool{})
+	return nil
+}
+
+// StartMaintenance records the call in the plan
+func (c *DryRunClient) StartMaintenance(ctx context.Context, machines []MaintenanceMachinesID) error {
+
+	c.plan.Record("StartMaintenance", map[string]string{
+		"machines": formatMachines(machines),
+	})
+	return nil
+}
+
+// StopMaintenance records the call in the plan. The machines are removed from the simulated schedule, as Mesos does
+func (c *DryRunClient) StopMaintenance(ctx context.Context, machines []MaintenanceMachinesID) error {
+
+	c.plan.Record("StopMaintenance", map[string]string{
+		"machines": formatMachines(machines),
+	})
+
+	schedule, err := c.GetMaintenanceSchedule(ctx)
+	if err != nil {
+		return err
+	}
+	hostnames := map[string]bool{}
+	for _, machine := range machines {
+		hostnames[machine.Hostname] = true
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.schedule = schedule.WithoutMachines(hostnames)
+	return nil
+}
+
+// DrainAgent records the call in the plan. The agent is simulated as draining from then on
+func (c *DryRunClient) DrainAgent(ctx context.Context, agentID string, maxGracePeriod time.Duration, markGone bool) error {
+
+	c.plan.Record("DrainAgent", map[string]string{
+		"agentId":        agentID,
+		"maxGracePeriod": maxGracePeriod.String(),
+		"markGone":       strconv.FormatBool(markGone),
+	})
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.drained[agentID] = true
+	return nil
+}
+
+func formatMachines(machines []MaintenanceMachinesID) string {
+
+	formatted := []string{}
+	for _, machine := range machines {
+		formatted = append(formatted, machine.Hostname+"="+machine.IP)
+	}
+	sort.Strings(formatted)
+	return strings.Join(formatted, ",")
+}
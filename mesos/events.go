@@ -0,0 +1,399 @@
+package mesos
+
+// Event stream of the v1 operator API: SUBSCRIBE returns the state of the cluster, and then the changes of its
+// tasks, agents and frameworks, as RecordIO records encoded in JSON or protobuf
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/benbjohnson/clock"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types of the v1 operator API, by protobuf value
+var operatorEventTypes = map[uint64]string{
+	1: "SUBSCRIBED",
+	2: "TASK_ADDED",
+	3: "TASK_UPDATED",
+	4: "AGENT_ADDED",
+	5: "AGENT_REMOVED",
+	6: "FRAMEWORK_ADDED",
+	7: "FRAMEWORK_UPDATED",
+	8: "FRAMEWORK_REMOVED",
+	9: "HEARTBEAT",
+}
+
+// maxEventLength is the maximum size of an event, to fail on a corrupted stream instead of allocating its length
+const maxEventLength = 256 << 20
+
+// missedHeartbeats is the number of heartbeats that can be missed before the stream is considered stalled
+const missedHeartbeats = 3
+
+// Subscriber follows the changes of the Mesos cluster
+type Subscriber interface {
+	// Subscribe calls handle with every event of the cluster, starting with a SUBSCRIBED one with its state, until
+	// ctx is done, the stream fails or handle returns an error
+	Subscribe(ctx context.Context, handle func(event *Event) error) error
+}
+
+// Event is an event of the Mesos cluster. Only the fields of its type are set:
+// SUBSCRIBED: Tasks, Frameworks and Agents, the state of the cluster
+// TASK_ADDED: Task
+// TASK_UPDATED: Task, with only its ID, FrameworkID, SlaveID, State and latest status
+// AGENT_ADDED: Agent. AGENT_REMOVED: Agent, with only its ID
+// FRAMEWORK_ADDED, FRAMEWORK_UPDATED: Framework. FRAMEWORK_REMOVED: Framework, without Active
+// HEARTBEAT: none, the master is alive and the stream up to date
+type Event struct {
+	Type       string
+	Tasks      *TasksResponse
+	Frameworks *FrameworksResponse
+	Agents     *SlavesResponse
+	Task       *Task
+	Agent      *Slave
+	Framework  *Framework
+}
+
+type operatorEvent struct {
+	Type             string                 `json:"type"`
+	Subscribed       *operatorSubscribed    `json:"subscribed"`
+	TaskAdded        *operatorTaskAdded     `json:"task_added"`
+	TaskUpdated      *operatorTaskUpdated   `json:"task_updated"`
+	AgentAdded       *operatorAgentAdded    `json:"agent_added"`
+	AgentRemoved     *operatorAgentRemoved  `json:"agent_removed"`
+	FrameworkAdded   *operatorFrameworkSent `json:"framework_added"`
+	FrameworkUpdated *operatorFrameworkSent `json:"framework_updated"`
+	FrameworkRemoved *operatorFramework     `json:"framework_removed"`
+}
+
+type operatorSubscribed struct {
+	GetState struct {
+		GetTasks      operatorTasks      `json:"get_tasks"`
+		GetFrameworks operatorFrameworks `json:"get_frameworks"`
+		GetAgents     operatorAgents     `json:"get_agents"`
+	} `json:"get_state"`
+	HeartbeatIntervalSeconds float64 `json:"heartbeat_interval_seconds"`
+}
+
+type operatorTaskAdded struct {
+	Task operatorTask `json:"task"`
+}
+
+type operatorTaskUpdated struct {
+	FrameworkID operatorID         `json:"framework_id"`
+	Status      operatorTaskStatus `json:"status"`
+	State       string             `json:"state"`
+}
+
+type operatorTaskStatus struct {
+	Status
+	TaskID  operatorID `json:"task_id"`
+	AgentID operatorID `json:"agent_id"`
+}
+
+type operatorAgentAdded struct {
+	Agent operatorAgent `json:"agent"`
+}
+
+type operatorAgentRemoved struct {
+	AgentID operatorID `json:"agent_id"`
+}
+
+type operatorFrameworkSent struct {
+	Framework operatorFramework `json:"framework"`
+}
+
+// Subscribe follows the SUBSCRIBE event stream of the leading master. It fails if the master sends no event,
+// heartbeats included, for missedHeartbeats heartbeat intervals, or before the timeout of the transport until
+// the first one
+func (c *OperatorClient) Subscribe(ctx context.Context, handle func(event *Event) error) error {
+
+	var payload []byte
+	var contentType string
+	switch c.Encoding {
+	case EncodingProtobuf:
+		payload, contentType = (&operatorCall{Type: "SUBSCRIBE"}).protobuf(), "application/x-protobuf"
+	case EncodingJSON, "":
+		payload, contentType = []byte(`{"type": "SUBSCRIBE"}`), "application/json"
+	default:
+		return fmt.Errorf("Unsupported Mesos operator API encoding %s", c.Encoding)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	clk := c.Clock
+	if clk == nil {
+		clk = clock.New()
+	}
+	watchdog := newStreamWatchdog(clk, c.Transport.Timeout, cancel)
+	defer watchdog.stop()
+
+	return c.Transport.stream(ctx, operatorPath, payload, contentType, func(body io.Reader) error {
+		records := bufio.NewReader(body)
+		for {
+			record, err := readRecord(records)
+			if err != nil {
+				if watchdog.expired() {
+					return fmt.Errorf("no event received in time")
+				}
+				if err == io.EOF {
+					return fmt.Errorf("closed by the master")
+				}
+				return err
+			}
+
+			event := &operatorEvent{}
+			if contentType == "application/json" {
+				err = json.Unmarshal(record, event)
+			} else {
+				err = event.decodeProtobuf(record)
+			}
+			if err != nil {
+				return err
+			}
+			if event.Subscribed != nil && event.Subscribed.HeartbeatIntervalSeconds > 0 {
+				watchdog.setInterval(missedHeartbeats *
+					time.Duration(event.Subscribed.HeartbeatIntervalSeconds*float64(time.Second)))
+			}
+			watchdog.reset()
+
+			if converted := event.event(); converted != nil {
+				if err := handle(converted); err != nil {
+					return err
+				}
+			}
+		}
+	})
+}
+
+// event converts the operator event, returning nil for the unknown events
+func (e *operatorEvent) event() *Event {
+
+	event := &Event{Type: e.Type}
+	switch {
+	case e.Subscribed != nil:
+		event.Type = "SUBSCRIBED"
+		event.Tasks = e.Subscribed.GetState.GetTasks.tasks()
+		event.Frameworks = e.Subscribed.GetState.GetFrameworks.frameworks()
+		event.Agents = e.Subscribed.GetState.GetAgents.slaves()
+	case e.TaskAdded != nil:
+		event.Type = "TASK_ADDED"
+		task := e.TaskAdded.Task.task()
+		event.Task = &task
+	case e.TaskUpdated != nil:
+		event.Type = "TASK_UPDATED"
+		event.Task = &Task{
+			ID:          e.TaskUpdated.Status.TaskID.Value,
+			SlaveID:     e.TaskUpdated.Status.AgentID.Value,
+			FrameworkID: e.TaskUpdated.FrameworkID.Value,
+			State:       e.TaskUpdated.State,
+			Statuses:    []Status{e.TaskUpdated.Status.Status},
+		}
+	case e.AgentAdded != nil:
+		event.Type = "AGENT_ADDED"
+		agent := e.AgentAdded.Agent.slave()
+		event.Agent = &agent
+	case e.AgentRemoved != nil:
+		event.Type = "AGENT_REMOVED"
+		event.Agent = &Slave{ID: e.AgentRemoved.AgentID.Value}
+	case e.FrameworkAdded != nil:
+		event.Type = "FRAMEWORK_ADDED"
+		framework := e.FrameworkAdded.Framework.framework()
+		event.Framework = &framework
+	case e.FrameworkUpdated != nil:
+		event.Type = "FRAMEWORK_UPDATED"
+		framework := e.FrameworkUpdated.Framework.framework()
+		event.Framework = &framework
+	case e.FrameworkRemoved != nil:
+		event.Type = "FRAMEWORK_REMOVED"
+		framework := e.FrameworkRemoved.framework()
+		event.Framework = &framework
+	case e.Type == "HEARTBEAT":
+	default:
+		return nil
+	}
+	return event
+}
+
+func (e *operatorEvent) decodeProtobuf(message []byte) error {
+
+	return pbDecode(message, func(field pbField) error {
+		var err error
+		switch field.Number {
+		case 1:
+			e.Type = operatorEventTypes[field.Value]
+		case 2:
+			e.Subscribed = &operatorSubscribed{}
+			err = pbDecode(field.Bytes, func(field pbField) error {
+				switch field.Number {
+				case 1:
+					return pbDecode(field.Bytes, func(field pbField) error {
+						state := &e.Subscribed.GetState
+						switch field.Number {
+						case 1:
+							return decodeTasks(field.Bytes, &state.GetTasks)
+						case 3:
+							return decodeFrameworks(field.Bytes, &state.GetFrameworks)
+						case 4:
+							return decodeAgents(field.Bytes, &state.GetAgents)
+						}
+						return nil
+					})
+				case 2:
+					e.Subscribed.HeartbeatIntervalSeconds = field.double()
+				}
+				return nil
+			})
+		case 3:
+			e.TaskAdded = &operatorTaskAdded{}
+			err = pbDecode(field.Bytes, func(field pbField) error {
+				var err error
+				if field.Number == 1 {
+					e.TaskAdded.Task, err = decodeTask(field.Bytes)
+				}
+				return err
+			})
+		case 4:
+			e.TaskUpdated = &operatorTaskUpdated{}
+			err = pbDecode(field.Bytes, func(field pbField) error {
+				var err error
+				switch field.Number {
+				case 1:
+					err = decodeID(field.Bytes, &e.TaskUpdated.FrameworkID)
+				case 2:
+					e.TaskUpdated.Status, err = decodeTaskStatus(field.Bytes)
+				case 3:
+					e.TaskUpdated.State = operatorTaskStates[field.Value]
+				}
+				return err
+			})
+		case 5:
+			e.AgentAdded = &operatorAgentAdded{}
+			err = pbDecode(field.Bytes, func(field pbField) error {
+				var err error
+				if field.Number == 1 {
+					e.AgentAdded.Agent, err = decodeAgent(field.Bytes)
+				}
+				return err
+			})
+		case 6:
+			e.AgentRemoved = &operatorAgentRemoved{}
+			err = pbDecode(field.Bytes, func(field pbField) error {
+				if field.Number == 1 {
+					return decodeID(field.Bytes, &e.AgentRemoved.AgentID)
+				}
+				return nil
+			})
+		case 7, 8:
+			sent := &operatorFrameworkSent{}
+			err = pbDecode(field.Bytes, func(field pbField) error {
+				var err error
+				if field.Number == 1 {
+					sent.Framework, err = decodeFramework(field.Bytes)
+				}
+				return err
+			})
+			if field.Number == 7 {
+				e.FrameworkAdded = sent
+			} else {
+				e.FrameworkUpdated = sent
+			}
+		case 9:
+			e.FrameworkRemoved = &operatorFramework{}
+			err = pbDecode(field.Bytes, func(field pbField) error {
+				if field.Number == 1 {
+					return decodeFrameworkInfo(field.Bytes, e.FrameworkRemoved)
+				}
+				return nil
+			})
+		}
+		return err
+	})
+}
+
+// readRecord reads a RecordIO record: its length in decimal, a new line, and the record
+func readRecord(reader *bufio.Reader) ([]byte, error) {
+
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || length < 0 || length > maxEventLength {
+		return nil, fmt.Errorf("Invalid RecordIO record length %q", header)
+	}
+	record := make([]byte, length)
+	_, err = io.ReadFull(reader, record)
+	return record, err
+}
+
+// streamWatchdog cancels a stream when no event is received within the interval. It's disabled while the
+// interval is not positive
+type streamWatchdog struct {
+	clock    clock.Clock
+	interval time.Duration
+	timer    *clock.Timer
+	cancel   context.CancelFunc
+	fired    bool
+	mutex    sync.Mutex
+}
+
+func newStreamWatchdog(clk clock.Clock, interval time.Duration, cancel context.CancelFunc) *streamWatchdog {
+
+	watchdog := &streamWatchdog{clock: clk, interval: interval, cancel: cancel}
+	if interval > 0 {
+		watchdog.timer = clk.AfterFunc(interval, watchdog.fire)
+	}
+	return watchdog
+}
+
+func (w *streamWatchdog) fire() {
+
+	w.mutex.Lock()
+	w.fired = true
+	w.mutex.Unlock()
+	w.cancel()
+}
+
+func (w *streamWatchdog) setInterval(interval time.Duration) {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.interval = interval
+}
+
+func (w *streamWatchdog) reset() {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.interval <= 0 || w.fired {
+		return
+	}
+	if w.timer == nil {
+		w.timer = w.clock.AfterFunc(w.interval, w.fire)
+		return
+	}
+	w.timer.Stop()
+	w.timer.Reset(w.interval)
+}
+
+func (w *streamWatchdog) expired() bool {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.fired
+}
+
+func (w *streamWatchdog) stop() {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timer != nil && !w.fired {
+		w.timer.Stop()
+	}
+}
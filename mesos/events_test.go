@@ -0,0 +1,139 @@
+package mesos
+
+import (
+	"context"
+	"fmt"
+	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+
+	jsonEvents := []string{
+		`{"type": "SUBSCRIBED", "subscribed": {"get_state": {
+			"get_tasks": {"tasks": [{"name": "task1", "task_id": {"value": "task1.id"}, "framework_id": {"value": "frameworkId1"},
+				"agent_id": {"value": "mesosslave1"}, "state": "TASK_RUNNING"}]},
+			"get_frameworks": {"frameworks": [{"framework_info": {"id": {"value": "frameworkId1"}, "name": "frameworkName1"}, "active": true}]},
+			"get_agents": {"agents": [{"agent_info": {"id": {"value": "mesosslave1"}, "hostname": "mesosslave1hostname"},
+				"pid": "slave(1)@10.0.0.2:5051"}]}},
+			"heartbeat_interval_seconds": 15}}`,
+		`{"type": "HEARTBEAT"}`,
+		`{"type": "TASK_UPDATED", "task_updated": {"framework_id": {"value": "frameworkId1"}, "state": "TASK_FINISHED",
+			"status": {"task_id": {"value": "task1.id"}, "agent_id": {"value": "mesosslave1"}, "state": "TASK_FINISHED", "timestamp": 1.5}}}`,
+		`{"type": "AGENT_REMOVED", "agent_removed": {"agent_id": {"value": "mesosslave1"}}}`,
+		`{"type": "FRAMEWORK_REMOVED", "framework_removed": {"framework_info": {"id": {"value": "frameworkId1"}, "name": "frameworkName1"}}}`,
+	}
+
+	task := pbMessage{}.string(1, "task1").message(2, pbMessage{}.string(1, "task1.id")).
+		message(3, pbMessage{}.string(1, "frameworkId1")).message(5, pbMessage{}.string(1, "mesosslave1")).varint(6, 1)
+	framework := pbMessage{}.message(1, pbMessage{}.string(2, "frameworkName1").message(3, pbMessage{}.string(1, "frameworkId1")))
+	agent := pbMessage{}.message(1, pbMessage{}.string(1, "mesosslave1hostname").message(6, pbMessage{}.string(1, "mesosslave1"))).
+		string(4, "slave(1)@10.0.0.2:5051")
+	state := pbMessage{}.message(1, pbMessage{}.message(2, task)).message(3, pbMessage{}.message(1, framework.varint(2, 1))).
+		message(4, pbMessage{}.message(1, agent))
+	protobufEvents := []pbMessage{
+		pbMessage{}.varint(1, 1).message(2, pbMessage{}.message(1, state)),
+		pbMessage{}.varint(1, 9),
+		pbMessage{}.varint(1, 3).message(4, pbMessage{}.message(1, pbMessage{}.string(1, "frameworkId1")).
+			message(2, pbMessage{}.message(1, pbMessage{}.string(1, "task1.id")).varint(2, 2).
+				message(5, pbMessage{}.string(1, "mesosslave1"))).varint(3, 2)),
+		pbMessage{}.varint(1, 5).message(6, pbMessage{}.message(1, pbMessage{}.string(1, "mesosslave1"))),
+		pbMessage{}.varint(1, 8).message(9, pbMessage{}.message(1, framework[2:])),
+	}
+
+	for _, encoding := range []string{EncodingJSON, EncodingProtobuf} {
+		Convey("When subscribing to the event stream in "+encoding, t, func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for i := range jsonEvents {
+					record := []byte(jsonEvents[i])
+					if encoding == EncodingProtobuf {
+						record = protobufEvents[i]
+					}
+					fmt.Fprintf(w, "%d\n%s", len(record), record)
+				}
+			}))
+			defer server.Close()
+			client := &OperatorClient{Transport: &Client{MasterURL: server.URL}, Encoding: encoding}
+
+			events := []*Event{}
+			err := client.Subscribe(context.Background(), func(event *Event) error {
+				events = append(events, event)
+				return nil
+			})
+
+			Convey("the events should be received until the master closes the stream", func() {
+				So(err.Error(), ShouldContainSubstring, "closed by the master")
+				So(events, ShouldHaveLength, 5)
+				So(events[0].Type, ShouldEqual, "SUBSCRIBED")
+				So(events[0].Tasks.Tasks[0].ID, ShouldEqual, "task1.id")
+				So(events[0].Tasks.Tasks[0].State, ShouldEqual, "TASK_RUNNING")
+				So(events[0].Frameworks.Frameworks, ShouldResemble, []Framework{{ID: "frameworkId1", Name: "frameworkName1", Active: true}})
+				So(events[0].Agents.Slaves, ShouldResemble, []Slave{{ID: "mesosslave1", Pid: "slave(1)@10.0.0.2:5051", Hostname: "mesosslave1hostname"}})
+				So(events[1].Type, ShouldEqual, "HEARTBEAT")
+				So(events[2].Type, ShouldEqual, "TASK_UPDATED")
+				So(events[2].Task.ID, ShouldEqual, "task1.id")
+				So(events[2].Task.SlaveID, ShouldEqual, "mesosslave1")
+				So(events[2].Task.State, ShouldEqual, "TASK_FINISHED")
+				So(events[3].Type, ShouldEqual, "AGENT_REMOVED")
+				So(events[3].Agent.ID, ShouldEqual, "mesosslave1")
+				So(events[4].Type, ShouldEqual, "FRAMEWORK_REMOVED")
+				So(events[4].Framework.ID, ShouldEqual, "frameworkId1")
+			})
+		})
+	}
+
+	Convey("When the event stream stalls", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			subscribed := `{"type": "SUBSCRIBED", "subscribed": {"get_state": {}, "heartbeat_interval_seconds": 15}}`
+			fmt.Fprintf(w, "%d\n%s", len(subscribed), subscribed)
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}))
+		defer server.Close()
+		mockClock := clock.NewMock()
+		client := &OperatorClient{Transport: &Client{MasterURL: server.URL}, Clock: mockClock}
+
+		Convey("the subscription should fail after missing the heartbeats", func() {
+			subscribed := make(chan bool)
+			go func() {
+				<-subscribed
+				mockClock.Add(missedHeartbeats * 15 * time.Second)
+			}()
+			err := client.Subscribe(context.Background(), func(event *Event) error {
+				close(subscribed)
+				return nil
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "no event received in time")
+		})
+	})
+
+	Convey("When the event stream has no call timeout nor heartbeat interval", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, event := range []string{`{"type": "SUBSCRIBED", "subscribed": {"get_state": {}}}`, `{"type": "HEARTBEAT"}`} {
+				fmt.Fprintf(w, "%d\n%s", len(event), event)
+				w.(http.Flusher).Flush()
+				time.Sleep(10 * time.Millisecond)
+			}
+		}))
+		defer server.Close()
+		client := &OperatorClient{Transport: &Client{MasterURL: server.URL}}
+
+		Convey("the stream should not be cancelled by the watchdog", func() {
+			events := 0
+			err := client.Subscribe(context.Background(), func(event *Event) error {
+				events++
+				return nil
+			})
+			So(events, ShouldEqual, 2)
+			So(err.Error(), ShouldContainSubstring, "closed by the master")
+		})
+	})
+}
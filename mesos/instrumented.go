@@ -0,0 +1,106 @@
+package mesos
+
+import (
+	"context"
+	"github.com/alanbover/deathnode/metrics"
+	"time"
+)
+
+// InstrumentedClient decorates a ClientInterface, measuring the latency and errors of every call
+type InstrumentedClient struct {
+	client ClientInterface
+}
+
+// NewInstrumentedClient returns a new InstrumentedClient object
+func NewInstrumentedClient(client ClientInterface) *InstrumentedClient {
+	return &InstrumentedClient{client: client}
+}
+
+func observe(call string, start time.Time, err error) {
+
+	metrics.APICallDuration.Observe(time.Since(start).Seconds(), "mesos", call)
+	if err != nil {
+		metrics.APICallErrors.Inc("mesos", call)
+	}
+}
+
+// GetMesosTasks measures the call to the decorated client
+func (c *InstrumentedClient) GetMesosTasks(ctx context.Context) (*TasksResponse, error) {
+
+	start := time.Now()
+	response, err := c.client.GetMesosTasks(ctx)
+	observe("GetMesosTasks", start, err)
+	return response, err
+}
+
+// GetMesosFrameworks measures the call to the decorated client
+func (c *InstrumentedClient) GetMesosFrameworks(ctx context.Context) (*FrameworksResponse, error) {
+
+	start := time.Now()
+	response, err := c.client.GetMesosFrameworks(ctx)
+	observe("GetMesosFrameworks", start, err)
+	return response, err
+}
+
+// GetMesosAgents measures the call to the decorated client
+func (c *InstrumentedClient) GetMesosAgents(ctx context.Context) (*SlavesResponse, error) {
+
+	start := time.Now()
+	response, err := c.client.GetMesosAgents(ctx)
+	observe("GetMesosAgents", start, err)
+	return response, err
+}
+
+// GetMaintenanceSchedule measures the call to the decorated client
+func (c *InstrumentedClient) GetMaintenanceSchedule(ctx context.Context) (*MaintenanceSchedule, error) {
+
+	start := time.Now()
+	response, err := c.client.GetMaintenanceSchedule(ctx)
+	observe("GetMaintenanceSchedule", start, err)
+	return response, err
+}
+
+// GetMaintenanceStatus measures the call to the decorated client
+func (c *InstrumentedClient) GetMaintenanceStatus(ctx context.Context) (*MaintenanceStatus, error) {
+
+	start := time.Now()
+	response, err := c.client.GetMaintenanceStatus(ctx)
+	observe("GetMaintenanceStatus", start, err)
+	return response, err
+}
+
+// UpdateMaintenanceSchedule measures the call to the decorated client
+func (c *InstrumentedClient) UpdateMaintenanceSchedule(ctx context.Context, schedule *MaintenanceSchedule) error {
+
+	start := time.Now()
+	err := c.client.UpdateMaintenanceSchedule(ctx, schedule)
+	observe("UpdateMaintenanceSchedule", start, err)
+	return err
+}
+
+// StartMaintenance measures the call to the decorated client
+func (c *InstrumentedClient) StartMaintenance(ctx context.Context, machines []MaintenanceMachinesID) error {
+
+	start := time.Now()
+	err := c.client.StartMaintenance(ctx, machines)
+	observe("StartMaintenance", start, err)
+	return err
+}
+
+// StopMaintenance measures the call to the decorated client
+func (c *InstrumentedClient) StopMaintenance(ctx context.Context, machines []MaintenanceMachinesID) error {
+
+	start := time.Now()
+	err := c.client.StopMaintenance(ctx, machines)
+	observe("StopMaintenance", start, err)
+	return err
+}
+
+// DrainAgent measures the call to the decorated client
+func (c *InstrumentedClient) DrainAgent(ctx context.Context, agentID string, maxGracePeriod time.Duration, markGone bool) error {
+
+	start := time.Now()
+	err := c.client.DrainAgent(ctx, agentID, maxGracePeriod, markGone)
+	observe("DrainAgent", start, err)
+	return err
+}
@@ -0,0 +1,352 @@
+package mesos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/benbjohnson/clock"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Encodings of the v1 operator API
+const (
+	EncodingJSON     = "json"
+	EncodingProtobuf = "protobuf"
+)
+
+// operatorPath is the endpoint of the v1 operator API
+const operatorPath = "/api/v1"
+
+// Call types of the v1 operator API, with their protobuf values
+var operatorCallTypes = map[string]uint64{
+	"GET_AGENTS":                  10,
+	"GET_FRAMEWORKS":              11,
+	"GET_TASKS":                   13,
+	"SUBSCRIBE":                   18,
+	"GET_MAINTENANCE_STATUS":      23,
+	"GET_MAINTENANCE_SCHEDULE":    24,
+	"UPDATE_MAINTENANCE_SCHEDULE": 25,
+	"START_MAINTENANCE":           26,
+	"STOP_MAINTENANCE":            27,
+	"DRAIN_AGENT":                 37,
+}
+
+// OperatorClient implements ClientInterface on the v1 operator API of the Mesos master, instead of the legacy
+// endpoints. The calls are encoded in JSON or protobuf
+type OperatorClient struct {
+	// Transport sends the calls to the leading master, with its authentication, timeouts and retries
+	Transport *Client
+	// Encoding is EncodingJSON or EncodingProtobuf. JSON is used if empty
+	Encoding string
+	// Clock times the heartbeats of the event stream. The real clock is used if nil
+	Clock clock.Clock
+}
+
+// operatorCall is a call of the v1 operator API
+type operatorCall struct {
+	Type                      string            `json:"type"`
+	UpdateMaintenanceSchedule *operatorSchedule `json:"update_maintenance_schedule,omitempty"`
+	StartMaintenance          *operatorMachines `json:"start_maintenance,omitempty"`
+	StopMaintenance           *operatorMachines `json:"stop_maintenance,omitempty"`
+	DrainAgent                *operatorDrain    `json:"drain_agent,omitempty"`
+}
+
+// operatorResponse is the response of the v1 operator API, with the calls deathnode performs
+type operatorResponse struct {
+	GetAgents              *operatorAgents            `json:"get_agents"`
+	GetFrameworks          *operatorFrameworks        `json:"get_frameworks"`
+	GetTasks               *operatorTasks             `json:"get_tasks"`
+	GetMaintenanceStatus   *operatorMaintenanceStatus `json:"get_maintenance_status"`
+	GetMaintenanceSchedule *operatorSchedule          `json:"get_maintenance_schedule"`
+}
+
+type operatorID struct {
+	Value string `json:"value"`
+}
+
+type operatorAgents struct {
+	Agents []operatorAgent `json:"agents"`
+}
+
+type operatorAgent struct {
+	AgentInfo struct {
+		ID       operatorID `json:"id"`
+		Hostname string     `json:"hostname"`
+	} `json:"agent_info"`
+	Pid       string     `json:"pid"`
+	DrainInfo *DrainInfo `json:"drain_info"`
+}
+
+type operatorFrameworks struct {
+	Frameworks []operatorFramework `json:"frameworks"`
+}
+
+type operatorFramework struct {
+	FrameworkInfo struct {
+		ID   operatorID `json:"id"`
+		Name string     `json:"name"`
+	} `json:"framework_info"`
+	Active bool `json:"active"`
+}
+
+type operatorTasks struct {
+	Tasks []operatorTask `json:"tasks"`
+}
+
+type operatorTask struct {
+	TaskID      operatorID `json:"task_id"`
+	Name        string     `json:"name"`
+	FrameworkID operatorID `json:"framework_id"`
+	AgentID     operatorID `json:"agent_id"`
+	State       string     `json:"state"`
+	Statuses    []Status   `json:"statuses"`
+	Labels      struct {
+		Labels []Labels `json:"labels"`
+	} `json:"labels"`
+}
+
+type operatorMaintenanceStatus struct {
+	Status *MaintenanceStatus `json:"status"`
+}
+
+type operatorSchedule struct {
+	Schedule *MaintenanceSchedule `json:"schedule"`
+}
+
+type operatorMachines struct {
+	Machines []MaintenanceMachinesID `json:"machines"`
+}
+
+type operatorDrain struct {
+	AgentID        operatorID           `json:"agent_id"`
+	MaxGracePeriod *MaintenanceDuration `json:"max_grace_period,omitempty"`
+	MarkGone       bool                 `json:"mark_gone"`
+}
+
+func (t operatorTasks) tasks() *TasksResponse {
+
+	tasks := &TasksResponse{Tasks: []Task{}}
+	for _, task := range t.Tasks {
+		tasks.Tasks = append(tasks.Tasks, task.task())
+	}
+	return tasks
+}
+
+func (t operatorTask) task() Task {
+
+	return Task{
+		ID:          t.TaskID.Value,
+		Name:        t.Name,
+		State:       t.State,
+		SlaveID:     t.AgentID.Value,
+		FrameworkID: t.FrameworkID.Value,
+		Statuses:    t.Statuses,
+		Labels:      t.Labels.Labels,
+	}
+}
+
+func (f operatorFrameworks) frameworks() *FrameworksResponse {
+
+	frameworks := &FrameworksResponse{Frameworks: []Framework{}}
+	for _, framework := range f.Frameworks {
+		frameworks.Frameworks = append(frameworks.Frameworks, framework.framework())
+	}
+	return frameworks
+}
+
+func (f operatorFramework) framework() Framework {
+
+	return Framework{
+		ID:     f.FrameworkInfo.ID.Value,
+		Name:   f.FrameworkInfo.Name,
+		Active: f.Active,
+	}
+}
+
+func (a operatorAgents) slaves() *SlavesResponse {
+
+	agents := &SlavesResponse{Slaves: []Slave{}}
+	for _, agent := range a.Agents {
+		agents.Slaves = append(agents.Slaves, agent.slave())
+	}
+	return agents
+}
+
+func (a operatorAgent) slave() Slave {
+
+	return Slave{
+		ID:        a.AgentInfo.ID.Value,
+		Pid:       a.Pid,
+		Hostname:  a.AgentInfo.Hostname,
+		DrainInfo: a.DrainInfo,
+	}
+}
+
+// GetMesosTasks returns the tasks known by the master
+func (c *OperatorClient) GetMesosTasks(ctx context.Context) (*TasksResponse, error) {
+
+	response, err := c.get(ctx, "GET_TASKS")
+	if err != nil {
+		return nil, err
+	}
+	if response.GetTasks == nil {
+		return nil, fmt.Errorf("Mesos GET_TASKS returned no tasks")
+	}
+
+	return response.GetTasks.tasks(), nil
+}
+
+// GetMesosFrameworks returns the frameworks registered in the master
+func (c *OperatorClient) GetMesosFrameworks(ctx context.Context) (*FrameworksResponse, error) {
+
+	response, err := c.get(ctx, "GET_FRAMEWORKS")
+	if err != nil {
+		return nil, err
+	}
+	if response.GetFrameworks == nil {
+		return nil, fmt.Errorf("Mesos GET_FRAMEWORKS returned no frameworks")
+	}
+
+	return response.GetFrameworks.frameworks(), nil
+}
+
+// GetMesosAgents returns the agents registered in the master
+func (c *OperatorClient) GetMesosAgents(ctx context.Context) (*SlavesResponse, error) {
+
+	response, err := c.get(ctx, "GET_AGENTS")
+	if err != nil {
+		return nil, err
+	}
+	if response.GetAgents == nil {
+		return nil, fmt.Errorf("Mesos GET_AGENTS returned no agents")
+	}
+
+	return response.GetAgents.slaves(), nil
+}
+
+// GetMaintenanceSchedule returns the maintenance schedule of the Mesos cluster
+func (c *OperatorClient) GetMaintenanceSchedule(ctx context.Context) (*MaintenanceSchedule, error) {
+
+	response, err := c.get(ctx, "GET_MAINTENANCE_SCHEDULE")
+	if err != nil {
+		return nil, err
+	}
+	if response.GetMaintenanceSchedule == nil || response.GetMaintenanceSchedule.Schedule == nil {
+		return &MaintenanceSchedule{}, nil
+	}
+	return response.GetMaintenanceSchedule.Schedule, nil
+}
+
+// GetMaintenanceStatus returns the machines draining, with the inverse offer responses of the frameworks, and the
+// machines down
+func (c *OperatorClient) GetMaintenanceStatus(ctx context.Context) (*MaintenanceStatus, error) {
+
+	response, err := c.get(ctx, "GET_MAINTENANCE_STATUS")
+	if err != nil {
+		return nil, err
+	}
+	if response.GetMaintenanceStatus == nil || response.GetMaintenanceStatus.Status == nil {
+		return &MaintenanceStatus{}, nil
+	}
+	return response.GetMaintenanceStatus.Status, nil
+}
+
+// UpdateMaintenanceSchedule replaces the maintenance schedule of the Mesos cluster
+func (c *OperatorClient) UpdateMaintenanceSchedule(ctx context.Context, schedule *MaintenanceSchedule) error {
+
+	return c.send(ctx, &operatorCall{
+		Type:                      "UPDATE_MAINTENANCE_SCHEDULE",
+		UpdateMaintenanceSchedule: &operatorSchedule{Schedule: schedule},
+	}, true, nil)
+}
+
+// StartMaintenance moves scheduled machines to down mode, killing their tasks. The machines already down, per
+// GET_MAINTENANCE_STATUS, are skipped
+func (c *OperatorClient) StartMaintenance(ctx context.Context, machines []MaintenanceMachinesID) error {
+
+	status, err := c.GetMaintenanceStatus(ctx)
+	if err != nil {
+		return err
+	}
+	down := map[string]bool{}
+	for _, machine := range status.DownMachines {
+		down[machine.Hostname] = true
+	}
+
+	pending := []MaintenanceMachinesID{}
+	for _, machine := range machines {
+		if !down[machine.Hostname] {
+			pending = append(pending, machine)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	return c.send(ctx, &operatorCall{
+		Type:             "START_MAINTENANCE",
+		StartMaintenance: &operatorMachines{Machines: pending},
+	}, false, nil)
+}
+
+// StopMaintenance brings machines in down mode back up, removing them from the maintenance schedule
+func (c *OperatorClient) StopMaintenance(ctx context.Context, machines []MaintenanceMachinesID) error {
+
+	return c.send(ctx, &operatorCall{
+		Type:            "STOP_MAINTENANCE",
+		StopMaintenance: &operatorMachines{Machines: machines},
+	}, false, nil)
+}
+
+// DrainAgent kills the tasks of the agent, giving them maxGracePeriod at most if not zero, and deactivates it.
+// With markGone the agent is marked gone once drained
+func (c *OperatorClient) DrainAgent(ctx context.Context, agentID string, maxGracePeriod time.Duration,
+	markGone bool) error {
+
+	drain := &operatorDrain{AgentID: operatorID{Value: agentID}, MarkGone: markGone}
+	if maxGracePeriod > 0 {
+		drain.MaxGracePeriod = &MaintenanceDuration{Nanoseconds: maxGracePeriod.Nanoseconds()}
+	}
+	// Draining an agent already draining with the same options has no side effects, so it's retried
+	return c.send(ctx, &operatorCall{Type: "DRAIN_AGENT", DrainAgent: drain}, true, nil)
+}
+
+func (c *OperatorClient) get(ctx context.Context, callType string) (*operatorResponse, error) {
+
+	response := &operatorResponse{}
+	if err := c.send(ctx, &operatorCall{Type: callType}, true, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// send performs the call, decoding its response in response if not nil
+func (c *OperatorClient) send(ctx context.Context, call *operatorCall, idempotent bool,
+	response *operatorResponse) error {
+
+	var payload []byte
+	var contentType string
+	var decode decoder
+	switch c.Encoding {
+	case EncodingProtobuf:
+		payload, contentType = call.protobuf(), "application/x-protobuf"
+		decode = func(body io.Reader) error {
+			message, err := ioutil.ReadAll(body)
+			if err != nil {
+				return err
+			}
+			return response.decodeProtobuf(message)
+		}
+	case EncodingJSON, "":
+		payload, _ = json.Marshal(call)
+		contentType, decode = "application/json", jsonDecoder(response)
+	default:
+		return fmt.Errorf("Unsupported Mesos operator API encoding %s", c.Encoding)
+	}
+
+	if response == nil {
+		decode = nil
+	}
+	return c.Transport.call(ctx, operatorPath, payload, contentType, idempotent, decode)
+}
@@ -0,0 +1,406 @@
+package mesos
+
+// Protobuf encoding of the v1 operator API messages deathnode uses, with the field numbers of mesos/v1/master.proto,
+// mesos/v1/mesos.proto and mesos/v1/maintenance.proto
+
+// Drain states of mesos/v1/mesos.proto, by protobuf value
+var operatorDrainStates = map[uint64]string{
+	1: DrainStateDraining,
+	2: DrainStateDrained,
+}
+
+// Inverse offer responses of mesos/v1/maintenance.proto, by protobuf value
+var operatorInverseOfferStatuses = map[uint64]string{
+	1: InverseOfferUnknown,
+	2: InverseOfferAccept,
+	3: InverseOfferDecline,
+}
+
+// Task states of mesos/v1/mesos.proto, by protobuf value
+var operatorTaskStates = map[uint64]string{
+	0:  "TASK_STARTING",
+	1:  "TASK_RUNNING",
+	2:  "TASK_FINISHED",
+	3:  "TASK_FAILED",
+	4:  "TASK_KILLED",
+	5:  "TASK_LOST",
+	6:  "TASK_STAGING",
+	7:  "TASK_ERROR",
+	8:  "TASK_KILLING",
+	9:  "TASK_DROPPED",
+	10: "TASK_UNREACHABLE",
+	11: "TASK_GONE",
+	12: "TASK_GONE_BY_OPERATOR",
+	13: "TASK_UNKNOWN",
+}
+
+func (c *operatorCall) protobuf() []byte {
+
+	call := pbMessage{}.varint(1, operatorCallTypes[c.Type])
+	if c.UpdateMaintenanceSchedule != nil {
+		call = call.message(11, pbMessage{}.message(1, encodeSchedule(c.UpdateMaintenanceSchedule.Schedule)))
+	}
+	if c.StartMaintenance != nil {
+		call = call.message(12, encodeMachines(c.StartMaintenance.Machines))
+	}
+	if c.StopMaintenance != nil {
+		call = call.message(13, encodeMachines(c.StopMaintenance.Machines))
+	}
+	if c.DrainAgent != nil {
+		drain := pbMessage{}.message(1, pbMessage{}.string(1, c.DrainAgent.AgentID.Value))
+		if c.DrainAgent.MaxGracePeriod != nil {
+			drain = drain.message(2, pbMessage{}.varint(1, uint64(c.DrainAgent.MaxGracePeriod.Nanoseconds)))
+		}
+		if c.DrainAgent.MarkGone {
+			drain = drain.varint(3, 1)
+		}
+		call = call.message(21, drain)
+	}
+	return call
+}
+
+func encodeSchedule(schedule *MaintenanceSchedule) pbMessage {
+
+	message := pbMessage{}
+	for _, window := range schedule.Windows {
+		encoded := pbMessage{}
+		for _, machine := range window.MachinesIds {
+			encoded = encoded.message(1, encodeMachineID(machine))
+		}
+		unavailability := pbMessage{}.message(1, pbMessage{}.varint(1, uint64(window.Unavailability.Start.Nanoseconds)))
+		if window.Unavailability.Duration != nil {
+			unavailability = unavailability.message(2,
+				pbMessage{}.varint(1, uint64(window.Unavailability.Duration.Nanoseconds)))
+		}
+		message = message.message(1, encoded.message(2, unavailability))
+	}
+	return message
+}
+
+func encodeMachines(machines []MaintenanceMachinesID) pbMessage {
+
+	message := pbMessage{}
+	for _, machine := range machines {
+		message = message.message(1, encodeMachineID(machine))
+	}
+	return message
+}
+
+func encodeMachineID(machine MaintenanceMachinesID) pbMessage {
+
+	message := pbMessage{}
+	if machine.Hostname != "" {
+		message = message.string(1, machine.Hostname)
+	}
+	if machine.IP != "" {
+		message = message.string(2, machine.IP)
+	}
+	return message
+}
+
+func (r *operatorResponse) decodeProtobuf(message []byte) error {
+
+	return pbDecode(message, func(field pbField) error {
+		switch field.Number {
+		case 10:
+			r.GetAgents = &operatorAgents{Agents: []operatorAgent{}}
+			return decodeAgents(field.Bytes, r.GetAgents)
+		case 11:
+			r.GetFrameworks = &operatorFrameworks{Frameworks: []operatorFramework{}}
+			return decodeFrameworks(field.Bytes, r.GetFrameworks)
+		case 13:
+			r.GetTasks = &operatorTasks{Tasks: []operatorTask{}}
+			return decodeTasks(field.Bytes, r.GetTasks)
+		case 17:
+			r.GetMaintenanceStatus = &operatorMaintenanceStatus{Status: &MaintenanceStatus{}}
+			return decodeMaintenanceStatus(field.Bytes, r.GetMaintenanceStatus.Status)
+		case 18:
+			r.GetMaintenanceSchedule = &operatorSchedule{Schedule: &MaintenanceSchedule{}}
+			return pbDecode(field.Bytes, func(field pbField) error {
+				if field.Number == 1 {
+					return decodeSchedule(field.Bytes, r.GetMaintenanceSchedule.Schedule)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+func decodeAgents(message []byte, agents *operatorAgents) error {
+
+	return pbDecode(message, func(field pbField) error {
+		if field.Number != 1 {
+			return nil
+		}
+		agent, err := decodeAgent(field.Bytes)
+		agents.Agents = append(agents.Agents, agent)
+		return err
+	})
+}
+
+func decodeAgent(message []byte) (operatorAgent, error) {
+
+	var agent operatorAgent
+	err := pbDecode(message, func(field pbField) error {
+		switch field.Number {
+		case 1:
+			return pbDecode(field.Bytes, func(field pbField) error {
+				switch field.Number {
+				case 1:
+					agent.AgentInfo.Hostname = field.string()
+				case 6:
+					return decodeID(field.Bytes, &agent.AgentInfo.ID)
+				}
+				return nil
+			})
+		case 4:
+			agent.Pid = field.string()
+		case 13:
+			agent.DrainInfo = &DrainInfo{}
+			return pbDecode(field.Bytes, func(field pbField) error {
+				if field.Number == 1 {
+					agent.DrainInfo.State = operatorDrainStates[field.Value]
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return agent, err
+}
+
+func decodeFrameworks(message []byte, frameworks *operatorFrameworks) error {
+
+	return pbDecode(message, func(field pbField) error {
+		if field.Number != 1 {
+			return nil
+		}
+		framework, err := decodeFramework(field.Bytes)
+		frameworks.Frameworks = append(frameworks.Frameworks, framework)
+		return err
+	})
+}
+
+func decodeFramework(message []byte) (operatorFramework, error) {
+
+	var framework operatorFramework
+	err := pbDecode(message, func(field pbField) error {
+		switch field.Number {
+		case 1:
+			return decodeFrameworkInfo(field.Bytes, &framework)
+		case 2:
+			framework.Active = field.Value != 0
+		}
+		return nil
+	})
+	return framework, err
+}
+
+func decodeFrameworkInfo(message []byte, framework *operatorFramework) error {
+
+	return pbDecode(message, func(field pbField) error {
+		switch field.Number {
+		case 2:
+			framework.FrameworkInfo.Name = field.string()
+		case 3:
+			return decodeID(field.Bytes, &framework.FrameworkInfo.ID)
+		}
+		return nil
+	})
+}
+
+// decodeTasks decodes the active tasks of GetTasks, skipping the pending, completed and orphan ones
+func decodeTasks(message []byte, tasks *operatorTasks) error {
+
+	return pbDecode(message, func(field pbField) error {
+		if field.Number != 2 {
+			return nil
+		}
+		task, err := decodeTask(field.Bytes)
+		tasks.Tasks = append(tasks.Tasks, task)
+		return err
+	})
+}
+
+func decodeTask(message []byte) (operatorTask, error) {
+
+	task := operatorTask{Statuses: []Status{}}
+	task.Labels.Labels = []Labels{}
+	err := pbDecode(message, func(field pbField) error {
+		switch field.Number {
+		case 1:
+			task.Name = field.string()
+		case 2:
+			return decodeID(field.Bytes, &task.TaskID)
+		case 3:
+			return decodeID(field.Bytes, &task.FrameworkID)
+		case 5:
+			return decodeID(field.Bytes, &task.AgentID)
+		case 6:
+			task.State = operatorTaskStates[field.Value]
+		case 8:
+			status, err := decodeTaskStatus(field.Bytes)
+			task.Statuses = append(task.Statuses, status.Status)
+			return err
+		case 11:
+			return pbDecode(field.Bytes, func(field pbField) error {
+				if field.Number != 1 {
+					return nil
+				}
+				var label Labels
+				err := pbDecode(field.Bytes, func(field pbField) error {
+					switch field.Number {
+					case 1:
+						label.Key = field.string()
+					case 2:
+						label.Value = field.string()
+					}
+					return nil
+				})
+				task.Labels.Labels = append(task.Labels.Labels, label)
+				return err
+			})
+		}
+		return nil
+	})
+	return task, err
+}
+
+func decodeTaskStatus(message []byte) (operatorTaskStatus, error) {
+
+	var status operatorTaskStatus
+	err := pbDecode(message, func(field pbField) error {
+		switch field.Number {
+		case 1:
+			return decodeID(field.Bytes, &status.TaskID)
+		case 2:
+			status.State = operatorTaskStates[field.Value]
+		case 5:
+			return decodeID(field.Bytes, &status.AgentID)
+		case 6:
+			status.Timestamp = field.double()
+		}
+		return nil
+	})
+	return status, err
+}
+
+func decodeMaintenanceStatus(message []byte, status *MaintenanceStatus) error {
+
+	return pbDecode(message, func(field pbField) error {
+		if field.Number != 1 {
+			return nil
+		}
+		return pbDecode(field.Bytes, func(field pbField) error {
+			switch field.Number {
+			case 1:
+				machine, err := decodeDrainingMachine(field.Bytes)
+				status.DrainingMachines = append(status.DrainingMachines, machine)
+				return err
+			case 2:
+				var machine MaintenanceMachinesID
+				err := decodeMachineID(field.Bytes, &machine)
+				status.DownMachines = append(status.DownMachines, machine)
+				return err
+			}
+			return nil
+		})
+	})
+}
+
+func decodeDrainingMachine(message []byte) (DrainingMachine, error) {
+
+	machine := DrainingMachine{Statuses: []InverseOfferStatus{}}
+	err := pbDecode(message, func(field pbField) error {
+		switch field.Number {
+		case 1:
+			return decodeMachineID(field.Bytes, &machine.ID)
+		case 2:
+			var status InverseOfferStatus
+			err := pbDecode(field.Bytes, func(field pbField) error {
+				switch field.Number {
+				case 1:
+					status.Status = operatorInverseOfferStatuses[field.Value]
+				case 2:
+					id := operatorID{}
+					err := decodeID(field.Bytes, &id)
+					status.FrameworkID.Value = id.Value
+					return err
+				}
+				return nil
+			})
+			machine.Statuses = append(machine.Statuses, status)
+			return err
+		}
+		return nil
+	})
+	return machine, err
+}
+
+func decodeSchedule(message []byte, schedule *MaintenanceSchedule) error {
+
+	return pbDecode(message, func(field pbField) error {
+		if field.Number != 1 {
+			return nil
+		}
+		window := MaintenanceWindow{MachinesIds: []MaintenanceMachinesID{}}
+		err := pbDecode(field.Bytes, func(field pbField) error {
+			switch field.Number {
+			case 1:
+				var machine MaintenanceMachinesID
+				err := decodeMachineID(field.Bytes, &machine)
+				window.MachinesIds = append(window.MachinesIds, machine)
+				return err
+			case 2:
+				return pbDecode(field.Bytes, func(field pbField) error {
+					switch field.Number {
+					case 1:
+						return decodeNanoseconds(field.Bytes, &window.Unavailability.Start.Nanoseconds)
+					case 2:
+						window.Unavailability.Duration = &MaintenanceDuration{}
+						return decodeNanoseconds(field.Bytes, &window.Unavailability.Duration.Nanoseconds)
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		schedule.Windows = append(schedule.Windows, window)
+		return err
+	})
+}
+
+func decodeMachineID(message []byte, machine *MaintenanceMachinesID) error {
+
+	return pbDecode(message, func(field pbField) error {
+		switch field.Number {
+		case 1:
+			machine.Hostname = field.string()
+		case 2:
+			machine.IP = field.string()
+		}
+		return nil
+	})
+}
+
+func decodeID(message []byte, id *operatorID) error {
+
+	return pbDecode(message, func(field pbField) error {
+		if field.Number == 1 {
+			id.Value = field.string()
+		}
+		return nil
+	})
+}
+
+// decodeNanoseconds decodes the nanoseconds of a TimeInfo or a DurationInfo
+func decodeNanoseconds(message []byte, nanoseconds *int64) error {
+
+	return pbDecode(message, func(field pbField) error {
+		if field.Number == 1 {
+			*nanoseconds = int64(field.Value)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,274 @@
+package mesos
+
+import (
+	"context"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOperatorClient(t *testing.T) {
+
+	for _, encoding := range []string{EncodingJSON, EncodingProtobuf} {
+		Convey("When reading the cluster with the operator API in "+encoding, t, func() {
+			master := newFakeOperatorMaster(encoding)
+			defer master.Close()
+			client := &OperatorClient{Transport: &Client{MasterURL: master.URL}, Encoding: encoding}
+
+			Convey("the active tasks should be returned", func() {
+				tasks, err := client.GetMesosTasks(context.Background())
+				So(err, ShouldBeNil)
+				So(tasks.Tasks, ShouldHaveLength, 3)
+				So(tasks.Tasks[0], ShouldResemble, Task{
+					ID:          "task1.id",
+					Name:        "task1",
+					State:       "TASK_RUNNING",
+					SlaveID:     "mesosslave1",
+					FrameworkID: "frameworkId1",
+					Statuses:    []Status{{State: "TASK_RUNNING", Timestamp: 123456.786543}},
+					Labels:      []Labels{{Key: "DEATHNODE_PROTECTED", Value: "true"}},
+				})
+			})
+			Convey("the frameworks should be returned", func() {
+				frameworks, err := client.GetMesosFrameworks(context.Background())
+				So(err, ShouldBeNil)
+				So(frameworks.Frameworks, ShouldHaveLength, 3)
+				So(frameworks.Frameworks[2], ShouldResemble, Framework{ID: "frameworkId3", Name: "frameworkName2", Active: true})
+			})
+			Convey("the agents should be returned", func() {
+				agents, err := client.GetMesosAgents(context.Background())
+				So(err, ShouldBeNil)
+				So(agents.Slaves, ShouldHaveLength, 3)
+				So(agents.Slaves[0], ShouldResemble, Slave{
+					ID: "mesosslave1", Pid: "slave(1)@10.0.0.2:5051", Hostname: "mesosslave1hostname"})
+				So(agents.Slaves[1].DrainInfo, ShouldResemble, &DrainInfo{State: DrainStateDraining})
+			})
+			Convey("the maintenance schedule should be returned", func() {
+				schedule, err := client.GetMaintenanceSchedule(context.Background())
+				So(err, ShouldBeNil)
+				So(schedule.Windows, ShouldHaveLength, 2)
+				So(schedule.Windows[0].MachinesIds, ShouldResemble, []MaintenanceMachinesID{
+					{Hostname: "operatorhostname", IP: "10.0.1.1"}})
+				So(schedule.Windows[0].Unavailability.Start.Nanoseconds, ShouldEqual, 1600000000000000000)
+			})
+			Convey("the maintenance status should be returned with the inverse offer responses", func() {
+				status, err := client.GetMaintenanceStatus(context.Background())
+				So(err, ShouldBeNil)
+				So(status.DownMachines, ShouldResemble, []MaintenanceMachinesID{{Hostname: "mesosslave1hostname", IP: "10.0.0.2"}})
+				So(status.DrainingMachines, ShouldHaveLength, 1)
+				So(status.DrainingMachines[0].ID, ShouldResemble, MaintenanceMachinesID{Hostname: "operatorhostname", IP: "10.0.1.1"})
+				So(status.DrainingMachines[0].Statuses, ShouldResemble, []InverseOfferStatus{
+					{Status: InverseOfferAccept, FrameworkID: FrameworkID{Value: "frameworkId1"}},
+					{Status: InverseOfferDecline, FrameworkID: FrameworkID{Value: "frameworkId2"}},
+				})
+			})
+			Convey("the maintenance schedule should be updated with the same encoding", func() {
+				schedule, _ := client.GetMaintenanceSchedule(context.Background())
+				err := client.UpdateMaintenanceSchedule(context.Background(), schedule)
+				So(err, ShouldBeNil)
+				So(master.calls(), ShouldResemble, []string{"GET_MAINTENANCE_SCHEDULE", "UPDATE_MAINTENANCE_SCHEDULE"})
+				So(master.updated, ShouldResemble, schedule)
+			})
+			Convey("only the machines not down yet should be moved down", func() {
+				err := client.StartMaintenance(context.Background(), []MaintenanceMachinesID{
+					{Hostname: "mesosslave1hostname", IP: "10.0.0.2"}, {Hostname: "mesosslave2hostname", IP: "10.0.0.3"}})
+				So(err, ShouldBeNil)
+				So(master.calls(), ShouldResemble, []string{"GET_MAINTENANCE_STATUS", "START_MAINTENANCE"})
+				So(master.machines, ShouldResemble, []MaintenanceMachinesID{{Hostname: "mesosslave2hostname", IP: "10.0.0.3"}})
+			})
+			Convey("no call should be done if every machine is already down", func() {
+				err := client.StartMaintenance(context.Background(), []MaintenanceMachinesID{
+					{Hostname: "mesosslave1hostname", IP: "10.0.0.2"}})
+				So(err, ShouldBeNil)
+				So(master.calls(), ShouldResemble, []string{"GET_MAINTENANCE_STATUS"})
+			})
+			Convey("the machines should be brought up", func() {
+				err := client.StopMaintenance(context.Background(), []MaintenanceMachinesID{
+					{Hostname: "mesosslave1hostname", IP: "10.0.0.2"}})
+				So(err, ShouldBeNil)
+				So(master.calls(), ShouldResemble, []string{"STOP_MAINTENANCE"})
+				So(master.machines, ShouldResemble, []MaintenanceMachinesID{{Hostname: "mesosslave1hostname", IP: "10.0.0.2"}})
+			})
+			Convey("the agents should be drained with the grace period and mark gone", func() {
+				err := client.DrainAgent(context.Background(), "mesosslave1", 5*time.Minute, true)
+				So(err, ShouldBeNil)
+				So(master.calls(), ShouldResemble, []string{"DRAIN_AGENT"})
+				So(master.drain, ShouldResemble, &operatorDrain{
+					AgentID:        operatorID{Value: "mesosslave1"},
+					MaxGracePeriod: &MaintenanceDuration{Nanoseconds: 300000000000},
+					MarkGone:       true,
+				})
+			})
+		})
+	}
+}
+
+// fakeOperatorMaster replays the operator API responses in testdata/v1, and records the calls received
+type fakeOperatorMaster struct {
+	*httptest.Server
+	received []string
+	updated  *MaintenanceSchedule
+	machines []MaintenanceMachinesID
+	drain    *operatorDrain
+	mutex    sync.Mutex
+}
+
+func newFakeOperatorMaster(encoding string) *fakeOperatorMaster {
+
+	master := &fakeOperatorMaster{}
+	master.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		call := &operatorCall{}
+		if encoding == EncodingProtobuf {
+			if r.Header.Get("Content-Type") != "application/x-protobuf" {
+				http.Error(w, "unexpected content type", http.StatusUnsupportedMediaType)
+				return
+			}
+			call = decodeOperatorCall(body)
+		} else if err := json.Unmarshal(body, call); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		master.mutex.Lock()
+		defer master.mutex.Unlock()
+		master.received = append(master.received, call.Type)
+		switch {
+		case call.UpdateMaintenanceSchedule != nil:
+			master.updated = call.UpdateMaintenanceSchedule.Schedule
+		case call.StartMaintenance != nil:
+			master.machines = call.StartMaintenance.Machines
+		case call.StopMaintenance != nil:
+			master.machines = call.StopMaintenance.Machines
+		case call.DrainAgent != nil:
+			master.drain = call.DrainAgent
+		}
+		if call.Type[:4] != "GET_" {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		extension := ".json"
+		if encoding == EncodingProtobuf {
+			extension = ".pb"
+		}
+		response, err := ioutil.ReadFile(filepath.Join(getCurrentPath(), "testdata/v1", call.Type+extension))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write(response)
+	}))
+	return master
+}
+
+func (m *fakeOperatorMaster) calls() []string {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.received
+}
+
+// decodeOperatorCall decodes the protobuf calls deathnode sends
+func decodeOperatorCall(message []byte) *operatorCall {
+
+	call := &operatorCall{}
+	pbDecode(message, func(field pbField) error {
+		switch field.Number {
+		case 1:
+			for callType, value := range operatorCallTypes {
+				if value == field.Value {
+					call.Type = callType
+				}
+			}
+		case 11:
+			call.UpdateMaintenanceSchedule = &operatorSchedule{Schedule: &MaintenanceSchedule{}}
+			pbDecode(field.Bytes, func(field pbField) error {
+				return decodeSchedule(field.Bytes, call.UpdateMaintenanceSchedule.Schedule)
+			})
+		case 12, 13:
+			machines := &operatorMachines{}
+			pbDecode(field.Bytes, func(field pbField) error {
+				var machine MaintenanceMachinesID
+				decodeMachineID(field.Bytes, &machine)
+				machines.Machines = append(machines.Machines, machine)
+				return nil
+			})
+			if field.Number == 12 {
+				call.StartMaintenance = machines
+			} else {
+				call.StopMaintenance = machines
+			}
+		case 21:
+			call.DrainAgent = &operatorDrain{}
+			pbDecode(field.Bytes, func(field pbField) error {
+				switch field.Number {
+				case 1:
+					decodeID(field.Bytes, &call.DrainAgent.AgentID)
+				case 2:
+					call.DrainAgent.MaxGracePeriod = &MaintenanceDuration{}
+					decodeNanoseconds(field.Bytes, &call.DrainAgent.MaxGracePeriod.Nanoseconds)
+				case 3:
+					call.DrainAgent.MarkGone = field.Value != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return call
+}
+
+func TestOperatorCallProtobuf(t *testing.T) {
+
+	Convey("When updating the maintenance schedule in protobuf", t, func() {
+		response, _ := ioutil.ReadFile(filepath.Join(getCurrentPath(), "testdata/v1/GET_MAINTENANCE_SCHEDULE.pb"))
+		var recorded []byte
+		pbDecode(response, func(field pbField) error {
+			if field.Number == 18 {
+				recorded = field.Bytes
+			}
+			return nil
+		})
+		schedule := &operatorResponse{}
+		schedule.decodeProtobuf(response)
+
+		call := &operatorCall{
+			Type:                      "UPDATE_MAINTENANCE_SCHEDULE",
+			UpdateMaintenanceSchedule: schedule.GetMaintenanceSchedule,
+		}
+
+		Convey("the schedule should be encoded as the master does", func() {
+			So(call.protobuf(), ShouldResemble, []byte(pbMessage{}.varint(1, 25).message(11, recorded)))
+		})
+	})
+}
+
+func TestOperatorCallTypes(t *testing.T) {
+
+	Convey("When encoding the operator calls in protobuf", t, func() {
+		Convey("their type should have the value of the Call.Type enum of mesos/v1/master/master.proto", func() {
+			for callType, value := range map[string]byte{
+				"GET_AGENTS":                  10,
+				"GET_FRAMEWORKS":              11,
+				"GET_TASKS":                   13,
+				"SUBSCRIBE":                   18,
+				"GET_MAINTENANCE_STATUS":      23,
+				"GET_MAINTENANCE_SCHEDULE":    24,
+				"UPDATE_MAINTENANCE_SCHEDULE": 25,
+				"START_MAINTENANCE":           26,
+				"STOP_MAINTENANCE":            27,
+				"DRAIN_AGENT":                 37,
+			} {
+				// The type is the varint field 1 of the Call
+				So((&operatorCall{Type: callType}).protobuf(), ShouldResemble, []byte{0x08, value})
+			}
+		})
+	})
+}
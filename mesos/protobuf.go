@@ -0,0 +1,101 @@
+package mesos
+
+// Minimal protobuf wire format, enough to encode the operator API calls and decode the fields of the responses
+// deathnode uses. Unknown fields are skipped
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Wire types of the protobuf encoding
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+// pbMessage is a protobuf message being encoded
+type pbMessage []byte
+
+func (m pbMessage) varint(field int, value uint64) pbMessage {
+	m = binary.AppendUvarint(m, uint64(field<<3|pbVarint))
+	return binary.AppendUvarint(m, value)
+}
+
+func (m pbMessage) bytes(field int, value []byte) pbMessage {
+	m = binary.AppendUvarint(m, uint64(field<<3|pbBytes))
+	m = binary.AppendUvarint(m, uint64(len(value)))
+	return append(m, value...)
+}
+
+func (m pbMessage) string(field int, value string) pbMessage {
+	return m.bytes(field, []byte(value))
+}
+
+func (m pbMessage) message(field int, value pbMessage) pbMessage {
+	return m.bytes(field, value)
+}
+
+// pbField is a field of a protobuf message being decoded. Value holds varints and fixed numbers, Bytes the length
+// delimited fields
+type pbField struct {
+	Number   int
+	WireType int
+	Value    uint64
+	Bytes    []byte
+}
+
+func (f pbField) string() string {
+	return string(f.Bytes)
+}
+
+func (f pbField) double() float64 {
+	return math.Float64frombits(f.Value)
+}
+
+// pbDecode calls visit with every field of the message, in order
+func pbDecode(message []byte, visit func(field pbField) error) error {
+
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return fmt.Errorf("Invalid protobuf field key")
+		}
+		message = message[n:]
+		field := pbField{Number: int(key >> 3), WireType: int(key & 7)}
+
+		switch field.WireType {
+		case pbVarint:
+			if field.Value, n = binary.Uvarint(message); n <= 0 {
+				return fmt.Errorf("Invalid protobuf varint in field %d", field.Number)
+			}
+			message = message[n:]
+		case pbFixed64:
+			if len(message) < 8 {
+				return fmt.Errorf("Truncated protobuf field %d", field.Number)
+			}
+			field.Value, message = binary.LittleEndian.Uint64(message), message[8:]
+		case pbFixed32:
+			if len(message) < 4 {
+				return fmt.Errorf("Truncated protobuf field %d", field.Number)
+			}
+			field.Value, message = uint64(binary.LittleEndian.Uint32(message)), message[4:]
+		case pbBytes:
+			length, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < length {
+				return fmt.Errorf("Truncated protobuf field %d", field.Number)
+			}
+			field.Bytes, message = message[n:n+int(length)], message[n+int(length):]
+		default:
+			return fmt.Errorf("Unsupported protobuf wire type %d in field %d", field.WireType, field.Number)
+		}
+
+		if err := visit(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
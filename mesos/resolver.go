@@ -0,0 +1,99 @@
+package mesos
+
+// Discovery of the leading Mesos master, among a list of masters or in ZooKeeper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LeaderResolver finds the URL of the leading Mesos master
+type LeaderResolver interface {
+	Resolve(ctx context.Context, httpClient *http.Client) (string, error)
+}
+
+// NewLeaderResolver returns the resolver for the masters setting: a ZooKeeper path such as
+// zk://host1:2181,host2:2181/mesos, or a comma separated list of master URLs
+func NewLeaderResolver(masters string, timeout time.Duration) (LeaderResolver, error) {
+
+	if strings.HasPrefix(masters, "zk://") {
+		zkURL, err := url.Parse(masters)
+		if err != nil || zkURL.Host == "" || zkURL.Path == "" {
+			return nil, fmt.Errorf("Invalid ZooKeeper Mesos path %s", masters)
+		}
+		return &ZookeeperResolver{
+			Servers: strings.Split(zkURL.Host, ","),
+			Path:    zkURL.Path,
+			Timeout: timeout,
+		}, nil
+	}
+
+	masterURLs := []string{}
+	for _, masterURL := range strings.Split(masters, ",") {
+		if masterURL = strings.TrimRight(strings.TrimSpace(masterURL), "/"); masterURL != "" {
+			masterURLs = append(masterURLs, masterURL)
+		}
+	}
+	if len(masterURLs) == 0 {
+		return nil, fmt.Errorf("At least one Mesos master is required")
+	}
+	return &RedirectResolver{MasterURLs: masterURLs}, nil
+}
+
+// RedirectResolver finds the leading master asking every master in turn for /master/redirect, which redirects to
+// the leader
+type RedirectResolver struct {
+	MasterURLs []string
+}
+
+// Resolve returns the URL of the leader, given by the first master answering
+func (r *RedirectResolver) Resolve(ctx context.Context, httpClient *http.Client) (string, error) {
+
+	var err error
+	for _, masterURL := range r.MasterURLs {
+		var leaderURL string
+		if leaderURL, err = r.resolveFrom(ctx, httpClient, masterURL); err == nil {
+			return leaderURL, nil
+		}
+	}
+	return "", fmt.Errorf("Unable to find the leading Mesos master: %s", err)
+}
+
+func (r *RedirectResolver) resolveFrom(ctx context.Context, httpClient *http.Client, masterURL string) (string, error) {
+
+	req, err := http.NewRequest("GET", masterURL+"/master/redirect", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if resp.StatusCode < 300 || resp.StatusCode > 399 || location == "" {
+		return "", fmt.Errorf("Mesos master %s answered /master/redirect with %d", masterURL, resp.StatusCode)
+	}
+
+	return leaderFromLocation(masterURL, location)
+}
+
+// leaderFromLocation returns the URL of the leader a master redirects to. The location has no scheme, the one of
+// the master is kept
+func leaderFromLocation(masterURL, location string) (string, error) {
+
+	base, err := url.Parse(masterURL)
+	if err != nil {
+		return "", err
+	}
+	leaderURL, err := base.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s://%s", leaderURL.Scheme, leaderURL.Host), nil
+}
@@ -0,0 +1,265 @@
+package mesos
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRedirectResolver(t *testing.T) {
+
+	Convey("When the masters redirect to the leader", t, func() {
+		leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"frameworks": []}`)
+		}))
+		defer leader.Close()
+		follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Location", "//"+leader.Listener.Addr().String())
+			w.WriteHeader(http.StatusTemporaryRedirect)
+		}))
+		defer follower.Close()
+
+		resolver, err := NewLeaderResolver("http://127.0.0.1:1,"+follower.URL, time.Second)
+		So(err, ShouldBeNil)
+
+		Convey("the leader should be found skipping the masters that don't answer", func() {
+			leaderURL, err := resolver.Resolve(context.Background(), &http.Client{
+				CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+			})
+			So(err, ShouldBeNil)
+			So(leaderURL, ShouldEqual, leader.URL)
+		})
+	})
+
+	Convey("When no master is given", t, func() {
+		_, err := NewLeaderResolver(" , ", time.Second)
+
+		Convey("the resolver should not be created", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestClientLeaderChange(t *testing.T) {
+
+	Convey("When the leader changes between two calls", t, func() {
+		var oldLeaderCalls, newLeaderCalls int32
+		newLeader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&newLeaderCalls, 1)
+			fmt.Fprint(w, `{"frameworks": []}`)
+		}))
+		defer newLeader.Close()
+		oldLeader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&oldLeaderCalls, 1) == 1 {
+				fmt.Fprint(w, `{"frameworks": []}`)
+				return
+			}
+			w.Header().Set("Location", "//"+newLeader.Listener.Addr().String())
+			w.WriteHeader(http.StatusTemporaryRedirect)
+		}))
+		defer oldLeader.Close()
+
+		resolver := &fakeResolver{leaders: []string{oldLeader.URL, newLeader.URL}}
+		client := &Client{Resolver: resolver}
+		_, err := client.GetMesosFrameworks(context.Background())
+		So(err, ShouldBeNil)
+
+		Convey("a call redirected by the old leader should be sent to the new one, even if not idempotent", func() {
+			err := client.StopMaintenance(context.Background(), []MaintenanceMachinesID{{Hostname: "host1"}})
+			So(err, ShouldBeNil)
+			So(atomic.LoadInt32(&oldLeaderCalls), ShouldEqual, 2)
+			So(atomic.LoadInt32(&newLeaderCalls), ShouldEqual, 1)
+			So(resolver.calls, ShouldEqual, 2)
+		})
+	})
+}
+
+func TestRedirectWithoutResolver(t *testing.T) {
+
+	Convey("When the only master configured is not the leader", t, func() {
+		var followerCalls, leaderCalls int32
+		leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&leaderCalls, 1)
+			fmt.Fprint(w, `{"frameworks": []}`)
+		}))
+		defer leader.Close()
+		follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&followerCalls, 1)
+			w.Header().Set("Location", "//"+leader.Listener.Addr().String()+r.URL.Path)
+			w.WriteHeader(http.StatusTemporaryRedirect)
+		}))
+		defer follower.Close()
+
+		client := &Client{MasterURL: follower.URL}
+
+		Convey("the calls should be sent to the master it redirects to", func() {
+			_, err := client.GetMesosFrameworks(context.Background())
+			So(err, ShouldBeNil)
+			err = client.StopMaintenance(context.Background(), []MaintenanceMachinesID{{Hostname: "host1"}})
+			So(err, ShouldBeNil)
+			So(atomic.LoadInt32(&followerCalls), ShouldEqual, 1)
+			So(atomic.LoadInt32(&leaderCalls), ShouldEqual, 2)
+		})
+		Convey("the configured master should be called again once the leader fails", func() {
+			client.GetMesosFrameworks(context.Background())
+			leader.Close()
+			_, err := client.GetMesosFrameworks(context.Background())
+			So(err, ShouldNotBeNil)
+			client.GetMesosFrameworks(context.Background())
+			So(atomic.LoadInt32(&followerCalls), ShouldEqual, 2)
+		})
+	})
+}
+
+func TestZookeeperResolver(t *testing.T) {
+
+	Convey("When the masters are registered in ZooKeeper", t, func() {
+		server := newFakeZookeeper(map[string][]byte{
+			"/mesos/log_replicas":         nil,
+			"/mesos/json.info_0000000012": []byte(`{"address": {"hostname": "master2", "ip": "10.0.0.2", "port": 5050}}`),
+			"/mesos/json.info_0000000010": []byte(`{"address": {"ip": "10.0.0.1", "port": 5050}, "hostname": "master1"}`),
+		})
+		defer server.Close()
+
+		resolver, err := NewLeaderResolver("zk://127.0.0.1:1,"+server.Addr().String()+"/mesos", time.Second)
+		So(err, ShouldBeNil)
+
+		Convey("the master with the lowest sequence should be the leader", func() {
+			leaderURL, err := resolver.Resolve(context.Background(), http.DefaultClient)
+			So(err, ShouldBeNil)
+			So(leaderURL, ShouldEqual, "http://10.0.0.1:5050")
+		})
+	})
+
+	Convey("When no master is registered in ZooKeeper", t, func() {
+		server := newFakeZookeeper(map[string][]byte{})
+		defer server.Close()
+
+		resolver := &ZookeeperResolver{Servers: []string{server.Addr().String()}, Path: "/mesos", Timeout: time.Second}
+
+		Convey("the leader should not be found", func() {
+			_, err := resolver.Resolve(context.Background(), http.DefaultClient)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// fakeResolver returns the leaders in order, one per resolution
+type fakeResolver struct {
+	leaders []string
+	calls   int
+}
+
+func (r *fakeResolver) Resolve(ctx context.Context, httpClient *http.Client) (string, error) {
+
+	leader := r.leaders[r.calls]
+	r.calls++
+	return leader, nil
+}
+
+// newFakeZookeeper serves the nodes, by path, with the getChildren and getData operations of the ZooKeeper protocol
+func newFakeZookeeper(nodes map[string][]byte) net.Listener {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeZookeeper(conn, nodes)
+		}
+	}()
+	return listener
+}
+
+func serveFakeZookeeper(conn net.Conn, nodes map[string][]byte) {
+
+	defer conn.Close()
+	zk := &zkConn{conn: conn}
+
+	if _, err := zk.readPacket(); err != nil {
+		return
+	}
+	response := &bytes.Buffer{}
+	binary.Write(response, binary.BigEndian, int32(0))
+	binary.Write(response, binary.BigEndian, int32(10000))
+	binary.Write(response, binary.BigEndian, int64(1))
+	writeZkBuffer(response, make([]byte, 16))
+	zk.writePacket(response.Bytes())
+
+	for {
+		packet, err := zk.readPacket()
+		if err != nil {
+			return
+		}
+		request := bytes.NewReader(packet)
+		var header struct {
+			Xid       int32
+			Operation int32
+		}
+		binary.Read(request, binary.BigEndian, &header)
+		if header.Operation == zkOpClose {
+			return
+		}
+		path, _ := readZkBuffer(request)
+
+		// A notification before the reply, which the client must skip
+		notification := &bytes.Buffer{}
+		binary.Write(notification, binary.BigEndian, int32(-2))
+		binary.Write(notification, binary.BigEndian, int64(0))
+		binary.Write(notification, binary.BigEndian, int32(0))
+		zk.writePacket(notification.Bytes())
+
+		reply := &bytes.Buffer{}
+		binary.Write(reply, binary.BigEndian, header.Xid)
+		binary.Write(reply, binary.BigEndian, int64(1))
+		switch header.Operation {
+		case zkOpGetChildren:
+			children := []string{}
+			for node := range nodes {
+				if len(node) > len(path) && node[:len(path)+1] == string(path)+"/" {
+					children = append(children, node[len(path)+1:])
+				}
+			}
+			binary.Write(reply, binary.BigEndian, int32(0))
+			binary.Write(reply, binary.BigEndian, int32(len(children)))
+			for _, child := range children {
+				writeZkBuffer(reply, []byte(child))
+			}
+		case zkOpGetData:
+			data, ok := nodes[string(path)]
+			if !ok {
+				// ZNONODE
+				binary.Write(reply, binary.BigEndian, int32(-101))
+				break
+			}
+			binary.Write(reply, binary.BigEndian, int32(0))
+			writeZkBuffer(reply, data)
+			io.CopyN(reply, zeroReader{}, 68)
+		}
+		zk.writePacket(reply.Bytes())
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
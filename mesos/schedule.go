@@ -0,0 +1,46 @@
+package mesos
+
+// Helpers to edit a maintenance schedule without touching the windows of the machines deathnode doesn't manage
+
+// Machines returns the machines scheduled in any window, by hostname
+func (s *MaintenanceSchedule) Machines() map[string]MaintenanceMachinesID {
+
+	machines := map[string]MaintenanceMachinesID{}
+	for _, window := range s.Windows {
+		for _, machine := range window.MachinesIds {
+			machines[machine.Hostname] = machine
+		}
+	}
+	return machines
+}
+
+// WithoutMachines returns a copy of the schedule without the machines whose hostname is in hostnames. The
+// windows left empty are removed
+func (s *MaintenanceSchedule) WithoutMachines(hostnames map[string]bool) *MaintenanceSchedule {
+
+	schedule := &MaintenanceSchedule{Windows: []MaintenanceWindow{}}
+	for _, window := range s.Windows {
+		machines := []MaintenanceMachinesID{}
+		for _, machine := range window.MachinesIds {
+			if !hostnames[machine.Hostname] {
+				machines = append(machines, machine)
+			}
+		}
+		if len(machines) > 0 {
+			schedule.Windows = append(schedule.Windows, MaintenanceWindow{
+				MachinesIds:    machines,
+				Unavailability: window.Unavailability,
+			})
+		}
+	}
+	return schedule
+}
+
+// Equal returns true if both unavailabilities start at the same time and last the same
+func (u MaintenanceUnavailability) Equal(other MaintenanceUnavailability) bool {
+
+	if u.Start != other.Start || (u.Duration == nil) != (other.Duration == nil) {
+		return false
+	}
+	return u.Duration == nil || *u.Duration == *other.Duration
+}
@@ -0,0 +1,14 @@
+	R
+J
+'
+mesosslave1hostname@'2
+mesosslave11.4.0"slave(1)@10.0.0.2:5051
+[
+'
+mesosslave2hostname@'2
+mesosslave21.9.0"slave(1)@10.0.0.3:5051j
+
+J
+'
+mesosslave3hostname@'2
+mesosslave31.4.0"slave(1)@10.0.0.4:5051
\ No newline at end of file
@@ -0,0 +1,14 @@
+
+Z
+,
+&
+rootframeworkName1
+frameworkId1
+,
+&
+rootframeworkName2
+frameworkId2
+,
+&
+rootframeworkName2
+frameworkId3
\ No newline at end of file
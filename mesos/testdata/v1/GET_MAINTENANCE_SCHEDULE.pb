@@ -0,0 +1,11 @@
+Y
+W
+,
+
+operatorhostname10.0.1.1
+
+
+'
+
+mesosslave1hostname10.0.0.2
+
\ No newline at end of file
@@ -0,0 +1,10 @@
+
+
+^
+
+operatorhostname10.0.1.1
+frameworkId1
+
+frameworkId2
+
+mesosslave1hostname10.0.0.2
\ No newline at end of file
@@ -0,0 +1,33 @@
+jl
+task1
+
+task1.id
+frameworkId1*
+mesosslave10B
+
+
+task1.id1$@Z
+
+DEATHNODE_PROTECTEDtruem
+task2
+
+task2.id
+frameworkId1*
+mesosslave20B
+
+
+task2.id1$@Z
+
+DEATHNODE_PROTECTEDfalseM
+task3
+
+task3.id
+frameworkId3*
+mesosslave30B
+
+
+task3.id1$@:
+finished
+finished.id
+frameworkId1*
+mesosslave10
\ No newline at end of file
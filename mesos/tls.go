@@ -0,0 +1,57 @@
+package mesos
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSOptions configures the HTTPS connections to the Mesos masters
+type TLSOptions struct {
+	// CAFile is a PEM bundle of the CAs trusted besides the system ones
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key presented to the masters
+	CertFile string
+	KeyFile  string
+	// ServerName is the name verified in the certificates of the masters, instead of their host
+	ServerName string
+}
+
+// Enabled returns true if any option is set
+func (o TLSOptions) Enabled() bool {
+	return o.CAFile != "" || o.CertFile != "" || o.KeyFile != "" || o.ServerName != ""
+}
+
+// NewTLSConfig returns the TLS configuration for the options
+func NewTLSConfig(options TLSOptions) (*tls.Config, error) {
+
+	config := &tls.Config{ServerName: options.ServerName}
+
+	if options.CAFile != "" {
+		pem, err := ioutil.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificate found in the Mesos CA file %s", options.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if options.CertFile != "" || options.KeyFile != "" {
+		if options.CertFile == "" || options.KeyFile == "" {
+			return nil, fmt.Errorf("Both the Mesos client certificate and key are required")
+		}
+		certificate, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
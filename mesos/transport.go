@@ -0,0 +1,297 @@
+package mesos
+
+// HTTP transport of the Mesos client: leader resolution, authentication, timeouts per attempt, retries with jittered backoff for
+// the idempotent calls, and typed errors for the responses out of the 2xx range
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// maxErrorBodyLength is the maximum number of bytes of a response body kept in an APIError
+const maxErrorBodyLength = 1024
+
+// APIError is returned when the Mesos master answers a call with a status code out of the 2xx range
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("Mesos %s %s returned %d: %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// retryable returns true if the call can succeed if retried: the master failed or is unavailable
+func (e *APIError) retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// notLeaderError is returned when the master answering a call redirects it, as it's not the leader anymore
+type notLeaderError struct {
+	url string
+}
+
+func (e *notLeaderError) Error() string {
+	return fmt.Sprintf("Mesos master %s is not the leader", e.url)
+}
+
+// decoder reads the body of a successful response
+type decoder func(body io.Reader) error
+
+func (c *Client) get(ctx context.Context, path string, response interface{}) error {
+	return c.do(ctx, "GET", path, nil, "", true, jsonDecoder(response))
+}
+
+func (c *Client) post(ctx context.Context, path string, payload []byte, idempotent bool) error {
+	return c.do(ctx, "POST", path, payload, "application/json", idempotent, nil)
+}
+
+// call posts a payload encoded as contentType, and decodes the response, in the same encoding, with decode
+func (c *Client) call(ctx context.Context, path string, payload []byte, contentType string, idempotent bool,
+	decode decoder) error {
+	return c.do(ctx, "POST", path, payload, contentType, idempotent, decode)
+}
+
+func jsonDecoder(response interface{}) decoder {
+	return func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(response)
+	}
+}
+
+// do performs the call, retrying it if it's idempotent and fails with a network error or a retryable status code.
+// A call redirected by a master that is not the leader anymore was not processed, so it's always sent once more
+// to the new leader. Likewise, a call rejected as unauthorized is sent once more with new credentials
+func (c *Client) do(ctx context.Context, method, path string, payload []byte, contentType string, idempotent bool,
+	decode decoder) error {
+
+	attempts := 1
+	if idempotent {
+		attempts += c.Retries
+	}
+
+	var err error
+	redirected, reauthenticated := false, false
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if waitErr := c.waitBackoff(ctx, attempt); waitErr != nil {
+				return err
+			}
+		}
+
+		err = c.attempt(ctx, method, path, payload, contentType, decode)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		switch e := err.(type) {
+		case *notLeaderError:
+			if !redirected {
+				redirected = true
+				attempt--
+			}
+		case *APIError:
+			if e.StatusCode == http.StatusUnauthorized && c.Authenticator != nil && !reauthenticated {
+				c.Authenticator.Invalidate()
+				reauthenticated = true
+				attempt--
+				continue
+			}
+			if !e.retryable() {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+func (c *Client) attempt(ctx context.Context, method, path string, payload []byte, contentType string,
+	decode decoder) error {
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	resp, url, err := c.send(ctx, method, path, payload, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if decode == nil {
+		return nil
+	}
+	if err := decode(resp.Body); err != nil {
+		return fmt.Errorf("Unable to decode the response of Mesos %s %s: %s", method, url, err)
+	}
+	return nil
+}
+
+// stream posts a payload encoded as contentType, and reads the response, in the same encoding, with read until it
+// ends or ctx is done. The per call timeout and the retries don't apply: the caller reconnects if it fails
+func (c *Client) stream(ctx context.Context, path string, payload []byte, contentType string, read decoder) error {
+
+	resp, url, err := c.send(ctx, "POST", path, payload, contentType)
+	if err != nil {
+		if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == http.StatusUnauthorized && c.Authenticator != nil {
+			c.Authenticator.Invalidate()
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := read(resp.Body); err != nil {
+		return fmt.Errorf("Mesos stream %s interrupted: %s", url, err)
+	}
+	return nil
+}
+
+// send sends the request to the leader, authenticated. It returns the response if its status code is in the
+// 2xx range, and the URL called
+func (c *Client) send(ctx context.Context, method, path string, payload []byte,
+	contentType string) (*http.Response, string, error) {
+
+	masterURL, err := c.leader(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	url := masterURL + path
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, url, err
+	}
+	req = req.WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Accept", contentType)
+	}
+	if c.Authenticator != nil {
+		if err := c.Authenticator.Authenticate(ctx, req, c.httpClient()); err != nil {
+			return nil, url, err
+		}
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		c.forgetLeader(masterURL)
+		return nil, url, err
+	}
+
+	if resp.StatusCode >= 300 && resp.StatusCode <= 399 {
+		resp.Body.Close()
+		c.forgetLeader(masterURL)
+		c.followRedirect(masterURL, resp.Header.Get("Location"))
+		return nil, url, &notLeaderError{url: masterURL}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodyLength))
+		resp.Body.Close()
+		apiErr := &APIError{Method: method, URL: url, StatusCode: resp.StatusCode, Body: string(body)}
+		if apiErr.retryable() {
+			c.forgetLeader(masterURL)
+		}
+		return nil, url, apiErr
+	}
+	return resp, url, nil
+}
+
+// leader returns the URL of the leading master, resolving it if it's not cached. Without Resolver, it's MasterURL
+// unless it redirected the calls to another master
+func (c *Client) leader(ctx context.Context) (string, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.leaderURL != "" {
+		return c.leaderURL, nil
+	}
+	if c.Resolver == nil {
+		return c.MasterURL, nil
+	}
+
+	leaderURL, err := c.Resolver.Resolve(ctx, c.httpClient())
+	if err != nil {
+		return "", err
+	}
+	log.Infof("Mesos leading master found at %s", leaderURL)
+	c.leaderURL = leaderURL
+	return leaderURL, nil
+}
+
+// followRedirect caches, without Resolver, the master the calls are redirected to as the leader. With Resolver,
+// the leader is resolved again instead
+func (c *Client) followRedirect(masterURL, location string) {
+
+	if c.Resolver != nil || location == "" {
+		return
+	}
+	leaderURL, err := leaderFromLocation(masterURL, location)
+	if err != nil {
+		log.Warnf("Invalid redirect of Mesos master %s to %s: %s", masterURL, location, err)
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	log.Infof("Mesos master %s redirects to the leading master at %s", masterURL, leaderURL)
+	c.leaderURL = leaderURL
+}
+
+// forgetLeader drops the cached leader, if it's still the one that failed, so it's resolved on the next call
+func (c *Client) forgetLeader(masterURL string) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.leaderURL == masterURL {
+		c.leaderURL = ""
+	}
+}
+
+// waitBackoff waits before a retry: the backoff doubled on every attempt, with up to 50% of jitter
+func (c *Client) waitBackoff(ctx context.Context, attempt int) error {
+
+	backoff := c.RetryBackoff << uint(attempt-1)
+	if backoff > 0 {
+		backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	}
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+
+	if c.Timeout > 0 {
+		return context.WithTimeout(ctx, c.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// httpClient returns the HTTP client of the calls, which returns the redirects instead of following them
+func (c *Client) httpClient() *http.Client {
+
+	httpClient := http.Client{}
+	if c.HTTPClient != nil {
+		httpClient = *c.HTTPClient
+	}
+	httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &httpClient
+}
@@ -0,0 +1,280 @@
+package mesos
+
+// Minimal ZooKeeper client, enough to read the Mesos master registrations: a session without watches, and the
+// getChildren and getData operations of the ZooKeeper binary protocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Operation codes of the ZooKeeper protocol
+const (
+	zkOpGetData     int32 = 4
+	zkOpGetChildren int32 = 8
+	zkOpClose       int32 = -11
+)
+
+// zkMasterPrefix is the prefix of the nodes the Mesos masters register with, in JSON. The one with the lowest
+// sequence number is the leader
+const zkMasterPrefix = "json.info_"
+
+// ZookeeperResolver finds the leading master in the ZooKeeper path the Mesos masters are registered in
+type ZookeeperResolver struct {
+	Servers []string
+	Path    string
+	// Scheme of the leader URL, http if empty
+	Scheme  string
+	Timeout time.Duration
+}
+
+// zkMasterInfo is part of the registration of a Mesos master in ZooKeeper
+type zkMasterInfo struct {
+	Hostname string `json:"hostname"`
+	Port     int    `json:"port"`
+	Address  struct {
+		Hostname string `json:"hostname"`
+		IP       string `json:"ip"`
+		Port     int    `json:"port"`
+	} `json:"address"`
+}
+
+// Resolve returns the URL of the leader, read from the first ZooKeeper server answering
+func (r *ZookeeperResolver) Resolve(ctx context.Context, httpClient *http.Client) (string, error) {
+
+	var err error
+	for _, server := range r.Servers {
+		var leaderURL string
+		if leaderURL, err = r.resolveFrom(ctx, server); err == nil {
+			return leaderURL, nil
+		}
+	}
+	return "", fmt.Errorf("Unable to find the leading Mesos master in ZooKeeper: %s", err)
+}
+
+func (r *ZookeeperResolver) resolveFrom(ctx context.Context, server string) (string, error) {
+
+	conn, err := dialZookeeper(ctx, server, r.Timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.close()
+
+	children, err := conn.getChildren(r.Path)
+	if err != nil {
+		return "", err
+	}
+	masters := []string{}
+	for _, child := range children {
+		if strings.HasPrefix(child, zkMasterPrefix) {
+			masters = append(masters, child)
+		}
+	}
+	if len(masters) == 0 {
+		return "", fmt.Errorf("No Mesos master registered in %s", r.Path)
+	}
+	sort.Strings(masters)
+
+	data, err := conn.getData(strings.TrimRight(r.Path, "/") + "/" + masters[0])
+	if err != nil {
+		return "", err
+	}
+	var info zkMasterInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return "", fmt.Errorf("Invalid Mesos master registration %s: %s", masters[0], err)
+	}
+
+	scheme := r.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	host, port := info.Address.Hostname, info.Address.Port
+	if host == "" {
+		host = info.Address.IP
+	}
+	if host == "" {
+		host, port = info.Hostname, info.Port
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, fmt.Sprintf("%d", port))), nil
+}
+
+type zkConn struct {
+	conn net.Conn
+	xid  int32
+}
+
+func dialZookeeper(ctx context.Context, server string, timeout time.Duration) (*zkConn, error) {
+
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+
+	// Connect request: protocol version, last zxid seen, session timeout, session id and password
+	request := &bytes.Buffer{}
+	binary.Write(request, binary.BigEndian, int32(0))
+	binary.Write(request, binary.BigEndian, int64(0))
+	binary.Write(request, binary.BigEndian, int32(timeout/time.Millisecond))
+	binary.Write(request, binary.BigEndian, int64(0))
+	writeZkBuffer(request, make([]byte, 16))
+
+	zk := &zkConn{conn: conn}
+	if err := zk.writePacket(request.Bytes()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	response, err := zk.readPacket()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var connectResponse struct {
+		ProtocolVersion int32
+		Timeout         int32
+	}
+	if err := binary.Read(bytes.NewReader(response), binary.BigEndian, &connectResponse); err != nil ||
+		connectResponse.Timeout <= 0 {
+		conn.Close()
+		return nil, fmt.Errorf("ZooKeeper server %s refused the session", server)
+	}
+	return zk, nil
+}
+
+func (z *zkConn) getChildren(path string) ([]string, error) {
+
+	response, err := z.call(zkOpGetChildren, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int32
+	if err := binary.Read(response, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	children := []string{}
+	for i := int32(0); i < count; i++ {
+		child, err := readZkBuffer(response)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, string(child))
+	}
+	return children, nil
+}
+
+func (z *zkConn) getData(path string) ([]byte, error) {
+
+	response, err := z.call(zkOpGetData, path)
+	if err != nil {
+		return nil, err
+	}
+	return readZkBuffer(response)
+}
+
+// call sends a request on a path without watch, and returns the body of its reply
+func (z *zkConn) call(operation int32, path string) (*bytes.Reader, error) {
+
+	z.xid++
+	request := &bytes.Buffer{}
+	binary.Write(request, binary.BigEndian, z.xid)
+	binary.Write(request, binary.BigEndian, operation)
+	writeZkBuffer(request, []byte(path))
+	request.WriteByte(0)
+	if err := z.writePacket(request.Bytes()); err != nil {
+		return nil, err
+	}
+
+	for {
+		packet, err := z.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		response := bytes.NewReader(packet)
+		var header struct {
+			Xid  int32
+			Zxid int64
+			Err  int32
+		}
+		if err := binary.Read(response, binary.BigEndian, &header); err != nil {
+			return nil, err
+		}
+		// Negative xids are notifications and pings, not replies
+		if header.Xid != z.xid {
+			continue
+		}
+		if header.Err != 0 {
+			return nil, fmt.Errorf("ZooKeeper error %d reading %s", header.Err, path)
+		}
+		return response, nil
+	}
+}
+
+func (z *zkConn) close() {
+
+	request := &bytes.Buffer{}
+	binary.Write(request, binary.BigEndian, z.xid+1)
+	binary.Write(request, binary.BigEndian, zkOpClose)
+	z.writePacket(request.Bytes())
+	z.conn.Close()
+}
+
+func (z *zkConn) writePacket(packet []byte) error {
+
+	if err := binary.Write(z.conn, binary.BigEndian, int32(len(packet))); err != nil {
+		return err
+	}
+	_, err := z.conn.Write(packet)
+	return err
+}
+
+func (z *zkConn) readPacket() ([]byte, error) {
+
+	var length int32
+	if err := binary.Read(z.conn, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length < 0 || length > 1<<20 {
+		return nil, fmt.Errorf("Invalid ZooKeeper packet length %d", length)
+	}
+	packet := make([]byte, length)
+	_, err := io.ReadFull(z.conn, packet)
+	return packet, err
+}
+
+func writeZkBuffer(w *bytes.Buffer, buffer []byte) {
+
+	binary.Write(w, binary.BigEndian, int32(len(buffer)))
+	w.Write(buffer)
+}
+
+func readZkBuffer(r *bytes.Reader) ([]byte, error) {
+
+	var length int32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		return nil, nil
+	}
+	buffer := make([]byte, length)
+	_, err := io.ReadFull(r, buffer)
+	return buffer, err
+}
@@ -0,0 +1,37 @@
+package metrics
+
+// Metrics exposed by deathnode
+
+var (
+	// APICallDuration measures the latency of every AWS and Mesos API call
+	APICallDuration = NewHistogramVec("deathnode_api_call_duration_seconds",
+		"Latency of the AWS and Mesos API calls.", DefaultBuckets, "service", "call")
+	// APICallErrors counts the AWS and Mesos API calls that returned an error
+	APICallErrors = NewCounterVec("deathnode_api_call_errors_total",
+		"AWS and Mesos API calls that returned an error.", "service", "call")
+	// LifecycleActionsCompleted counts the CompleteLifecycleAction attempts, by result (success or failure)
+	LifecycleActionsCompleted = NewCounterVec("deathnode_lifecycle_actions_completed_total",
+		"Lifecycle actions completed to destroy an instance, by result.", "result")
+	// LifecycleHeartbeats counts the lifecycle heartbeats sent to extend the lifecycle hook timeout
+	LifecycleHeartbeats = NewCounterVec("deathnode_lifecycle_heartbeats_total",
+		"Lifecycle heartbeats sent to extend the lifecycle hook timeout.")
+	// InstancesMarkedToBeRemoved reports, per autoscaling group, the instances with the deathnode mark
+	InstancesMarkedToBeRemoved = NewGaugeVec("deathnode_instances_marked_to_be_removed",
+		"Instances marked to be removed on the last run.", "autoscaling_group")
+	// UndesiredInstances reports, per autoscaling group, the instances over its desired capacity
+	UndesiredInstances = NewGaugeVec("deathnode_undesired_instances",
+		"Instances over the desired capacity on the last run.", "autoscaling_group")
+	// DrainingSeconds reports, per draining instance, the time since it was marked to be removed
+	DrainingSeconds = NewGaugeVec("deathnode_instance_draining_seconds",
+		"Seconds since the instance was marked to be removed, on the last run.", "autoscaling_group", "instance_id")
+	// MesosStateTrusted is 1 if the Mesos state was completely read, and 0 while instances are not marked nor
+	// destroyed because of a failed or partial read
+	MesosStateTrusted = NewGaugeVec("deathnode_mesos_state_trusted",
+		"1 if the Mesos state could be trusted on the last run, 0 otherwise.")
+	// MesosStateAgeSeconds reports the time since the Mesos state was last completely read
+	MesosStateAgeSeconds = NewGaugeVec("deathnode_mesos_state_age_seconds",
+		"Seconds since the Mesos state was last completely read, on the last run.")
+	// BlockedDrainingAgents reports the draining agents that every protected framework or task label is blocking
+	BlockedDrainingAgents = NewGaugeVec("deathnode_blocked_draining_agents",
+		"Draining agents blocked by a protected framework or task label, on the last run.", "kind", "name")
+)
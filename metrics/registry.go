@@ -0,0 +1,320 @@
+package metrics
+
+// Minimal metrics registry, exposed in Prometheus text format
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram buckets used for latencies, in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var defaultRegistry = &Registry{}
+
+// collector is a metric family that can be written in Prometheus text format
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry stores the metric families to expose
+type Registry struct {
+	mutex      sync.Mutex
+	collectors []collector
+}
+
+func (r *Registry) register(c collector) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Write writes all the registered metric families in Prometheus text format
+func (r *Registry) Write(w io.Writer) {
+
+	r.mutex.Lock()
+	collectors := append([]collector{}, r.collectors...)
+	r.mutex.Unlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler returns an http.Handler serving the metrics of the default registry
+func Handler() http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buffer := &bytes.Buffer{}
+		defaultRegistry.Write(buffer)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buffer.Bytes())
+	})
+}
+
+// desc stores the name, help and label names of a metric family
+type desc struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+}
+
+func (d *desc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.metricType)
+}
+
+func (d *desc) key(labelValues []string) string {
+
+	if len(labelValues) != len(d.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", d.name, len(d.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+func (d *desc) labels(labelValues []string, extra ...string) string {
+
+	pairs := []string{}
+	for i, labelName := range d.labelNames {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labelName, escape(labelValues[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extra[i], escape(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// valueVec is a metric family with a single value per label set, used by counters and gauges
+type valueVec struct {
+	desc
+	mutex  sync.Mutex
+	values map[string]float64
+	series map[string][]string
+}
+
+func newValueVec(metricType, name, help string, labelNames []string) *valueVec {
+
+	return &valueVec{
+		desc:   desc{name: name, help: help, metricType: metricType, labelNames: labelNames},
+		values: map[string]float64{},
+		series: map[string][]string{},
+	}
+}
+
+func (v *valueVec) add(value float64, labelValues []string) {
+
+	key := v.key(labelValues)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.values[key] += value
+	v.series[key] = labelValues
+}
+
+func (v *valueVec) set(value float64, labelValues []string) {
+
+	key := v.key(labelValues)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.values[key] = value
+	v.series[key] = labelValues
+}
+
+func (v *valueVec) get(labelValues []string) float64 {
+
+	key := v.key(labelValues)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.values[key]
+}
+
+func (v *valueVec) reset() {
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.values = map[string]float64{}
+	v.series = map[string][]string{}
+}
+
+func (v *valueVec) write(w io.Writer) {
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.writeHeader(w)
+	for _, key := range sortedKeys(v.series) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.labels(v.series[key]), formatFloat(v.values[key]))
+	}
+}
+
+// CounterVec is a counter metric family partitioned by labels
+type CounterVec struct {
+	*valueVec
+}
+
+// NewCounterVec returns a new CounterVec registered in the default registry
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+
+	counter := &CounterVec{newValueVec("counter", name, help, labelNames)}
+	defaultRegistry.register(counter)
+	return counter
+}
+
+// Inc increments by one the counter for the label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+// Value returns the current value of the counter for the label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	return c.get(labelValues)
+}
+
+// GaugeVec is a gauge metric family partitioned by labels
+type GaugeVec struct {
+	*valueVec
+}
+
+// NewGaugeVec returns a new GaugeVec registered in the default registry
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+
+	gauge := &GaugeVec{newValueVec("gauge", name, help, labelNames)}
+	defaultRegistry.register(gauge)
+	return gauge
+}
+
+// Set sets the gauge for the label values
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.set(value, labelValues)
+}
+
+// Add adds value to the gauge for the label values
+func (g *GaugeVec) Add(value float64, labelValues ...string) {
+	g.add(value, labelValues)
+}
+
+// Value returns the current value of the gauge for the label values
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	return g.get(labelValues)
+}
+
+// Reset deletes all the label sets of the gauge
+func (g *GaugeVec) Reset() {
+	g.reset()
+}
+
+// HistogramVec is a histogram metric family partitioned by labels
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mutex   sync.Mutex
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// NewHistogramVec returns a new HistogramVec registered in the default registry
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+
+	histogramVec := &HistogramVec{
+		desc:    desc{name: name, help: help, metricType: "histogram", labelNames: labelNames},
+		buckets: buckets,
+		series:  map[string]*histogram{},
+	}
+	defaultRegistry.register(histogramVec)
+	return histogramVec
+}
+
+// Observe adds an observation to the histogram for the label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+
+	key := h.key(labelValues)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogram{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bucket := range h.buckets {
+		if value <= bucket {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+// Count returns the number of observations of the histogram for the label values
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+
+	key := h.key(labelValues)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if series, ok := h.series[key]; ok {
+		return series.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.writeHeader(w)
+	keys := []string{}
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := h.series[key]
+		for i, bucket := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n",
+				h.name, h.labels(series.labelValues, "le", formatFloat(bucket)), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(series.labelValues, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labels(series.labelValues), formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labels(series.labelValues), series.count)
+	}
+}
+
+func sortedKeys(series map[string][]string) []string {
+
+	keys := []string{}
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
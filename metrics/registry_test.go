@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+
+	Convey("When incrementing a counter", t, func() {
+		counter := NewCounterVec("test_counter_total", "A test counter.", "call")
+		counter.Inc("first")
+		counter.Inc("first")
+		counter.Inc("second")
+
+		Convey("its value should be kept per label set", func() {
+			So(counter.Value("first"), ShouldEqual, 2)
+			So(counter.Value("second"), ShouldEqual, 1)
+		})
+		Convey("it should be written in Prometheus text format", func() {
+			buffer := &bytes.Buffer{}
+			counter.write(buffer)
+			So(buffer.String(), ShouldEqual, "# HELP test_counter_total A test counter.\n"+
+				"# TYPE test_counter_total counter\n"+
+				"test_counter_total{call=\"first\"} 2\n"+
+				"test_counter_total{call=\"second\"} 1\n")
+		})
+	})
+}
+
+func TestGaugeVec(t *testing.T) {
+
+	Convey("When setting a gauge", t, func() {
+		gauge := NewGaugeVec("test_gauge", "A test gauge.", "name")
+		gauge.Set(5, "with \"quotes\"")
+
+		Convey("the label values should be escaped", func() {
+			buffer := &bytes.Buffer{}
+			gauge.write(buffer)
+			So(buffer.String(), ShouldContainSubstring, "test_gauge{name=\"with \\\"quotes\\\"\"} 5\n")
+		})
+		Convey("resetting it should delete all its label sets", func() {
+			gauge.Reset()
+			buffer := &bytes.Buffer{}
+			gauge.write(buffer)
+			So(buffer.String(), ShouldNotContainSubstring, "test_gauge{")
+		})
+	})
+}
+
+func TestHistogramVec(t *testing.T) {
+
+	Convey("When observing values in a histogram", t, func() {
+		histogram := NewHistogramVec("test_duration_seconds", "A test histogram.", []float64{0.1, 1}, "call")
+		histogram.Observe(0.05, "first")
+		histogram.Observe(0.5, "first")
+		histogram.Observe(5, "first")
+
+		Convey("it should write cumulative buckets, sum and count", func() {
+			buffer := &bytes.Buffer{}
+			histogram.write(buffer)
+			So(buffer.String(), ShouldContainSubstring, "test_duration_seconds_bucket{call=\"first\",le=\"0.1\"} 1\n")
+			So(buffer.String(), ShouldContainSubstring, "test_duration_seconds_bucket{call=\"first\",le=\"1\"} 2\n")
+			So(buffer.String(), ShouldContainSubstring, "test_duration_seconds_bucket{call=\"first\",le=\"+Inf\"} 3\n")
+			So(buffer.String(), ShouldContainSubstring, "test_duration_seconds_sum{call=\"first\"} 5.55\n")
+			So(buffer.String(), ShouldContainSubstring, "test_duration_seconds_count{call=\"first\"} 3\n")
+			So(histogram.Count("first"), ShouldEqual, 3)
+		})
+	})
+}
+
+func TestHandler(t *testing.T) {
+
+	Convey("When requesting the metrics", t, func() {
+		recorder := httptest.NewRecorder()
+		Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		Convey("it should return all the registered metric families", func() {
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+			So(strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"), ShouldBeTrue)
+			So(recorder.Body.String(), ShouldContainSubstring, "# TYPE deathnode_api_call_duration_seconds histogram")
+			So(recorder.Body.String(), ShouldContainSubstring, "# TYPE deathnode_undesired_instances gauge")
+		})
+	})
+}
@@ -1,10 +1,13 @@
 package monitor
 
 import (
+	gocontext "context"
 	"fmt"
+	"github.com/alanbover/deathnode/aws"
 	"github.com/alanbover/deathnode/context"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	log "github.com/sirupsen/logrus"
+	"sort"
 )
 
 // AutoscalingServiceMonitor holds a map of [ASGprefix][ASGname]AutoscalingGroupMonitor
@@ -15,19 +18,34 @@ type AutoscalingServiceMonitor struct {
 
 // AutoscalingGroupMonitor monitors an AWS autoscaling group, caching it's data
 type AutoscalingGroupMonitor struct {
-	autoscalingGroupName string
-	desiredCapacity      int64
-	instanceMonitors     map[string]*InstanceMonitor
-	ctx                  *context.ApplicationContext
+	autoscalingGroupPrefix string
+	autoscalingGroupName   string
+	desiredCapacity        int64
+	instanceMonitors       map[string]*InstanceMonitor
+	// lifecycleHook is the lifecycle hook last reconciled, nil until it's set
+	lifecycleHook *aws.LifecycleHook
+	// staleLifecycleHooks are the lifecycle hooks put by deathnode under another name, until they are deleted
+	staleLifecycleHooks []string
+	ctx                 *context.ApplicationContext
 }
 
 const (
-	// LifeCycleTimeout sets the time set for LifeCycleHooks timeout
-	LifeCycleTimeout int64 = 3600
-	// LifeCycleRefreshTimeoutPercentage sets the percentage of LifeCycleTimeout to wait before reset it
+	// LifeCycleRefreshTimeoutPercentage sets the percentage of the lifecycle hook timeout to wait before reset it
 	LifeCycleRefreshTimeoutPercentage = 0.75
+	// maxLifeCycleGlobalTimeout is the maximum time AWS keeps an instance waiting on a lifecycle hook
+	maxLifeCycleGlobalTimeout int64 = 172800
 )
 
+// LifeCycleGlobalTimeout returns how long AWS keeps an instance waiting on a lifecycle hook, no matter the
+// heartbeats sent: 100 times its heartbeat timeout, up to 48 hours
+func LifeCycleGlobalTimeout(heartbeatTimeout int64) int64 {
+
+	if 100*heartbeatTimeout > maxLifeCycleGlobalTimeout {
+		return maxLifeCycleGlobalTimeout
+	}
+	return 100 * heartbeatTimeout
+}
+
 // NewAutoscalingServiceMonitor returns an AutoscalingServiceMonitor object
 func NewAutoscalingServiceMonitor(ctx *context.ApplicationContext) *AutoscalingServiceMonitor {
 
@@ -44,15 +62,39 @@ func NewAutoscalingServiceMonitor(ctx *context.ApplicationContext) *AutoscalingS
 	return autoscalingServiceMonitor
 }
 
+// SetAutoscalingGroupPrefixes replaces the monitored autoscalingGroupPrefixes. The autoscaling group monitors of the
+// prefixes that are still monitored are kept, so their instances state is not lost
+func (a *AutoscalingServiceMonitor) SetAutoscalingGroupPrefixes(autoscalingGroupPrefixes []string) {
+
+	autoscalingMonitors := map[string]map[string]*AutoscalingGroupMonitor{}
+	for _, autoscalingGroupPrefix := range autoscalingGroupPrefixes {
+		if monitors, ok := a.autoscalingMonitors[autoscalingGroupPrefix]; ok {
+			autoscalingMonitors[autoscalingGroupPrefix] = monitors
+		} else {
+			log.Infof("Found new autoscalingGroupPrefix to monitor: %s", autoscalingGroupPrefix)
+			autoscalingMonitors[autoscalingGroupPrefix] = map[string]*AutoscalingGroupMonitor{}
+		}
+	}
+
+	for autoscalingGroupPrefix := range a.autoscalingMonitors {
+		if _, ok := autoscalingMonitors[autoscalingGroupPrefix]; !ok {
+			log.Infof("AutoscalingGroupPrefix %s not monitored anymore. Deleting it", autoscalingGroupPrefix)
+		}
+	}
+
+	a.autoscalingMonitors = autoscalingMonitors
+}
+
 // NewAutoscalingGroupMonitor returns a "empty" AutoscalingGroupMonitor object
 func newAutoscalingGroupMonitor(ctx *context.ApplicationContext,
-	autoscalingGroupName string) (*AutoscalingGroupMonitor, error) {
+	autoscalingGroupPrefix, autoscalingGroupName string) (*AutoscalingGroupMonitor, error) {
 
 	return &AutoscalingGroupMonitor{
-		autoscalingGroupName: autoscalingGroupName,
-		desiredCapacity:      0,
-		instanceMonitors:     map[string]*InstanceMonitor{},
-		ctx:                  ctx,
+		autoscalingGroupPrefix: autoscalingGroupPrefix,
+		autoscalingGroupName:   autoscalingGroupName,
+		desiredCapacity:        0,
+		instanceMonitors:       map[string]*InstanceMonitor{},
+		ctx:                    ctx,
 	}, nil
 }
 
@@ -69,6 +111,17 @@ func (a *AutoscalingServiceMonitor) GetInstanceByID(instanceID string) (*Instanc
 	return nil, fmt.Errorf("InstanceId %s not found", instanceID)
 }
 
+// GetAutoscalingGroupMonitor returns the AutoscalingGroupMonitor related with the autoscalingGroupName
+func (a *AutoscalingServiceMonitor) GetAutoscalingGroupMonitor(autoscalingGroupName string) (*AutoscalingGroupMonitor, error) {
+
+	for _, autoscalingPrefix := range a.autoscalingMonitors {
+		if autoscalingMonitor, ok := autoscalingPrefix[autoscalingGroupName]; ok {
+			return autoscalingMonitor, nil
+		}
+	}
+	return nil, fmt.Errorf("AutoscalingGroup %s not found", autoscalingGroupName)
+}
+
 // GetAutoscalingGroupMonitorsList returns all AutoscalingGroupMonitors cached in AutoscalingGroups in a list
 func (a *AutoscalingServiceMonitor) GetAutoscalingGroupMonitorsList() []*AutoscalingGroupMonitor {
 
@@ -97,19 +150,22 @@ func findAutoscalingGroup(autoscalingGroupName string,
 
 // Refresh updates autoscalingGroups caching all AWS autoscaling groups given the N prefixes
 // provided when AutoscalingGroups was created
-func (a *AutoscalingServiceMonitor) Refresh() error {
+func (a *AutoscalingServiceMonitor) Refresh(runCtx gocontext.Context) error {
 
 	for autoscalingGroupPrefix := range a.autoscalingMonitors {
-		if err := a.refreshAutoscalingPrefix(autoscalingGroupPrefix); err != nil {
+		if err := runCtx.Err(); err != nil {
+			return err
+		}
+		if err := a.refreshAutoscalingPrefix(runCtx, autoscalingGroupPrefix); err != nil {
 			log.Warning(err)
 		}
 	}
 	return nil
 }
 
-func (a *AutoscalingServiceMonitor) refreshAutoscalingPrefix(prefix string) error {
+func (a *AutoscalingServiceMonitor) refreshAutoscalingPrefix(runCtx gocontext.Context, prefix string) error {
 
-	response, err := a.ctx.AwsConn.DescribeAGsByPrefix(prefix)
+	response, err := a.ctx.AwsConn.DescribeAGsByPrefix(runCtx, prefix)
 	if err != nil {
 		return err
 	}
@@ -121,13 +177,16 @@ func (a *AutoscalingServiceMonitor) refreshAutoscalingPrefix(prefix string) erro
 	// find new autoscalingGroups
 	for _, autoscalingGroup := range response {
 		if _, ok := a.autoscalingMonitors[prefix][*autoscalingGroup.AutoScalingGroupName]; !ok {
-			a.newAutoscalingGroupMonitor(prefix, *autoscalingGroup.AutoScalingGroupName)
+			a.newAutoscalingGroupMonitor(runCtx, prefix, *autoscalingGroup.AutoScalingGroupName)
 		}
 	}
 
-	for autoscalingGroupName := range a.autoscalingMonitors[prefix] {
+	for autoscalingGroupName, autoscalingGroupMonitor := range a.autoscalingMonitors[prefix] {
 		if autoscalingGroup, ok := findAutoscalingGroup(autoscalingGroupName, response); ok {
-			a.autoscalingMonitors[prefix][autoscalingGroupName].refresh(autoscalingGroup)
+			autoscalingGroupMonitor.refresh(runCtx, autoscalingGroup)
+			if err := autoscalingGroupMonitor.reconcileLifecycleHook(runCtx); err != nil {
+				log.Warnf("Error reconciling the lifecyclehook of autoscaling %s: %s", autoscalingGroupName, err)
+			}
 		} else {
 			log.Infof("Autoscaling group %s removed. Deleting it", autoscalingGroupName)
 			delete(a.autoscalingMonitors[prefix], autoscalingGroupName)
@@ -137,29 +196,117 @@ func (a *AutoscalingServiceMonitor) refreshAutoscalingPrefix(prefix string) erro
 	return nil
 }
 
-func (a *AutoscalingServiceMonitor) newAutoscalingGroupMonitor(autoscalingGroupPrefix string,
+func (a *AutoscalingServiceMonitor) newAutoscalingGroupMonitor(runCtx gocontext.Context, autoscalingGroupPrefix string,
 	autoscalingGroupName string) {
 
 	log.Infof("Found new autoscalingGroup to monitor: %s", autoscalingGroupName)
-	autoscalingGroupMonitor, _ := newAutoscalingGroupMonitor(a.ctx, autoscalingGroupName)
-
-	// Set life cycle hook if it's not set already
-	ok, _ := a.ctx.AwsConn.HasLifeCycleHook(autoscalingGroupName)
-	if !ok {
-		log.Infof("Setting lifecyclehook for autoscaling %s", autoscalingGroupName)
-		lifeCycleTimeout := int64(LifeCycleTimeout)
-		err := a.ctx.AwsConn.PutLifeCycleHook(autoscalingGroupName, &lifeCycleTimeout)
-		if err != nil {
-			log.Warnf("Error putting lifecyclehook to autoscaling %s: %s",
-				autoscalingGroupName, err)
-			return
+	autoscalingGroupMonitor, _ := newAutoscalingGroupMonitor(a.ctx, autoscalingGroupPrefix, autoscalingGroupName)
+
+	if err := autoscalingGroupMonitor.reconcileLifecycleHook(runCtx); err != nil {
+		log.Warnf("Error putting lifecyclehook to autoscaling %s: %s", autoscalingGroupName, err)
+		return
+	}
+
+	a.autoscalingMonitors[autoscalingGroupPrefix][autoscalingGroupName] = autoscalingGroupMonitor
+}
+
+// reconcileLifecycleHook sets the lifecycle hook of the policy if it's not set already, or updates it if its
+// settings differ from the policy ones. It's only described again once the policy changes, as on reload. The hooks
+// deathnode put under another name, as before a rename, are deleted once no instance is waiting on them, as
+// deleting them would continue their termination
+func (a *AutoscalingGroupMonitor) reconcileLifecycleHook(runCtx gocontext.Context) error {
+
+	lifecycleHook := a.Policy().LifecycleHook()
+	if a.lifecycleHook == nil || *a.lifecycleHook != *lifecycleHook {
+		if err := a.putLifecycleHook(runCtx, lifecycleHook); err != nil {
+			return err
+		}
+		a.lifecycleHook = lifecycleHook
+	}
+
+	if len(a.staleLifecycleHooks) == 0 {
+		return nil
+	}
+	for _, instanceMonitor := range a.instanceMonitors {
+		if instanceMonitor.lifecycleState == LifecycleStateTerminatingWait {
+			log.Infof("Instance %s may be waiting on lifecyclehooks %v of autoscaling %s, not deleting them yet",
+				instanceMonitor.instanceID, a.staleLifecycleHooks, a.autoscalingGroupName)
+			return nil
+		}
+	}
+	for len(a.staleLifecycleHooks) > 0 {
+		lifecycleHookName := a.staleLifecycleHooks[0]
+		log.Infof("Deleting lifecyclehook %s of autoscaling %s", lifecycleHookName, a.autoscalingGroupName)
+		if err := a.ctx.AwsConn.DeleteLifeCycleHook(runCtx, a.autoscalingGroupName, lifecycleHookName); err != nil {
+			return err
+		}
+		a.staleLifecycleHooks = a.staleLifecycleHooks[1:]
+	}
+	return nil
+}
+
+func (a *AutoscalingGroupMonitor) putLifecycleHook(runCtx gocontext.Context, lifecycleHook *aws.LifecycleHook) error {
+
+	lifecycleHooks, err := a.ctx.AwsConn.DescribeLifeCycleHooks(runCtx, a.autoscalingGroupName)
+	if err != nil {
+		log.Warnf("Unable to describe the lifecyclehooks of autoscaling %s: %s", a.autoscalingGroupName, err)
+	}
+	var currentLifecycleHook *aws.LifecycleHook
+	a.staleLifecycleHooks = nil
+	for _, describedLifecycleHook := range lifecycleHooks {
+		if describedLifecycleHook.Name == lifecycleHook.Name {
+			currentLifecycleHook = describedLifecycleHook
+		} else if describedLifecycleHook.Metadata == aws.LifecycleHookMetadata {
+			a.staleLifecycleHooks = append(a.staleLifecycleHooks, describedLifecycleHook.Name)
 		}
+	}
+	if currentLifecycleHook != nil && *currentLifecycleHook == *lifecycleHook {
+		log.Infof("Autoscaling %s already have set lifecyclehook %s. Ignoring it...",
+			a.autoscalingGroupName, lifecycleHook.Name)
+		return nil
+	}
+
+	if currentLifecycleHook == nil {
+		log.Infof("Setting lifecyclehook %s for autoscaling %s", lifecycleHook.Name, a.autoscalingGroupName)
 	} else {
-		log.Infof("Autoscaling %s already have set lifecyclehook. Ignoring it...",
-			autoscalingGroupName)
+		log.Infof("Updating lifecyclehook %s for autoscaling %s: timeout %d -> %d, default result %s -> %s",
+			lifecycleHook.Name, a.autoscalingGroupName, currentLifecycleHook.HeartbeatTimeout,
+			lifecycleHook.HeartbeatTimeout, currentLifecycleHook.DefaultResult, lifecycleHook.DefaultResult)
 	}
+	return a.ctx.AwsConn.PutLifeCycleHook(runCtx, a.autoscalingGroupName, lifecycleHook)
+}
 
-	a.autoscalingMonitors[autoscalingGroupPrefix][autoscalingGroupName] = autoscalingGroupMonitor
+// AutoscalingGroupPrefix returns the autoscalingGroupPrefix the autoscaling group was found with
+func (a *AutoscalingGroupMonitor) AutoscalingGroupPrefix() string {
+	return a.autoscalingGroupPrefix
+}
+
+// AutoscalingGroupName returns the name of the autoscaling group being monitored
+func (a *AutoscalingGroupMonitor) AutoscalingGroupName() string {
+	return a.autoscalingGroupName
+}
+
+// DesiredCapacity returns the desired capacity of the autoscaling group on the last refresh
+func (a *AutoscalingGroupMonitor) DesiredCapacity() int64 {
+	return a.desiredCapacity
+}
+
+// SetDesiredCapacity changes the desired capacity of the autoscaling group
+func (a *AutoscalingGroupMonitor) SetDesiredCapacity(runCtx gocontext.Context, desiredCapacity int64) error {
+
+	if err := runCtx.Err(); err != nil {
+		return err
+	}
+	if err := a.ctx.AwsConn.SetDesiredCapacity(runCtx, &a.autoscalingGroupName, desiredCapacity); err != nil {
+		return err
+	}
+	a.desiredCapacity = desiredCapacity
+	return nil
+}
+
+// Policy returns the effective policy for the autoscaling group
+func (a *AutoscalingGroupMonitor) Policy() context.Policy {
+	return a.ctx.Conf.Policy(a.autoscalingGroupPrefix)
 }
 
 // GetNumUndesiredInstances return the number of instances to be removed from the AutoscalingGroup
@@ -173,25 +320,85 @@ func (a *AutoscalingGroupMonitor) GetNumUndesiredInstances() int {
 	return 0
 }
 
+// GetSurplusMarkedInstances returns the instances marked on scale-in that are not needed to reach the desired
+// capacity anymore, because it has grown back. Only the instances AWS has not started to terminate are returned,
+// the most recently marked first. Manual drains are never returned
+func (a *AutoscalingGroupMonitor) GetSurplusMarkedInstances() []*InstanceMonitor {
+
+	activeInstances := 0
+	marked := []*InstanceMonitor{}
+	for _, instanceMonitor := range a.instanceMonitors {
+		if instanceMonitor.IsTerminating() {
+			continue
+		}
+		activeInstances++
+		if instanceMonitor.IsMarkedToBeRemoved() {
+			marked = append(marked, instanceMonitor)
+		}
+	}
+
+	neededMarks := activeInstances - int(a.desiredCapacity)
+	if neededMarks < 0 {
+		neededMarks = 0
+	}
+	surplus := len(marked) - neededMarks
+	if surplus <= 0 {
+		return []*InstanceMonitor{}
+	}
+
+	sort.Slice(marked, func(i, j int) bool {
+		if marked[i].tagRemovalTimestamp != marked[j].tagRemovalTimestamp {
+			return marked[i].tagRemovalTimestamp > marked[j].tagRemovalTimestamp
+		}
+		return marked[i].instanceID < marked[j].instanceID
+	})
+
+	instances := []*InstanceMonitor{}
+	for _, instanceMonitor := range marked {
+		if len(instances) == surplus {
+			break
+		}
+		if instanceMonitor.DrainReason() == DrainReasonScaleIn {
+			instances = append(instances, instanceMonitor)
+		}
+	}
+	return instances
+}
+
 // GetInstances return the instances in AutoscalingGroupMonitor cache that
 // doesn't have the deathnode mark
 func (a *AutoscalingGroupMonitor) GetInstances() []*InstanceMonitor {
 	return a.getInstances(false)
 }
 
-func (a *AutoscalingGroupMonitor) refresh(autoscalingGroup *autoscaling.Group) error {
+// GetAllInstances return all the instances in AutoscalingGroupMonitor cache, including the ones
+// with the deathnode mark
+func (a *AutoscalingGroupMonitor) GetAllInstances() []*InstanceMonitor {
+
+	instances := []*InstanceMonitor{}
+	for _, instanceMonitor := range a.instanceMonitors {
+		instances = append(instances, instanceMonitor)
+	}
+	return instances
+}
+
+func (a *AutoscalingGroupMonitor) refresh(runCtx gocontext.Context, autoscalingGroup *autoscaling.Group) error {
 
-	if err := a.enforceInstanceProtection(autoscalingGroup); err != nil {
+	if err := a.enforceInstanceProtection(runCtx, autoscalingGroup); err != nil {
 		return err
 	}
 
+	if *autoscalingGroup.DesiredCapacity > a.desiredCapacity && len(a.getInstancesMarkedToBeRemoved()) > 0 {
+		log.Infof("Autoscaling group %s desired capacity grew from %d to %d with instances marked to be removed",
+			a.autoscalingGroupName, a.desiredCapacity, *autoscalingGroup.DesiredCapacity)
+	}
 	a.desiredCapacity = *autoscalingGroup.DesiredCapacity
 
 	// find new instances in autoscaling group
 	for _, instance := range autoscalingGroup.Instances {
 		_, ok := a.instanceMonitors[*instance.InstanceId]
 		if !ok {
-			if err := a.newInstance(instance); err != nil {
+			if err := a.newInstance(runCtx, instance); err != nil {
 				log.Error(err)
 				continue
 			}
@@ -200,7 +407,7 @@ func (a *AutoscalingGroupMonitor) refresh(autoscalingGroup *autoscaling.Group) e
 
 	for instanceID := range a.instanceMonitors {
 		if instance, ok := findInstance(instanceID, autoscalingGroup); ok {
-			a.instanceMonitors[*instance.InstanceId].setLifecycleState(*instance.LifecycleState)
+			a.instanceMonitors[*instance.InstanceId].setLifecycleState(runCtx, *instance.LifecycleState)
 		} else {
 			log.Debugf("Instance %s has disappeared from ASG %s. Stop monitoring it",
 				instanceID, a.autoscalingGroupName)
@@ -211,7 +418,7 @@ func (a *AutoscalingGroupMonitor) refresh(autoscalingGroup *autoscaling.Group) e
 	return nil
 }
 
-func (a *AutoscalingGroupMonitor) setInstanceProtection(autoscalingGroup *autoscaling.Group) error {
+func (a *AutoscalingGroupMonitor) setInstanceProtection(runCtx gocontext.Context, autoscalingGroup *autoscaling.Group) error {
 
 	log.Infof("Setting autoscaling %s and it's instances scaleInProtection flag",
 		*autoscalingGroup.AutoScalingGroupName)
@@ -221,7 +428,7 @@ func (a *AutoscalingGroupMonitor) setInstanceProtection(autoscalingGroup *autosc
 		instancesToProtect = append(instancesToProtect, instance.InstanceId)
 	}
 
-	err := a.ctx.AwsConn.SetASGInstanceProtection(autoscalingGroup.AutoScalingGroupName, instancesToProtect)
+	err := a.ctx.AwsConn.SetASGInstanceProtection(runCtx, autoscalingGroup.AutoScalingGroupName, instancesToProtect)
 	if err != nil {
 		return err
 	}
@@ -229,10 +436,10 @@ func (a *AutoscalingGroupMonitor) setInstanceProtection(autoscalingGroup *autosc
 	return nil
 }
 
-func (a *AutoscalingGroupMonitor) enforceInstanceProtection(autoscalingGroup *autoscaling.Group) error {
+func (a *AutoscalingGroupMonitor) enforceInstanceProtection(runCtx gocontext.Context, autoscalingGroup *autoscaling.Group) error {
 
 	if !*autoscalingGroup.NewInstancesProtectedFromScaleIn {
-		if err := a.setInstanceProtection(autoscalingGroup); err != nil {
+		if err := a.setInstanceProtection(runCtx, autoscalingGroup); err != nil {
 			return err
 		}
 	}
@@ -241,13 +448,13 @@ func (a *AutoscalingGroupMonitor) enforceInstanceProtection(autoscalingGroup *au
 	return nil
 }
 
-func (a *AutoscalingGroupMonitor) newInstance(instance *autoscaling.Instance) error {
+func (a *AutoscalingGroupMonitor) newInstance(runCtx gocontext.Context, instance *autoscaling.Instance) error {
 
 	log.Debugf("Found new instance to monitor in autoscaling %s: %s",
 		a.autoscalingGroupName, *instance.InstanceId)
 
 	instanceMonitor, err := newInstanceMonitor(
-		a.ctx, a.autoscalingGroupName, *instance.InstanceId, *instance.LifecycleState, true)
+		runCtx, a.ctx, a.autoscalingGroupPrefix, a.autoscalingGroupName, *instance.InstanceId, *instance.LifecycleState, true)
 	if err != nil {
 		return err
 	}
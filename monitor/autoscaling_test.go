@@ -1,6 +1,7 @@
 package monitor
 
 import (
+	gocontext "context"
 	"github.com/alanbover/deathnode/aws"
 	"github.com/alanbover/deathnode/context"
 	"github.com/benbjohnson/clock"
@@ -75,7 +76,7 @@ func TestRefresh(t *testing.T) {
 					"DescribeAGByName": {"default", "refresh"},
 				},
 			})
-			monitors.Refresh()
+			monitors.Refresh(gocontext.Background())
 			monitor := monitors.GetAutoscalingGroupMonitorsList()[0]
 			Convey("it should have 3 instances", func() {
 				So(len(monitor.instanceMonitors), ShouldEqual, 3)
@@ -104,7 +105,7 @@ func TestRefresh(t *testing.T) {
 					"DescribeAGByName": {"default", "two_asg", "default"},
 				},
 			})
-			monitors.Refresh()
+			monitors.Refresh(gocontext.Background())
 			Convey("two different autoscalingGroups should be monitored", func() {
 				currentMonitors := monitors.GetAutoscalingGroupMonitorsList()
 				So(len(currentMonitors), ShouldEqual, 2)
@@ -113,7 +114,7 @@ func TestRefresh(t *testing.T) {
 					currentMonitors[1].autoscalingGroupName)
 			})
 			Convey("it dissapears after a new refresh", func() {
-				monitors.Refresh()
+				monitors.Refresh(gocontext.Background())
 				So(len(monitors.GetAutoscalingGroupMonitorsList()), ShouldEqual, 1)
 			})
 		})
@@ -127,7 +128,6 @@ func TestInitializeAutoscalingGroup(t *testing.T) {
 			Records: map[string]*[]string{
 				"DescribeInstanceById": {"default", "default", "default"},
 				"DescribeAGByName":     {"instance_profile_disabled"},
-				"HasLifeCycleHook":     {"false"},
 			},
 		}
 		newTestAutoscalingMonitors(awsConn)
@@ -145,6 +145,93 @@ func TestInitializeAutoscalingGroup(t *testing.T) {
 			So(len(callArguments[0]), ShouldBeGreaterThanOrEqualTo, 1)
 			So(callArguments[0][0], ShouldEqual, "some-Autoscaling-Group")
 			So(callArguments[0][1], ShouldEqual, "3600")
+			So(callArguments[0][2], ShouldEqual, "DEATHNODE")
+			So(callArguments[0][3], ShouldEqual, "CONTINUE")
+		})
+	})
+}
+
+func TestReconcileLifecycleHook(t *testing.T) {
+
+	Convey("When creating an AutoscalingGroup that already has a lifecycleHook", t, func() {
+
+		Convey("if its settings match the policy ones, it shouldn't be put again", func() {
+			awsConn := &aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById":   {"default", "default", "default"},
+					"DescribeAGByName":       {"default"},
+					"DescribeLifeCycleHooks": {"default"},
+				},
+			}
+			newTestAutoscalingMonitors(awsConn)
+			So(awsConn.Requests["PutLifeCycleHook"], ShouldBeEmpty)
+		})
+		Convey("if its settings differ from the policy ones, it should be updated", func() {
+			awsConn := &aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById":   {"default", "default", "default"},
+					"DescribeAGByName":       {"default"},
+					"DescribeLifeCycleHooks": {"outdated_lifecycle_hook"},
+				},
+			}
+			newTestAutoscalingMonitors(awsConn)
+			callArguments := awsConn.Requests["PutLifeCycleHook"]
+			So(len(callArguments), ShouldEqual, 1)
+			So(callArguments[0], ShouldResemble, []string{"some-Autoscaling-Group", "3600", "DEATHNODE", "CONTINUE"})
+		})
+		Convey("if deathnode left a hook under another name before restarting, it should be deleted", func() {
+			awsConn := &aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById":   {"default", "default", "default"},
+					"DescribeAGByName":       {"default"},
+					"DescribeLifeCycleHooks": {"stale_lifecycle_hook"},
+				},
+			}
+			newTestAutoscalingMonitors(awsConn)
+			So(awsConn.Requests["PutLifeCycleHook"], ShouldBeEmpty)
+			So(awsConn.Requests["DeleteLifeCycleHook"], ShouldResemble, [][]string{{"some-Autoscaling-Group", "DEATHNODE-OLD"}})
+		})
+	})
+
+	Convey("When the lifecycleHook policy of an AutoscalingGroup already monitored changes", t, func() {
+		awsConn := &aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById": {"default", "default", "default"},
+				"DescribeAGByName":     {"default", "default", "one_undesired_host_one_terminating", "default"},
+			},
+		}
+		autoscalingGroups := newTestAutoscalingMonitors(awsConn)
+
+		Convey("if its settings change, it should be updated on the next refresh", func() {
+			autoscalingGroups.ctx.Conf.LifecycleTimeout = 600
+			autoscalingGroups.Refresh(gocontext.Background())
+			callArguments := awsConn.Requests["PutLifeCycleHook"]
+			So(len(callArguments), ShouldEqual, 2)
+			So(callArguments[1], ShouldResemble, []string{"some-Autoscaling-Group", "600", "DEATHNODE", "CONTINUE"})
+			So(awsConn.Requests["DeleteLifeCycleHook"], ShouldBeEmpty)
+		})
+		Convey("if it's renamed, the new one should be put and the previous one deleted", func() {
+			autoscalingGroups.ctx.Conf.LifecycleHookName = "DEATHNODE-PROD"
+			autoscalingGroups.Refresh(gocontext.Background())
+			callArguments := awsConn.Requests["PutLifeCycleHook"]
+			So(len(callArguments), ShouldEqual, 2)
+			So(callArguments[1][2], ShouldEqual, "DEATHNODE-PROD")
+			So(awsConn.Requests["DeleteLifeCycleHook"], ShouldResemble, [][]string{{"some-Autoscaling-Group", "DEATHNODE"}})
+
+			Convey("and it should not be put nor deleted again", func() {
+				autoscalingGroups.Refresh(gocontext.Background())
+				So(len(awsConn.Requests["PutLifeCycleHook"]), ShouldEqual, 2)
+				So(len(awsConn.Requests["DeleteLifeCycleHook"]), ShouldEqual, 1)
+			})
+		})
+		Convey("if it's renamed while an instance is waiting on the previous one, it should be deleted afterwards", func() {
+			autoscalingGroups.Refresh(gocontext.Background())
+			autoscalingGroups.ctx.Conf.LifecycleHookName = "DEATHNODE-PROD"
+			autoscalingGroups.Refresh(gocontext.Background())
+			So(len(awsConn.Requests["PutLifeCycleHook"]), ShouldEqual, 2)
+			So(awsConn.Requests["DeleteLifeCycleHook"], ShouldBeEmpty)
+			autoscalingGroups.Refresh(gocontext.Background())
+			So(awsConn.Requests["DeleteLifeCycleHook"], ShouldResemble, [][]string{{"some-Autoscaling-Group", "DEATHNODE"}})
 		})
 	})
 }
@@ -163,7 +250,7 @@ func TestGetInstances(t *testing.T) {
 		})
 		Convey("but after mark on instance to be deleted", func() {
 			instanceToBeMarked := monitor.GetInstances()[0]
-			instanceToBeMarked.TagToBeRemoved()
+			instanceToBeMarked.TagToBeRemoved(gocontext.Background())
 			Convey("GetInstances should not return it", func() {
 				So(instanceToBeMarked, ShouldNotBeIn, monitor.GetInstances())
 			})
@@ -199,6 +286,31 @@ func TestGetInstanceById(t *testing.T) {
 	})
 }
 
+func TestSetAutoscalingGroupPrefixes(t *testing.T) {
+
+	Convey("When changing the monitored autoscalingGroupPrefixes", t, func() {
+		autoscalingGroups := newTestAutoscalingMonitors(&aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById": {"default", "default", "default"},
+				"DescribeAGByName":     {"default"},
+			},
+		})
+		monitor := autoscalingGroups.GetAutoscalingGroupMonitorsList()[0]
+
+		Convey("the monitors of the prefixes still monitored should be kept", func() {
+			autoscalingGroups.SetAutoscalingGroupPrefixes([]string{"some-Autoscaling-Group", "other-Autoscaling-Group"})
+			So(autoscalingGroups.autoscalingMonitors, ShouldContainKey, "other-Autoscaling-Group")
+			So(autoscalingGroups.GetAutoscalingGroupMonitorsList(), ShouldHaveLength, 1)
+			So(autoscalingGroups.GetAutoscalingGroupMonitorsList()[0], ShouldEqual, monitor)
+		})
+		Convey("the monitors of the prefixes not monitored anymore should be deleted", func() {
+			autoscalingGroups.SetAutoscalingGroupPrefixes([]string{"other-Autoscaling-Group"})
+			So(autoscalingGroups.autoscalingMonitors, ShouldNotContainKey, "some-Autoscaling-Group")
+			So(autoscalingGroups.GetAutoscalingGroupMonitorsList(), ShouldBeEmpty)
+		})
+	})
+}
+
 func newTestMonitor(awsConn *aws.ConnectionMock) *AutoscalingGroupMonitor {
 
 	return newTestAutoscalingMonitors(awsConn).GetAutoscalingGroupMonitorsList()[0]
@@ -216,6 +328,48 @@ func newTestAutoscalingMonitors(awsConn *aws.ConnectionMock) *AutoscalingService
 	}
 
 	autoscalingGroups := NewAutoscalingServiceMonitor(ctx)
-	autoscalingGroups.Refresh()
+	autoscalingGroups.Refresh(gocontext.Background())
 	return autoscalingGroups
 }
+
+func TestGetSurplusMarkedInstances(t *testing.T) {
+
+	Convey("When an instance is marked to be removed on scale-in", t, func() {
+
+		Convey("if the desired capacity still needs it to be removed", func() {
+			monitor := newTestMonitor(&aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById": {"default", "default", "default"},
+					"DescribeAGByName":     {"one_undesired_host"},
+				},
+			})
+			monitor.GetInstances()[0].TagToBeRemoved(gocontext.Background())
+
+			Convey("it should not be surplus", func() {
+				So(monitor.GetSurplusMarkedInstances(), ShouldBeEmpty)
+			})
+		})
+		Convey("if the desired capacity has grown back", func() {
+			monitor := newTestMonitor(&aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById": {"default", "default", "default"},
+					"DescribeAGByName":     {"default"},
+				},
+			})
+			instance := monitor.GetInstances()[0]
+			instance.TagToBeRemoved(gocontext.Background())
+
+			Convey("it should be surplus", func() {
+				So(monitor.GetSurplusMarkedInstances(), ShouldResemble, []*InstanceMonitor{instance})
+			})
+			Convey("unless it was drained manually", func() {
+				instance.TagDrainReason(gocontext.Background(), DrainReasonManual)
+				So(monitor.GetSurplusMarkedInstances(), ShouldBeEmpty)
+			})
+			Convey("unless AWS already started terminating it", func() {
+				instance.lifecycleState = LifecycleStateTerminatingWait
+				So(monitor.GetSurplusMarkedInstances(), ShouldBeEmpty)
+			})
+		})
+	})
+}
@@ -1,33 +1,45 @@
 package monitor
 
 import (
+	gocontext "context"
 	"fmt"
+	"github.com/alanbover/deathnode/audit"
 	"github.com/alanbover/deathnode/context"
+	"github.com/alanbover/deathnode/metrics"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	log "github.com/sirupsen/logrus"
 	"strconv"
+	"strings"
 )
 
 // LifecycleStateTerminatingWait defines the state of an instance in the autoscalingGroup when it's waiting for
 // confirmation to be removed
 const LifecycleStateTerminatingWait = "Terminating:Wait"
 
+// Reasons for an instance to be marked to be removed. Only the manual one is stored in the drain reason tag
+const (
+	DrainReasonScaleIn = "scale-in"
+	DrainReasonManual  = "manual"
+)
+
 // InstanceMonitor monitors an AWS instance
 type InstanceMonitor struct {
-	autoscalingGroupID  string
-	launchConfiguration string
-	ipAddress           string
-	instanceID          string
-	lifecycleState      string
-	isProtected         bool
-	tagRemovalTimestamp int64
-	ctx                 *context.ApplicationContext
+	autoscalingGroupPrefix string
+	autoscalingGroupID     string
+	launchConfiguration    string
+	ipAddress              string
+	instanceID             string
+	lifecycleState         string
+	isProtected            bool
+	tagRemovalTimestamp    int64
+	drainReason            string
+	ctx                    *context.ApplicationContext
 }
 
-func newInstanceMonitor(ctx *context.ApplicationContext, autoscalingGroupID, instanceID, lifecycleState string,
-	isProtected bool) (*InstanceMonitor, error) {
+func newInstanceMonitor(runCtx gocontext.Context, ctx *context.ApplicationContext, autoscalingGroupPrefix,
+	autoscalingGroupID, instanceID, lifecycleState string, isProtected bool) (*InstanceMonitor, error) {
 
-	response, err := ctx.AwsConn.DescribeInstanceByID(instanceID)
+	response, err := ctx.AwsConn.DescribeInstanceByID(runCtx, instanceID)
 
 	if err != nil {
 		return &InstanceMonitor{}, err
@@ -39,13 +51,15 @@ func newInstanceMonitor(ctx *context.ApplicationContext, autoscalingGroupID, ins
 	}
 
 	return &InstanceMonitor{
-		autoscalingGroupID:  autoscalingGroupID,
-		ipAddress:           *response.PrivateIpAddress,
-		instanceID:          instanceID,
-		lifecycleState:      lifecycleState,
-		isProtected:         isProtected,
-		ctx:                 ctx,
-		tagRemovalTimestamp: tagRemovalTimestamp,
+		autoscalingGroupPrefix: autoscalingGroupPrefix,
+		autoscalingGroupID:     autoscalingGroupID,
+		ipAddress:              *response.PrivateIpAddress,
+		instanceID:             instanceID,
+		lifecycleState:         lifecycleState,
+		isProtected:            isProtected,
+		ctx:                    ctx,
+		tagRemovalTimestamp:    tagRemovalTimestamp,
+		drainReason:            getTagValue(response.Tags, drainReasonTag(ctx.Conf.DeathNodeMark)),
 	}, nil
 }
 
@@ -74,9 +88,22 @@ func (a *InstanceMonitor) AutoscalingGroupID() *string {
 	return &a.autoscalingGroupID
 }
 
+// AutoscalingGroupPrefix returns the autoscalingGroupPrefix of the instance being monitored
+func (a *InstanceMonitor) AutoscalingGroupPrefix() string {
+	return a.autoscalingGroupPrefix
+}
+
+// Policy returns the effective policy for the instance, given its autoscalingGroupPrefix
+func (a *InstanceMonitor) Policy() context.Policy {
+	return a.ctx.Conf.Policy(a.autoscalingGroupPrefix)
+}
+
 // RemoveInstanceProtection removes the instance protection for the autoscaling
-func (a *InstanceMonitor) RemoveInstanceProtection() error {
-	err := a.ctx.AwsConn.RemoveASGInstanceProtection(&a.autoscalingGroupID, &a.instanceID)
+func (a *InstanceMonitor) RemoveInstanceProtection(runCtx gocontext.Context) error {
+	if err := runCtx.Err(); err != nil {
+		return err
+	}
+	err := a.ctx.AwsConn.RemoveASGInstanceProtection(runCtx, &a.autoscalingGroupID, &a.instanceID)
 	if err != nil {
 		return err
 	}
@@ -92,31 +119,102 @@ func (a *InstanceMonitor) IsProtected() bool {
 // TagToBeRemoved sets a tag for the instance with:
 // Key: valueOf(DEATH_NODE_TAG_MARK)
 // Value: Current timestamp (epoch)
-func (a *InstanceMonitor) TagToBeRemoved() error {
+func (a *InstanceMonitor) TagToBeRemoved(runCtx gocontext.Context) error {
+	if err := runCtx.Err(); err != nil {
+		return err
+	}
 	currentTimestamp := a.ctx.Clock.Now().Unix()
-	err := a.ctx.AwsConn.SetInstanceTag(a.ctx.Conf.DeathNodeMark, fmt.Sprintf("%v", currentTimestamp), a.instanceID)
+	err := a.ctx.AwsConn.SetInstanceTag(runCtx, a.ctx.Conf.DeathNodeMark, fmt.Sprintf("%v", currentTimestamp), a.instanceID)
 	a.tagRemovalTimestamp = currentTimestamp
 	return err
 }
 
+// TagDrainReason sets a tag for the instance with the reason it has been marked to be removed
+func (a *InstanceMonitor) TagDrainReason(runCtx gocontext.Context, reason string) error {
+	if err := runCtx.Err(); err != nil {
+		return err
+	}
+	err := a.ctx.AwsConn.SetInstanceTag(runCtx, drainReasonTag(a.ctx.Conf.DeathNodeMark), reason, a.instanceID)
+	if err != nil {
+		return err
+	}
+	a.drainReason = reason
+	return nil
+}
+
+// UntagToBeRemoved deletes the tags that mark the instance to be removed
+func (a *InstanceMonitor) UntagToBeRemoved(runCtx gocontext.Context) error {
+	if err := runCtx.Err(); err != nil {
+		return err
+	}
+	if err := a.ctx.AwsConn.DeleteInstanceTag(runCtx, a.ctx.Conf.DeathNodeMark, a.instanceID); err != nil {
+		return err
+	}
+	a.tagRemovalTimestamp = 0
+	if a.drainReason != "" {
+		if err := a.ctx.AwsConn.DeleteInstanceTag(runCtx, drainReasonTag(a.ctx.Conf.DeathNodeMark), a.instanceID); err != nil {
+			return err
+		}
+		a.drainReason = ""
+	}
+	return nil
+}
+
+// DrainReason returns why the instance has been marked to be removed, or empty if it's not marked
+func (a *InstanceMonitor) DrainReason() string {
+
+	if !a.IsMarkedToBeRemoved() {
+		return ""
+	}
+	if a.drainReason == "" {
+		return DrainReasonScaleIn
+	}
+	return a.drainReason
+}
+
+// SetInstanceProtection sets the instance protection for the autoscaling back
+func (a *InstanceMonitor) SetInstanceProtection(runCtx gocontext.Context) error {
+	if err := runCtx.Err(); err != nil {
+		return err
+	}
+	err := a.ctx.AwsConn.SetASGInstanceProtection(runCtx, &a.autoscalingGroupID, []*string{&a.instanceID})
+	if err != nil {
+		return err
+	}
+	a.isProtected = true
+	return nil
+}
+
 // IsMarkedToBeRemoved is true when the instance has been marked for removal
 func (a *InstanceMonitor) IsMarkedToBeRemoved() bool {
 	return a.tagRemovalTimestamp != 0
 }
 
+// IsTerminating is true when AWS has already started the termination of the instance
+func (a *InstanceMonitor) IsTerminating() bool {
+	return strings.HasPrefix(a.lifecycleState, "Terminating")
+}
+
 // RefreshLifecycleHook resets the timeout for the lifecycle hook and re-tag the instance with a new epoch
-func (a *InstanceMonitor) RefreshLifecycleHook() error {
+func (a *InstanceMonitor) RefreshLifecycleHook(runCtx gocontext.Context) error {
 
 	// Reset the lifecycle timeout for the instance
 	log.Debugf("Refresh lifecycle hook for instance %s", a.InstanceID())
+	if err := runCtx.Err(); err != nil {
+		return err
+	}
 	err := a.ctx.AwsConn.RecordLifecycleActionHeartbeat(
-		a.AutoscalingGroupID(), a.InstanceID())
+		runCtx, a.AutoscalingGroupID(), a.InstanceID(), a.Policy().LifecycleHook().Name)
 	if err != nil {
 		log.Errorf("Unable to record lifecycle action on instance %s", *a.InstanceID())
 		return err
 	}
+	metrics.LifecycleHeartbeats.Inc()
+	a.ctx.Audit.Record(audit.LifecycleHeartbeat, a.autoscalingGroupID, a.instanceID, map[string]interface{}{
+		"tagRemovalTimestamp": a.tagRemovalTimestamp,
+	})
 	// Tag the instance with the new timestamp
-	err = a.TagToBeRemoved()
+	err = a.TagToBeRemoved(runCtx)
 	if err != nil {
 		log.Warnf("Unable to re-tag the instance after record lifecycle on instance %s", a.InstanceID())
 		return err
@@ -124,13 +222,13 @@ func (a *InstanceMonitor) RefreshLifecycleHook() error {
 	return nil
 }
 
-func (a *InstanceMonitor) setLifecycleState(lifecycleState string) {
+func (a *InstanceMonitor) setLifecycleState(runCtx gocontext.Context, lifecycleState string) {
 	a.lifecycleState = lifecycleState
 
 	if lifecycleState == LifecycleStateTerminatingWait && a.isProtected {
 		// A non-controled instance went to Terminating:Wait, probably because it went unhealthy
 		log.Debugf("setLifecycleState called for instance %s", a.InstanceID())
-		a.RefreshLifecycleHook()
+		a.RefreshLifecycleHook(runCtx)
 	}
 }
 
@@ -146,3 +244,17 @@ func getTagRemovalTimestamp(tags []*ec2.Tag, deathNodeMark string) (int64, error
 	}
 	return 0, nil
 }
+
+func getTagValue(tags []*ec2.Tag, key string) string {
+	for _, tag := range tags {
+		if key == *tag.Key {
+			return *tag.Value
+		}
+	}
+	return ""
+}
+
+// drainReasonTag returns the key of the tag storing the drain reason, given the deathNodeMark
+func drainReasonTag(deathNodeMark string) string {
+	return deathNodeMark + "_REASON"
+}
@@ -1,6 +1,7 @@
 package monitor
 
 import (
+	gocontext "context"
 	"github.com/alanbover/deathnode/aws"
 	"github.com/alanbover/deathnode/context"
 	"github.com/benbjohnson/clock"
@@ -24,7 +25,7 @@ func TestNewInstanceMonitor(t *testing.T) {
 			Clock: clock.New(),
 		}
 
-		monitor, _ := newInstanceMonitor(ctx, "autoscalingid", "i-249b35ae", "InService", false)
+		monitor, _ := newInstanceMonitor(gocontext.Background(), ctx, "autoscalingprefix", "autoscalingid", "i-249b35ae", "InService", false)
 
 		Convey("it should not be nil", func() {
 			So(monitor, ShouldNotBeNil)
@@ -36,7 +37,7 @@ func TestNewInstanceMonitor(t *testing.T) {
 			So(monitor.IsMarkedToBeRemoved(), ShouldBeFalse)
 		})
 		Convey("and MarkToBeRemoved is called", func() {
-			monitor.TagToBeRemoved()
+			monitor.TagToBeRemoved(gocontext.Background())
 			Convey("SetInstanceTag should be called with correct parameters", func() {
 				callArguments := awsConn.Requests["SetInstanceTag"]
 				So(callArguments[0][0], ShouldEqual, "DEATH_NODE_MARK")
@@ -69,7 +70,7 @@ func TestInstanceMarkToBeRemoved(t *testing.T) {
 			Clock: clock.New(),
 		}
 
-		monitor, _ := newInstanceMonitor(ctx, "autoscalingid", "i-249b35ae", "InService", false)
+		monitor, _ := newInstanceMonitor(gocontext.Background(), ctx, "autoscalingprefix", "autoscalingid", "i-249b35ae", "InService", false)
 		Convey("and isMarkToBeRemoved is called", func() {
 			So(monitor.IsMarkedToBeRemoved(), ShouldBeTrue)
 		})
@@ -93,12 +94,12 @@ func TestInstanceProtection(t *testing.T) {
 			Clock: clock.New(),
 		}
 
-		monitor, _ := newInstanceMonitor(ctx, "autoscalingid", "i-249b35ae", "InService", true)
+		monitor, _ := newInstanceMonitor(gocontext.Background(), ctx, "autoscalingprefix", "autoscalingid", "i-249b35ae", "InService", true)
 		Convey("instance should have instanceProtection", func() {
 			So(monitor.isProtected, ShouldBeTrue)
 		})
 		Convey("and RemoveInstanceProtection is called", func() {
-			monitor.RemoveInstanceProtection()
+			monitor.RemoveInstanceProtection(gocontext.Background())
 			Convey("instance should not have instanceProtection", func() {
 				So(monitor.isProtected, ShouldBeFalse)
 			})
@@ -129,10 +130,10 @@ func TestLifecycleState(t *testing.T) {
 			Clock: clock.New(),
 		}
 
-		monitor, _ := newInstanceMonitor(ctx, "autoscalingid", "i-249b35ae", "InService", true)
+		monitor, _ := newInstanceMonitor(gocontext.Background(), ctx, "autoscalingprefix", "autoscalingid", "i-249b35ae", "InService", true)
 		Convey("and we call SetLifecycleState", func() {
 			Convey("when the instance has instanceProtection enabled", func() {
-				monitor.setLifecycleState(LifecycleStateTerminatingWait)
+				monitor.setLifecycleState(gocontext.Background(), LifecycleStateTerminatingWait)
 				Convey("instance should have new LifecycleState value", func() {
 					So(monitor.lifecycleState, ShouldEqual, LifecycleStateTerminatingWait)
 				})
@@ -142,7 +143,7 @@ func TestLifecycleState(t *testing.T) {
 			})
 			Convey("when the instance has instanceProtection disabled", func() {
 				monitor.isProtected = false
-				monitor.setLifecycleState(LifecycleStateTerminatingWait)
+				monitor.setLifecycleState(gocontext.Background(), LifecycleStateTerminatingWait)
 				Convey("instance should have new LifecycleState value", func() {
 					So(monitor.lifecycleState, ShouldEqual, LifecycleStateTerminatingWait)
 				})
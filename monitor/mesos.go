@@ -4,27 +4,61 @@ package monitor
 // With MesosCache we reduce the number of calls to mesos, also we map it for quicker access
 
 import (
+	gocontext "context"
 	"github.com/alanbover/deathnode/context"
 	"github.com/alanbover/deathnode/mesos"
 	log "github.com/sirupsen/logrus"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 // MesosMonitor monitors the mesos cluster, creating a cache to reduce the number of calls against it
 type MesosMonitor struct {
 	mesosCache *mesosCache
 	ctx        *context.ApplicationContext
+	// subscribed is true while the cache is kept up to date by the event stream, instead of Refresh
+	subscribed bool
+	// streamTasks holds the tasks of the event stream that are not finished, by agent ID and task ID
+	streamTasks map[string]map[string]mesos.Task
+	// inverseOffers holds the inverse offer responses of the frameworks, by draining hostname and framework ID
+	inverseOffers map[string]map[string]string
+	mutex         sync.RWMutex
 }
 
 // MesosCache stores the objects of the mesosApi in a way that is directly accesible
 // tasks: map[slaveId][]Task
 // frameworks: map[frameworkID]Framework
 // slaves: map[privateIPAddress]Slave
+// endpoints: map[endpoint]bool, true if the endpoint was read on the last refresh
 type mesosCache struct {
 	tasks      map[string][]mesos.Task
 	frameworks map[string]mesos.Framework
 	slaves     map[string]mesos.Slave
+	endpoints  map[string]bool
+	// refreshedAt is the last time every endpoint was read, or an event of the stream received. The cache is kept
+	// from then on a failed refresh
+	refreshedAt time.Time
+}
+
+// Endpoints of the Mesos master cached
+const (
+	mesosEndpointFrameworks = "frameworks"
+	mesosEndpointAgents     = "agents"
+	mesosEndpointTasks      = "tasks"
+)
+
+// MesosCacheStatus describes if the mesos cache can be trusted to decide which agents can be destroyed. It's
+// untrusted until every endpoint is read, after any failed or partial refresh, and once it's older than
+// MesosStateMaxAgeSeconds
+type MesosCacheStatus struct {
+	Trusted     bool            `json:"trusted"`
+	Stale       bool            `json:"stale"`
+	Subscribed  bool            `json:"subscribed"`
+	RefreshedAt time.Time       `json:"refreshedAt"`
+	Endpoints   map[string]bool `json:"endpoints"`
 }
 
 // NewMesosMonitor returns a new mesos.monitor object
@@ -35,52 +69,126 @@ func NewMesosMonitor(ctx *context.ApplicationContext) *MesosMonitor {
 			tasks:      map[string][]mesos.Task{},
 			frameworks: map[string]mesos.Framework{},
 			slaves:     map[string]mesos.Slave{},
+			endpoints:  map[string]bool{},
 		},
-		ctx: ctx,
+		inverseOffers: map[string]map[string]string{},
+		ctx:           ctx,
 	}
 }
 
-// Refresh updates the mesos cache
-func (m *MesosMonitor) Refresh() {
+// Refresh updates the mesos cache, unless it's kept up to date by the event stream. The inverse offer responses
+// are always read, as the event stream doesn't carry them
+func (m *MesosMonitor) Refresh(runCtx gocontext.Context) {
+
+	inverseOffers := m.getInverseOffers(runCtx)
+	m.mutex.Lock()
+	m.inverseOffers = inverseOffers
+	m.mutex.Unlock()
+
+	if m.isSubscribed() {
+		return
+	}
+	tasks, tasksErr := m.getTasks(runCtx)
+	frameworks, frameworksErr := m.getFrameworks(runCtx)
+	slaves, slavesErr := m.getSlaves(runCtx)
+	endpoints := map[string]bool{
+		mesosEndpointTasks:      tasksErr == nil,
+		mesosEndpointFrameworks: frameworksErr == nil,
+		mesosEndpointAgents:     slavesErr == nil,
+	}
 
-	m.mesosCache.tasks = m.getTasks()
-	m.mesosCache.frameworks = m.getProtectedFrameworks()
-	m.mesosCache.slaves = m.getSlaves()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.subscribed {
+		return
+	}
+	if tasksErr != nil || frameworksErr != nil || slavesErr != nil {
+		// Keep the last complete state, as a partial one would report the agents as unprotected
+		log.Warnf("Unable to refresh the Mesos state, it will not be trusted until the next refresh: %v", endpoints)
+		m.mesosCache.endpoints = endpoints
+		return
+	}
+	m.mesosCache = &mesosCache{
+		tasks:       tasks,
+		frameworks:  frameworks,
+		slaves:      slaves,
+		endpoints:   endpoints,
+		refreshedAt: m.ctx.Clock.Now(),
+	}
 }
 
-func (m *MesosMonitor) getProtectedFrameworks() map[string]mesos.Framework {
+// CacheStatus returns if the mesos cache can be trusted, and when it was last refreshed. While subscribed to the
+// event stream it's refreshed by every event, so a stream that stopped updating it gets stale too
+func (m *MesosMonitor) CacheStatus() MesosCacheStatus {
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	status := MesosCacheStatus{
+		Trusted:     true,
+		Subscribed:  m.subscribed,
+		RefreshedAt: m.mesosCache.refreshedAt,
+		Endpoints:   map[string]bool{},
+	}
+	for _, endpoint := range []string{mesosEndpointFrameworks, mesosEndpointAgents, mesosEndpointTasks} {
+		status.Endpoints[endpoint] = m.mesosCache.endpoints[endpoint]
+		status.Trusted = status.Trusted && m.mesosCache.endpoints[endpoint]
+	}
+	maxAge := time.Duration(m.ctx.Conf.MesosStateMaxAgeSeconds) * time.Second
+	if maxAge > 0 && !status.RefreshedAt.IsZero() && m.ctx.Clock.Since(status.RefreshedAt) > maxAge {
+		status.Stale = true
+		status.Trusted = false
+	}
+	return status
+}
+
+func (m *MesosMonitor) getFrameworks(runCtx gocontext.Context) (map[string]mesos.Framework, error) {
 
-	protectedFrameworksMap := map[string]mesos.Framework{}
-	response, err := m.ctx.MesosConn.GetMesosFrameworks()
+	frameworksMap := map[string]mesos.Framework{}
+	response, err := m.ctx.MesosConn.GetMesosFrameworks(runCtx)
 	if err != nil {
 		log.Warning(err)
-		return protectedFrameworksMap
+		return nil, err
 	}
 
 	for _, framework := range response.Frameworks {
-		for _, protectedFramework := range m.ctx.Conf.ProtectedFrameworks {
-			if protectedFramework == framework.Name {
-				protectedFrameworksMap[framework.ID] = framework
-			}
-		}
+		frameworksMap[framework.ID] = framework
 	}
-	return protectedFrameworksMap
+	return frameworksMap, nil
 }
 
-func (m *MesosMonitor) getSlaves() map[string]mesos.Slave {
+func (m *MesosMonitor) getSlaves(runCtx gocontext.Context) (map[string]mesos.Slave, error) {
 
 	slavesMap := map[string]mesos.Slave{}
-	response, err := m.ctx.MesosConn.GetMesosAgents()
+	response, err := m.ctx.MesosConn.GetMesosAgents(runCtx)
 	if err != nil {
 		log.Warning(err)
-		return slavesMap
+		return nil, err
 	}
 
 	for _, slave := range response.Slaves {
 		ipAddress := m.getAgentIPAddressFromPID(slave.Pid)
 		slavesMap[ipAddress] = slave
 	}
-	return slavesMap
+	return slavesMap, nil
+}
+
+func (m *MesosMonitor) getInverseOffers(runCtx gocontext.Context) map[string]map[string]string {
+
+	inverseOffers := map[string]map[string]string{}
+	status, err := m.ctx.MesosConn.GetMaintenanceStatus(runCtx)
+	if err != nil {
+		log.Warning(err)
+		return inverseOffers
+	}
+
+	for _, machine := range status.DrainingMachines {
+		responses := map[string]string{}
+		for _, response := range machine.Statuses {
+			responses[response.FrameworkID.Value] = response.Status
+		}
+		inverseOffers[machine.ID.Hostname] = responses
+	}
+	return inverseOffers
 }
 
 func (m *MesosMonitor) getAgentIPAddressFromPID(pid string) string {
@@ -89,10 +197,10 @@ func (m *MesosMonitor) getAgentIPAddressFromPID(pid string) string {
 	return strings.Split(tmp, ":")[0]
 }
 
-func (m *MesosMonitor) isTaskProtected(task mesos.Task) bool {
+func (m *MesosMonitor) isTaskProtected(task mesos.Task, protectedTasksLabels []string) bool {
 
 	for _, label := range task.Labels {
-		for _, protectedTasksLabel := range m.ctx.Conf.ProtectedTasksLabels {
+		for _, protectedTasksLabel := range protectedTasksLabels {
 			if label.Key == protectedTasksLabel && strings.ToUpper(label.Value) == "TRUE" {
 				return true
 			}
@@ -101,44 +209,180 @@ func (m *MesosMonitor) isTaskProtected(task mesos.Task) bool {
 	return false
 }
 
-func (m *MesosMonitor) getTasks() map[string][]mesos.Task {
+func (m *MesosMonitor) getTasks(runCtx gocontext.Context) (map[string][]mesos.Task, error) {
 
 	tasksMap := map[string][]mesos.Task{}
-	response, err := m.ctx.MesosConn.GetMesosTasks()
+	response, err := m.ctx.MesosConn.GetMesosTasks(runCtx)
 	if err != nil {
 		log.Warning(err)
-		return tasksMap
+		return nil, err
 	}
 
 	for _, task := range response.Tasks {
 		if task.State == "TASK_RUNNING" {
-			task.IsProtected = m.isTaskProtected(task)
 			tasksMap[task.SlaveID] = append(tasksMap[task.SlaveID], task)
 		}
 	}
-	return tasksMap
+	return tasksMap, nil
 }
 
-// SetMesosAgentsInMaintenance sets a list of mesos agents in Maintenance mode
-func (m *MesosMonitor) SetMesosAgentsInMaintenance(hosts map[string]string) error {
-	return m.ctx.MesosConn.SetHostsInMaintenance(hosts)
+// SetMesosAgentsInMaintenance adds the hosts to a deathnode maintenance window, one with only owned machines and
+// the same start as the given unavailability, or a new one with it. The other windows are left untouched, and hosts already scheduled in
+// any window stay there. It returns the hosts added to the schedule
+func (m *MesosMonitor) SetMesosAgentsInMaintenance(runCtx gocontext.Context, hosts map[string]string,
+	owned map[string]bool, unavailability mesos.MaintenanceUnavailability) (map[string]bool, error) {
+
+	if err := runCtx.Err(); err != nil {
+		return nil, err
+	}
+	schedule, err := m.ctx.MesosConn.GetMaintenanceSchedule(runCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduled := schedule.Machines()
+	missing := []mesos.MaintenanceMachinesID{}
+	for hostname, ip := range hosts {
+		if _, ok := scheduled[hostname]; !ok {
+			missing = append(missing, mesos.MaintenanceMachinesID{Hostname: hostname, IP: ip})
+		}
+	}
+	added := map[string]bool{}
+	if len(missing) == 0 {
+		return added, nil
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i].Hostname < missing[j].Hostname })
+
+	if window := deathnodeWindow(schedule, owned, unavailability.Start); window < 0 {
+		schedule.Windows = append(schedule.Windows, mesos.MaintenanceWindow{
+			MachinesIds:    missing,
+			Unavailability: unavailability,
+		})
+	} else {
+		schedule.Windows[window].MachinesIds = append(schedule.Windows[window].MachinesIds, missing...)
+	}
+
+	if err := m.ctx.MesosConn.UpdateMaintenanceSchedule(runCtx, schedule); err != nil {
+		return nil, err
+	}
+	for _, machine := range missing {
+		added[machine.Hostname] = true
+	}
+	return added, nil
 }
 
-func (m *MesosMonitor) isFromProtectedFramework(task mesos.Task) bool {
+// ReleaseMesosAgents prunes the machines from the maintenance schedule. The terminated ones are moved to down
+// and back up first, so the master forgets them
+func (m *MesosMonitor) ReleaseMesosAgents(runCtx gocontext.Context, machines map[string]string,
+	terminated map[string]bool) error {
+
+	if err := runCtx.Err(); err != nil {
+		return err
+	}
+	schedule, err := m.ctx.MesosConn.GetMaintenanceSchedule(runCtx)
+	if err != nil {
+		return err
+	}
+
+	scheduled := schedule.Machines()
+	released := map[string]bool{}
+	down := []mesos.MaintenanceMachinesID{}
+	for hostname := range machines {
+		machine, ok := scheduled[hostname]
+		if !ok {
+			continue
+		}
+		released[hostname] = true
+		if terminated[hostname] {
+			down = append(down, machine)
+		}
+	}
+	if len(released) == 0 {
+		return nil
+	}
+
+	if len(down) > 0 {
+		sort.Slice(down, func(i, j int) bool { return down[i].Hostname < down[j].Hostname })
+		// A machine already down fails to be moved down again, but can still be brought up
+		if err := m.ctx.MesosConn.StartMaintenance(runCtx, down); err != nil {
+			log.Warnf("Unable to move machines down: %s", err)
+		}
+		if err := m.ctx.MesosConn.StopMaintenance(runCtx, down); err != nil {
+			return err
+		}
+	}
+	return m.ctx.MesosConn.UpdateMaintenanceSchedule(runCtx, schedule.WithoutMachines(released))
+}
+
+// GetRegisteredAgents returns the agents registered in the master by IP, with their drain state. They are read
+// from the master on every call, as the event stream doesn't update the drain state
+func (m *MesosMonitor) GetRegisteredAgents(runCtx gocontext.Context) (map[string]mesos.Slave, error) {
+
+	response, err := m.ctx.MesosConn.GetMesosAgents(runCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	agents := map[string]mesos.Slave{}
+	for _, agent := range response.Slaves {
+		agents[m.getAgentIPAddressFromPID(agent.Pid)] = agent
+	}
+	return agents, nil
+}
+
+// DrainMesosAgent kills the tasks of the agent with DRAIN_AGENT and deactivates it
+func (m *MesosMonitor) DrainMesosAgent(runCtx gocontext.Context, agent mesos.Slave, maxGracePeriod time.Duration,
+	markGone bool) error {
+
+	if err := runCtx.Err(); err != nil {
+		return err
+	}
+	return m.ctx.MesosConn.DrainAgent(runCtx, agent.ID, maxGracePeriod, markGone)
+}
+
+// deathnodeWindow returns the index of the first window whose machines are all owned and that starts at start, or
+// -1 if there is none. Joining a window that already started, or that starts earlier, would cut the lead time the
+// frameworks get to move their tasks
+func deathnodeWindow(schedule *mesos.MaintenanceSchedule, owned map[string]bool, start mesos.MaintenanceStart) int {
+
+	for i, window := range schedule.Windows {
+		if len(window.MachinesIds) > 0 && window.Unavailability.Start == start && allOwned(window.MachinesIds, owned) {
+			return i
+		}
+	}
+	return -1
+}
+
+func allOwned(machines []mesos.MaintenanceMachinesID, owned map[string]bool) bool {
+
+	for _, machine := range machines {
+		if !owned[machine.Hostname] {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *MesosMonitor) isFromProtectedFramework(task mesos.Task, protectedFrameworks []string) bool {
 
 	framework, ok := m.mesosCache.frameworks[task.FrameworkID]
-	if ok {
-		log.Debugf("Framework %s is running on node %s, preventing Deathnode for killing it",
-			framework.Name, task.SlaveID)
-		return true
+	if !ok {
+		return false
 	}
 
+	for _, protectedFramework := range protectedFrameworks {
+		if protectedFramework == framework.Name {
+			log.Debugf("Framework %s is running on node %s, preventing Deathnode for killing it",
+				framework.Name, task.SlaveID)
+			return true
+		}
+	}
 	return false
 }
 
-func (m *MesosMonitor) hasProtectedLabel(task mesos.Task) bool {
+func (m *MesosMonitor) hasProtectedLabel(task mesos.Task, protectedTasksLabels []string) bool {
 
-	if task.IsProtected {
+	if m.isTaskProtected(task, protectedTasksLabels) {
 		log.Debugf("Protected task %s is running on node %s, preventing Deathnode for killing it",
 			task.Name, task.SlaveID)
 		return true
@@ -150,6 +394,8 @@ type taskEvaluate func(*MesosMonitor, mesos.Task) bool
 
 func (m *MesosMonitor) agentTaskEvaluation(ipAddress string, fn taskEvaluate) bool {
 
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	slaveID := m.mesosCache.slaves[ipAddress].ID
 	slaveTasks := m.mesosCache.tasks[slaveID]
 	for _, task := range slaveTasks {
@@ -177,10 +423,82 @@ func (m *MesosMonitor) HasFrameworks(ipAddress, framework string) bool {
 	})
 }
 
-// IsProtected returns true if the mesos agent has any protected condition.
-func (m *MesosMonitor) IsProtected(ipAddress string) bool {
+// GetInverseOfferStatuses returns the responses of the frameworks to the inverse offers of the agent, by framework
+// ID. It returns false if the agent is not draining in a maintenance window
+func (m *MesosMonitor) GetInverseOfferStatuses(ipAddress string) (map[string]string, bool) {
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	responses, ok := m.inverseOffers[m.mesosCache.slaves[ipAddress].Hostname]
+	return responses, ok
+}
+
+// HasAcceptedInverseOffers returns true if the agent is draining in a maintenance window, and every framework
+// running tasks in it has accepted its inverse offers
+func (m *MesosMonitor) HasAcceptedInverseOffers(ipAddress string) bool {
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	slave := m.mesosCache.slaves[ipAddress]
+	responses, ok := m.inverseOffers[slave.Hostname]
+	if !ok {
+		return false
+	}
+	for _, task := range m.mesosCache.tasks[slave.ID] {
+		if responses[task.FrameworkID] != mesos.InverseOfferAccept {
+			return false
+		}
+	}
+	return true
+}
+
+// ProtectingTask is a task preventing a mesos agent to be destroyed, either because it's from a protected framework
+// or because it has a protected label
+type ProtectingTask struct {
+	Name      string `json:"name"`
+	Framework string `json:"framework,omitempty"`
+	Label     string `json:"label,omitempty"`
+}
+
+// GetProtectingTasks returns the tasks running in the mesos agent that are protected, given the policy of its
+// autoscaling group
+func (m *MesosMonitor) GetProtectingTasks(ipAddress string, policy context.Policy) []ProtectingTask {
+
+	protectingTasks := []ProtectingTask{}
+	m.agentTaskEvaluation(ipAddress, func(m *MesosMonitor, task mesos.Task) bool {
+		protectingTask := ProtectingTask{Name: task.Name}
+		if framework, ok := m.mesosCache.frameworks[task.FrameworkID]; ok && contains(policy.ProtectedFrameworks, framework.Name) {
+			protectingTask.Framework = framework.Name
+		}
+		for _, label := range task.Labels {
+			if contains(policy.ProtectedTasksLabels, label.Key) && strings.ToUpper(label.Value) == "TRUE" {
+				protectingTask.Label = label.Key
+			}
+		}
+		if protectingTask.Framework != "" || protectingTask.Label != "" {
+			protectingTasks = append(protectingTasks, protectingTask)
+		}
+		return false
+	})
+	return protectingTasks
+}
+
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// IsProtected returns true if the mesos agent has any protected condition, given the policy of its
+// autoscaling group
+func (m *MesosMonitor) IsProtected(ipAddress string, policy context.Policy) bool {
 
 	return m.agentTaskEvaluation(ipAddress, func(m *MesosMonitor, task mesos.Task) bool {
-		return m.hasProtectedLabel(task) || m.isFromProtectedFramework(task)
+		return m.hasProtectedLabel(task, policy.ProtectedTasksLabels) ||
+			m.isFromProtectedFramework(task, policy.ProtectedFrameworks)
 	})
 }
@@ -0,0 +1,196 @@
+package monitor
+
+// Keeps the mesos cache up to date with the event stream of the Mesos master, instead of downloading every task,
+// framework and agent on each run
+
+import (
+	gocontext "context"
+	"fmt"
+	"github.com/alanbover/deathnode/mesos"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"time"
+)
+
+// mesosResubscribeInterval is the wait before subscribing again after the event stream fails
+const mesosResubscribeInterval = 5 * time.Second
+
+// terminalTaskStates are the states of the tasks that won't run again
+var terminalTaskStates = map[string]bool{
+	"TASK_FINISHED":         true,
+	"TASK_FAILED":           true,
+	"TASK_KILLED":           true,
+	"TASK_ERROR":            true,
+	"TASK_LOST":             true,
+	"TASK_DROPPED":          true,
+	"TASK_GONE":             true,
+	"TASK_GONE_BY_OPERATOR": true,
+}
+
+// FollowEvents keeps the cache up to date with the event stream of the Mesos master until ctx is done. When the
+// stream fails it's subscribed again, rebuilding the cache from the new state. In the meantime Refresh polls the
+// master
+func (m *MesosMonitor) FollowEvents(ctx gocontext.Context) {
+
+	for {
+		err := m.ctx.MesosEvents.Subscribe(ctx, m.applyEvent)
+		m.unsubscribe()
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warnf("Mesos event stream interrupted, subscribing again in %s: %s", mesosResubscribeInterval, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.ctx.Clock.After(mesosResubscribeInterval):
+		}
+	}
+}
+
+func (m *MesosMonitor) isSubscribed() bool {
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.subscribed
+}
+
+func (m *MesosMonitor) unsubscribe() {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.subscribed = false
+	m.streamTasks = nil
+}
+
+// applyEvent updates the cache with an event of the stream. The SUBSCRIBED event replaces it with the state of
+// the cluster
+func (m *MesosMonitor) applyEvent(event *mesos.Event) error {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if event.Type == "SUBSCRIBED" {
+		m.resync(event)
+		return nil
+	}
+	if !m.subscribed {
+		return nil
+	}
+	// Every event, heartbeats included, means the cache is up to date
+	m.mesosCache.refreshedAt = m.ctx.Clock.Now()
+
+	switch event.Type {
+	case "TASK_ADDED", "TASK_UPDATED":
+		if err := m.updateStreamTask(event.Type, *event.Task); err != nil {
+			// Without its labels the task can't be told apart from an unprotected one, so the master is polled
+			// until the subscription is done again, rebuilding the cache
+			m.subscribed = false
+			m.streamTasks = nil
+			m.mesosCache.endpoints[mesosEndpointTasks] = false
+			return err
+		}
+	case "AGENT_ADDED":
+		m.mesosCache.slaves[m.getAgentIPAddressFromPID(event.Agent.Pid)] = *event.Agent
+	case "AGENT_REMOVED":
+		for ipAddress, slave := range m.mesosCache.slaves {
+			if slave.ID == event.Agent.ID {
+				delete(m.mesosCache.slaves, ipAddress)
+			}
+		}
+		delete(m.streamTasks, event.Agent.ID)
+		delete(m.mesosCache.tasks, event.Agent.ID)
+	case "FRAMEWORK_ADDED", "FRAMEWORK_UPDATED":
+		m.mesosCache.frameworks[event.Framework.ID] = *event.Framework
+	case "FRAMEWORK_REMOVED":
+		delete(m.mesosCache.frameworks, event.Framework.ID)
+	}
+	return nil
+}
+
+func (m *MesosMonitor) resync(event *mesos.Event) {
+
+	cache := &mesosCache{
+		tasks:      map[string][]mesos.Task{},
+		frameworks: map[string]mesos.Framework{},
+		slaves:     map[string]mesos.Slave{},
+		endpoints: map[string]bool{
+			mesosEndpointFrameworks: true,
+			mesosEndpointAgents:     true,
+			mesosEndpointTasks:      true,
+		},
+		refreshedAt: m.ctx.Clock.Now(),
+	}
+	for _, framework := range event.Frameworks.Frameworks {
+		cache.frameworks[framework.ID] = framework
+	}
+	for _, slave := range event.Agents.Slaves {
+		cache.slaves[m.getAgentIPAddressFromPID(slave.Pid)] = slave
+	}
+
+	m.mesosCache = cache
+	m.streamTasks = map[string]map[string]mesos.Task{}
+	for _, task := range event.Tasks.Tasks {
+		if !terminalTaskStates[task.State] {
+			m.setStreamTask(task)
+		}
+	}
+	for agentID := range m.streamTasks {
+		m.updateAgentTasks(agentID)
+	}
+	m.subscribed = true
+
+	log.Infof("Subscribed to the Mesos event stream: %d agents, %d frameworks and %d tasks",
+		len(event.Agents.Slaves), len(event.Frameworks.Frameworks), len(event.Tasks.Tasks))
+}
+
+// updateStreamTask adds a task, or updates its state. The task updates only carry the ID, agent, framework,
+// state and latest status of the task, so an update of a task not running yet in the cache fails
+func (m *MesosMonitor) updateStreamTask(eventType string, task mesos.Task) error {
+
+	if eventType == "TASK_UPDATED" {
+		existing, ok := m.streamTasks[task.SlaveID][task.ID]
+		if !ok && terminalTaskStates[task.State] {
+			return nil
+		}
+		if !ok {
+			return fmt.Errorf("Update of unknown task %s found in the Mesos event stream", task.ID)
+		}
+		existing.State = task.State
+		existing.Statuses = append(existing.Statuses, task.Statuses...)
+		task = existing
+	}
+
+	if terminalTaskStates[task.State] {
+		delete(m.streamTasks[task.SlaveID], task.ID)
+	} else {
+		m.setStreamTask(task)
+	}
+	m.updateAgentTasks(task.SlaveID)
+	return nil
+}
+
+func (m *MesosMonitor) setStreamTask(task mesos.Task) {
+
+	if _, ok := m.streamTasks[task.SlaveID]; !ok {
+		m.streamTasks[task.SlaveID] = map[string]mesos.Task{}
+	}
+	m.streamTasks[task.SlaveID][task.ID] = task
+}
+
+// updateAgentTasks rebuilds the running tasks of the agent in the cache from the tasks of the stream
+func (m *MesosMonitor) updateAgentTasks(agentID string) {
+
+	running := []mesos.Task{}
+	for _, task := range m.streamTasks[agentID] {
+		if task.State == "TASK_RUNNING" {
+			running = append(running, task)
+		}
+	}
+	if len(running) == 0 {
+		delete(m.mesosCache.tasks, agentID)
+		return
+	}
+	sort.Slice(running, func(i, j int) bool { return running[i].ID < running[j].ID })
+	m.mesosCache.tasks[agentID] = running
+}
@@ -0,0 +1,177 @@
+package monitor
+
+import (
+	gocontext "context"
+	"fmt"
+	"github.com/alanbover/deathnode/mesos"
+	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestApplyMesosEvents(t *testing.T) {
+
+	Convey("When the mesos cache is kept up to date by the event stream", t, func() {
+		monitor := createTestMesosMonitor("frameworkName1", "DEATHNODE_PROTECTED")
+		policy := monitor.ctx.Conf.Policy("")
+		monitor.applyEvent(subscribedEvent())
+
+		Convey("the cache should be built from the state of the cluster", func() {
+			So(monitor.IsProtected("10.0.0.2", policy), ShouldBeFalse)
+			So(monitor.IsProtected("10.0.0.3", policy), ShouldBeTrue)
+		})
+		Convey("refreshing should not poll the master", func() {
+			monitor.Refresh(gocontext.Background())
+			So(monitor.IsProtected("10.0.0.2", policy), ShouldBeFalse)
+		})
+		Convey("a task started on an agent should protect it", func() {
+			monitor.applyEvent(&mesos.Event{Type: "TASK_ADDED", Task: &mesos.Task{
+				ID: "task1.id", Name: "task1", State: "TASK_STAGING", SlaveID: "mesosslave1", FrameworkID: "frameworkId1"}})
+			So(monitor.IsProtected("10.0.0.2", policy), ShouldBeFalse)
+
+			monitor.applyEvent(taskUpdatedEvent("task1.id", "mesosslave1", "TASK_RUNNING"))
+			So(monitor.IsProtected("10.0.0.2", policy), ShouldBeTrue)
+			So(monitor.HasTaskNameMatchRegexp("10.0.0.2", "^task1$"), ShouldBeTrue)
+
+			Convey("and stop protecting it when it finishes", func() {
+				monitor.applyEvent(taskUpdatedEvent("task1.id", "mesosslave1", "TASK_FINISHED"))
+				So(monitor.IsProtected("10.0.0.2", policy), ShouldBeFalse)
+			})
+		})
+		Convey("an update of an unknown task should stop trusting the cache until it's polled", func() {
+			err := monitor.applyEvent(taskUpdatedEvent("task4.id", "mesosslave1", "TASK_RUNNING"))
+			So(err, ShouldNotBeNil)
+			So(monitor.isSubscribed(), ShouldBeFalse)
+			So(monitor.CacheStatus().Trusted, ShouldBeFalse)
+			So(monitor.IsProtected("10.0.0.2", policy), ShouldBeFalse)
+
+			monitor.Refresh(gocontext.Background())
+			So(monitor.CacheStatus().Trusted, ShouldBeTrue)
+			So(monitor.IsProtected("10.0.0.2", policy), ShouldBeTrue)
+		})
+		Convey("an update of an unknown task already finished should be ignored", func() {
+			So(monitor.applyEvent(taskUpdatedEvent("task4.id", "mesosslave1", "TASK_FINISHED")), ShouldBeNil)
+			So(monitor.isSubscribed(), ShouldBeTrue)
+		})
+		Convey("the tasks of a removed agent should be forgotten", func() {
+			monitor.applyEvent(&mesos.Event{Type: "AGENT_REMOVED", Agent: &mesos.Slave{ID: "mesosslave2"}})
+			So(monitor.IsProtected("10.0.0.3", policy), ShouldBeFalse)
+
+			Convey("and an agent added should be known", func() {
+				monitor.applyEvent(&mesos.Event{Type: "AGENT_ADDED", Agent: &mesos.Slave{
+					ID: "mesosslave4", Pid: "slave(1)@10.0.0.5:5051", Hostname: "mesosslave4hostname"}})
+				monitor.applyEvent(&mesos.Event{Type: "TASK_ADDED", Task: &mesos.Task{
+					ID: "task4.id", Name: "task4", State: "TASK_RUNNING", SlaveID: "mesosslave4", FrameworkID: "frameworkId1"}})
+				So(monitor.IsProtected("10.0.0.5", policy), ShouldBeTrue)
+			})
+		})
+		Convey("the tasks of a removed framework should not be protected by it", func() {
+			monitor.applyEvent(&mesos.Event{Type: "FRAMEWORK_REMOVED", Framework: &mesos.Framework{ID: "frameworkId1"}})
+			So(monitor.IsProtected("10.0.0.3", policy), ShouldBeFalse)
+
+			Convey("until it's added again", func() {
+				monitor.applyEvent(&mesos.Event{Type: "FRAMEWORK_ADDED", Framework: &mesos.Framework{
+					ID: "frameworkId1", Name: "frameworkName1", Active: true}})
+				So(monitor.IsProtected("10.0.0.3", policy), ShouldBeTrue)
+			})
+		})
+		Convey("the master should be polled again once the stream is interrupted", func() {
+			monitor.unsubscribe()
+			monitor.Refresh(gocontext.Background())
+			So(monitor.IsProtected("10.0.0.2", policy), ShouldBeTrue)
+		})
+	})
+}
+
+func TestFollowMesosEvents(t *testing.T) {
+
+	Convey("When the event stream fails", t, func() {
+		subscriber := &fakeSubscriber{subscriptions: make(chan []*mesos.Event)}
+		monitor := createTestMesosMonitor("frameworkName1", "")
+		monitor.ctx.MesosEvents = subscriber
+		mockClock := clock.NewMock()
+		monitor.ctx.Clock = mockClock
+		policy := monitor.ctx.Conf.Policy("")
+
+		ctx, cancel := gocontext.WithCancel(gocontext.Background())
+		stopped := make(chan struct{})
+		go func() {
+			monitor.FollowEvents(ctx)
+			close(stopped)
+		}()
+		subscriber.subscriptions <- []*mesos.Event{subscribedEvent()}
+
+		Convey("it should be subscribed again, rebuilding the cache", func() {
+			subscriber.resubscribe(mockClock, []*mesos.Event{
+				subscribedEvent(), taskUpdatedEvent("task2.id", "mesosslave2", "TASK_KILLED")})
+			subscriber.resubscribe(mockClock, nil)
+			So(monitor.IsProtected("10.0.0.3", policy), ShouldBeFalse)
+			So(subscriber.calls, ShouldEqual, 3)
+		})
+
+		Reset(func() {
+			cancel()
+			<-stopped
+		})
+	})
+}
+
+// fakeSubscriber sends every subscription received in subscriptions, and fails once they are sent
+type fakeSubscriber struct {
+	subscriptions chan []*mesos.Event
+	calls         int
+}
+
+func (s *fakeSubscriber) Subscribe(ctx gocontext.Context, handle func(event *mesos.Event) error) error {
+
+	s.calls++
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case events := <-s.subscriptions:
+		for _, event := range events {
+			handle(event)
+		}
+	}
+	return fmt.Errorf("stream closed")
+}
+
+// resubscribe sends the events once the monitor subscribes again, advancing the clock until it does
+func (s *fakeSubscriber) resubscribe(mockClock *clock.Mock, events []*mesos.Event) {
+
+	for {
+		select {
+		case s.subscriptions <- events:
+			return
+		case <-time.After(time.Millisecond):
+			mockClock.Add(mesosResubscribeInterval)
+		}
+	}
+}
+
+// subscribedEvent returns the state of a cluster with a task from frameworkName1 running in mesosslave2
+func subscribedEvent() *mesos.Event {
+
+	return &mesos.Event{
+		Type: "SUBSCRIBED",
+		Tasks: &mesos.TasksResponse{Tasks: []mesos.Task{
+			{ID: "task2.id", Name: "task2", State: "TASK_RUNNING", SlaveID: "mesosslave2", FrameworkID: "frameworkId1"},
+			{ID: "task3.id", Name: "task3", State: "TASK_FINISHED", SlaveID: "mesosslave1", FrameworkID: "frameworkId1"},
+		}},
+		Frameworks: &mesos.FrameworksResponse{Frameworks: []mesos.Framework{
+			{ID: "frameworkId1", Name: "frameworkName1", Active: true},
+		}},
+		Agents: &mesos.SlavesResponse{Slaves: []mesos.Slave{
+			{ID: "mesosslave1", Pid: "slave(1)@10.0.0.2:5051", Hostname: "mesosslave1hostname"},
+			{ID: "mesosslave2", Pid: "slave(1)@10.0.0.3:5051", Hostname: "mesosslave2hostname"},
+		}},
+	}
+}
+
+func taskUpdatedEvent(taskID, agentID, state string) *mesos.Event {
+
+	return &mesos.Event{Type: "TASK_UPDATED", Task: &mesos.Task{
+		ID: taskID, SlaveID: agentID, FrameworkID: "frameworkId1", State: state,
+		Statuses: []mesos.Status{{State: state}}}}
+}
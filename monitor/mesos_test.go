@@ -1,10 +1,11 @@
 package monitor
 
 import (
-	"encoding/json"
+	gocontext "context"
 	"fmt"
 	"github.com/alanbover/deathnode/context"
 	"github.com/alanbover/deathnode/mesos"
+	"github.com/benbjohnson/clock"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
 )
@@ -14,9 +15,10 @@ func TestGetMesosFrameworks(t *testing.T) {
 	Convey("When creating a new mesos monitor", t, func() {
 		monitor := createTestMesosMonitor("frameworkName1", "")
 
-		Convey("getProtectedFrameworks should return only the ones that match the protected frameworks", func() {
-			frameworks := monitor.getProtectedFrameworks()
-			So(len(frameworks), ShouldEqual, 1)
+		Convey("getFrameworks should return all the registered frameworks", func() {
+			frameworks, err := monitor.getFrameworks(gocontext.Background())
+			So(err, ShouldBeNil)
+			So(len(frameworks), ShouldEqual, 3)
 			So(frameworks, ShouldContainKey, "frameworkId1")
 		})
 	})
@@ -27,33 +29,68 @@ func TestIsProtected(t *testing.T) {
 	Convey("when calling IsProtected", t, func() {
 		Convey("when checking protected labels", func() {
 			monitor := createTestMesosMonitor("", "DEATHNODE_PROTECTED")
-			monitor.Refresh()
+			monitor.Refresh(gocontext.Background())
 			Convey("true if a node have tasks running from protected labels", func() {
-				So(monitor.IsProtected("10.0.0.2"), ShouldBeTrue)
+				So(monitor.IsProtected("10.0.0.2", monitor.ctx.Conf.Policy("")), ShouldBeTrue)
 			})
 			Convey("false if a node doesn't have tasks running from protected labels", func() {
-				So(monitor.IsProtected("10.0.0.4"), ShouldBeFalse)
+				So(monitor.IsProtected("10.0.0.4", monitor.ctx.Conf.Policy("")), ShouldBeFalse)
 			})
 		})
 		Convey("when checking protected frameworks", func() {
 			monitor := createTestMesosMonitor("frameworkName1", "")
-			monitor.Refresh()
+			monitor.Refresh(gocontext.Background())
 			Convey("true if a node have tasks running from protected frameworks", func() {
-				So(monitor.IsProtected("10.0.0.2"), ShouldBeTrue)
+				So(monitor.IsProtected("10.0.0.2", monitor.ctx.Conf.Policy("")), ShouldBeTrue)
 			})
 			Convey("false if a node doesn't have tasks running from protected frameworks", func() {
-				So(monitor.IsProtected("10.0.0.4"), ShouldBeFalse)
+				So(monitor.IsProtected("10.0.0.4", monitor.ctx.Conf.Policy("")), ShouldBeFalse)
 			})
 		})
 	})
 }
 
+func TestIsProtectedWithPolicyOverrides(t *testing.T) {
+
+	Convey("when calling IsProtected for an autoscaling group prefix with overrides", t, func() {
+		monitor := createTestMesosMonitor("frameworkName1", "")
+		monitor.ctx.Conf.PolicyOverrides = map[string]context.PolicyOverride{
+			"stateless": {ProtectedFrameworks: []string{}},
+		}
+		monitor.Refresh(gocontext.Background())
+		Convey("the global protected frameworks should apply to the rest of prefixes", func() {
+			So(monitor.IsProtected("10.0.0.2", monitor.ctx.Conf.Policy("stateful")), ShouldBeTrue)
+		})
+		Convey("the overridden protected frameworks should apply to the prefix", func() {
+			So(monitor.IsProtected("10.0.0.2", monitor.ctx.Conf.Policy("stateless")), ShouldBeFalse)
+		})
+	})
+}
+
+func TestGetProtectingTasks(t *testing.T) {
+
+	Convey("when calling GetProtectingTasks", t, func() {
+		monitor := createTestMesosMonitor("frameworkName1", "DEATHNODE_PROTECTED")
+		monitor.Refresh(gocontext.Background())
+		Convey("it should return the tasks from protected frameworks or with protected labels", func() {
+			protectingTasks := monitor.GetProtectingTasks("10.0.0.2", monitor.ctx.Conf.Policy(""))
+			So(protectingTasks, ShouldNotBeEmpty)
+			for _, task := range protectingTasks {
+				So(task.Framework != "" || task.Label != "", ShouldBeTrue)
+			}
+		})
+		Convey("it should return nothing for agents without protected tasks", func() {
+			So(monitor.GetProtectingTasks("10.0.0.4", monitor.ctx.Conf.Policy("")), ShouldBeEmpty)
+		})
+	})
+}
+
 func TestHasFrameworks(t *testing.T) {
 
 	Convey("when calling HasFrameworks", t, func() {
 		Convey("when checking protected labels it should return", func() {
 			monitor := createTestMesosMonitor("frameworkName1", "")
-			monitor.Refresh()
+			monitor.Refresh(gocontext.Background())
 			Convey("true if a node have tasks running from the selected framework", func() {
 				So(monitor.HasFrameworks("10.0.0.2", "frameworkName1"), ShouldBeTrue)
 			})
@@ -69,7 +106,7 @@ func TestHasTaskNameMatchRegexp(t *testing.T) {
 	Convey("when calling HasTaskNameMatchRegexp", t, func() {
 		Convey("when checking task name match regexp it should return", func() {
 			monitor := createTestMesosMonitor("", "")
-			monitor.Refresh()
+			monitor.Refresh(gocontext.Background())
 			Convey("true if a node have tasks running matching the regexp", func() {
 				So(monitor.HasTaskNameMatchRegexp("10.0.0.2", "tas.*"), ShouldBeTrue)
 			})
@@ -81,33 +118,158 @@ func TestHasTaskNameMatchRegexp(t *testing.T) {
 }
 
 func TestSetMesosAgentsInMaintenance(t *testing.T) {
-	Convey("When generating the payload for a maintenance call", t, func() {
+
+	Convey("When setting agents in maintenance", t, func() {
 		mesosConn := &mesos.ClientMock{
-			Records: map[string]*[]string{},
+			Records: map[string]*[]string{
+				"GetMaintenanceSchedule": {"maintenance"},
+			},
 		}
-		templateJSON := mesos.MaintenanceRequest{}
-		var testValues = []struct {
-			hosts map[string]string
-			num   int
-		}{
-			{map[string]string{}, 0},
-			{map[string]string{"hostname1": "10.0.0.1"}, 1},
-			{map[string]string{"hostname1": "10.0.0.1", "hostname2": "10.0.0.2"}, 2},
+		monitor := NewMesosMonitor(&context.ApplicationContext{MesosConn: mesosConn})
+		unavailability := mesos.MaintenanceUnavailability{Start: mesos.MaintenanceStart{Nanoseconds: 1}}
+
+		Convey("new hosts should be added to the deathnode window, leaving the other windows untouched", func() {
+			added, err := monitor.SetMesosAgentsInMaintenance(gocontext.Background(),
+				map[string]string{"mesosslave1hostname": "10.0.0.2", "mesosslave2hostname": "10.0.0.3"},
+				map[string]bool{"mesosslave1hostname": true}, unavailability)
+			So(err, ShouldBeNil)
+			So(added, ShouldResemble, map[string]bool{"mesosslave2hostname": true})
+			So(*mesosConn.Requests["UpdateMaintenanceSchedule"], ShouldResemble,
+				[]string{"operatorhostname", "mesosslave1hostname,mesosslave2hostname"})
+		})
+		Convey("new hosts should get their own window if deathnode doesn't own any", func() {
+			_, err := monitor.SetMesosAgentsInMaintenance(gocontext.Background(),
+				map[string]string{"mesosslave2hostname": "10.0.0.3"}, map[string]bool{}, unavailability)
+			So(err, ShouldBeNil)
+			So(*mesosConn.Requests["UpdateMaintenanceSchedule"], ShouldResemble,
+				[]string{"operatorhostname", "mesosslave1hostname", "mesosslave2hostname"})
+		})
+		Convey("new hosts should get a new window once the deathnode window has started", func() {
+			later := mesos.MaintenanceUnavailability{
+				Start:    mesos.MaintenanceStart{Nanoseconds: 1500000600000000000},
+				Duration: &mesos.MaintenanceDuration{Nanoseconds: 3600000000000},
+			}
+			_, err := monitor.SetMesosAgentsInMaintenance(gocontext.Background(),
+				map[string]string{"mesosslave2hostname": "10.0.0.3"}, map[string]bool{"mesosslave1hostname": true}, later)
+			So(err, ShouldBeNil)
+			So(*mesosConn.Requests["UpdateMaintenanceSchedule"], ShouldResemble,
+				[]string{"operatorhostname", "mesosslave1hostname", "mesosslave2hostname"})
+		})
+
+		Convey("hosts already scheduled by an operator should stay in their window", func() {
+			added, err := monitor.SetMesosAgentsInMaintenance(gocontext.Background(),
+				map[string]string{"operatorhostname": "10.0.1.1"}, map[string]bool{}, unavailability)
+			So(err, ShouldBeNil)
+			So(added, ShouldBeEmpty)
+			So(mesosConn.Requests["UpdateMaintenanceSchedule"], ShouldBeNil)
+		})
+	})
+}
+
+func TestReleaseMesosAgents(t *testing.T) {
+
+	Convey("When releasing agents from maintenance", t, func() {
+		mesosConn := &mesos.ClientMock{
+			Records: map[string]*[]string{
+				"GetMaintenanceSchedule": {"maintenance"},
+			},
 		}
+		monitor := NewMesosMonitor(&context.ApplicationContext{MesosConn: mesosConn})
+
+		Convey("a terminated agent should be moved down and up, and pruned from the schedule", func() {
+			err := monitor.ReleaseMesosAgents(gocontext.Background(),
+				map[string]string{"mesosslave1hostname": "10.0.0.2"}, map[string]bool{"mesosslave1hostname": true})
+			So(err, ShouldBeNil)
+			So(*mesosConn.Requests["StartMaintenance"], ShouldResemble, []string{"mesosslave1hostname"})
+			So(*mesosConn.Requests["StopMaintenance"], ShouldResemble, []string{"mesosslave1hostname"})
+			So(*mesosConn.Requests["UpdateMaintenanceSchedule"], ShouldResemble, []string{"operatorhostname"})
+		})
+		Convey("an unmarked agent should only be pruned from the schedule", func() {
+			err := monitor.ReleaseMesosAgents(gocontext.Background(),
+				map[string]string{"mesosslave1hostname": "10.0.0.2"}, map[string]bool{})
+			So(err, ShouldBeNil)
+			So(mesosConn.Requests["StartMaintenance"], ShouldBeNil)
+			So(*mesosConn.Requests["UpdateMaintenanceSchedule"], ShouldResemble, []string{"operatorhostname"})
+		})
+		Convey("an agent not in the schedule anymore should be ignored", func() {
+			err := monitor.ReleaseMesosAgents(gocontext.Background(),
+				map[string]string{"mesosslave3hostname": "10.0.0.4"}, map[string]bool{"mesosslave3hostname": true})
+			So(err, ShouldBeNil)
+			So(mesosConn.Requests, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestMesosCacheStatus(t *testing.T) {
+
+	Convey("When refreshing the mesos cache", t, func() {
+		monitor := createTestMesosMonitor("frameworkName1", "")
+		mesosConn := monitor.ctx.MesosConn.(*mesos.ClientMock)
+		mesosConn.Records["GetMesosFrameworks"] = &[]string{"default", "default", "default"}
+		mesosConn.Records["GetMesosSlaves"] = &[]string{"default", "default", "default"}
+		mesosConn.Records["GetMesosTasks"] = &[]string{"default", "default"}
+
+		Convey("it should not be trusted before the first refresh", func() {
+			So(monitor.CacheStatus().Trusted, ShouldBeFalse)
+		})
+		Convey("it should be trusted once every endpoint was read", func() {
+			monitor.Refresh(gocontext.Background())
+			status := monitor.CacheStatus()
+			So(status.Trusted, ShouldBeTrue)
+			So(status.RefreshedAt.IsZero(), ShouldBeFalse)
+			So(status.Endpoints, ShouldResemble, map[string]bool{"frameworks": true, "agents": true, "tasks": true})
 
-		for _, testValue := range testValues {
-			Convey(fmt.Sprintf("it should be possible to configure for %v agents", testValue.num), func() {
-				template := mesosConn.GenMaintenanceCallPayload(testValue.hosts)
-				json.Unmarshal(template, &templateJSON)
-				So(len(templateJSON.Windows[0].MachinesIds), ShouldEqual, testValue.num)
+			Convey("and untrusted after a partial refresh, keeping the last complete state", func() {
+				refreshedAt := status.RefreshedAt
+				mesosConn.Errors = map[string]error{"GetMesosTasks": fmt.Errorf("connection refused")}
+				monitor.Refresh(gocontext.Background())
+				status := monitor.CacheStatus()
+				So(status.Trusted, ShouldBeFalse)
+				So(status.RefreshedAt.Equal(refreshedAt), ShouldBeTrue)
+				So(status.Endpoints, ShouldResemble, map[string]bool{"frameworks": true, "agents": true, "tasks": false})
+				So(monitor.IsProtected("10.0.0.2", monitor.ctx.Conf.Policy("")), ShouldBeTrue)
+
+				Convey("and trusted again once every endpoint is read", func() {
+					mesosConn.Errors = nil
+					monitor.Refresh(gocontext.Background())
+					So(monitor.CacheStatus().Trusted, ShouldBeTrue)
+				})
 			})
-		}
+		})
+	})
+}
+
+func TestInverseOffers(t *testing.T) {
+
+	Convey("When the agents are draining in a maintenance window", t, func() {
+		monitor := createTestMesosMonitor("frameworkName1", "")
+		monitor.ctx.MesosConn.(*mesos.ClientMock).Records["GetMaintenanceStatus"] = &[]string{"inverseoffers"}
+		monitor.Refresh(gocontext.Background())
+
+		Convey("the responses of the frameworks should be returned by agent", func() {
+			responses, draining := monitor.GetInverseOfferStatuses("10.0.0.2")
+			So(draining, ShouldBeTrue)
+			So(responses, ShouldResemble, map[string]string{
+				"frameworkId1": mesos.InverseOfferAccept, "frameworkId2": mesos.InverseOfferDecline})
+		})
+		Convey("an agent should be accepted once every framework running tasks in it accepted", func() {
+			So(monitor.HasAcceptedInverseOffers("10.0.0.2"), ShouldBeTrue)
+		})
+		Convey("an agent should not be accepted if a framework running tasks in it declined", func() {
+			So(monitor.HasAcceptedInverseOffers("10.0.0.3"), ShouldBeFalse)
+		})
+		Convey("an agent not draining should not be accepted", func() {
+			_, draining := monitor.GetInverseOfferStatuses("10.0.0.4")
+			So(draining, ShouldBeFalse)
+			So(monitor.HasAcceptedInverseOffers("10.0.0.4"), ShouldBeFalse)
+		})
 	})
 }
 
 func createTestMesosMonitor(protectedFramework string, protectedTasksLabels string) *MesosMonitor {
 
 	ctx := &context.ApplicationContext{
+		Clock: clock.New(),
 		MesosConn: &mesos.ClientMock{
 			Records: map[string]*[]string{
 				"GetMesosFrameworks": {"default"},
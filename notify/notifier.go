@@ -0,0 +1,243 @@
+package notify
+
+// Sends the drain lifecycle events to webhooks, retrying with backoff and keeping the undelivered ones in a
+// dead-letter log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/benbjohnson/clock"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Types of the drain lifecycle events
+const (
+	DrainStarted       = "DRAIN_STARTED"
+	DrainStuck         = "DRAIN_STUCK"
+	DrainCancelled     = "DRAIN_CANCELLED"
+	DrainEscalated     = "DRAIN_ESCALATED"
+	InstanceTerminated = "INSTANCE_TERMINATED"
+)
+
+const (
+	queueSize             = 100
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = 30 * time.Second
+	requestTimeout        = 10 * time.Second
+)
+
+func isEventType(eventType string) bool {
+	switch eventType {
+	case DrainStarted, DrainStuck, DrainCancelled, DrainEscalated, InstanceTerminated:
+		return true
+	}
+	return false
+}
+
+// Event is a drain lifecycle event of an instance
+type Event struct {
+	Type             string    `json:"type"`
+	Timestamp        time.Time `json:"timestamp"`
+	AutoscalingGroup string    `json:"autoscalingGroup"`
+	InstanceID       string    `json:"instanceId"`
+	IP               string    `json:"ip"`
+	DrainingSeconds  float64   `json:"drainingSeconds"`
+}
+
+// deadLetter is an event that couldn't be delivered to a webhook
+type deadLetter struct {
+	Timestamp time.Time `json:"timestamp"`
+	URL       string    `json:"url"`
+	Event     Event     `json:"event"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error"`
+}
+
+type delivery struct {
+	webhook Webhook
+	event   Event
+}
+
+// Notifier delivers the events to the webhooks in background. A nil Notifier discards all the events
+type Notifier struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	clock      clock.Clock
+	httpClient *http.Client
+	deadLetter io.Writer
+	// deadLetterMutex serializes the dead letters written from Notify and from the delivery goroutine
+	deadLetterMutex sync.Mutex
+	mutex           sync.Mutex
+	webhooks        []Webhook
+	closed          bool
+	queue           chan delivery
+	done            chan struct{}
+}
+
+// NewNotifier returns a new Notifier object and starts delivering events. Undelivered events are written as
+// JSON lines to deadLetter, or logged if it's nil
+func NewNotifier(webhooks []Webhook, deadLetter io.Writer, clock clock.Clock) *Notifier {
+
+	notifier := &Notifier{
+		MaxAttempts:    defaultMaxAttempts,
+		InitialBackoff: defaultInitialBackoff,
+		MaxBackoff:     defaultMaxBackoff,
+		clock:          clock,
+		httpClient:     &http.Client{Timeout: requestTimeout},
+		deadLetter:     deadLetter,
+		webhooks:       webhooks,
+		queue:          make(chan delivery, queueSize),
+		done:           make(chan struct{}),
+	}
+	go notifier.deliver()
+	return notifier
+}
+
+// SetWebhooks replaces the webhooks the next events are sent to
+func (n *Notifier) SetWebhooks(webhooks []Webhook) {
+
+	if n == nil {
+		return
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.webhooks = webhooks
+}
+
+// Notify queues the event for every webhook accepting it, without waiting for the delivery
+func (n *Notifier) Notify(event Event) {
+
+	if n == nil {
+		return
+	}
+
+	event.Timestamp = n.clock.Now()
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.closed {
+		return
+	}
+
+	for _, webhook := range n.webhooks {
+		if !webhook.accepts(event) {
+			continue
+		}
+		select {
+		case n.queue <- delivery{webhook: webhook, event: event}:
+		default:
+			n.writeDeadLetter(webhook, event, 0, fmt.Errorf("notification queue full"))
+		}
+	}
+}
+
+// Close stops accepting events and waits until the queued ones are delivered or ctx is done
+func (n *Notifier) Close(ctx context.Context) error {
+
+	if n == nil {
+		return nil
+	}
+
+	n.mutex.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mutex.Unlock()
+
+	select {
+	case <-n.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (n *Notifier) deliver() {
+
+	defer close(n.done)
+	for delivery := range n.queue {
+		n.send(delivery.webhook, delivery.event)
+	}
+}
+
+// send POSTs the event to the webhook, retrying with exponential backoff
+func (n *Notifier) send(webhook Webhook, event Event) {
+
+	payload, err := webhook.payload(event)
+	if err != nil {
+		n.writeDeadLetter(webhook, event, 0, err)
+		return
+	}
+
+	backoff := n.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err = n.post(webhook.URL, payload)
+		if err == nil {
+			log.Debugf("Event %s for instance %s sent to %s", event.Type, event.InstanceID, webhook.URL)
+			return
+		}
+		if attempt >= n.MaxAttempts {
+			n.writeDeadLetter(webhook, event, attempt, err)
+			return
+		}
+
+		log.Warnf("Unable to send event %s to %s, retrying in %s: %s", event.Type, webhook.URL, backoff, err)
+		n.clock.Sleep(backoff)
+		backoff *= 2
+		if backoff > n.MaxBackoff {
+			backoff = n.MaxBackoff
+		}
+	}
+}
+
+func (n *Notifier) post(url string, payload []byte) error {
+
+	response, err := n.httpClient.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", response.StatusCode)
+	}
+	return nil
+}
+
+func (n *Notifier) writeDeadLetter(webhook Webhook, event Event, attempts int, err error) {
+
+	log.Errorf("Unable to deliver event %s for instance %s to %s after %d attempts: %s",
+		event.Type, event.InstanceID, webhook.URL, attempts, err)
+	if n.deadLetter == nil {
+		return
+	}
+
+	line, marshalErr := json.Marshal(deadLetter{
+		Timestamp: n.clock.Now(),
+		URL:       webhook.URL,
+		Event:     event,
+		Attempts:  attempts,
+		Error:     err.Error(),
+	})
+	if marshalErr != nil {
+		log.Errorf("Unable to write dead letter: %s", marshalErr)
+		return
+	}
+	n.deadLetterMutex.Lock()
+	defer n.deadLetterMutex.Unlock()
+	if _, writeErr := n.deadLetter.Write(append(line, '\n')); writeErr != nil {
+		log.Errorf("Unable to write dead letter: %s", writeErr)
+	}
+}
@@ -0,0 +1,134 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/benbjohnson/clock"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookStandIn is a local HTTP endpoint recording the payloads received. It fails the first failures requests
+type webhookStandIn struct {
+	mutex    sync.Mutex
+	failures int
+	requests int
+	payloads []string
+}
+
+func (w *webhookStandIn) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+
+	body, _ := ioutil.ReadAll(request.Body)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.requests++
+	if w.requests <= w.failures {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.payloads = append(w.payloads, string(body))
+}
+
+func (w *webhookStandIn) received() []string {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return append([]string{}, w.payloads...)
+}
+
+func newTestNotifier(webhooks []Webhook, deadLetter *bytes.Buffer) *Notifier {
+
+	notifier := NewNotifier(webhooks, deadLetter, clock.New())
+	notifier.InitialBackoff = time.Millisecond
+	notifier.MaxBackoff = 2 * time.Millisecond
+	notifier.MaxAttempts = 3
+	return notifier
+}
+
+func TestNotifier(t *testing.T) {
+
+	event := Event{Type: DrainStarted, AutoscalingGroup: "some-Autoscaling-Group", InstanceID: "i-34719eb8", IP: "10.0.0.2"}
+
+	Convey("When notifying an event", t, func() {
+		standIn := &webhookStandIn{}
+		server := httptest.NewServer(standIn)
+		defer server.Close()
+
+		Convey("it should be POSTed as JSON to the webhooks accepting it", func() {
+			notifier := newTestNotifier([]Webhook{
+				{URL: server.URL},
+				{URL: server.URL, Events: []string{InstanceTerminated}},
+			}, nil)
+			notifier.Notify(event)
+			So(notifier.Close(context.Background()), ShouldBeNil)
+
+			So(standIn.received(), ShouldHaveLength, 1)
+			received := Event{}
+			So(json.Unmarshal([]byte(standIn.received()[0]), &received), ShouldBeNil)
+			So(received.InstanceID, ShouldEqual, "i-34719eb8")
+		})
+		Convey("a webhook template should render the payload", func() {
+			notifier := newTestNotifier([]Webhook{{
+				URL:      server.URL,
+				Template: `{"text": {{ printf "Draining %s (%s)" .InstanceID .IP | json }}}`,
+			}}, nil)
+			notifier.Notify(event)
+			So(notifier.Close(context.Background()), ShouldBeNil)
+
+			So(standIn.received(), ShouldResemble, []string{`{"text": "Draining i-34719eb8 (10.0.0.2)"}`})
+		})
+		Convey("it should be retried if the webhook fails", func() {
+			standIn.failures = 2
+			notifier := newTestNotifier([]Webhook{{URL: server.URL}}, nil)
+			notifier.Notify(event)
+			So(notifier.Close(context.Background()), ShouldBeNil)
+
+			So(standIn.received(), ShouldHaveLength, 1)
+			So(standIn.requests, ShouldEqual, 3)
+		})
+		Convey("it should be written to the dead-letter log if the webhook keeps failing", func() {
+			standIn.failures = 10
+			deadLetter := &bytes.Buffer{}
+			notifier := newTestNotifier([]Webhook{{URL: server.URL}}, deadLetter)
+			notifier.Notify(event)
+			So(notifier.Close(context.Background()), ShouldBeNil)
+
+			So(standIn.requests, ShouldEqual, 3)
+			So(deadLetter.String(), ShouldContainSubstring, `"instanceId":"i-34719eb8"`)
+			So(deadLetter.String(), ShouldContainSubstring, `"attempts":3`)
+			So(strings.Count(deadLetter.String(), "\n"), ShouldEqual, 1)
+		})
+	})
+
+	Convey("When notifying without a notifier", t, func() {
+		var notifier *Notifier
+		Convey("the event should be discarded", func() {
+			So(func() { notifier.Notify(event) }, ShouldNotPanic)
+		})
+	})
+}
+
+func TestWebhookValidate(t *testing.T) {
+
+	Convey("When validating a webhook", t, func() {
+		Convey("it should fail without url", func() {
+			So((&Webhook{}).Validate(), ShouldNotBeNil)
+		})
+		Convey("it should fail with unknown event types", func() {
+			So((&Webhook{URL: "http://localhost", Events: []string{"UNKNOWN"}}).Validate(), ShouldNotBeNil)
+		})
+		Convey("it should fail with an invalid template", func() {
+			So((&Webhook{URL: "http://localhost", Template: "{{ .InstanceID "}).Validate(), ShouldNotBeNil)
+		})
+		Convey("it should succeed with a valid one", func() {
+			So((&Webhook{URL: "http://localhost", Events: []string{DrainStuck}}).Validate(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+)
+
+// Webhook is an endpoint to POST the drain events to. Events filters the event types to send, all of them
+// when empty. Template is a text/template rendering the JSON payload from the Event; the Event itself is sent
+// when empty
+type Webhook struct {
+	URL      string   `json:"url"`
+	Events   []string `json:"events"`
+	Template string   `json:"template"`
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		content, err := json.Marshal(value)
+		return string(content), err
+	},
+}
+
+// Validate checks that the webhook has an URL, known event types and a valid template
+func (w *Webhook) Validate() error {
+
+	if w.URL == "" {
+		return fmt.Errorf("Webhook without url found")
+	}
+	for _, eventType := range w.Events {
+		if !isEventType(eventType) {
+			return fmt.Errorf("Event type %s not found for webhook %s", eventType, w.URL)
+		}
+	}
+	if _, err := w.parseTemplate(); err != nil {
+		return fmt.Errorf("Invalid template for webhook %s: %s", w.URL, err)
+	}
+	return nil
+}
+
+func (w *Webhook) accepts(event Event) bool {
+
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, eventType := range w.Events {
+		if eventType == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Webhook) parseTemplate() (*template.Template, error) {
+	return template.New(w.URL).Funcs(templateFuncs).Option("missingkey=error").Parse(w.Template)
+}
+
+// payload renders the event with the webhook template, checking that the result is valid JSON
+func (w *Webhook) payload(event Event) ([]byte, error) {
+
+	if w.Template == "" {
+		return json.Marshal(event)
+	}
+
+	tmpl, err := w.parseTemplate()
+	if err != nil {
+		return nil, err
+	}
+	buffer := &bytes.Buffer{}
+	if err := tmpl.Execute(buffer, event); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buffer.Bytes()) {
+		return nil, fmt.Errorf("template for webhook %s rendered an invalid JSON payload", w.URL)
+	}
+	return buffer.Bytes(), nil
+}
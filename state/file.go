@@ -0,0 +1,75 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileStore implements Store using a local JSON file. The file is replaced atomically on every Save, so a
+// crash never leaves it half written
+type FileStore struct {
+	path string
+}
+
+// NewFileStore returns a new FileStore object persisting the state in path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load reads the state file, returning an empty State if it doesn't exist
+func (s *FileStore) Load(ctx context.Context) (*State, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return New(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := &State{}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, err
+	}
+	state.init()
+	return state, nil
+}
+
+// Save writes the state to a temporary file and renames it over the state file
+func (s *FileStore) Save(ctx context.Context, state *State) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.path)
+}
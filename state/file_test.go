@@ -0,0 +1,88 @@
+package state
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+
+	Convey("When using a file store", t, func() {
+		dir, err := ioutil.TempDir("", "deathnode-state")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "state.json")
+		store := NewFileStore(path)
+
+		Convey("loading it before any save should return an empty state", func() {
+			state, err := store.Load(context.Background())
+			So(err, ShouldBeNil)
+			So(state.Drains, ShouldBeEmpty)
+			So(state.LastDeleteTimestamps, ShouldBeEmpty)
+		})
+		Convey("loading it after a save should return the state saved", func() {
+			markedAt := time.Unix(1500000000, 0).UTC()
+			state := New()
+			state.Drain("i-34719eb8").MarkedAt = markedAt
+			state.LastDeleteTimestamps["some-Autoscaling-Group"] = markedAt.Add(time.Minute)
+			So(store.Save(context.Background(), state), ShouldBeNil)
+
+			loaded, err := NewFileStore(path).Load(context.Background())
+			So(err, ShouldBeNil)
+			So(loaded.Drains["i-34719eb8"].MarkedAt.Equal(markedAt), ShouldBeTrue)
+			So(loaded.LastDeleteTimestamps["some-Autoscaling-Group"].Equal(markedAt.Add(time.Minute)), ShouldBeTrue)
+
+			Convey("without leaving temporary files", func() {
+				files, _ := ioutil.ReadDir(dir)
+				So(files, ShouldHaveLength, 1)
+			})
+		})
+		Convey("loading a corrupted file should fail", func() {
+			So(ioutil.WriteFile(path, []byte("{"), 0644), ShouldBeNil)
+			_, err := store.Load(context.Background())
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPrune(t *testing.T) {
+
+	Convey("When pruning the state", t, func() {
+		state := New()
+		state.Drain("i-34719eb8")
+		state.Drain("i-777")
+		state.Prune(map[string]bool{"i-777": true})
+
+		Convey("only the drain records of the instances given should be kept", func() {
+			So(state.Drains, ShouldHaveLength, 1)
+			So(state.Drains, ShouldContainKey, "i-777")
+		})
+	})
+}
+
+func TestCompletions(t *testing.T) {
+
+	Convey("When recording lifecycle completions", t, func() {
+		now := time.Unix(1500000000, 0)
+		state := New()
+		state.RecordCompletion("some-Autoscaling-Group", now.Add(-2*time.Hour))
+		state.RecordCompletion("some-Autoscaling-Group", now)
+		state.RecordCompletion("other-Autoscaling-Group", now)
+
+		Convey("they should be counted by autoscaling group and for all of them", func() {
+			So(state.CompletionsSince("some-Autoscaling-Group", now.Add(-time.Hour)), ShouldEqual, 1)
+			So(state.CompletionsSince("", now.Add(-time.Hour)), ShouldEqual, 2)
+			So(state.CompletionsSince("", now.Add(-3*time.Hour)), ShouldEqual, 3)
+		})
+		Convey("pruning them should only keep the recent ones", func() {
+			state.PruneCompletions(now.Add(-time.Hour))
+			So(state.Completions["some-Autoscaling-Group"], ShouldHaveLength, 1)
+			So(state.Completions, ShouldHaveLength, 2)
+		})
+	})
+}
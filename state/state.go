@@ -0,0 +1,138 @@
+package state
+
+// Drain bookkeeping that needs to survive restarts: the instances being drained, the last destroy of every
+// autoscaling group prefix, used to honour delayDelete, the recent completions used by the drain budgets and the
+// machines deathnode put in the Mesos maintenance schedule
+
+import (
+	"context"
+	"time"
+)
+
+// Store implements a backend to persist the State
+type Store interface {
+	// Load returns the last State saved, or an empty one if nothing was saved yet
+	Load(ctx context.Context) (*State, error)
+	// Save replaces the State persisted
+	Save(ctx context.Context, state *State) error
+}
+
+// State stores the drain records by instance id, the last destroy timestamps by autoscaling group prefix, the
+// lifecycle completions by autoscaling group and the maintenances by hostname
+type State struct {
+	Drains               map[string]*Drain       `json:"drains"`
+	LastDeleteTimestamps map[string]time.Time    `json:"lastDeleteTimestamps"`
+	Completions          map[string][]time.Time  `json:"completions,omitempty"`
+	Maintenances         map[string]*Maintenance `json:"maintenances,omitempty"`
+}
+
+// Drain stores what deathnode did on an instance marked to be removed
+type Drain struct {
+	AutoscalingGroup       string    `json:"autoscalingGroup"`
+	AutoscalingGroupPrefix string    `json:"autoscalingGroupPrefix"`
+	Reason                 string    `json:"reason"`
+	MarkedAt               time.Time `json:"markedAt"`
+	MaintenanceRequestedAt time.Time `json:"maintenanceRequestedAt,omitempty"`
+	AgentDrainRequestedAt  time.Time `json:"agentDrainRequestedAt,omitempty"`
+	ProtectionRemovedAt    time.Time `json:"protectionRemovedAt,omitempty"`
+	StuckNotifiedAt        time.Time `json:"stuckNotifiedAt,omitempty"`
+	TerminatingWaitAt      time.Time `json:"terminatingWaitAt,omitempty"`
+	EscalatedAt            time.Time `json:"escalatedAt,omitempty"`
+	NotRestoredAt          time.Time `json:"notRestoredAt,omitempty"`
+}
+
+// Maintenance stores a machine deathnode put in the Mesos maintenance schedule, until it's pruned from it
+type Maintenance struct {
+	IP           string    `json:"ip"`
+	TerminatedAt time.Time `json:"terminatedAt,omitempty"`
+}
+
+// New returns an empty State
+func New() *State {
+
+	return &State{
+		Drains:               map[string]*Drain{},
+		LastDeleteTimestamps: map[string]time.Time{},
+		Completions:          map[string][]time.Time{},
+		Maintenances:         map[string]*Maintenance{},
+	}
+}
+
+// Drain returns the drain record of the instance, creating it if it doesn't exist
+func (s *State) Drain(instanceID string) *Drain {
+
+	drain, ok := s.Drains[instanceID]
+	if !ok {
+		drain = &Drain{}
+		s.Drains[instanceID] = drain
+	}
+	return drain
+}
+
+// Prune deletes the drain records of the instances not in instanceIDs
+func (s *State) Prune(instanceIDs map[string]bool) {
+
+	for instanceID := range s.Drains {
+		if !instanceIDs[instanceID] {
+			delete(s.Drains, instanceID)
+		}
+	}
+}
+
+// RecordCompletion stores a lifecycle completion on an autoscaling group
+func (s *State) RecordCompletion(autoscalingGroup string, at time.Time) {
+	s.Completions[autoscalingGroup] = append(s.Completions[autoscalingGroup], at)
+}
+
+// CompletionsSince returns the lifecycle completions on an autoscaling group after since. An empty
+// autoscalingGroup counts the completions on all of them
+func (s *State) CompletionsSince(autoscalingGroup string, since time.Time) int {
+
+	count := 0
+	for name, completions := range s.Completions {
+		if autoscalingGroup != "" && name != autoscalingGroup {
+			continue
+		}
+		for _, completion := range completions {
+			if completion.After(since) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// PruneCompletions deletes the lifecycle completions before since
+func (s *State) PruneCompletions(since time.Time) {
+
+	for name, completions := range s.Completions {
+		recent := []time.Time{}
+		for _, completion := range completions {
+			if completion.After(since) {
+				recent = append(recent, completion)
+			}
+		}
+		if len(recent) == 0 {
+			delete(s.Completions, name)
+		} else {
+			s.Completions[name] = recent
+		}
+	}
+}
+
+// init creates the maps missing, as the ones decoded from an empty or old state
+func (s *State) init() {
+
+	if s.Drains == nil {
+		s.Drains = map[string]*Drain{}
+	}
+	if s.LastDeleteTimestamps == nil {
+		s.LastDeleteTimestamps = map[string]time.Time{}
+	}
+	if s.Completions == nil {
+		s.Completions = map[string][]time.Time{}
+	}
+	if s.Maintenances == nil {
+		s.Maintenances = map[string]*Maintenance{}
+	}
+}